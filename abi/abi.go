@@ -0,0 +1,195 @@
+// Package abi calls contract methods described by a JSON schema.
+//
+// The schema is embedded in the module as a custom section named "abi" or given
+// as a sidecar JSON file:
+//
+//	{"methods": [{"name": "transfer",
+//	              "params": [{"name": "to", "type": "bytes"}, {"name": "amount", "type": "u64"}],
+//	              "returns": "bool"}]}
+//
+// A method is an exported function of type (i32, i32) -> i64, or (i32, i32) -> ()
+// when it returns nothing. It receives the address and length of its encoded
+// arguments, passed with the module allocator, and returns the address of its
+// encoded result in the upper 32 bits and its length in the lower 32 bits. The
+// result buffer is freed by the host once decoded.
+//
+// Values are encoded in little endian: bool as a byte, u8, u32, u64, i32 and i64
+// with their width, string and bytes as a u32 length followed by the bytes, and
+// arrays T[] as a u32 count followed by the elements. The arguments of a method
+// are encoded one after the other in the schema order.
+package abi
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// SectionName is the name of the custom section holding the schema
+const SectionName = "abi"
+
+// ErrNoSchema is returned when a module has no abi custom section
+var ErrNoSchema = errors.New("abi: no schema section")
+
+// Param is a named method parameter
+type Param struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// Method is a contract method, Returns is empty when it returns nothing
+type Method struct {
+	Name    string  `json:"name"`
+	Params  []Param `json:"params"`
+	Returns string  `json:"returns,omitempty"`
+}
+
+// Schema lists the methods of a contract
+type Schema struct {
+	Methods []Method `json:"methods"`
+}
+
+// Parse parses and checks a JSON schema
+func Parse(data []byte) (*Schema, error) {
+	var s Schema
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("abi: %v", err)
+	}
+	methods := make(map[string]bool)
+	for _, m := range s.Methods {
+		if m.Name == "" || methods[m.Name] {
+			return nil, fmt.Errorf("abi: invalid or duplicate method name %q", m.Name)
+		}
+		methods[m.Name] = true
+		params := make(map[string]bool)
+		for _, p := range m.Params {
+			if p.Name == "" || params[p.Name] {
+				return nil, fmt.Errorf("abi: %s: invalid or duplicate parameter name %q", m.Name, p.Name)
+			}
+			params[p.Name] = true
+			if !validType(p.Type) {
+				return nil, fmt.Errorf("abi: %s: %s: unknown type %q", m.Name, p.Name, p.Type)
+			}
+		}
+		if m.Returns != "" && !validType(m.Returns) {
+			return nil, fmt.Errorf("abi: %s: unknown result type %q", m.Name, m.Returns)
+		}
+	}
+	return &s, nil
+}
+
+// FromModule parses the schema embedded in the abi custom section of m
+func FromModule(m *wasm.Module) (*Schema, error) {
+	for _, sec := range m.CustomSecs {
+		if sec.Name == SectionName {
+			return Parse(sec.Data)
+		}
+	}
+	return nil, ErrNoSchema
+}
+
+// Method returns the method called name
+func (s *Schema) Method(name string) (*Method, bool) {
+	for i := range s.Methods {
+		if s.Methods[i].Name == name {
+			return &s.Methods[i], true
+		}
+	}
+	return nil, false
+}
+
+// Call calls method of the contract run by machine with the schema embedded in its module
+func Call(machine *vm.VM, method string, args map[string]interface{}) (interface{}, error) {
+	s, err := FromModule(machine.Module)
+	if err != nil {
+		return nil, err
+	}
+	return s.Call(machine, method, args)
+}
+
+// Call encodes args into the memory of machine, calls method and decodes its
+// result, which is nil for methods returning nothing
+func (s *Schema) Call(machine *vm.VM, method string, args map[string]interface{}) (interface{}, error) {
+	m, ok := s.Method(method)
+	if !ok {
+		return nil, fmt.Errorf("abi: unknown method %q", method)
+	}
+	if err := m.checkExport(machine); err != nil {
+		return nil, err
+	}
+	data, err := m.EncodeArgs(args)
+	if err != nil {
+		return nil, err
+	}
+	ptr, length, err := machine.PassBytes(data)
+	if err != nil {
+		return nil, err
+	}
+	results, err := machine.InvokeByName(m.Name, vm.I32(int32(ptr)), vm.I32(int32(length)))
+	if err != nil {
+		return nil, err
+	}
+	if m.Returns == "" {
+		return nil, nil
+	}
+	packed := uint64(results[0].I64())
+	result, err := machine.ReadReturnedBytes(uint32(packed>>32), uint32(packed))
+	if err != nil {
+		return nil, err
+	}
+	return m.DecodeResult(result)
+}
+
+// checkExport checks the method is exported with the calling convention type
+func (m *Method) checkExport(machine *vm.VM) error {
+	extern, ok := machine.GetExport(m.Name)
+	fn, isFunc := extern.(*vm.FuncRef)
+	if !ok || !isFunc {
+		return fmt.Errorf("abi: method %q is not an exported function", m.Name)
+	}
+	sig := fn.Type()
+	params := len(sig.ParamTypes) == 2 && sig.ParamTypes[0] == wasm.ValueTypeI32 && sig.ParamTypes[1] == wasm.ValueTypeI32
+	results := len(sig.ReturnTypes) == 0
+	if m.Returns != "" {
+		results = len(sig.ReturnTypes) == 1 && sig.ReturnTypes[0] == wasm.ValueTypeI64
+	}
+	if !params || !results {
+		return fmt.Errorf("abi: method %q has type %v%v", m.Name, sig.ParamTypes, sig.ReturnTypes)
+	}
+	return nil
+}
+
+// EncodeArgs encodes args in the parameter order after checking there is one
+// value of the parameter type per parameter
+func (m *Method) EncodeArgs(args map[string]interface{}) ([]byte, error) {
+	if len(args) != len(m.Params) {
+		return nil, fmt.Errorf("abi: %s: expect %d arguments, got %d", m.Name, len(m.Params), len(args))
+	}
+	e := &encoder{}
+	for _, p := range m.Params {
+		v, ok := args[p.Name]
+		if !ok {
+			return nil, fmt.Errorf("abi: %s: missing argument %s", m.Name, p.Name)
+		}
+		if err := e.encode(p.Type, v); err != nil {
+			return nil, fmt.Errorf("abi: %s: %s: %v", m.Name, p.Name, err)
+		}
+	}
+	return e.buf, nil
+}
+
+// DecodeResult decodes the result of the method
+func (m *Method) DecodeResult(data []byte) (interface{}, error) {
+	d := &decoder{buf: data}
+	v, err := d.decode(m.Returns)
+	if err == nil && len(d.buf) != 0 {
+		err = errors.New("trailing bytes")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("abi: %s: result: %v", m.Name, err)
+	}
+	return v, nil
+}
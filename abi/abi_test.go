@@ -0,0 +1,210 @@
+package abi
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+const testSchema = `{"methods": [
+  {"name": "transfer", "params": [{"name": "to", "type": "bytes"}, {"name": "amount", "type": "u64"}], "returns": "bool"},
+  {"name": "balance", "params": [], "returns": "u64"},
+  {"name": "greet", "params": [{"name": "name", "type": "string"}], "returns": "string"},
+  {"name": "reset", "params": [{"name": "value", "type": "u64"}]}
+]}`
+
+// testContract keeps a balance and echoes the encoded greet argument as its result
+const testContract = `(module
+  (memory 1)
+  (global $next (mut i32) (i32.const 1024))
+  (global $balance (mut i64) (i64.const 100))
+  (func $malloc (export "malloc") (param $n i32) (result i32)
+    (global.get $next)
+    (global.set $next (i32.add (global.get $next) (local.get $n))))
+  (func (export "free") (param i32))
+  (func $pack (param $ptr i32) (param $len i32) (result i64)
+    (i64.or (i64.shl (i64.extend_i32_u (local.get $ptr)) (i64.const 32)) (i64.extend_i32_u (local.get $len))))
+  (func (export "transfer") (param $p i32) (param $n i32) (result i64)
+    (local $amount i64) (local $q i32)
+    (local.set $amount (i64.load (i32.add (i32.add (local.get $p) (i32.const 4)) (i32.load (local.get $p)))))
+    (local.set $q (call $malloc (i32.const 1)))
+    (if (i64.le_u (local.get $amount) (global.get $balance))
+      (then
+        (global.set $balance (i64.sub (global.get $balance) (local.get $amount)))
+        (i32.store8 (local.get $q) (i32.const 1))))
+    (call $pack (local.get $q) (i32.const 1)))
+  (func (export "balance") (param i32 i32) (result i64)
+    (local $q i32)
+    (local.set $q (call $malloc (i32.const 8)))
+    (i64.store (local.get $q) (global.get $balance))
+    (call $pack (local.get $q) (i32.const 8)))
+  (func (export "greet") (param $p i32) (param $n i32) (result i64)
+    (call $pack (local.get $p) (local.get $n)))
+  (func (export "reset") (param $p i32) (param i32)
+    (global.set $balance (i64.load (local.get $p)))))`
+
+// withCustomSection appends a custom section to a binary module
+func withCustomSection(code []byte, name string, data []byte) []byte {
+	uleb := func(b []byte, v int) []byte {
+		for v >= 0x80 {
+			b = append(b, byte(v)|0x80)
+			v >>= 7
+		}
+		return append(b, byte(v))
+	}
+	payload := append(uleb(nil, len(name)), name...)
+	payload = append(payload, data...)
+	code = append(code, 0)
+	code = uleb(code, len(payload))
+	return append(code, payload...)
+}
+
+func newContract(t *testing.T, schema string) *vm.VM {
+	code, err := wat.Compile([]byte(testContract))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if schema != "" {
+		code = withCustomSection(code, SectionName, []byte(schema))
+	}
+	machine, err := vm.NewVM(code, &vm.FreeGasPolicy{}, &vm.Gas{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return machine
+}
+
+func TestCall(t *testing.T) {
+	machine := newContract(t, testSchema)
+	to := make([]byte, 20)
+	calls := []struct {
+		method string
+		args   map[string]interface{}
+		result interface{}
+	}{
+		{"transfer", map[string]interface{}{"to": to, "amount": 30}, true},
+		{"balance", nil, uint64(70)},
+		{"transfer", map[string]interface{}{"to": to, "amount": uint64(71)}, false},
+		{"greet", map[string]interface{}{"name": "wasm"}, "wasm"},
+		{"reset", map[string]interface{}{"value": json.Number("5")}, nil},
+		{"balance", map[string]interface{}{}, uint64(5)},
+	}
+	for _, call := range calls {
+		result, err := Call(machine, call.method, call.args)
+		if err != nil || !reflect.DeepEqual(result, call.result) {
+			t.Errorf("%s(%v): expect %#v, got %#v %v", call.method, call.args, call.result, result, err)
+		}
+	}
+
+	errCalls := []struct {
+		method string
+		args   map[string]interface{}
+		err    string
+	}{
+		{"mint", nil, `unknown method "mint"`},
+		{"transfer", map[string]interface{}{"to": to}, "expect 2 arguments, got 1"},
+		{"transfer", map[string]interface{}{"to": to, "value": 1}, "missing argument amount"},
+		{"transfer", map[string]interface{}{"to": "0x00", "amount": 1}, "to: expect bytes, got string"},
+		{"transfer", map[string]interface{}{"to": to, "amount": -1}, "amount: expect u64, got -1 out of range"},
+		{"transfer", map[string]interface{}{"to": to, "amount": 1.5}, "amount: expect u64, got 1.5"},
+	}
+	for _, call := range errCalls {
+		if _, err := Call(machine, call.method, call.args); err == nil || !strings.Contains(err.Error(), call.err) {
+			t.Errorf("%s(%v): expect %q, got %v", call.method, call.args, call.err, err)
+		}
+	}
+}
+
+func TestSidecarSchema(t *testing.T) {
+	machine := newContract(t, "")
+	if _, err := Call(machine, "balance", nil); err != ErrNoSchema {
+		t.Errorf("Expect %v, got %v", ErrNoSchema, err)
+	}
+	schema, err := Parse([]byte(`{"methods": [
+  {"name": "balance", "params": [], "returns": "u64"},
+  {"name": "free", "params": [{"name": "ptr", "type": "u32"}]}]}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result, err := schema.Call(machine, "balance", nil); err != nil || result != uint64(100) {
+		t.Errorf("Expect 100, got %v %v", result, err)
+	}
+	if _, err := schema.Call(machine, "free", map[string]interface{}{"ptr": 0}); err == nil ||
+		!strings.Contains(err.Error(), `method "free" has type [i32][]`) {
+		t.Errorf("Expect a calling convention error, got %v", err)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	for _, schema := range []string{
+		`{"methods": [{"name": "a"}, {"name": "a"}]}`,
+		`{"methods": [{"name": "a", "params": [{"name": "x", "type": "u16"}]}]}`,
+		`{"methods": [{"name": "a", "params": [{"name": "x", "type": "u8"}, {"name": "x", "type": "u8"}]}]}`,
+		`{"methods": [{"name": "a", "returns": "map"}]}`,
+		`{"methods": [{"name": ""}]}`,
+		`[]`,
+	} {
+		if _, err := Parse([]byte(schema)); err == nil {
+			t.Errorf("Expect %s to be rejected", schema)
+		}
+	}
+}
+
+func TestCodec(t *testing.T) {
+	m := &Method{Name: "m", Params: []Param{
+		{"flags", "bool[]"}, {"small", "u8"}, {"delta", "i32"}, {"big", "i64"}, {"names", "string[]"}, {"blobs", "bytes[]"},
+	}}
+	args := map[string]interface{}{
+		"flags": []bool{true, false},
+		"small": uint8(255),
+		"delta": int32(-2),
+		"big":   float64(-1 << 40),
+		"names": []string{"a", "bc"},
+		"blobs": [][]byte{{1, 2}},
+	}
+	data, err := m.EncodeArgs(args)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []byte{
+		2, 0, 0, 0, 1, 0,
+		255,
+		0xfe, 0xff, 0xff, 0xff,
+		0, 0, 0, 0, 0, 0xff, 0xff, 0xff,
+		2, 0, 0, 0, 1, 0, 0, 0, 'a', 2, 0, 0, 0, 'b', 'c',
+		1, 0, 0, 0, 2, 0, 0, 0, 1, 2,
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expect % x, got % x", expected, data)
+	}
+
+	results := []struct {
+		returns string
+		data    []byte
+		value   interface{}
+	}{
+		{"i32", []byte{0xfe, 0xff, 0xff, 0xff}, int32(-2)},
+		{"u32[]", []byte{1, 0, 0, 0, 7, 0, 0, 0}, []interface{}{uint32(7)}},
+		{"bytes", []byte{1, 0, 0, 0, 9}, []byte{9}},
+	}
+	for _, r := range results {
+		m := &Method{Name: "m", Returns: r.returns}
+		if v, err := m.DecodeResult(r.data); err != nil || !reflect.DeepEqual(v, r.value) {
+			t.Errorf("%s: expect %#v, got %#v %v", r.returns, r.value, v, err)
+		}
+	}
+	for _, data := range [][]byte{{2}, {1, 0}, {}} {
+		m := &Method{Name: "m", Returns: "bool"}
+		if _, err := m.DecodeResult(data); err == nil {
+			t.Errorf("Expect % x to be rejected as a bool", data)
+		}
+	}
+	m = &Method{Name: "m", Returns: "string[]"}
+	if _, err := m.DecodeResult([]byte{0xff, 0xff, 0xff, 0xff}); err == nil {
+		t.Error("Expect an oversized count to be rejected")
+	}
+}
@@ -0,0 +1,233 @@
+package abi
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"strings"
+)
+
+// integer types by name with their width in bytes
+var intTypes = map[string]struct {
+	size   int
+	signed bool
+}{
+	"u8":  {1, false},
+	"u32": {4, false},
+	"u64": {8, false},
+	"i32": {4, true},
+	"i64": {8, true},
+}
+
+func validType(t string) bool {
+	if elem := strings.TrimSuffix(t, "[]"); elem != t {
+		return validType(elem)
+	}
+	_, ok := intTypes[t]
+	return ok || t == "bool" || t == "string" || t == "bytes"
+}
+
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) uint(v uint64, size int) {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	e.buf = append(e.buf, b[:size]...)
+}
+
+func (e *encoder) bytes(b []byte) error {
+	if uint64(len(b)) > math.MaxUint32 {
+		return errors.New("too long")
+	}
+	e.uint(uint64(len(b)), 4)
+	e.buf = append(e.buf, b...)
+	return nil
+}
+
+func (e *encoder) encode(t string, v interface{}) error {
+	if elem := strings.TrimSuffix(t, "[]"); elem != t {
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			return fmt.Errorf("expect %s, got %T", t, v)
+		}
+		if uint64(rv.Len()) > math.MaxUint32 {
+			return errors.New("too many elements")
+		}
+		e.uint(uint64(rv.Len()), 4)
+		for i := 0; i < rv.Len(); i++ {
+			if err := e.encode(elem, rv.Index(i).Interface()); err != nil {
+				return fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		return nil
+	}
+	switch t {
+	case "bool":
+		b, ok := v.(bool)
+		if !ok {
+			return fmt.Errorf("expect bool, got %T", v)
+		}
+		if b {
+			e.uint(1, 1)
+		} else {
+			e.uint(0, 1)
+		}
+		return nil
+	case "string":
+		s, ok := v.(string)
+		if !ok {
+			return fmt.Errorf("expect string, got %T", v)
+		}
+		return e.bytes([]byte(s))
+	case "bytes":
+		b, ok := v.([]byte)
+		if !ok {
+			return fmt.Errorf("expect bytes, got %T", v)
+		}
+		return e.bytes(b)
+	}
+	info := intTypes[t]
+	bits, err := toInt(v, info.size*8, info.signed)
+	if err != nil {
+		return fmt.Errorf("expect %s, %v", t, err)
+	}
+	e.uint(bits, info.size)
+	return nil
+}
+
+// toInt converts a Go integer, an integral float64 as decoded from JSON or a
+// json.Number to the bits of an integer of size bits checking its range
+func toInt(v interface{}, size int, signed bool) (uint64, error) {
+	var (
+		i        int64
+		u        uint64
+		negative bool
+	)
+	switch x := v.(type) {
+	case int, int8, int16, int32, int64:
+		i = reflect.ValueOf(x).Int()
+		negative, u = i < 0, uint64(i)
+	case uint, uint8, uint16, uint32, uint64:
+		u = reflect.ValueOf(x).Uint()
+	case float64:
+		if x != math.Trunc(x) || x < math.MinInt64 || x >= math.MaxUint64 {
+			return 0, fmt.Errorf("got %v", x)
+		}
+		if x < 0 {
+			i = int64(x)
+			negative, u = true, uint64(i)
+		} else {
+			u = uint64(x)
+		}
+	case json.Number:
+		if n, err := x.Int64(); err == nil {
+			return toInt(n, size, signed)
+		}
+		n, err := x.Float64()
+		if err != nil {
+			return 0, fmt.Errorf("got %q", x)
+		}
+		return toInt(n, size, signed)
+	default:
+		return 0, fmt.Errorf("got %T", v)
+	}
+	if negative {
+		if !signed || i < -1<<(uint(size)-1) {
+			return 0, fmt.Errorf("got %d out of range", i)
+		}
+		return u & (math.MaxUint64 >> (64 - uint(size))), nil
+	}
+	max := uint64(math.MaxUint64) >> (64 - uint(size))
+	if signed {
+		max >>= 1
+	}
+	if u > max {
+		return 0, fmt.Errorf("got %d out of range", u)
+	}
+	return u, nil
+}
+
+type decoder struct {
+	buf []byte
+}
+
+func (d *decoder) next(n uint64) ([]byte, error) {
+	if n > uint64(len(d.buf)) {
+		return nil, errors.New("unexpected end")
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b, nil
+}
+
+func (d *decoder) uint(size int) (uint64, error) {
+	b, err := d.next(uint64(size))
+	if err != nil {
+		return 0, err
+	}
+	padded := make([]byte, 8)
+	copy(padded, b)
+	return binary.LittleEndian.Uint64(padded), nil
+}
+
+func (d *decoder) bytes() ([]byte, error) {
+	n, err := d.uint(4)
+	if err != nil {
+		return nil, err
+	}
+	b, err := d.next(n)
+	return append([]byte(nil), b...), err
+}
+
+func (d *decoder) decode(t string) (interface{}, error) {
+	if elem := strings.TrimSuffix(t, "[]"); elem != t {
+		n, err := d.uint(4)
+		if err != nil {
+			return nil, err
+		}
+		if n > uint64(len(d.buf)) {
+			return nil, errors.New("unexpected end")
+		}
+		values := make([]interface{}, n)
+		for i := range values {
+			if values[i], err = d.decode(elem); err != nil {
+				return nil, fmt.Errorf("element %d: %v", i, err)
+			}
+		}
+		return values, nil
+	}
+	switch t {
+	case "bool":
+		b, err := d.uint(1)
+		if err == nil && b > 1 {
+			err = fmt.Errorf("invalid bool %d", b)
+		}
+		return b == 1, err
+	case "string":
+		b, err := d.bytes()
+		return string(b), err
+	case "bytes":
+		return d.bytes()
+	}
+	info := intTypes[t]
+	bits, err := d.uint(info.size)
+	if err != nil {
+		return nil, err
+	}
+	switch t {
+	case "u8":
+		return uint8(bits), nil
+	case "u32":
+		return uint32(bits), nil
+	case "i32":
+		return int32(bits), nil
+	case "i64":
+		return int64(bits), nil
+	}
+	return bits, nil
+}
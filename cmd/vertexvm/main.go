@@ -0,0 +1,220 @@
+// Command vertexvm runs and inspects wasm modules.
+//
+//	vertexvm [flags] module.wasm
+//	vertexvm inspect module.wasm
+//	vertexvm wast script.wast...
+//	vertexvm debug module.wasm
+//
+// The exported function given by --invoke is called with the typed --arg
+// values and its typed result is printed. Traps and gas exhaustion are
+// reported with a non-zero exit code.
+//
+// The inspect subcommand prints the module sections and disassembles its functions.
+//
+// The wast subcommand runs spec test scripts, prints the commands which did not
+// behave as asserted and exits with a non-zero code if any did.
+//
+// The debug subcommand starts an interactive step debugger on the module.
+//
+// Modules named with a .wat extension are compiled from the text format.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/vertexdlt/vertexvm/inspect"
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wasm"
+	"github.com/vertexdlt/vertexvm/wast"
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+// Exit codes
+const (
+	exitOK = iota
+	exitTrap
+	exitUsage
+)
+
+// stubResolver fails every host call, modules run by the command line
+// have no host environment
+type stubResolver struct{}
+
+func (r *stubResolver) GetFunction(module, name string) vm.HostFunction {
+	return func(vm *vm.VM, args ...uint64) (uint64, error) {
+		return 0, fmt.Errorf("unresolved import %s.%s", module, name)
+	}
+}
+
+// readModule reads a binary module, or compiles a text format one
+func readModule(path string) ([]byte, error) {
+	code, err := ioutil.ReadFile(path)
+	if err != nil || !strings.HasSuffix(path, ".wat") {
+		return code, err
+	}
+	return wat.Compile(code)
+}
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) > 0 && args[0] == "inspect" {
+		return inspectModule(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "wast" {
+		return runScripts(args[1:], stdout, stderr)
+	}
+	if len(args) > 0 && args[0] == "debug" {
+		return debugModule(args[1:], os.Stdin, stdout, stderr)
+	}
+	flags := flag.NewFlagSet("vertexvm", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var (
+		invoke     = flags.String("invoke", "", "name of the exported function to call")
+		gasLimit   = flags.Uint64("gas-limit", 0, "gas limit, 0 runs without gas metering unless a policy is given")
+		policyPath = flags.String("gas-policy", "", "JSON gas policy file, defaults to SimpleGasPolicy with a --gas-limit and FreeGasPolicy without")
+		fnArgs     valueList
+	)
+	flags.Var(&fnArgs, "arg", "typed argument such as i32:1 or f64:2.5, repeatable")
+	flags.Usage = func() {
+		fmt.Fprintln(stderr, "usage: vertexvm [flags] <module.wasm>")
+		fmt.Fprintln(stderr, "       vertexvm inspect <module.wasm>")
+		fmt.Fprintln(stderr, "       vertexvm wast <script.wast>...")
+		fmt.Fprintln(stderr, "       vertexvm debug <module.wasm>")
+		flags.PrintDefaults()
+	}
+	if err := flags.Parse(args); err != nil {
+		return exitUsage
+	}
+	if flags.NArg() != 1 || *invoke == "" {
+		flags.Usage()
+		return exitUsage
+	}
+
+	code, err := readModule(flags.Arg(0))
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	var policy vm.GasPolicy = &vm.FreeGasPolicy{}
+	if *policyPath != "" {
+		if policy, err = loadGasPolicy(*policyPath); err != nil {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+	} else if *gasLimit > 0 {
+		policy = &vm.SimpleGasPolicy{}
+	}
+	gas := &vm.Gas{Limit: *gasLimit}
+	if gas.Limit == 0 {
+		gas.Limit = ^uint64(0)
+	}
+
+	machine, err := vm.NewVM(code, policy, gas, &stubResolver{})
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitTrap
+	}
+	extern, ok := machine.GetExport(*invoke)
+	if !ok {
+		fmt.Fprintf(stderr, "export %s not found\n", *invoke)
+		return exitUsage
+	}
+	fn, ok := extern.(*vm.FuncRef)
+	if !ok {
+		fmt.Fprintf(stderr, "export %s is not a function\n", *invoke)
+		return exitUsage
+	}
+	if err := fnArgs.check(fn.Type().ParamTypes); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+
+	results, err := machine.InvokeByName(*invoke, fnArgs...)
+	if err != nil {
+		if _, ok := err.(*vm.ArgumentError); ok {
+			fmt.Fprintln(stderr, err)
+			return exitUsage
+		}
+		fmt.Fprintf(stderr, "trap: %v\n", err)
+		fmt.Fprintf(stderr, "gas used: %d\n", machine.GetGasUsed())
+		return exitTrap
+	}
+	for _, result := range results {
+		fmt.Fprintln(stdout, formatValue(result))
+	}
+	fmt.Fprintf(stderr, "gas used: %d\n", machine.GetGasUsed())
+	return exitOK
+}
+
+func inspectModule(args []string, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "usage: vertexvm inspect <module.wasm>")
+		return exitUsage
+	}
+	code, err := readModule(args[0])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	m, err := wasm.ReadModule(code)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitTrap
+	}
+	if err := inspect.Dump(stdout, m); err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitTrap
+	}
+	return exitOK
+}
+
+func runScripts(paths []string, stdout, stderr io.Writer) int {
+	if len(paths) == 0 {
+		fmt.Fprintln(stderr, "usage: vertexvm wast <script.wast>...")
+		return exitUsage
+	}
+	code := exitOK
+	for _, path := range paths {
+		result, err := (&wast.Runner{}).RunFile(path)
+		if err != nil {
+			fmt.Fprintf(stderr, "%s: %v\n", path, err)
+			code = exitUsage
+			continue
+		}
+		for _, failure := range result.Failures {
+			fmt.Fprintf(stdout, "%s:%v\n", path, failure)
+		}
+		fmt.Fprintf(stdout, "%s: %d commands, %d failed\n", path, result.Commands, len(result.Failures))
+		if len(result.Failures) > 0 && code == exitOK {
+			code = exitTrap
+		}
+	}
+	return code
+}
+
+func debugModule(args []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	if len(args) != 1 {
+		fmt.Fprintln(stderr, "usage: vertexvm debug <module.wasm>")
+		return exitUsage
+	}
+	code, err := readModule(args[0])
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitUsage
+	}
+	r, err := newREPL(code, stdout)
+	if err != nil {
+		fmt.Fprintln(stderr, err)
+		return exitTrap
+	}
+	r.run(stdin)
+	return exitOK
+}
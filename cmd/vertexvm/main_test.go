@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testModule exports add(i32, i32) i32, half(f64) f64 and trap()
+var testModule = []byte{
+	0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00,
+	// types: (i32, i32) -> i32, (f64) -> f64, () -> ()
+	0x01, 0x0f, 0x03,
+	0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f,
+	0x60, 0x01, 0x7c, 0x01, 0x7c,
+	0x60, 0x00, 0x00,
+	// functions
+	0x03, 0x04, 0x03, 0x00, 0x01, 0x02,
+	// exports
+	0x07, 0x15, 0x03,
+	0x03, 'a', 'd', 'd', 0x00, 0x00,
+	0x04, 'h', 'a', 'l', 'f', 0x00, 0x01,
+	0x04, 't', 'r', 'a', 'p', 0x00, 0x02,
+	// code
+	0x0a, 0x1c, 0x03,
+	0x07, 0x00, 0x20, 0x00, 0x20, 0x01, 0x6a, 0x0b,
+	0x0e, 0x00, 0x20, 0x00, 0x44, 0, 0, 0, 0, 0, 0, 0xe0, 0x3f, 0xa2, 0x0b,
+	0x03, 0x00, 0x00, 0x0b,
+}
+
+func writeTemp(t *testing.T, dir, name string, data []byte) string {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestRun(t *testing.T) {
+	dir, err := ioutil.TempDir("", "vertexvm")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	module := writeTemp(t, dir, "test.wasm", testModule)
+	script := writeTemp(t, dir, "test.wast", []byte(`(module (func (export "one") (result i32) (i32.const 1)))
+(assert_return (invoke "one") (i32.const 1))
+(assert_return (invoke "one") (i32.const 2))`))
+	policy := writeTemp(t, dir, "policy.json", []byte(`{"default_op_cost": 0, "op_costs": {"i32.add": 5, "0x0b": 0}, "call_cost": 0, "frame_slot_cost": 0}`))
+
+	tests := []struct {
+		args   []string
+		code   int
+		stdout string
+		stderr string
+	}{
+		{[]string{"--invoke", "add", "--arg", "i32:1", "--arg", "i32:-3", module}, exitOK, "-2\n", "gas used: 0\n"},
+		{[]string{"--invoke", "add", "--arg", "i32:0xffffffff", "--arg", "i32:2", "--gas-limit", "100", module}, exitOK, "1\n", "gas used: 3\n"},
+		{[]string{"--invoke", "add", "--arg", "i32:1", "--arg", "i32:2", "--gas-policy", policy, module}, exitOK, "3\n", "gas used: 5\n"},
+		{[]string{"--invoke", "half", "--arg", "f64:2.5", module}, exitOK, "1.25\n", ""},
+		{[]string{"--invoke", "add", "--arg", "i32:1", "--arg", "i32:2", "--gas-limit", "2", module}, exitTrap, "", "trap: out of gas\n"},
+		{[]string{"--invoke", "trap", module}, exitTrap, "", "trap: unreachable\n"},
+		{[]string{"--invoke", "add", "--arg", "i32:1", "--arg", "f32:2", module}, exitUsage, "", "argument 1: expect i32, got f32\n"},
+		{[]string{"--invoke", "add", "--arg", "i32:1", module}, exitUsage, "", "expect 2 arguments, got 1\n"},
+		{[]string{"--invoke", "missing", module}, exitUsage, "", "export missing not found\n"},
+		{[]string{"--invoke", "add", "--arg", "u8:1", module}, exitUsage, "", "unknown type u8"},
+		{[]string{"inspect", module}, exitOK, "", ""},
+		{[]string{"inspect"}, exitUsage, "", "usage: vertexvm inspect"},
+		{[]string{"wast", script}, exitTrap, script + ":line 3: assert_return: expect i32:2, got i32:1\n" +
+			script + ": 3 commands, 1 failed\n", ""},
+		{[]string{"wast"}, exitUsage, "", "usage: vertexvm wast"},
+		{[]string{"debug"}, exitUsage, "", "usage: vertexvm debug"},
+	}
+	for _, test := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(test.args, &stdout, &stderr)
+		if test.args[0] == "inspect" && code == exitOK {
+			if !strings.Contains(stdout.String(), " - func[1] -> \"half\"\n") {
+				t.Errorf("Expect export listing in inspect output, got %s", stdout.String())
+			}
+			continue
+		}
+		if code != test.code || stdout.String() != test.stdout || !strings.Contains(stderr.String(), test.stderr) {
+			t.Errorf("%v: expect exit %d %q %q, got %d %q %q", test.args, test.code, test.stdout, test.stderr,
+				code, stdout.String(), stderr.String())
+		}
+	}
+}
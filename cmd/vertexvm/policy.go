@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/vm"
+)
+
+// policyFile overrides the costs of vm.NewDefaultGasPolicy, absent fields keep their default
+type policyFile struct {
+	DefaultOpCost     *uint64           `json:"default_op_cost"` // applied before OpCosts to every opcode
+	OpCosts           map[string]uint64 `json:"op_costs"`        // keyed by mnemonic or opcode byte, such as "i32.add" or "0x6a"
+	PageCost          *uint64           `json:"page_cost"`
+	HostCallCost      *uint64           `json:"host_call_cost"`
+	HostCallCosts     map[string]uint64 `json:"host_call_costs"` // keyed by "module.name"
+	BrTableTargetCost *uint64           `json:"br_table_target_cost"`
+	MemCopyWordCost   *uint64           `json:"mem_copy_word_cost"`
+	CallCost          *uint64           `json:"call_cost"`
+	FrameSlotCost     *uint64           `json:"frame_slot_cost"`
+	RefundQuotient    *uint64           `json:"refund_quotient"`
+}
+
+func loadGasPolicy(path string) (*vm.TableGasPolicy, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var f policyFile
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, fmt.Errorf("gas policy %s: %v", path, err)
+	}
+	p, err := f.policy()
+	if err != nil {
+		return nil, fmt.Errorf("gas policy %s: %v", path, err)
+	}
+	return p, nil
+}
+
+func (f *policyFile) policy() (*vm.TableGasPolicy, error) {
+	p := vm.NewDefaultGasPolicy()
+	if f.DefaultOpCost != nil {
+		for i := range p.OpCosts {
+			p.OpCosts[i] = *f.DefaultOpCost
+		}
+	}
+	for key, cost := range f.OpCosts {
+		op, ok := opcode.Lookup(key)
+		if !ok {
+			code, err := strconv.ParseUint(key, 0, 8)
+			if err != nil {
+				return nil, fmt.Errorf("invalid opcode %q", key)
+			}
+			op = opcode.Opcode(code)
+		}
+		p.OpCosts[op] = cost
+	}
+	for name, cost := range f.HostCallCosts {
+		p.HostCallCosts[name] = cost
+	}
+	set := func(dst *uint64, src *uint64) {
+		if src != nil {
+			*dst = *src
+		}
+	}
+	set(&p.PageCost, f.PageCost)
+	set(&p.HostCallCost, f.HostCallCost)
+	set(&p.BrTableTargetCost, f.BrTableTargetCost)
+	set(&p.MemCopyWordCost, f.MemCopyWordCost)
+	set(&p.CallCost, f.CallCost)
+	set(&p.FrameSlotCost, f.FrameSlotCost)
+	set(&p.RefundQuotient, f.RefundQuotient)
+	return p, nil
+}
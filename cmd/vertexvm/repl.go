@@ -0,0 +1,196 @@
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/vertexdlt/vertexvm/vm"
+)
+
+const help = `commands:
+  run <export|index> [args...]   invoke a function, stopping on breakpoints
+  start <export|index> [args...] invoke a function, pausing on its first instruction
+  break <index> <offset>         set a breakpoint
+  delete <index> <offset>        remove a breakpoint
+  step, s                        execute one instruction
+  continue, c                    run to the next breakpoint or the end
+  abort                          abort the paused invocation
+  backtrace, bt                  print the call frames
+  locals [frame]                 print params and locals of a frame, innermost by default
+  stack                          print the operand stack of the innermost frame
+  globals                        print globals
+  mem <offset> <length>          dump linear memory
+  quit, q                        exit`
+
+// repl is the step debugger of the debug subcommand
+type repl struct {
+	debugger *vm.Debugger
+	machine  *vm.VM
+	out      io.Writer
+}
+
+// newREPL instantiates code for debugging with a REPL writing to out, the
+// module can be stepped up to its first host call
+func newREPL(code []byte, out io.Writer) (*repl, error) {
+	machine, err := vm.NewVM(code, &vm.FreeGasPolicy{}, &vm.Gas{}, &stubResolver{})
+	if err != nil {
+		return nil, err
+	}
+	return &repl{debugger: vm.NewDebugger(machine), machine: machine, out: out}, nil
+}
+
+func (r *repl) run(in io.Reader) {
+	scanner := bufio.NewScanner(in)
+	fmt.Fprint(r.out, "(debug) ")
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) > 0 {
+			if fields[0] == "quit" || fields[0] == "q" {
+				return
+			}
+			if err := r.exec(fields[0], fields[1:]); err != nil {
+				fmt.Fprintln(r.out, "error:", err)
+			}
+		}
+		fmt.Fprint(r.out, "(debug) ")
+	}
+}
+
+func parseInts(args []string) ([]int, error) {
+	ints := make([]int, len(args))
+	for i, arg := range args {
+		v, err := strconv.ParseInt(arg, 0, 64)
+		if err != nil {
+			return nil, err
+		}
+		ints[i] = int(v)
+	}
+	return ints, nil
+}
+
+func (r *repl) function(name string) (uint64, error) {
+	if fidx, ok := r.machine.GetFunctionIndex(name); ok {
+		return fidx, nil
+	}
+	fidx, err := strconv.ParseUint(name, 0, 32)
+	if err != nil {
+		return 0, fmt.Errorf("unknown function %s", name)
+	}
+	return fidx, nil
+}
+
+func (r *repl) exec(cmd string, args []string) error {
+	var (
+		stop *vm.DebugStop
+		err  error
+	)
+	switch cmd {
+	case "help", "h":
+		fmt.Fprintln(r.out, help)
+	case "run", "start":
+		if len(args) == 0 {
+			return fmt.Errorf("missing function")
+		}
+		fidx, err := r.function(args[0])
+		if err != nil {
+			return err
+		}
+		params := make([]uint64, len(args)-1)
+		for i, arg := range args[1:] {
+			v, err := strconv.ParseInt(arg, 0, 64)
+			if err != nil {
+				return err
+			}
+			params[i] = uint64(v)
+		}
+		if cmd == "run" {
+			stop, err = r.debugger.Start(fidx, params...)
+		} else {
+			stop, err = r.debugger.StartPaused(fidx, params...)
+		}
+		if err != nil {
+			return err
+		}
+	case "break", "delete":
+		ints, err := parseInts(args)
+		if err != nil || len(ints) != 2 {
+			return fmt.Errorf("usage: %s <index> <offset>", cmd)
+		}
+		if cmd == "break" {
+			r.debugger.SetBreakpoint(ints[0], ints[1])
+		} else {
+			r.debugger.ClearBreakpoint(ints[0], ints[1])
+		}
+	case "step", "s":
+		stop, err = r.debugger.Step()
+	case "continue", "c":
+		stop, err = r.debugger.Continue()
+	case "abort":
+		stop, err = r.debugger.Abort()
+	case "backtrace", "bt":
+		frames := r.debugger.Frames()
+		for i := len(frames) - 1; i >= 0; i-- {
+			fmt.Fprintf(r.out, "#%d func %d offset %d %s\n", len(frames)-1-i, frames[i].FunctionIndex(), frames[i].IP(), frames[i].Function().Name)
+		}
+	case "locals":
+		frames := r.debugger.Frames()
+		if len(frames) == 0 {
+			return vm.ErrDebuggerNotStarted
+		}
+		n := 0
+		if len(args) > 0 {
+			if n, err = strconv.Atoi(args[0]); err != nil || n < 0 || n >= len(frames) {
+				return fmt.Errorf("invalid frame %s", args[0])
+			}
+		}
+		r.printValues(r.debugger.Locals(frames[len(frames)-1-n]))
+	case "stack":
+		r.printValues(r.debugger.Stack())
+	case "globals":
+		r.printValues(r.debugger.Globals())
+	case "mem":
+		ints, err := parseInts(args)
+		if err != nil || len(ints) != 2 {
+			return fmt.Errorf("usage: mem <offset> <length>")
+		}
+		b, err := r.debugger.Memory(ints[0], ints[1])
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(r.out, hex.Dump(b))
+	default:
+		return fmt.Errorf("unknown command %s, try help", cmd)
+	}
+	if err != nil {
+		return err
+	}
+	if stop != nil {
+		r.printStop(stop)
+	}
+	return nil
+}
+
+func (r *repl) printValues(values []uint64) {
+	for i, v := range values {
+		fmt.Fprintf(r.out, "[%d] %d (0x%x)\n", i, v, v)
+	}
+}
+
+func (r *repl) printStop(stop *vm.DebugStop) {
+	switch stop.Reason {
+	case vm.StopDone:
+		if stop.Err != nil {
+			fmt.Fprintf(r.out, "trap: %v\n", stop.Err)
+		} else {
+			fmt.Fprintf(r.out, "returned %d (0x%x)\n", stop.Result, stop.Result)
+		}
+	case vm.StopBreakpoint:
+		fmt.Fprintf(r.out, "breakpoint func %d offset %d: %s\n", stop.Frame.FunctionIndex(), stop.Frame.IP(), stop.Op)
+	case vm.StopStep:
+		fmt.Fprintf(r.out, "func %d offset %d: %s\n", stop.Frame.FunctionIndex(), stop.Frame.IP(), stop.Op)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestREPL(t *testing.T) {
+	tests := []struct {
+		name   string
+		module string
+		script string
+		output string
+	}{
+		{"step", "call.wat", "start calc\nbt\ns\nstep\nstack\nabort\nstep\n", `(debug) func 2 offset 0: i32.const
+(debug) #0 func 2 offset 0 
+(debug) func 2 offset 2: i32.const
+(debug) func 2 offset 4: call
+(debug) [0] 5 (0x5)
+[1] 10 (0xa)
+(debug) trap: execution aborted
+(debug) error: debugger: no execution in progress
+(debug) `},
+		{"breakpoint", "call.wat", "break 1 4\nrun calc\nbt\nlocals\nlocals 1\nlocals 2\nc\n", `(debug) (debug) breakpoint func 1 offset 4: i32.add
+(debug) #0 func 1 offset 4 
+#1 func 2 offset 5 
+(debug) [0] 5 (0x5)
+[1] 10 (0xa)
+(debug) (debug) error: invalid frame 2
+(debug) returned 16 (0x10)
+(debug) `},
+		{"delete breakpoint", "call.wat", "break 1 4\ndelete 0x1 0x4\nrun calc\n", `(debug) (debug) (debug) returned 16 (0x10)
+(debug) `},
+		{"arguments", "call.wat", "run add 2 -3\nrun 1 0x10 0x20\n", `(debug) returned 4294967295 (0xffffffff)
+(debug) returned 48 (0x30)
+(debug) `},
+		{"inspect", "mem_access.wat", "globals\nmem 131068 4\nmem 131068 8\nrun failed_access\n", `(debug) [0] 66560 (0x10400)
+[1] 66560 (0x10400)
+[2] 1024 (0x400)
+(debug) 00000000  00 00 00 00                                       |....|
+(debug) error: out of bounds memory access
+(debug) trap: out of bounds memory access
+(debug) `},
+		{"usage", "call.wat", "\nbogus\nbreak 1\nmem x 4\nrun\nrun missing\nrun add x\nlocals\n", `(debug) (debug) error: unknown command bogus, try help
+(debug) error: usage: break <index> <offset>
+(debug) error: usage: mem <offset> <length>
+(debug) error: missing function
+(debug) error: unknown function missing
+(debug) error: strconv.ParseInt: parsing "x": invalid syntax
+(debug) error: debugger: no execution in progress
+(debug) `},
+		{"help and quit", "call.wat", "help\nq\nrun calc\n", "(debug) " + help + "\n(debug) "},
+	}
+	for _, test := range tests {
+		code, err := readModule("../../vm/test_data/" + test.module)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		r, err := newREPL(code, &out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.run(strings.NewReader(test.script))
+		if out.String() != test.output {
+			t.Errorf("%s: expect output\n%s\ngot\n%s", test.name, test.output, out.String())
+		}
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// valueList collects repeated typed flag values
+type valueList []vm.Value
+
+func (l *valueList) String() string {
+	values := make([]string, len(*l))
+	for i, v := range *l {
+		values[i] = v.String()
+	}
+	return strings.Join(values, " ")
+}
+
+func (l *valueList) Set(s string) error {
+	v, err := parseValue(s)
+	if err != nil {
+		return err
+	}
+	*l = append(*l, v)
+	return nil
+}
+
+// check matches the number of values against the function params
+func (l valueList) check(params []wasm.ValueType) error {
+	if len(l) != len(params) {
+		return fmt.Errorf("expect %d arguments, got %d", len(params), len(l))
+	}
+	return nil
+}
+
+// parseValue parses a type:value pair, integers may be signed, unsigned or hex
+func parseValue(s string) (vm.Value, error) {
+	parts := strings.SplitN(s, ":", 2)
+	if len(parts) != 2 {
+		return vm.Value{}, fmt.Errorf("invalid argument %q, expect type:value", s)
+	}
+	var (
+		v   vm.Value
+		err error
+	)
+	switch parts[0] {
+	case "i32":
+		var i uint64
+		i, err = parseInt(parts[1], 32)
+		v = vm.I32(int32(i))
+	case "i64":
+		var i uint64
+		i, err = parseInt(parts[1], 64)
+		v = vm.I64(int64(i))
+	case "f32":
+		var f float64
+		f, err = strconv.ParseFloat(parts[1], 32)
+		v = vm.F32(float32(f))
+	case "f64":
+		var f float64
+		f, err = strconv.ParseFloat(parts[1], 64)
+		v = vm.F64(f)
+	default:
+		return vm.Value{}, fmt.Errorf("invalid argument %q, unknown type %s", s, parts[0])
+	}
+	if err != nil {
+		return vm.Value{}, fmt.Errorf("invalid argument %q: %v", s, err)
+	}
+	return v, nil
+}
+
+func parseInt(s string, bitSize int) (uint64, error) {
+	if strings.HasPrefix(s, "-") {
+		i, err := strconv.ParseInt(s, 0, bitSize)
+		return uint64(i), err
+	}
+	return strconv.ParseUint(s, 0, bitSize)
+}
+
+// formatValue prints integers as signed and floats in the shortest exact form
+func formatValue(v vm.Value) string {
+	switch v.Type {
+	case wasm.ValueTypeI32:
+		return strconv.FormatInt(int64(v.I32()), 10)
+	case wasm.ValueTypeI64:
+		return strconv.FormatInt(v.I64(), 10)
+	case wasm.ValueTypeF32:
+		return strconv.FormatFloat(float64(v.F32()), 'g', -1, 32)
+	case wasm.ValueTypeF64:
+		return strconv.FormatFloat(v.F64(), 'g', -1, 64)
+	}
+	return strconv.FormatUint(v.Bits(), 10)
+}
@@ -0,0 +1,205 @@
+// Package crypto is a host module running hash functions and signature
+// verifications natively for contracts importing the "crypto" module, which
+// would burn much more gas computing them in WebAssembly.
+//
+// Pointers and lengths are i32, digests are written to out:
+//
+//	sha256(data, data_len, out)       32 bytes
+//	keccak256(data, data_len, out)    32 bytes, the original Keccak padding used by Ethereum
+//	blake2b256(data, data_len, out)   32 bytes, unkeyed
+//	ripemd160(data, data_len, out)    20 bytes
+//	ed25519_verify(sig, public_key, msg, msg_len) -> 1 if valid, 0 otherwise
+//	secp256k1_verify(hash, sig, public_key, public_key_len) -> 1 if valid, 0 otherwise
+//
+// ed25519 signatures are 64 bytes and public keys 32 bytes. secp256k1
+// signatures are r || s of 32 bytes each over a 32 byte hash, public keys are
+// compressed SEC 1 points of 33 bytes or uncompressed ones of 65 bytes.
+//
+// Each function burns the cost given by the module GasPolicy for its input
+// length on top of the host call cost and the memory copies charged by the VM
+// gas policy.
+package crypto
+
+import (
+	"crypto/sha256"
+	"errors"
+
+	"github.com/vertexdlt/vertexvm/vm"
+)
+
+// ModuleName is the module name of the host imports
+const ModuleName = "crypto"
+
+// ErrWrongNumberOfArgs is returned when an import does not have the host function arity
+var ErrWrongNumberOfArgs = errors.New("crypto: wrong number of arguments")
+
+// GasPolicy prices the functions of the module
+type GasPolicy interface {
+	// GetCostForCrypto is charged before function name runs over bytes of input
+	GetCostForCrypto(name string, bytes int) uint64
+}
+
+// Cost is a base cost plus a cost per started 32-byte word of input
+type Cost struct {
+	Base    uint64
+	PerWord uint64
+}
+
+// TableGasPolicy prices functions by name, functions not listed are free
+type TableGasPolicy map[string]Cost
+
+// GetCostForCrypto returns the base cost of name plus its cost per input word
+func (p TableGasPolicy) GetCostForCrypto(name string, bytes int) uint64 {
+	cost := p[name]
+	return cost.Base + (uint64(bytes)+31)/32*cost.PerWord
+}
+
+// DefaultGasPolicy has costs relative to i32.add in the default VM gas table,
+// derived from BenchmarkGasCost: the base cost is the gas worth of an empty
+// input and the cost per word a 32nd of the increase on a 1 KiB input, rounded
+// up from the slowest of three runs. secp256k1_verify always reads a 32 byte hash.
+//
+//	                   empty   1 KiB
+//	sha256                 5      42
+//	keccak256            215    2199
+//	blake2b256            22     169
+//	ripemd160             32     586
+//	ed25519_verify      4229    4359
+//	secp256k1_verify  159096  153956
+var DefaultGasPolicy = TableGasPolicy{
+	"sha256":           {Base: 6, PerWord: 2},
+	"keccak256":        {Base: 220, PerWord: 63},
+	"blake2b256":       {Base: 23, PerWord: 5},
+	"ripemd160":        {Base: 32, PerWord: 18},
+	"ed25519_verify":   {Base: 4300, PerWord: 5},
+	"secp256k1_verify": {Base: 160000},
+}
+
+// Module resolves the host functions of the crypto module
+type Module struct {
+	policy GasPolicy
+}
+
+// NewModule creates a module charging gas with policy
+func NewModule(policy GasPolicy) *Module {
+	return &Module{policy: policy}
+}
+
+// GetFunction returns the host function name of the crypto module
+func (m *Module) GetFunction(module, name string) vm.HostFunction {
+	if module != ModuleName {
+		return nil
+	}
+	switch name {
+	case "sha256":
+		return m.hash(name, func(data []byte) []byte {
+			sum := sha256.Sum256(data)
+			return sum[:]
+		})
+	case "keccak256":
+		return m.hash(name, func(data []byte) []byte {
+			sum := keccak256(data, 0x01)
+			return sum[:]
+		})
+	case "blake2b256":
+		return m.hash(name, func(data []byte) []byte {
+			sum := blake2b256(data)
+			return sum[:]
+		})
+	case "ripemd160":
+		return m.hash(name, func(data []byte) []byte {
+			sum := ripemd160(data)
+			return sum[:]
+		})
+	case "ed25519_verify":
+		return arity(4, m.ed25519Verify)
+	case "secp256k1_verify":
+		return arity(4, m.secp256k1Verify)
+	}
+	return nil
+}
+
+// arity guards fn against imports declared with another number of params
+func arity(n int, fn vm.HostFunction) vm.HostFunction {
+	return func(machine *vm.VM, args ...uint64) (uint64, error) {
+		if len(args) != n {
+			return 0, ErrWrongNumberOfArgs
+		}
+		return fn(machine, args...)
+	}
+}
+
+func (m *Module) hash(name string, sum func([]byte) []byte) vm.HostFunction {
+	return arity(3, func(machine *vm.VM, args ...uint64) (uint64, error) {
+		if err := machine.BurnGas(m.policy.GetCostForCrypto(name, int(uint32(args[1])))); err != nil {
+			return 0, err
+		}
+		if err := machine.BurnMemCopyGas(int(uint32(args[1]))); err != nil {
+			return 0, err
+		}
+		data, err := machine.Memory().ReadBytes(uint32(args[0]), uint32(args[1]))
+		if err != nil {
+			return 0, err
+		}
+		digest := sum(data)
+		if err := machine.BurnMemCopyGas(len(digest)); err != nil {
+			return 0, err
+		}
+		return 0, machine.Memory().WriteBytes(uint32(args[2]), digest)
+	})
+}
+
+func (m *Module) ed25519Verify(machine *vm.VM, args ...uint64) (uint64, error) {
+	if err := machine.BurnGas(m.policy.GetCostForCrypto("ed25519_verify", int(uint32(args[3])))); err != nil {
+		return 0, err
+	}
+	if err := machine.BurnMemCopyGas(Ed25519SignatureSize + Ed25519PublicKeySize + int(uint32(args[3]))); err != nil {
+		return 0, err
+	}
+	sig, err := machine.Memory().ReadBytes(uint32(args[0]), Ed25519SignatureSize)
+	if err != nil {
+		return 0, err
+	}
+	publicKey, err := machine.Memory().ReadBytes(uint32(args[1]), Ed25519PublicKeySize)
+	if err != nil {
+		return 0, err
+	}
+	msg, err := machine.Memory().ReadBytes(uint32(args[2]), uint32(args[3]))
+	if err != nil {
+		return 0, err
+	}
+	return boolResult(ed25519Verify(publicKey, msg, sig)), nil
+}
+
+func (m *Module) secp256k1Verify(machine *vm.VM, args ...uint64) (uint64, error) {
+	if err := machine.BurnGas(m.policy.GetCostForCrypto("secp256k1_verify", Secp256k1HashSize)); err != nil {
+		return 0, err
+	}
+	length := uint32(args[3])
+	if length != Secp256k1CompressedPublicKeySize && length != Secp256k1UncompressedPublicKeySize {
+		return 0, nil
+	}
+	if err := machine.BurnMemCopyGas(Secp256k1HashSize + Secp256k1SignatureSize + int(length)); err != nil {
+		return 0, err
+	}
+	hash, err := machine.Memory().ReadBytes(uint32(args[0]), Secp256k1HashSize)
+	if err != nil {
+		return 0, err
+	}
+	sig, err := machine.Memory().ReadBytes(uint32(args[1]), Secp256k1SignatureSize)
+	if err != nil {
+		return 0, err
+	}
+	publicKey, err := machine.Memory().ReadBytes(uint32(args[2]), length)
+	if err != nil {
+		return 0, err
+	}
+	return boolResult(secp256k1Verify(publicKey, hash, sig)), nil
+}
+
+func boolResult(ok bool) uint64 {
+	if ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,274 @@
+package crypto
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+func unhex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+	return b
+}
+
+func TestHashes(t *testing.T) {
+	tests := []struct {
+		name  string
+		sum   func([]byte) []byte
+		input string
+		hash  string
+	}{
+		{"sha256", func(b []byte) []byte { s := sha256.Sum256(b); return s[:] }, "", "e3b0c44298fc1c149afbf4c8996fb92427ae41e4649b934ca495991b7852b855"},
+		{"sha256", func(b []byte) []byte { s := sha256.Sum256(b); return s[:] }, "abc", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"sha256", func(b []byte) []byte { s := sha256.Sum256(b); return s[:] }, strings.Repeat("a", 64), "ffe054fe7ae0cb6dc65c3af9b61d5209f439851db43d0ba5997337df154668eb"},
+		{"keccak256", func(b []byte) []byte { s := keccak256(b, 0x01); return s[:] }, "", "c5d2460186f7233c927e7db2dcc703c0e500b653ca82273b7bfad8045d85a470"},
+		{"keccak256", func(b []byte) []byte { s := keccak256(b, 0x01); return s[:] }, "abc", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{"sha3-256", func(b []byte) []byte { s := keccak256(b, 0x06); return s[:] }, "abc", "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+		{"sha3-256", func(b []byte) []byte { s := keccak256(b, 0x06); return s[:] }, strings.Repeat("a", 136), "3fc5559f14db8e453a0a3091edbd2bc25e11528d81c66fa570a4efdcc2695ee1"},
+		{"sha3-256", func(b []byte) []byte { s := keccak256(b, 0x06); return s[:] }, strings.Repeat("a", 200), "cce34485baf2bf2aca99b94833892a4f52896d3d153f7b840cc4f9fe695f1387"},
+		{"blake2b256", func(b []byte) []byte { s := blake2b256(b); return s[:] }, "", "0e5751c026e543b2e8ab2eb06099daa1d1e5df47778f7787faab45cdf12fe3a8"},
+		{"blake2b256", func(b []byte) []byte { s := blake2b256(b); return s[:] }, "abc", "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{"blake2b256", func(b []byte) []byte { s := blake2b256(b); return s[:] }, strings.Repeat("a", 128), "ae2aa48507885c4c950fb809b2076f959cde9f8ea6da260d9a3587df33dac450"},
+		{"blake2b256", func(b []byte) []byte { s := blake2b256(b); return s[:] }, strings.Repeat("a", 300), "3c1292de00a518e36823f9ff908ac2da46be38718c018713403461df077e15f6"},
+		{"ripemd160", func(b []byte) []byte { s := ripemd160(b); return s[:] }, "", "9c1185a5c5e9fc54612808977ee8f548b2258d31"},
+		{"ripemd160", func(b []byte) []byte { s := ripemd160(b); return s[:] }, "abc", "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc"},
+		{"ripemd160", func(b []byte) []byte { s := ripemd160(b); return s[:] }, strings.Repeat("a", 60), "3c3cebb72c9dc00eab36a5c26e6dd0cb2aac2fcd"},
+		{"ripemd160", func(b []byte) []byte { s := ripemd160(b); return s[:] }, strings.Repeat("a", 130), "015233d5ca773378a134fd3dbc4b3fa2ab0d7850"},
+	}
+	for _, test := range tests {
+		if sum := test.sum([]byte(test.input)); !bytes.Equal(sum, unhex(test.hash)) {
+			t.Errorf("%s(%q): expect %s, got %x", test.name, test.input, test.hash, sum)
+		}
+	}
+}
+
+func TestEd25519Verify(t *testing.T) {
+	tests := []struct {
+		publicKey, msg, sig string
+	}{
+		// RFC 8032 test 1 and 2
+		{
+			"d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a", "",
+			"e5564300c360ac729086e2cc806e828a84877f1eb8e5d974d873e065224901555fb8821590a33bacc61e39701cf9b46bd25bf5f0595bbe24655141438e7a100b",
+		},
+		{
+			"3d4017c3e843895a92b70aa74d1b7ebc9c982ccf2ec4968cc0cd55f12af4660c", "72",
+			"92a009a9f0d4cab8720e820b5f642540a2b27b5416503f8fb3762223ebdb69da085ac1e43e15996e458f3613d0f11d8c387b2eaeb4302aeeb00d291612bb0c00",
+		},
+		// signed with openssl
+		{
+			"4063d2f8d4e29bc561bd677341da40a26469e06685748c78c3b8a3436e4fe0e3", hex.EncodeToString([]byte("vertexvm ed25519")),
+			"5def479c7f85409301f33949fae83f2a56fabc6d29d112b1a62e9089afa98281660d98c55ee7b842739ad1b41ece26275ef573f9d80ebf346ca6a0e91892a403",
+		},
+	}
+	for _, test := range tests {
+		publicKey, msg, sig := unhex(test.publicKey), unhex(test.msg), unhex(test.sig)
+		if !ed25519Verify(publicKey, msg, sig) {
+			t.Errorf("Expect signature %s of %s to be valid", test.sig, test.msg)
+		}
+		tampered := append(append([]byte(nil), msg...), 0)
+		if ed25519Verify(publicKey, tampered, sig) {
+			t.Errorf("Expect signature %s of a tampered message to be invalid", test.sig)
+		}
+		sig[63] |= 0x80 // S above the group order
+		if ed25519Verify(publicKey, msg, sig) {
+			t.Errorf("Expect signature %s with a non canonical S to be invalid", test.sig)
+		}
+	}
+}
+
+// secp256k1 signature of sha256("vertexvm") generated with openssl
+var (
+	secpHash         = "5737c6243c434afd1ed9c89e844133891e66dfe14dd116194e87f608a225a7bf"
+	secpSig          = "4b933d924ccd7533b816861adca495b07aebb81489b79d67f30f80e4411e025efe458cd1eea38b480846e7c33ee788c82715f93040fb96b622c13776bc0f1d01"
+	secpCompressed   = "029bcc6d87c8ae2d6fba66a75ea383cf19ab8d41711b8be270e4a23ac0ce060fee"
+	secpUncompressed = "049bcc6d87c8ae2d6fba66a75ea383cf19ab8d41711b8be270e4a23ac0ce060feeb9095ab523f50daa9a6dc0ff9e2a8b675d365d1b491b0dd4caae4ed267548502"
+)
+
+func TestSecp256k1Verify(t *testing.T) {
+	hash, sig := unhex(secpHash), unhex(secpSig)
+	for _, key := range []string{secpCompressed, secpUncompressed} {
+		if !secp256k1Verify(unhex(key), hash, sig) {
+			t.Errorf("Expect the signature to be valid for %s", key)
+		}
+	}
+	invalid := []struct {
+		name           string
+		key, hash, sig []byte
+	}{
+		{"tampered hash", unhex(secpCompressed), append([]byte{hash[0] ^ 1}, hash[1:]...), sig},
+		{"other parity", append([]byte{3}, unhex(secpCompressed)[1:]...), hash, sig},
+		{"point off the curve", append(unhex(secpUncompressed)[:64], 0), hash, sig},
+		{"zero r", unhex(secpCompressed), hash, append(make([]byte, 32), sig[32:]...)},
+		{"s above the order", unhex(secpCompressed), hash, append(sig[:32:32], bytes.Repeat([]byte{0xff}, 32)...)},
+	}
+	for _, test := range invalid {
+		if secp256k1Verify(test.key, test.hash, test.sig) {
+			t.Errorf("Expect the signature with %s to be invalid", test.name)
+		}
+	}
+}
+
+func TestModule(t *testing.T) {
+	code, err := wat.Compile([]byte(`(module
+  (import "crypto" "sha256" (func $sha256 (param i32 i32 i32)))
+  (import "crypto" "keccak256" (func $keccak256 (param i32 i32 i32)))
+  (import "crypto" "blake2b256" (func $blake2b256 (param i32 i32 i32)))
+  (import "crypto" "ripemd160" (func $ripemd160 (param i32 i32 i32)))
+  (import "crypto" "ed25519_verify" (func $ed25519 (param i32 i32 i32 i32) (result i32)))
+  (import "crypto" "secp256k1_verify" (func $secp256k1 (param i32 i32 i32 i32) (result i32)))
+  (memory 1)
+  (data (i32.const 0) "abc")
+  (func (export "sha256") (call $sha256 (i32.const 0) (i32.const 3) (i32.const 32)))
+  (func (export "keccak256") (call $keccak256 (i32.const 0) (i32.const 3) (i32.const 32)))
+  (func (export "blake2b256") (call $blake2b256 (i32.const 0) (i32.const 3) (i32.const 32)))
+  (func (export "ripemd160") (call $ripemd160 (i32.const 0) (i32.const 3) (i32.const 32)))
+  (func (export "ed25519_verify") (result i32) (call $ed25519 (i32.const 256) (i32.const 320) (i32.const 352) (i32.const 16)))
+  (func (export "secp256k1_verify") (result i32) (call $secp256k1 (i32.const 64) (i32.const 96) (i32.const 160) (i32.const 33)))
+  (func (export "oob") (call $keccak256 (i32.const 65535) (i32.const 2) (i32.const 0))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	gas := &vm.Gas{Limit: 1 << 20}
+	machine, err := vm.NewVM(code, &vm.FreeGasPolicy{}, gas, NewModule(DefaultGasPolicy))
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory := machine.Memory()
+	memory.WriteBytes(64, unhex(secpHash))
+	memory.WriteBytes(96, unhex(secpSig))
+	memory.WriteBytes(160, unhex(secpCompressed))
+	memory.WriteBytes(256, unhex("5def479c7f85409301f33949fae83f2a56fabc6d29d112b1a62e9089afa98281660d98c55ee7b842739ad1b41ece26275ef573f9d80ebf346ca6a0e91892a403"))
+	memory.WriteBytes(320, unhex("4063d2f8d4e29bc561bd677341da40a26469e06685748c78c3b8a3436e4fe0e3"))
+	memory.WriteBytes(352, []byte("vertexvm ed25519"))
+
+	hashes := []struct {
+		name, sum string
+	}{
+		{"sha256", "ba7816bf8f01cfea414140de5dae2223b00361a396177a9cb410ff61f20015ad"},
+		{"keccak256", "4e03657aea45a94fc7d47ba826c8d667c0d1e6e33a64a036ec44f58fa12d6c45"},
+		{"blake2b256", "bddd813c634239723171ef3fee98579b94964e3bb1cb3e427262c8c068d52319"},
+		{"ripemd160", "8eb208f7e05d987a9b044a8e98c6b087f15a0bfc"},
+	}
+	for _, test := range hashes {
+		used := gas.Used
+		if _, err := machine.InvokeByName(test.name); err != nil {
+			t.Fatalf("%s: %v", test.name, err)
+		}
+		expected := unhex(test.sum)
+		if sum, _ := memory.ReadBytes(32, uint32(len(expected))); !bytes.Equal(sum, expected) {
+			t.Errorf("Expect %s of abc to be %s, got %x", test.name, test.sum, sum)
+		}
+		if cost := DefaultGasPolicy.GetCostForCrypto(test.name, 3); gas.Used-used != cost {
+			t.Errorf("Expect %s of a word to cost %d, got %d", test.name, cost, gas.Used-used)
+		}
+	}
+
+	verifies := []struct {
+		name  string
+		bytes int
+	}{
+		{"ed25519_verify", 16},
+		{"secp256k1_verify", Secp256k1HashSize},
+	}
+	for _, test := range verifies {
+		used := gas.Used
+		if results, err := machine.InvokeByName(test.name); err != nil || results[0] != vm.I32(1) {
+			t.Errorf("%s: expect a valid signature, got %v %v", test.name, results, err)
+		}
+		if cost := DefaultGasPolicy.GetCostForCrypto(test.name, test.bytes); gas.Used-used != cost {
+			t.Errorf("Expect %s to cost %d, got %d", test.name, cost, gas.Used-used)
+		}
+	}
+
+	if _, err := machine.InvokeByName("oob"); err != vm.ErrOutOfBoundMemoryAccess {
+		t.Errorf("Expect %v, got %v", vm.ErrOutOfBoundMemoryAccess, err)
+	}
+}
+
+// nsPerGas runs a sub-benchmark timing a unit of gas of the default VM gas
+// policy on a run of i32.add
+func nsPerGas(b *testing.B) float64 {
+	code, err := wat.Compile([]byte(`(module (func (export "add") ` +
+		strings.Repeat("(drop (i32.add (i32.const 7) (i32.const 3)))", 1000) + `))`))
+	if err != nil {
+		b.Fatal(err)
+	}
+	gas := &vm.Gas{Limit: 1 << 62}
+	machine, err := vm.NewVM(code, vm.NewDefaultGasPolicy(), gas, NewModule(DefaultGasPolicy))
+	if err != nil {
+		b.Fatal(err)
+	}
+	fnIndex, _ := machine.GetFunctionIndex("add")
+	var unit float64
+	b.Run("i32.add", func(b *testing.B) {
+		used := gas.Used
+		start := time.Now()
+		for i := 0; i < b.N; i++ {
+			if _, err := machine.Invoke(fnIndex); err != nil {
+				b.Fatal(err)
+			}
+		}
+		unit = float64(time.Since(start).Nanoseconds()) / float64(gas.Used-used)
+		b.ReportMetric(unit, "ns/gas")
+	})
+	return unit
+}
+
+// BenchmarkGasCost reports the gas worth of the time each function takes on
+// empty and 1 KiB inputs. DefaultGasPolicy takes the empty input as the base
+// cost and a 32nd of the difference as the cost per word.
+func BenchmarkGasCost(b *testing.B) {
+	unit := nsPerGas(b)
+	seed := make([]byte, ed25519.SeedSize)
+	key := ed25519.NewKeyFromSeed(seed)
+	hash, sig, publicKey := unhex(secpHash), unhex(secpSig), unhex(secpCompressed)
+	benches := []struct {
+		name string
+		fn   func([]byte)
+	}{
+		{"sha256", func(data []byte) { sha256.Sum256(data) }},
+		{"keccak256", func(data []byte) { keccak256(data, 0x01) }},
+		{"blake2b256", func(data []byte) { blake2b256(data) }},
+		{"ripemd160", func(data []byte) { ripemd160(data) }},
+		{"ed25519_verify", nil},
+		{"secp256k1_verify", func([]byte) {
+			if !secp256k1Verify(publicKey, hash, sig) {
+				b.Fatal("invalid signature")
+			}
+		}},
+	}
+	for _, bench := range benches {
+		for _, size := range []int{0, 1024} {
+			data := make([]byte, size)
+			fn := bench.fn
+			if bench.name == "ed25519_verify" {
+				// sign outside of the timed loop
+				signature := ed25519.Sign(key, data)
+				fn = func(data []byte) {
+					if !ed25519Verify(key.Public().(ed25519.PublicKey), data, signature) {
+						b.Fatal("invalid signature")
+					}
+				}
+			}
+			b.Run(fmt.Sprintf("%s/%d", bench.name, size), func(b *testing.B) {
+				start := time.Now()
+				for i := 0; i < b.N; i++ {
+					fn(data)
+				}
+				b.ReportMetric(float64(time.Since(start).Nanoseconds())/float64(b.N)/unit, "gas/op")
+			})
+		}
+	}
+}
@@ -0,0 +1,18 @@
+package crypto
+
+import "crypto/ed25519"
+
+// Ed25519 key and signature sizes
+const (
+	Ed25519PublicKeySize = ed25519.PublicKeySize
+	Ed25519SignatureSize = ed25519.SignatureSize
+)
+
+// ed25519Verify checks the RFC 8032 signature sig of msg by publicKey,
+// signatures with S above the group order are rejected
+func ed25519Verify(publicKey, msg, sig []byte) bool {
+	if len(publicKey) != Ed25519PublicKeySize || len(sig) != Ed25519SignatureSize {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(publicKey), msg, sig)
+}
@@ -0,0 +1,210 @@
+package crypto
+
+import "math/big"
+
+// Secp256k1 sizes, public keys are compressed or uncompressed SEC 1 points
+const (
+	Secp256k1HashSize                  = 32
+	Secp256k1SignatureSize             = 64
+	Secp256k1CompressedPublicKeySize   = 33
+	Secp256k1UncompressedPublicKeySize = 65
+)
+
+// weierstrassPoint is an affine point of y^2 = x^3 + 7
+type weierstrassPoint struct {
+	x, y *big.Int
+}
+
+// jacobianPoint is the point (x / z^2, y / z^3) of y^2 = x^3 + 7, a zero z is
+// the point at infinity. Jacobian coordinates need a single inversion at the
+// end of a scalar multiplication instead of one per addition.
+type jacobianPoint struct {
+	x, y, z *big.Int
+}
+
+var (
+	secpP = bigFromHex("fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f")
+	secpN = bigFromHex("fffffffffffffffffffffffffffffffebaaedce6af48a03bbfd25e8cd0364141")
+	secpG = &weierstrassPoint{
+		bigFromHex("79be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"),
+		bigFromHex("483ada7726a3c4655da4fbfc0e1108a8fd17b448a68554199c47d08ffb10d4b8"),
+	}
+)
+
+// reduce sets z to z mod p
+func reduce(z *big.Int) *big.Int {
+	return z.Mod(z, secpP)
+}
+
+func bigFromHex(s string) *big.Int {
+	v, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid constant " + s)
+	}
+	return v
+}
+
+func (p *weierstrassPoint) jacobian() *jacobianPoint {
+	return &jacobianPoint{new(big.Int).Set(p.x), new(big.Int).Set(p.y), big.NewInt(1)}
+}
+
+func (p *jacobianPoint) infinity() bool {
+	return p.z.Sign() == 0
+}
+
+// affineX returns the affine x coordinate of a point other than infinity
+func (p *jacobianPoint) affineX() *big.Int {
+	zinv := new(big.Int).ModInverse(p.z, secpP)
+	zinv.Mul(zinv, zinv).Mod(zinv, secpP)
+	return zinv.Mul(p.x, zinv).Mod(zinv, secpP)
+}
+
+// double uses the dbl-2009-l formulas for a = 0
+func (p *jacobianPoint) double() *jacobianPoint {
+	if p.infinity() || p.y.Sign() == 0 {
+		return &jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+	a := reduce(new(big.Int).Mul(p.x, p.x))
+	b := reduce(new(big.Int).Mul(p.y, p.y))
+	c := reduce(new(big.Int).Mul(b, b))
+	// d = 2((x + b)^2 - a - c)
+	d := new(big.Int).Add(p.x, b)
+	d = reduce(d.Mul(d, d).Sub(d, a).Sub(d, c).Lsh(d, 1))
+	e := a.Mul(a, big.NewInt(3))
+	x := new(big.Int).Mul(e, e)
+	x = reduce(x.Sub(x, d).Sub(x, d))
+	y := new(big.Int).Sub(d, x)
+	y = reduce(y.Mul(y, e).Sub(y, c.Lsh(c, 3)))
+	z := new(big.Int).Mul(p.y, p.z)
+	z = reduce(z.Lsh(z, 1))
+	return &jacobianPoint{x, y, z}
+}
+
+// add uses the add-2007-bl formulas
+func (p *jacobianPoint) add(q *jacobianPoint) *jacobianPoint {
+	if p.infinity() {
+		return q
+	}
+	if q.infinity() {
+		return p
+	}
+	z1z1 := reduce(new(big.Int).Mul(p.z, p.z))
+	z2z2 := reduce(new(big.Int).Mul(q.z, q.z))
+	u1 := reduce(new(big.Int).Mul(p.x, z2z2))
+	u2 := reduce(new(big.Int).Mul(q.x, z1z1))
+	s1 := reduce(new(big.Int).Mul(p.y, q.z))
+	s1 = reduce(s1.Mul(s1, z2z2))
+	s2 := reduce(new(big.Int).Mul(q.y, p.z))
+	s2 = reduce(s2.Mul(s2, z1z1))
+	h := reduce(u2.Sub(u2, u1))
+	r := reduce(s2.Sub(s2, s1).Lsh(s2, 1))
+	if h.Sign() == 0 {
+		if r.Sign() == 0 {
+			return p.double()
+		}
+		return &jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	}
+	i := new(big.Int).Lsh(h, 1)
+	i = reduce(i.Mul(i, i))
+	j := reduce(new(big.Int).Mul(h, i))
+	v := reduce(u1.Mul(u1, i))
+	x := new(big.Int).Mul(r, r)
+	x = reduce(x.Sub(x, j).Sub(x, v).Sub(x, v))
+	y := new(big.Int).Sub(v, x)
+	y = reduce(y.Mul(y, r).Sub(y, s1.Mul(s1, j).Lsh(s1, 1)))
+	// z = ((z1 + z2)^2 - z1z1 - z2z2) h = 2 z1 z2 h
+	z := new(big.Int).Mul(p.z, q.z)
+	z = reduce(z.Lsh(z, 1).Mul(z, h))
+	return &jacobianPoint{x, y, z}
+}
+
+// mulAdd returns k1 p + k2 q sharing the doublings of both scalars
+func mulAdd(k1 *big.Int, p *weierstrassPoint, k2 *big.Int, q *weierstrassPoint) *jacobianPoint {
+	jp, jq := p.jacobian(), q.jacobian()
+	sum := jp.add(jq)
+	r := &jacobianPoint{new(big.Int), new(big.Int), new(big.Int)}
+	bits := k1.BitLen()
+	if k2.BitLen() > bits {
+		bits = k2.BitLen()
+	}
+	for i := bits - 1; i >= 0; i-- {
+		r = r.double()
+		switch {
+		case k1.Bit(i) == 1 && k2.Bit(i) == 1:
+			r = r.add(sum)
+		case k1.Bit(i) == 1:
+			r = r.add(jp)
+		case k2.Bit(i) == 1:
+			r = r.add(jq)
+		}
+	}
+	return r
+}
+
+// curveY returns the square root of x^3 + 7 if any
+func curveY(x *big.Int) (*big.Int, bool) {
+	rhs := new(big.Int).Exp(x, big.NewInt(3), secpP)
+	rhs.Add(rhs, big.NewInt(7)).Mod(rhs, secpP)
+	// p = 3 mod 4 so the root is rhs^((p+1)/4)
+	exp := new(big.Int).Add(secpP, big.NewInt(1))
+	y := new(big.Int).Exp(rhs, exp.Rsh(exp, 2), secpP)
+	return y, new(big.Int).Exp(y, big.NewInt(2), secpP).Cmp(rhs) == 0
+}
+
+// decodeSecp256k1PublicKey parses a point compressed with the parity of y in
+// the prefix 2 or 3, or uncompressed with the prefix 4
+func decodeSecp256k1PublicKey(b []byte) (*weierstrassPoint, bool) {
+	switch {
+	case len(b) == Secp256k1CompressedPublicKeySize && (b[0] == 2 || b[0] == 3):
+		x := new(big.Int).SetBytes(b[1:])
+		if x.Cmp(secpP) >= 0 {
+			return nil, false
+		}
+		y, ok := curveY(x)
+		if !ok {
+			return nil, false
+		}
+		if y.Bit(0) != uint(b[0]&1) {
+			y.Sub(secpP, y)
+		}
+		return &weierstrassPoint{x, y}, true
+	case len(b) == Secp256k1UncompressedPublicKeySize && b[0] == 4:
+		x := new(big.Int).SetBytes(b[1:33])
+		y := new(big.Int).SetBytes(b[33:])
+		if x.Cmp(secpP) >= 0 || y.Cmp(secpP) >= 0 {
+			return nil, false
+		}
+		if expected, ok := curveY(x); !ok || (expected.Cmp(y) != 0 && new(big.Int).Sub(secpP, expected).Cmp(y) != 0) {
+			return nil, false
+		}
+		return &weierstrassPoint{x, y}, true
+	}
+	return nil, false
+}
+
+// secp256k1Verify checks the ECDSA signature r || s of hash by publicKey,
+// signatures with a high s are accepted
+func secp256k1Verify(publicKey, hash, sig []byte) bool {
+	if len(hash) != Secp256k1HashSize || len(sig) != Secp256k1SignatureSize {
+		return false
+	}
+	q, ok := decodeSecp256k1PublicKey(publicKey)
+	if !ok {
+		return false
+	}
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if r.Sign() == 0 || s.Sign() == 0 || r.Cmp(secpN) >= 0 || s.Cmp(secpN) >= 0 {
+		return false
+	}
+	e := new(big.Int).SetBytes(hash)
+	w := new(big.Int).ModInverse(s, secpN)
+	u1 := e.Mul(e, w).Mod(e, secpN)
+	u2 := w.Mul(r, w).Mod(w, secpN)
+	x := mulAdd(u1, secpG, u2, q)
+	if x.infinity() {
+		return false
+	}
+	ax := x.affineX()
+	return ax.Mod(ax, secpN).Cmp(r) == 0
+}
@@ -0,0 +1,488 @@
+// Package env is a blockchain host environment for contracts importing the
+// "env" module. It gives contracts a key value storage of their own, events,
+// block and caller context, return data, revert and calls to other contracts.
+//
+// A contract is called by name with an exported function of type () -> ().
+// Pointers and lengths are i32, functions copying data out of the host take a
+// buffer and its capacity and return the length of the data, only copied when
+// it fits:
+//
+//	storage_read(key, key_len, buf, cap) -> len, -1 when not set
+//	storage_write(key, key_len, value, value_len)
+//	storage_delete(key, key_len)
+//	emit_event(topic, topic_len, data, data_len)
+//	block_height() -> i64
+//	block_timestamp() -> i64
+//	gas_left() -> i64
+//	caller(buf, cap) -> len
+//	address(buf, cap) -> len
+//	input(buf, cap) -> len
+//	set_return(data, data_len)
+//	revert(reason, reason_len)
+//	call_contract(address, address_len, method, method_len, input, input_len, gas i64) -> status
+//	return_data(buf, cap) -> len
+//
+// revert ends the call discarding its storage writes and events, its reason
+// becomes the return data. call_contract returns CallOK, CallReverted or
+// CallFailed and the return data of the callee is then read with return_data.
+// The callee runs with at most gas from the caller gas, 0 gives it all the
+// remaining gas.
+//
+// The VM gas policy charges each import as a host call of module "env" and the
+// bytes copied in and out of memory, DefaultHostCallCosts prices them for
+// vm.TableGasPolicy. A policy implementing StorageGasPolicy also prices the
+// bytes of the keys and values written by storage_write, NewDefaultGasPolicy
+// returns one with the default costs.
+package env
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// ModuleName is the module name of the host imports
+const ModuleName = "env"
+
+// DefaultMaxCallDepth is the default depth of nested contract calls
+const DefaultMaxCallDepth = 64
+
+// Statuses returned by call_contract
+const (
+	CallOK       = 0
+	CallReverted = 1
+	CallFailed   = 2
+)
+
+// Errors returned by Host
+var (
+	ErrUnknownContract = errors.New("env: unknown contract")
+	ErrCallDepth       = errors.New("env: call depth exceeded")
+)
+
+// DefaultHostCallCosts are costs for vm.TableGasPolicy.HostCallCosts pricing
+// storage and calls above the context getters
+var DefaultHostCallCosts = map[string]uint64{
+	"env.storage_read":   50,
+	"env.storage_write":  200,
+	"env.storage_delete": 100,
+	"env.emit_event":     50,
+	"env.call_contract":  100,
+}
+
+// DefaultStorageByteCost is charged per byte of key and value written by storage_write
+const DefaultStorageByteCost = 10
+
+// StorageGasPolicy is a gas policy pricing the bytes written to storage
+type StorageGasPolicy interface {
+	vm.GasPolicy
+	// GetCostForStorage is charged by storage_write for the bytes of its key and value
+	GetCostForStorage(bytes int) uint64
+}
+
+// GasPolicy is a vm.TableGasPolicy charging StorageByteCost per byte written to storage
+type GasPolicy struct {
+	*vm.TableGasPolicy
+	StorageByteCost uint64
+}
+
+// GetCostForStorage returns StorageByteCost per byte
+func (p *GasPolicy) GetCostForStorage(bytes int) uint64 {
+	return uint64(bytes) * p.StorageByteCost
+}
+
+// NewDefaultGasPolicy returns vm.NewDefaultGasPolicy with DefaultHostCallCosts
+// and DefaultStorageByteCost
+func NewDefaultGasPolicy() *GasPolicy {
+	p := vm.NewDefaultGasPolicy()
+	for name, cost := range DefaultHostCallCosts {
+		p.HostCallCosts[name] = cost
+	}
+	return &GasPolicy{TableGasPolicy: p, StorageByteCost: DefaultStorageByteCost}
+}
+
+var (
+	i32 = wasm.ValueTypeI32
+	i64 = wasm.ValueTypeI64
+)
+
+// signatures are the types of the host imports
+var signatures = map[string]wasm.FuncType{
+	"storage_read":    {ParamTypes: []wasm.ValueType{i32, i32, i32, i32}, ReturnTypes: []wasm.ValueType{i32}},
+	"storage_write":   {ParamTypes: []wasm.ValueType{i32, i32, i32, i32}},
+	"storage_delete":  {ParamTypes: []wasm.ValueType{i32, i32}},
+	"emit_event":      {ParamTypes: []wasm.ValueType{i32, i32, i32, i32}},
+	"block_height":    {ReturnTypes: []wasm.ValueType{i64}},
+	"block_timestamp": {ReturnTypes: []wasm.ValueType{i64}},
+	"gas_left":        {ReturnTypes: []wasm.ValueType{i64}},
+	"caller":          {ParamTypes: []wasm.ValueType{i32, i32}, ReturnTypes: []wasm.ValueType{i32}},
+	"address":         {ParamTypes: []wasm.ValueType{i32, i32}, ReturnTypes: []wasm.ValueType{i32}},
+	"input":           {ParamTypes: []wasm.ValueType{i32, i32}, ReturnTypes: []wasm.ValueType{i32}},
+	"set_return":      {ParamTypes: []wasm.ValueType{i32, i32}},
+	"revert":          {ParamTypes: []wasm.ValueType{i32, i32}},
+	"call_contract":   {ParamTypes: []wasm.ValueType{i32, i32, i32, i32, i32, i32, i64}, ReturnTypes: []wasm.ValueType{i32}},
+	"return_data":     {ParamTypes: []wasm.ValueType{i32, i32}, ReturnTypes: []wasm.ValueType{i32}},
+}
+
+// Block is the block a call is executed in
+type Block struct {
+	Height    uint64
+	Timestamp uint64
+}
+
+// Message is a call of method of the contract at Address
+type Message struct {
+	Caller  []byte
+	Address []byte
+	Method  string
+	Input   []byte
+}
+
+// Event is emitted by a contract, events of reverted calls are dropped
+type Event struct {
+	Address []byte
+	Topic   []byte
+	Data    []byte
+}
+
+// Result is the outcome of a completed or reverted call
+type Result struct {
+	ReturnData []byte // set_return data, or the revert reason
+	Events     []Event
+	Reverted   bool
+}
+
+// Host runs the contracts deployed at addresses against a storage
+type Host struct {
+	Storage      Storage
+	GasPolicy    vm.GasPolicy
+	MaxCallDepth int // DefaultMaxCallDepth when 0
+	codes        map[string][]byte
+}
+
+// NewHost creates a host running contracts with gasPolicy over storage
+func NewHost(storage Storage, gasPolicy vm.GasPolicy) *Host {
+	return &Host{Storage: storage, GasPolicy: gasPolicy, codes: make(map[string][]byte)}
+}
+
+// Deploy registers code at address after checking it only imports host functions
+func (h *Host) Deploy(address, code []byte) error {
+	m, err := wasm.ReadModule(code)
+	if err != nil {
+		return err
+	}
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	if m.ImportSec != nil {
+		for _, entry := range m.ImportSec.Imports {
+			if err := checkImport(m, entry); err != nil {
+				return err
+			}
+		}
+	}
+	h.codes[string(address)] = code
+	return nil
+}
+
+func checkImport(m *wasm.Module, entry wasm.Import) error {
+	sig, ok := signatures[entry.FieldName]
+	if entry.ModuleName != ModuleName || !ok {
+		return fmt.Errorf("env: unknown import %s.%s", entry.ModuleName, entry.FieldName)
+	}
+	if entry.ImportDesc.Kind != wasm.ExternalFunction || !equalTypes(&m.TypeSec.FuncTypes[entry.ImportDesc.TypeIdx], &sig) {
+		return fmt.Errorf("env: import %s.%s expects %v%v", entry.ModuleName, entry.FieldName, sig.ParamTypes, sig.ReturnTypes)
+	}
+	return nil
+}
+
+func equalTypes(a, b *wasm.FuncType) bool {
+	if len(a.ParamTypes) != len(b.ParamTypes) || len(a.ReturnTypes) != len(b.ReturnTypes) {
+		return false
+	}
+	for i := range a.ParamTypes {
+		if a.ParamTypes[i] != b.ParamTypes[i] {
+			return false
+		}
+	}
+	for i := range a.ReturnTypes {
+		if a.ReturnTypes[i] != b.ReturnTypes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Call runs msg in block with gas and commits its storage writes unless it
+// failed or reverted. A failed call returns an error, a reverted one a result.
+func (h *Host) Call(block Block, msg Message, gas *vm.Gas) (*Result, error) {
+	return h.call(block, msg, gas, h.Storage, 0)
+}
+
+// call runs msg with a journal over storage, committed when the call completes
+func (h *Host) call(block Block, msg Message, gas *vm.Gas, storage Storage, depth int) (*Result, error) {
+	maxDepth := h.MaxCallDepth
+	if maxDepth == 0 {
+		maxDepth = DefaultMaxCallDepth
+	}
+	if depth >= maxDepth {
+		return nil, ErrCallDepth
+	}
+	code, ok := h.codes[string(msg.Address)]
+	if !ok {
+		return nil, ErrUnknownContract
+	}
+	c := &call{host: h, block: block, msg: msg, storage: newJournal(storage), depth: depth}
+	err := c.run(code, gas)
+	if r, ok := err.(*revertError); ok {
+		return &Result{ReturnData: r.reason, Reverted: true}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := c.storage.commit(); err != nil {
+		return nil, &storageError{err}
+	}
+	return &Result{ReturnData: c.returnData, Events: c.events}, nil
+}
+
+// call is the state of a running contract call
+type call struct {
+	host       *Host
+	block      Block
+	msg        Message
+	storage    *journal
+	events     []Event
+	returnData []byte
+	lastReturn []byte // return data of the last call_contract
+	depth      int
+}
+
+func (c *call) run(code []byte, gas *vm.Gas) error {
+	machine, err := vm.NewVM(code, c.host.GasPolicy, gas, c)
+	if err != nil {
+		return err
+	}
+	extern, ok := machine.GetExport(c.msg.Method)
+	fn, isFunc := extern.(*vm.FuncRef)
+	if !ok || !isFunc || len(fn.Type().ParamTypes) != 0 || len(fn.Type().ReturnTypes) != 0 {
+		return fmt.Errorf("env: unknown method %q", c.msg.Method)
+	}
+	_, err = fn.Call()
+	return err
+}
+
+// GetFunction resolves the host imports for the call
+func (c *call) GetFunction(module, name string) vm.HostFunction {
+	if module != ModuleName {
+		return nil
+	}
+	switch name {
+	case "storage_read":
+		return c.storageRead
+	case "storage_write":
+		return c.storageWrite
+	case "storage_delete":
+		return c.storageDelete
+	case "emit_event":
+		return c.emitEvent
+	case "block_height":
+		return func(*vm.VM, ...uint64) (uint64, error) { return c.block.Height, nil }
+	case "block_timestamp":
+		return func(*vm.VM, ...uint64) (uint64, error) { return c.block.Timestamp, nil }
+	case "gas_left":
+		return func(machine *vm.VM, args ...uint64) (uint64, error) { return machine.GetGasRemaining(), nil }
+	case "caller":
+		return c.copyOut(func() []byte { return c.msg.Caller })
+	case "address":
+		return c.copyOut(func() []byte { return c.msg.Address })
+	case "input":
+		return c.copyOut(func() []byte { return c.msg.Input })
+	case "return_data":
+		return c.copyOut(func() []byte { return c.lastReturn })
+	case "set_return":
+		return c.setReturn
+	case "revert":
+		return c.revert
+	case "call_contract":
+		return c.callContract
+	}
+	return nil
+}
+
+// storageKey prefixes key with the contract address
+func (c *call) storageKey(key []byte) []byte {
+	k := make([]byte, 4, 4+len(c.msg.Address)+len(key))
+	binary.BigEndian.PutUint32(k, uint32(len(c.msg.Address)))
+	k = append(k, c.msg.Address...)
+	return append(k, key...)
+}
+
+func (c *call) storageRead(machine *vm.VM, args ...uint64) (uint64, error) {
+	if err := machine.BurnMemCopyGas(int(uint32(args[1]))); err != nil {
+		return 0, err
+	}
+	key, err := machine.Memory().ReadBytes(uint32(args[0]), uint32(args[1]))
+	if err != nil {
+		return 0, err
+	}
+	value, found, err := c.storage.Get(c.storageKey(key))
+	if err != nil {
+		return 0, &storageError{err}
+	}
+	if !found {
+		return math.MaxUint32, nil
+	}
+	return copyOut(machine, args[2], args[3], value)
+}
+
+func (c *call) storageWrite(machine *vm.VM, args ...uint64) (uint64, error) {
+	bytes := int(uint32(args[1])) + int(uint32(args[3]))
+	if policy, ok := c.host.GasPolicy.(StorageGasPolicy); ok {
+		if err := machine.BurnGas(policy.GetCostForStorage(bytes)); err != nil {
+			return 0, err
+		}
+	}
+	if err := machine.BurnMemCopyGas(bytes); err != nil {
+		return 0, err
+	}
+	key, err := machine.Memory().ReadBytes(uint32(args[0]), uint32(args[1]))
+	if err != nil {
+		return 0, err
+	}
+	value, err := machine.Memory().ReadBytes(uint32(args[2]), uint32(args[3]))
+	if err != nil {
+		return 0, err
+	}
+	return 0, c.storage.Set(c.storageKey(key), value)
+}
+
+func (c *call) storageDelete(machine *vm.VM, args ...uint64) (uint64, error) {
+	if err := machine.BurnMemCopyGas(int(uint32(args[1]))); err != nil {
+		return 0, err
+	}
+	key, err := machine.Memory().ReadBytes(uint32(args[0]), uint32(args[1]))
+	if err != nil {
+		return 0, err
+	}
+	return 0, c.storage.Delete(c.storageKey(key))
+}
+
+func (c *call) emitEvent(machine *vm.VM, args ...uint64) (uint64, error) {
+	if err := machine.BurnMemCopyGas(int(uint32(args[1])) + int(uint32(args[3]))); err != nil {
+		return 0, err
+	}
+	topic, err := machine.Memory().ReadBytes(uint32(args[0]), uint32(args[1]))
+	if err != nil {
+		return 0, err
+	}
+	data, err := machine.Memory().ReadBytes(uint32(args[2]), uint32(args[3]))
+	if err != nil {
+		return 0, err
+	}
+	c.events = append(c.events, Event{Address: c.msg.Address, Topic: topic, Data: data})
+	return 0, nil
+}
+
+func (c *call) copyOut(data func() []byte) vm.HostFunction {
+	return func(machine *vm.VM, args ...uint64) (uint64, error) {
+		return copyOut(machine, args[0], args[1], data())
+	}
+}
+
+func (c *call) setReturn(machine *vm.VM, args ...uint64) (uint64, error) {
+	if err := machine.BurnMemCopyGas(int(uint32(args[1]))); err != nil {
+		return 0, err
+	}
+	data, err := machine.Memory().ReadBytes(uint32(args[0]), uint32(args[1]))
+	if err != nil {
+		return 0, err
+	}
+	c.returnData = data
+	return 0, nil
+}
+
+func (c *call) revert(machine *vm.VM, args ...uint64) (uint64, error) {
+	if err := machine.BurnMemCopyGas(int(uint32(args[1]))); err != nil {
+		return 0, err
+	}
+	reason, err := machine.Memory().ReadBytes(uint32(args[0]), uint32(args[1]))
+	if err != nil {
+		return 0, err
+	}
+	return 0, &revertError{reason}
+}
+
+func (c *call) callContract(machine *vm.VM, args ...uint64) (uint64, error) {
+	if err := machine.BurnMemCopyGas(int(uint32(args[1])) + int(uint32(args[3])) + int(uint32(args[5]))); err != nil {
+		return 0, err
+	}
+	address, err := machine.Memory().ReadBytes(uint32(args[0]), uint32(args[1]))
+	if err != nil {
+		return 0, err
+	}
+	method, err := machine.Memory().ReadBytes(uint32(args[2]), uint32(args[3]))
+	if err != nil {
+		return 0, err
+	}
+	input, err := machine.Memory().ReadBytes(uint32(args[4]), uint32(args[5]))
+	if err != nil {
+		return 0, err
+	}
+	limit := args[6]
+	if limit == 0 {
+		limit = math.MaxUint64
+	}
+	msg := Message{Caller: c.msg.Address, Address: address, Method: string(method), Input: input}
+	sub := machine.ReserveGas(limit)
+	result, err := c.host.call(c.block, msg, sub, c.storage, c.depth+1)
+	machine.ReleaseGas(sub)
+	if _, ok := err.(*storageError); ok {
+		return 0, err
+	}
+	switch {
+	case err != nil:
+		c.lastReturn = nil
+		return CallFailed, nil
+	case result.Reverted:
+		c.lastReturn = result.ReturnData
+		return CallReverted, nil
+	}
+	c.lastReturn = result.ReturnData
+	c.events = append(c.events, result.Events...)
+	return CallOK, nil
+}
+
+// copyOut writes data to the buffer of capacity at ptr if it fits and returns
+// its length
+func copyOut(machine *vm.VM, ptr, capacity uint64, data []byte) (uint64, error) {
+	if uint64(len(data)) > uint64(uint32(capacity)) {
+		return uint64(len(data)), nil
+	}
+	if err := machine.BurnMemCopyGas(len(data)); err != nil {
+		return 0, err
+	}
+	return uint64(len(data)), machine.Memory().WriteBytes(uint32(ptr), data)
+}
+
+// revertError ends a call reverted by the contract
+type revertError struct {
+	reason []byte
+}
+
+func (e *revertError) Error() string {
+	return fmt.Sprintf("env: reverted: %q", e.reason)
+}
+
+// storageError is a failure of the storage, it fails the callers too
+type storageError struct {
+	err error
+}
+
+func (e *storageError) Error() string {
+	return fmt.Sprintf("env: storage: %v", e.err)
+}
@@ -0,0 +1,219 @@
+package env
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+// counter increments a stored counter, fails after a write or returns the
+// block height followed by its caller
+const counter = `(module
+  (import "env" "storage_read" (func $read (param i32 i32 i32 i32) (result i32)))
+  (import "env" "storage_write" (func $write (param i32 i32 i32 i32)))
+  (import "env" "emit_event" (func $emit (param i32 i32 i32 i32)))
+  (import "env" "set_return" (func $return (param i32 i32)))
+  (import "env" "revert" (func $revert (param i32 i32)))
+  (import "env" "caller" (func $caller (param i32 i32) (result i32)))
+  (import "env" "block_height" (func $height (result i64)))
+  (memory 1)
+  (data (i32.const 0) "ninc")
+  (data (i32.const 4) "no")
+  (func (export "increment")
+    (if (i32.eq (call $read (i32.const 0) (i32.const 1) (i32.const 16) (i32.const 8)) (i32.const -1))
+      (then (i64.store (i32.const 16) (i64.const 0))))
+    (i64.store (i32.const 16) (i64.add (i64.load (i32.const 16)) (i64.const 1)))
+    (call $write (i32.const 0) (i32.const 1) (i32.const 16) (i32.const 8))
+    (call $emit (i32.const 1) (i32.const 3) (i32.const 16) (i32.const 8))
+    (call $return (i32.const 16) (i32.const 8)))
+  (func (export "fail")
+    (call $write (i32.const 0) (i32.const 1) (i32.const 16) (i32.const 8))
+    (call $revert (i32.const 4) (i32.const 2)))
+  (func (export "whoami")
+    (i64.store (i32.const 32) (call $height))
+    (call $return (i32.const 32) (i32.add (i32.const 8) (call $caller (i32.const 40) (i32.const 64))))))`
+
+// proxy calls the counter method named by its input and returns the call
+// status followed by the counter return data
+const proxy = `(module
+  (import "env" "call_contract" (func $call (param i32 i32 i32 i32 i32 i32 i64) (result i32)))
+  (import "env" "return_data" (func $data (param i32 i32) (result i32)))
+  (import "env" "set_return" (func $return (param i32 i32)))
+  (import "env" "input" (func $input (param i32 i32) (result i32)))
+  (memory 1)
+  (data (i32.const 0) "counter")
+  (func (export "forward")
+    (local $n i32)
+    (local.set $n (call $input (i32.const 16) (i32.const 32)))
+    (i32.store8 (i32.const 64)
+      (call $call (i32.const 0) (i32.const 7) (i32.const 16) (local.get $n) (i32.const 0) (i32.const 0) (i64.const 0)))
+    (call $return (i32.const 64) (i32.add (i32.const 1) (call $data (i32.const 65) (i32.const 64))))))`
+
+func newTestHost(t *testing.T) (*Host, *MemStorage) {
+	storage := NewMemStorage()
+	h := NewHost(storage, NewDefaultGasPolicy())
+	for address, src := range map[string]string{"counter": counter, "proxy": proxy} {
+		code, err := wat.Compile([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Deploy([]byte(address), code); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return h, storage
+}
+
+func u64(v uint64) []byte {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return b
+}
+
+func TestCall(t *testing.T) {
+	h, storage := newTestHost(t)
+	block := Block{Height: 7, Timestamp: 1000}
+	increment := Message{Caller: []byte("alice"), Address: []byte("counter"), Method: "increment"}
+	for i := uint64(1); i <= 2; i++ {
+		gas := &vm.Gas{Limit: 100000}
+		result, err := h.Call(block, increment, gas)
+		if err != nil || result.Reverted || !bytes.Equal(result.ReturnData, u64(i)) {
+			t.Fatalf("Expect counter %d, got %v %v", i, result, err)
+		}
+		if len(result.Events) != 1 || string(result.Events[0].Topic) != "inc" || !bytes.Equal(result.Events[0].Data, u64(i)) {
+			t.Errorf("Expect an inc event, got %v", result.Events)
+		}
+		if gas.Breakdown[vm.GasCategoryHostCall] < DefaultHostCallCosts["env.storage_write"] {
+			t.Errorf("Expect storage write to be charged, got %v", gas.Breakdown)
+		}
+	}
+
+	fail := increment
+	fail.Method = "fail"
+	result, err := h.Call(block, fail, &vm.Gas{Limit: 100000})
+	if err != nil || !result.Reverted || string(result.ReturnData) != "no" {
+		t.Errorf("Expect a revert, got %v %v", result, err)
+	}
+	if value, _, _ := storage.Get(append([]byte{0, 0, 0, 7}, "countern"...)); !bytes.Equal(value, u64(2)) || storage.Len() != 1 {
+		t.Errorf("Expect the reverted write to be discarded, got %v", value)
+	}
+
+	if _, err := h.Call(block, increment, &vm.Gas{Limit: 100}); err != vm.ErrOutOfGas {
+		t.Errorf("Expect %v, got %v", vm.ErrOutOfGas, err)
+	}
+	missing := increment
+	missing.Method = "missing"
+	if _, err := h.Call(block, missing, &vm.Gas{Limit: 100000}); err == nil || !strings.Contains(err.Error(), "unknown method") {
+		t.Errorf("Expect an unknown method, got %v", err)
+	}
+	missing.Address = []byte("nobody")
+	if _, err := h.Call(block, missing, &vm.Gas{Limit: 100000}); err != ErrUnknownContract {
+		t.Errorf("Expect %v, got %v", ErrUnknownContract, err)
+	}
+}
+
+// store writes values of 8 and 1024 bytes
+const store = `(module
+  (import "env" "storage_write" (func $write (param i32 i32 i32 i32)))
+  (memory 1)
+  (func (export "small")
+    (call $write (i32.const 0) (i32.const 1) (i32.const 16) (i32.const 8)))
+  (func (export "large")
+    (call $write (i32.const 0) (i32.const 1) (i32.const 16) (i32.const 1024))))`
+
+func TestStorageCost(t *testing.T) {
+	storage := NewMemStorage()
+	policy := NewDefaultGasPolicy()
+	h := NewHost(storage, policy)
+	code, err := wat.Compile([]byte(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := h.Deploy([]byte("store"), code); err != nil {
+		t.Fatal(err)
+	}
+	used := func(method string) uint64 {
+		gas := &vm.Gas{Limit: 100000}
+		if _, err := h.Call(Block{}, Message{Address: []byte("store"), Method: method}, gas); err != nil {
+			t.Fatalf("%s: %v", method, err)
+		}
+		return gas.Used
+	}
+	small, large := used("small"), used("large")
+	expected := policy.GetCostForStorage(1016) + policy.GetCostForMemCopy(1024) - policy.GetCostForMemCopy(8)
+	if large-small != expected {
+		t.Errorf("Expect the larger value to cost %d more, got %d", expected, large-small)
+	}
+	if small < DefaultHostCallCosts["env.storage_write"]+policy.GetCostForStorage(9) {
+		t.Errorf("Expect the key and value bytes to be charged, got %d", small)
+	}
+
+	// policies not pricing storage only charge the host call
+	h.GasPolicy = policy.TableGasPolicy
+	if free := used("large"); large-free != policy.GetCostForStorage(1025) {
+		t.Errorf("Expect %d without the storage cost, got %d", large-policy.GetCostForStorage(1025), free)
+	}
+}
+
+func TestCallContract(t *testing.T) {
+	h, _ := newTestHost(t)
+	block := Block{Height: 7}
+	forward := func(method string) *Result {
+		gas := &vm.Gas{Limit: 100000}
+		result, err := h.Call(block, Message{Caller: []byte("alice"), Address: []byte("proxy"), Method: "forward", Input: []byte(method)}, gas)
+		if err != nil || result.Reverted {
+			t.Fatalf("forward %s: %v %v", method, result, err)
+		}
+		if gas.Used > gas.Limit || gas.Breakdown[vm.GasCategorySubCall] != 0 {
+			t.Errorf("forward %s: expect the sub-call gas to be released, got %v", method, gas)
+		}
+		return result
+	}
+
+	result := forward("increment")
+	if !bytes.Equal(result.ReturnData, append([]byte{CallOK}, u64(1)...)) {
+		t.Errorf("Expect the incremented counter, got %v", result.ReturnData)
+	}
+	if len(result.Events) != 1 || string(result.Events[0].Address) != "counter" {
+		t.Errorf("Expect the counter event, got %v", result.Events)
+	}
+	if result := forward("fail"); !bytes.Equal(result.ReturnData, []byte{CallReverted, 'n', 'o'}) || len(result.Events) != 0 {
+		t.Errorf("Expect the revert reason, got %v", result)
+	}
+	if result := forward("whoami"); !bytes.Equal(result.ReturnData, append(append([]byte{CallOK}, u64(7)...), "proxy"...)) {
+		t.Errorf("Expect the block height and proxy as caller, got %q", result.ReturnData)
+	}
+	if result := forward("missing"); !bytes.Equal(result.ReturnData, []byte{CallFailed}) {
+		t.Errorf("Expect the call to fail, got %v", result.ReturnData)
+	}
+	if result := forward("increment"); !bytes.Equal(result.ReturnData, append([]byte{CallOK}, u64(2)...)) {
+		t.Errorf("Expect the reverted call not to increment, got %v", result.ReturnData)
+	}
+
+	h.MaxCallDepth = 1
+	if result := forward("increment"); !bytes.Equal(result.ReturnData, []byte{CallFailed}) {
+		t.Errorf("Expect the call depth to be exceeded, got %v", result.ReturnData)
+	}
+}
+
+func TestDeploy(t *testing.T) {
+	h, _ := newTestHost(t)
+	for _, src := range []string{
+		`(module (import "env" "abort" (func)))`,
+		`(module (import "other" "storage_read" (func (param i32 i32 i32 i32) (result i32))))`,
+		`(module (import "env" "storage_read" (func (param i32 i32 i32) (result i32))))`,
+		`(module (import "env" "block_height" (global i64)))`,
+	} {
+		code, err := wat.Compile([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.Deploy([]byte("bad"), code); err == nil {
+			t.Errorf("Expect %s to be rejected", src)
+		}
+	}
+}
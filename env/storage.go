@@ -0,0 +1,102 @@
+package env
+
+import "sort"
+
+// Storage is the key value store holding the contract states
+type Storage interface {
+	// Get returns the value of key, found is false when key is not set
+	Get(key []byte) (value []byte, found bool, err error)
+	Set(key, value []byte) error
+	Delete(key []byte) error
+}
+
+// MemStorage is an in-memory Storage
+type MemStorage struct {
+	values map[string][]byte
+}
+
+// NewMemStorage creates an empty MemStorage
+func NewMemStorage() *MemStorage {
+	return &MemStorage{values: make(map[string][]byte)}
+}
+
+// Get returns a copy of the value of key
+func (s *MemStorage) Get(key []byte) ([]byte, bool, error) {
+	value, ok := s.values[string(key)]
+	return append([]byte(nil), value...), ok, nil
+}
+
+// Set stores a copy of value
+func (s *MemStorage) Set(key, value []byte) error {
+	s.values[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+// Delete removes key
+func (s *MemStorage) Delete(key []byte) error {
+	delete(s.values, string(key))
+	return nil
+}
+
+// Len returns the number of keys set
+func (s *MemStorage) Len() int {
+	return len(s.values)
+}
+
+// journal buffers the writes of a call over the storage of its caller, they
+// reach the parent storage only when the call commits
+type journal struct {
+	parent  Storage
+	writes  map[string][]byte
+	deleted map[string]bool
+}
+
+func newJournal(parent Storage) *journal {
+	return &journal{parent: parent, writes: make(map[string][]byte), deleted: make(map[string]bool)}
+}
+
+func (j *journal) Get(key []byte) ([]byte, bool, error) {
+	if j.deleted[string(key)] {
+		return nil, false, nil
+	}
+	if value, ok := j.writes[string(key)]; ok {
+		return append([]byte(nil), value...), true, nil
+	}
+	return j.parent.Get(key)
+}
+
+func (j *journal) Set(key, value []byte) error {
+	delete(j.deleted, string(key))
+	j.writes[string(key)] = append([]byte{}, value...)
+	return nil
+}
+
+func (j *journal) Delete(key []byte) error {
+	delete(j.writes, string(key))
+	j.deleted[string(key)] = true
+	return nil
+}
+
+// commit applies the writes to the parent storage in key order
+func (j *journal) commit() error {
+	keys := make([]string, 0, len(j.writes)+len(j.deleted))
+	for key := range j.writes {
+		keys = append(keys, key)
+	}
+	for key := range j.deleted {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		var err error
+		if j.deleted[key] {
+			err = j.parent.Delete([]byte(key))
+		} else {
+			err = j.parent.Set([]byte(key), j.writes[key])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -1,3 +1,3 @@
 module github.com/vertexdlt/vertexvm
 
-go 1.12
+go 1.13
@@ -0,0 +1,169 @@
+package inspect
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/vertexdlt/vertexvm/leb128"
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// ErrTruncatedCode is returned when an instruction immediate runs past the end of the code
+var ErrTruncatedCode = errors.New("inspect: truncated instruction")
+
+// Instruction is a decoded instruction
+type Instruction struct {
+	Offset     int // offset in the function code
+	Size       int // encoded size including immediates
+	Op         opcode.Opcode
+	Immediates []uint64 // signed constants are sign extended, floats are raw bits
+}
+
+type decoder struct {
+	code []byte
+	pos  int
+}
+
+func (d *decoder) leb(maxbit uint32, signed bool) (uint64, error) {
+	if d.pos >= len(d.code) {
+		return 0, ErrTruncatedCode
+	}
+	n, v, err := leb128.Read(d.code[d.pos:], maxbit, signed)
+	if err != nil {
+		return 0, err
+	}
+	if d.code[d.pos+int(n)-1]&0x80 != 0 {
+		return 0, ErrTruncatedCode
+	}
+	d.pos += int(n)
+	return uint64(v), nil
+}
+
+func (d *decoder) fixed(size int) (uint64, error) {
+	if d.pos+size > len(d.code) {
+		return 0, ErrTruncatedCode
+	}
+	var v uint64
+	for i := size - 1; i >= 0; i-- {
+		v = v<<8 | uint64(d.code[d.pos+i])
+	}
+	d.pos += size
+	return v, nil
+}
+
+func (d *decoder) immediates(op opcode.Opcode) ([]uint64, error) {
+	info := op.Info()
+	if info == nil {
+		return nil, nil
+	}
+	var imms []uint64
+	for _, kind := range info.Immediates {
+		var (
+			v   uint64
+			err error
+		)
+		switch kind {
+		case opcode.ImmBlockType:
+			v, err = d.fixed(1)
+		case opcode.ImmLabelTable:
+			var count uint64
+			if count, err = d.leb(32, false); err != nil {
+				return imms, err
+			}
+			imms = append(imms, count)
+			for i := uint64(0); i < count; i++ {
+				if v, err = d.leb(32, false); err != nil {
+					return imms, err
+				}
+				imms = append(imms, v)
+			}
+			v, err = d.leb(32, false)
+		case opcode.ImmMemArg:
+			if v, err = d.leb(32, false); err != nil {
+				return imms, err
+			}
+			imms = append(imms, v)
+			v, err = d.leb(32, false)
+		case opcode.ImmReserved:
+			v, err = d.leb(1, false)
+		case opcode.ImmI32:
+			v, err = d.leb(32, true)
+		case opcode.ImmI64:
+			v, err = d.leb(64, true)
+		case opcode.ImmF32:
+			v, err = d.fixed(4)
+		case opcode.ImmF64:
+			v, err = d.fixed(8)
+		default:
+			v, err = d.leb(32, false)
+		}
+		if err != nil {
+			return imms, err
+		}
+		imms = append(imms, v)
+	}
+	return imms, nil
+}
+
+// Disassemble decodes the instructions of a function body or an init expression
+func Disassemble(code []byte) ([]Instruction, error) {
+	d := &decoder{code: code}
+	var instructions []Instruction
+	for d.pos < len(code) {
+		ins := Instruction{Offset: d.pos, Op: opcode.Opcode(code[d.pos])}
+		d.pos++
+		imms, err := d.immediates(ins.Op)
+		if err != nil {
+			return instructions, fmt.Errorf("%s at offset %d: %v", ins.Op, ins.Offset, err)
+		}
+		ins.Immediates = imms
+		ins.Size = d.pos - ins.Offset
+		instructions = append(instructions, ins)
+	}
+	return instructions, nil
+}
+
+// String formats the instruction in the text format
+func (ins Instruction) String() string {
+	info := ins.Op.Info()
+	if info == nil || len(info.Immediates) == 0 {
+		return ins.Op.String()
+	}
+	imms := ins.Immediates
+	switch info.Immediates[0] {
+	case opcode.ImmBlockType:
+		if byte(imms[0]) == byte(wasm.BlockTypeEmpty) {
+			return ins.Op.String()
+		}
+		return fmt.Sprintf("%s (result %s)", ins.Op, wasm.ValueType(imms[0]))
+	case opcode.ImmType:
+		return fmt.Sprintf("%s (type %d)", ins.Op, imms[0])
+	case opcode.ImmReserved:
+		return ins.Op.String()
+	case opcode.ImmMemArg:
+		return fmt.Sprintf("%s offset=%d align=%d", ins.Op, imms[1], uint64(1)<<imms[0])
+	case opcode.ImmI32:
+		return fmt.Sprintf("%s %d", ins.Op, int32(imms[0]))
+	case opcode.ImmI64:
+		return fmt.Sprintf("%s %d", ins.Op, int64(imms[0]))
+	case opcode.ImmF32:
+		return fmt.Sprintf("%s %v", ins.Op, math.Float32frombits(uint32(imms[0])))
+	case opcode.ImmF64:
+		return fmt.Sprintf("%s %v", ins.Op, math.Float64frombits(imms[0]))
+	case opcode.ImmSubOpcode:
+		if sub := opcode.SubInfo(uint32(imms[0])); sub != nil {
+			return sub.Name
+		}
+		return fmt.Sprintf("%s 0x%02x", ins.Op, imms[0])
+	case opcode.ImmLabelTable:
+		imms = imms[1:]
+	}
+	parts := []string{ins.Op.String()}
+	for _, imm := range imms {
+		parts = append(parts, fmt.Sprint(imm))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,265 @@
+// Package inspect prints the sections of a decoded wasm module and
+// disassembles its function bodies, in the spirit of wasm-objdump.
+package inspect
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// maxDataPreview is the number of data segment bytes printed
+const maxDataPreview = 32
+
+var kindNames = map[byte]string{
+	wasm.ExternalFunction:   "func",
+	wasm.ExternalTable:      "table",
+	wasm.ExternalMemory:     "memory",
+	wasm.ExternalGlobalType: "global",
+}
+
+type printer struct {
+	w          *bufio.Writer
+	m          *wasm.Module
+	funcNames  map[uint32]string
+	importSigs []uint32
+	imported   map[byte]int // import count by kind
+}
+
+// Dump writes every section of m followed by the disassembly of its functions
+func Dump(w io.Writer, m *wasm.Module) error {
+	p := &printer{w: bufio.NewWriter(w), m: m, funcNames: map[uint32]string{}, imported: map[byte]int{}}
+	if m.NameSec != nil {
+		for idx, name := range m.NameSec.FunctionNames {
+			p.funcNames[idx] = name
+		}
+	}
+	if m.ImportSec != nil {
+		for _, entry := range m.ImportSec.Imports {
+			if entry.ImportDesc.Kind == wasm.ExternalFunction {
+				p.importSigs = append(p.importSigs, entry.ImportDesc.TypeIdx)
+			}
+			p.imported[entry.ImportDesc.Kind]++
+		}
+	}
+	p.sections()
+	if err := p.code(); err != nil {
+		p.w.Flush()
+		return err
+	}
+	return p.w.Flush()
+}
+
+func (p *printer) printf(format string, args ...interface{}) {
+	fmt.Fprintf(p.w, format, args...)
+}
+
+func (p *printer) funcRef(idx uint32) string {
+	if name, ok := p.funcNames[idx]; ok {
+		return fmt.Sprintf("func[%d] <%s>", idx, name)
+	}
+	return fmt.Sprintf("func[%d]", idx)
+}
+
+func valueTypes(types []wasm.ValueType) string {
+	names := make([]string, len(types))
+	for i, t := range types {
+		names[i] = t.String()
+	}
+	return "(" + strings.Join(names, ", ") + ")"
+}
+
+func limits(l wasm.Limits) string {
+	if l.Flag&1 == 0 {
+		return fmt.Sprintf("initial=%d", l.Min)
+	}
+	return fmt.Sprintf("initial=%d max=%d", l.Min, l.Max)
+}
+
+func globalType(t wasm.GlobalType) string {
+	return fmt.Sprintf("%s mutable=%d", t.ValueType, t.Mutability)
+}
+
+// initExpr formats a constant expression without its end
+func initExpr(expr []byte) string {
+	instructions, err := Disassemble(expr)
+	if err != nil {
+		return fmt.Sprintf("<invalid: %v>", err)
+	}
+	parts := make([]string, 0, len(instructions))
+	for _, ins := range instructions {
+		if ins.Op != opcode.End {
+			parts = append(parts, ins.String())
+		}
+	}
+	return strings.Join(parts, "; ")
+}
+
+func (p *printer) sections() {
+	m := p.m
+	if m.NameSec != nil && m.NameSec.ModuleName != "" {
+		p.printf("module %s\n", m.NameSec.ModuleName)
+	}
+	p.printf("version %d\n", m.Version)
+
+	if m.TypeSec != nil {
+		p.printf("\ntype[%d]:\n", len(m.TypeSec.FuncTypes))
+		for i, ft := range m.TypeSec.FuncTypes {
+			p.printf(" - type[%d] %s -> %s\n", i, valueTypes(ft.ParamTypes), valueTypes(ft.ReturnTypes))
+		}
+	}
+
+	if m.ImportSec != nil {
+		p.printf("\nimport[%d]:\n", len(m.ImportSec.Imports))
+		counts := map[byte]int{}
+		for _, entry := range m.ImportSec.Imports {
+			desc := entry.ImportDesc
+			idx := counts[desc.Kind]
+			counts[desc.Kind]++
+			from := fmt.Sprintf("<- %s.%s", entry.ModuleName, entry.FieldName)
+			switch desc.Kind {
+			case wasm.ExternalFunction:
+				p.printf(" - %s sig=%d %s\n", p.funcRef(uint32(idx)), desc.TypeIdx, from)
+			case wasm.ExternalTable:
+				p.printf(" - table[%d] type=funcref %s %s\n", idx, limits(desc.Table.Limits), from)
+			case wasm.ExternalMemory:
+				p.printf(" - memory[%d] pages: %s %s\n", idx, limits(desc.Mem.Limits), from)
+			case wasm.ExternalGlobalType:
+				p.printf(" - global[%d] %s %s\n", idx, globalType(*desc.GlobalType), from)
+			default:
+				p.printf(" - <unknown kind %d> %s\n", desc.Kind, from)
+			}
+		}
+	}
+
+	if m.FuncSec != nil {
+		p.printf("\nfunction[%d]:\n", len(m.FuncSec.TypeIndices))
+		for i, typeIdx := range m.FuncSec.TypeIndices {
+			locals := 0
+			if m.CodeSec != nil && i < len(m.CodeSec.Codes) {
+				for _, local := range m.CodeSec.Codes[i].Locals {
+					locals += int(local.Count)
+				}
+			}
+			p.printf(" - %s sig=%d locals=%d\n", p.funcRef(uint32(len(p.importSigs)+i)), typeIdx, locals)
+		}
+	}
+
+	if m.TableSec != nil {
+		p.printf("\ntable[%d]:\n", len(m.TableSec.Tables))
+		for i, table := range m.TableSec.Tables {
+			p.printf(" - table[%d] type=funcref %s\n", p.imported[wasm.ExternalTable]+i, limits(table.Limits))
+		}
+	}
+
+	if m.MemSec != nil {
+		p.printf("\nmemory[%d]:\n", len(m.MemSec.Mems))
+		for i, mem := range m.MemSec.Mems {
+			p.printf(" - memory[%d] pages: %s\n", p.imported[wasm.ExternalMemory]+i, limits(mem.Limits))
+		}
+	}
+
+	if m.GlobalSec != nil {
+		p.printf("\nglobal[%d]:\n", len(m.GlobalSec.Globals))
+		for i, global := range m.GlobalSec.Globals {
+			p.printf(" - global[%d] %s - init %s\n", p.imported[wasm.ExternalGlobalType]+i, globalType(global.Type), initExpr(global.Init))
+		}
+	}
+
+	if m.ExportSec != nil {
+		exports := make([]wasm.Export, 0, len(m.ExportSec.ExportMap))
+		for _, export := range m.ExportSec.ExportMap {
+			exports = append(exports, export)
+		}
+		sort.Slice(exports, func(i, j int) bool { return exports[i].Name < exports[j].Name })
+		p.printf("\nexport[%d]:\n", len(exports))
+		for _, export := range exports {
+			ref := fmt.Sprintf("%s[%d]", kindNames[export.Desc.Kind], export.Desc.Idx)
+			if export.Desc.Kind == wasm.ExternalFunction {
+				ref = p.funcRef(export.Desc.Idx)
+			}
+			p.printf(" - %s -> %q\n", ref, export.Name)
+		}
+	}
+
+	if m.StartSec != nil {
+		p.printf("\nstart: %s\n", p.funcRef(m.StartSec.FuncIdx))
+	}
+
+	if m.ElementSec != nil {
+		p.printf("\nelem[%d]:\n", len(m.ElementSec.Elements))
+		for i, elem := range m.ElementSec.Elements {
+			p.printf(" - segment[%d] table=%d count=%d - init %s\n", i, elem.TableIdx, len(elem.Offset), initExpr(elem.Init))
+			for j, fidx := range elem.Offset {
+				p.printf("  - elem[%d] = %s\n", j, p.funcRef(fidx))
+			}
+		}
+	}
+
+	if m.DataSec != nil {
+		p.printf("\ndata[%d]:\n", len(m.DataSec.DataSegments))
+		for i, data := range m.DataSec.DataSegments {
+			p.printf(" - segment[%d] memory=%d size=%d - init %s\n", i, data.MemIdx, len(data.Init), initExpr(data.Offset))
+			preview := data.Init
+			if len(preview) > maxDataPreview {
+				preview = preview[:maxDataPreview]
+			}
+			if len(preview) > 0 {
+				p.printf("  - % x", preview)
+				if len(preview) < len(data.Init) {
+					p.printf(" ...")
+				}
+				p.printf("  %q\n", preview)
+			}
+		}
+	}
+
+	for _, custom := range m.CustomSecs {
+		p.printf("\ncustom %q size=%d\n", custom.Name, len(custom.Data))
+	}
+}
+
+func (p *printer) code() error {
+	if p.m.CodeSec == nil {
+		return nil
+	}
+	p.printf("\ncode[%d]:\n", len(p.m.CodeSec.Codes))
+	for i, code := range p.m.CodeSec.Codes {
+		p.printf("%s:\n", p.funcRef(uint32(len(p.importSigs)+i)))
+		for _, local := range code.Locals {
+			p.printf(" local %s x %d\n", local.ValueType, local.Count)
+		}
+		instructions, err := Disassemble(code.Exprs)
+		depth := 0
+		for _, ins := range instructions {
+			if ins.Op == opcode.End || ins.Op == opcode.Else {
+				depth--
+			}
+			encoded := code.Exprs[ins.Offset : ins.Offset+ins.Size]
+			if len(encoded) > 8 {
+				encoded = encoded[:8]
+			}
+			p.printf(" %06x: %-24s| %s%s\n", ins.Offset, fmt.Sprintf("% x", encoded), strings.Repeat("  ", max(depth, 0)), ins)
+			switch ins.Op {
+			case opcode.Block, opcode.Loop, opcode.If, opcode.Else:
+				depth++
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("function %d: %v", len(p.importSigs)+i, err)
+		}
+	}
+	return nil
+}
+
+func max(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
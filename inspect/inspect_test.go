@@ -0,0 +1,98 @@
+package inspect
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+func section(id byte, body ...byte) []byte {
+	return append([]byte{id, byte(len(body))}, body...)
+}
+
+func testModule() []byte {
+	m := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+	// (i32, i32) -> i32, () -> ()
+	m = append(m, section(1, 0x02, 0x60, 0x02, 0x7f, 0x7f, 0x01, 0x7f, 0x60, 0x00, 0x00)...)
+	m = append(m, section(2, 0x02,
+		0x03, 'e', 'n', 'v', 0x03, 'a', 'd', 'd', 0x00, 0x00,
+		0x03, 'e', 'n', 'v', 0x01, 'g', 0x03, 0x7e, 0x00)...)
+	m = append(m, section(3, 0x02, 0x00, 0x01)...)
+	m = append(m, section(4, 0x01, 0x70, 0x01, 0x02, 0x04)...)
+	m = append(m, section(5, 0x01, 0x00, 0x01)...)
+	m = append(m, section(6, 0x01, 0x7f, 0x01, 0x41, 0x7f, 0x0b)...)
+	m = append(m, section(7, 0x02,
+		0x04, 'c', 'a', 'l', 'c', 0x00, 0x01,
+		0x03, 'm', 'e', 'm', 0x02, 0x00)...)
+	m = append(m, section(8, 0x02)...)
+	m = append(m, section(9, 0x01, 0x00, 0x41, 0x01, 0x0b, 0x02, 0x01, 0x02)...)
+	calc := []byte{
+		0x01, 0x01, 0x7f, // one i32 local
+		0x02, 0x7f, // block (result i32)
+		0x20, 0x00,
+		0x20, 0x01,
+		0x10, 0x00,
+		0x28, 0x02, 0x04,
+		0x0e, 0x01, 0x00, 0x00,
+		0x0b,
+		0x44, 0, 0, 0, 0, 0, 0, 0xf8, 0x3f,
+		0x1a,
+		0xfc, 0x02,
+		0x0b,
+	}
+	m = append(m, section(10, append([]byte{0x02, byte(len(calc))}, append(calc, 0x02, 0x00, 0x0b)...)...)...)
+	m = append(m, section(11, 0x01, 0x00, 0x41, 0x08, 0x0b, 0x02, 'h', 'i')...)
+	m = append(m, section(0, 0x04, 'n', 'a', 'm', 'e', 0x01, 0x07, 0x01, 0x01, 0x04, 'c', 'a', 'l', 'c')...)
+	return m
+}
+
+func TestDump(t *testing.T) {
+	m, err := wasm.ReadModule(testModule())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	if err := Dump(&out, m); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range []string{
+		" - type[0] (i32, i32) -> (i32)",
+		" - type[1] () -> ()",
+		" - func[0] sig=0 <- env.add",
+		" - global[0] i64 mutable=0 <- env.g",
+		" - func[1] <calc> sig=0 locals=1",
+		" - func[2] sig=1 locals=0",
+		" - table[0] type=funcref initial=2 max=4",
+		" - memory[0] pages: initial=1",
+		" - global[1] i32 mutable=1 - init i32.const -1",
+		" - func[1] <calc> -> \"calc\"",
+		" - memory[0] -> \"mem\"",
+		"start: func[2]",
+		" - segment[0] table=0 count=2 - init i32.const 1",
+		"  - elem[1] = func[2]",
+		" - segment[0] memory=0 size=2 - init i32.const 8",
+		"  - 68 69  \"hi\"",
+		"custom \"name\" size=9",
+		"func[1] <calc>:",
+		" local i32 x 1",
+		" 000000: 02 7f                   | block (result i32)",
+		" 000008: 28 02 04                |   i32.load offset=4 align=4",
+		" 00000b: 0e 01 00 00             |   br_table 0 0",
+		" 00000f: 0b                      | end",
+		" 000010: 44 00 00 00 00 00 00 f8 | f64.const 1.5",
+		" 00001a: fc 02                   | i32.trunc_sat_f64_s",
+	} {
+		if !strings.Contains(out.String(), line+"\n") {
+			t.Errorf("Expect line %q in\n%s", line, out.String())
+		}
+	}
+}
+
+func TestDisassembleTruncated(t *testing.T) {
+	instructions, err := Disassemble([]byte{0x41, 0x01, 0x41, 0x80})
+	if err == nil || len(instructions) != 1 {
+		t.Errorf("Expect truncated error after one instruction, got %v %v", instructions, err)
+	}
+}
@@ -4,8 +4,17 @@ import (
 	"errors"
 )
 
+// LEB128 decoding errors
+var (
+	ErrUnexpectedEnd = errors.New("leb128: unexpected end")
+	ErrTooLong       = errors.New("leb128: integer representation too long")
+	ErrTooLarge      = errors.New("leb128: integer too large")
+)
+
 // Read reads an unsigned integer of size n defined in https://webassembly.github.io/spec/core/binary/values.html#binary-int
 // Read panics if n>64.
+// The encoding must be at most ceil(maxbit/7) bytes long and the unused bits of
+// its last byte must be zero, or copies of the sign bit if hasSign.
 func Read(b []byte, maxbit uint32, hasSign bool) (uint32, int64, error) {
 	if maxbit > 64 {
 		return 0, 0, errors.New("leb128: n must <= 64")
@@ -13,27 +22,38 @@ func Read(b []byte, maxbit uint32, hasSign bool) (uint32, int64, error) {
 	var (
 		shift   uint32
 		bytecnt uint32
-		cur     int64
 		result  int64
-		sign    int64 = -1
 	)
-	for i := 0; i < len(b); i++ {
-		cur = int64(b[i])
-		result |= (cur & 0x7f) << shift
-		shift += 7
-		sign <<= 7
+	maxBytes := (maxbit + 7 - 1) / 7
+	for {
+		if int(bytecnt) >= len(b) {
+			return 0, 0, ErrUnexpectedEnd
+		}
+		cur := b[bytecnt]
 		bytecnt++
-		if cur&0x80 == 0 {
-			break
+		if bytecnt == maxBytes {
+			if cur&0x80 != 0 {
+				return 0, 0, ErrTooLong
+			}
+			// the payload bits beyond maxbit, and the sign bit when signed
+			used := maxbit - shift
+			unused := (cur & 0x7f) >> (used - 1)
+			if !hasSign {
+				unused >>= 1
+			}
+			if unused != 0 && (!hasSign || unused != 0x7f>>(used-1)) {
+				return 0, 0, ErrTooLarge
+			}
 		}
-		if bytecnt > (maxbit+7-1)/7 {
-			return 0, 0, errors.New("Unsigned LEB at byte overflow")
+		result |= int64(cur&0x7f) << shift
+		shift += 7
+		if cur&0x80 == 0 {
+			if hasSign && shift < 64 && cur&0x40 != 0 {
+				result |= -1 << shift
+			}
+			return bytecnt, result, nil
 		}
 	}
-	if hasSign && ((sign>>1)&result) != 0 {
-		result |= sign
-	}
-	return bytecnt, result, nil
 }
 
 // ReadUint32 reads a LEB128 encoded unsigned 32-bit integer from r, and
@@ -0,0 +1,249 @@
+package number
+
+import "math"
+
+// Float computes the float operators of the WebAssembly spec on the bits of
+// their operands. A NaN result is always the canonical NaN, which is an
+// arithmetic NaN too, so the results do not depend on the NaNs operated on.
+//
+// HardFloat runs the operators on the CPU floating point unit, SoftFloat with
+// integer arithmetic only so their results are identical on every
+// architecture. abs, neg and copysign only change the sign bit, they are
+// functions of the package.
+type Float interface {
+	F32Add(a, b uint32) uint32
+	F32Sub(a, b uint32) uint32
+	F32Mul(a, b uint32) uint32
+	F32Div(a, b uint32) uint32
+	F32Min(a, b uint32) uint32
+	F32Max(a, b uint32) uint32
+	F32Sqrt(a uint32) uint32
+	F32Ceil(a uint32) uint32
+	F32Floor(a uint32) uint32
+	F32Trunc(a uint32) uint32
+	F32Nearest(a uint32) uint32
+	F32Eq(a, b uint32) bool
+	F32Lt(a, b uint32) bool
+	F32Le(a, b uint32) bool
+
+	F64Add(a, b uint64) uint64
+	F64Sub(a, b uint64) uint64
+	F64Mul(a, b uint64) uint64
+	F64Div(a, b uint64) uint64
+	F64Min(a, b uint64) uint64
+	F64Max(a, b uint64) uint64
+	F64Sqrt(a uint64) uint64
+	F64Ceil(a uint64) uint64
+	F64Floor(a uint64) uint64
+	F64Trunc(a uint64) uint64
+	F64Nearest(a uint64) uint64
+	F64Eq(a, b uint64) bool
+	F64Lt(a, b uint64) bool
+	F64Le(a, b uint64) bool
+
+	// F32DemoteF64 rounds a to the nearest float32, ties to even
+	F32DemoteF64(a uint64) uint32
+	// F64PromoteF32 converts a to float64 exactly
+	F64PromoteF32(a uint32) uint64
+	// F32ConvertInt rounds the integer v, signed or not, to the nearest float32
+	F32ConvertInt(v uint64, signed bool) uint32
+	// F64ConvertInt rounds the integer v, signed or not, to the nearest float64
+	F64ConvertInt(v uint64, signed bool) uint64
+	// Truncate converts a float of type from to an integer of type to as FloatTruncate
+	Truncate(from Type, to Type, floatBits uint64) (uint64, TrapCode)
+}
+
+// HardFloat computes with the floating point unit
+var HardFloat Float = hardFloat{}
+
+type hardFloat struct{}
+
+// Canonical NaNs and sign masks
+const (
+	F32CanonicalNaN = uint32(0x7fc00000)
+	F64CanonicalNaN = uint64(0x7ff8000000000000)
+	F32SignMask     = uint32(1 << 31)
+	F64SignMask     = uint64(1 << 63)
+)
+
+// f32 returns the bits of f, the canonical NaN if f is a NaN
+func f32(f float32) uint32 {
+	if f != f {
+		return F32CanonicalNaN
+	}
+	return math.Float32bits(f)
+}
+
+// f64 returns the bits of f, the canonical NaN if f is a NaN
+func f64(f float64) uint64 {
+	if f != f {
+		return F64CanonicalNaN
+	}
+	return math.Float64bits(f)
+}
+
+func fromF32(a uint32) float32 { return math.Float32frombits(a) }
+func fromF64(a uint64) float64 { return math.Float64frombits(a) }
+
+// The conversions to the operand type round each operation on its own, they
+// keep the compiler from fusing operations
+
+// F32Add returns a + b
+func (hardFloat) F32Add(a, b uint32) uint32 { return f32(float32(fromF32(a) + fromF32(b))) }
+
+// F32Sub returns a - b
+func (hardFloat) F32Sub(a, b uint32) uint32 { return f32(float32(fromF32(a) - fromF32(b))) }
+
+// F32Mul returns a * b
+func (hardFloat) F32Mul(a, b uint32) uint32 { return f32(float32(fromF32(a) * fromF32(b))) }
+
+// F32Div returns a / b
+func (hardFloat) F32Div(a, b uint32) uint32 { return f32(float32(fromF32(a) / fromF32(b))) }
+
+// F32Min returns the lesser of a and b, a NaN if either is and -0 for -0 and +0
+func (hardFloat) F32Min(a, b uint32) uint32 {
+	x, y := fromF32(a), fromF32(b)
+	switch {
+	case x != x || y != y:
+		return F32CanonicalNaN
+	case x == y:
+		return a | b // -0 when either zero is negative
+	case x < y:
+		return a
+	}
+	return b
+}
+
+// F32Max returns the greater of a and b, a NaN if either is and +0 for -0 and +0
+func (hardFloat) F32Max(a, b uint32) uint32 {
+	x, y := fromF32(a), fromF32(b)
+	switch {
+	case x != x || y != y:
+		return F32CanonicalNaN
+	case x == y:
+		return a & b // +0 when either zero is positive
+	case x > y:
+		return a
+	}
+	return b
+}
+
+// F32Copysign returns a with the sign of b
+func F32Copysign(a, b uint32) uint32 { return a&^F32SignMask | b&F32SignMask }
+
+// F32Abs returns a without sign, NaNs included
+func F32Abs(a uint32) uint32 { return a &^ F32SignMask }
+
+// F32Neg returns a with the opposite sign, NaNs included
+func F32Neg(a uint32) uint32 { return a ^ F32SignMask }
+
+// F32Ceil rounds a toward +inf
+func (hardFloat) F32Ceil(a uint32) uint32 { return f32(float32(math.Ceil(float64(fromF32(a))))) }
+
+// F32Floor rounds a toward -inf
+func (hardFloat) F32Floor(a uint32) uint32 { return f32(float32(math.Floor(float64(fromF32(a))))) }
+
+// F32Trunc rounds a toward zero
+func (hardFloat) F32Trunc(a uint32) uint32 { return f32(float32(math.Trunc(float64(fromF32(a))))) }
+
+// F32Nearest rounds a to the nearest integer, ties to even, keeping the sign of zero
+func (hardFloat) F32Nearest(a uint32) uint32 {
+	return f32(float32(math.RoundToEven(float64(fromF32(a)))))
+}
+
+// F32Sqrt returns the square root of a, correctly rounded as the float64 root
+// of a float32 has more than twice its precision
+func (hardFloat) F32Sqrt(a uint32) uint32 { return f32(float32(math.Sqrt(float64(fromF32(a))))) }
+
+// F64Add returns a + b
+func (hardFloat) F64Add(a, b uint64) uint64 { return f64(float64(fromF64(a) + fromF64(b))) }
+
+// F64Sub returns a - b
+func (hardFloat) F64Sub(a, b uint64) uint64 { return f64(float64(fromF64(a) - fromF64(b))) }
+
+// F64Mul returns a * b
+func (hardFloat) F64Mul(a, b uint64) uint64 { return f64(float64(fromF64(a) * fromF64(b))) }
+
+// F64Div returns a / b
+func (hardFloat) F64Div(a, b uint64) uint64 { return f64(float64(fromF64(a) / fromF64(b))) }
+
+// F64Min returns the lesser of a and b, a NaN if either is and -0 for -0 and +0
+func (hardFloat) F64Min(a, b uint64) uint64 {
+	x, y := fromF64(a), fromF64(b)
+	switch {
+	case x != x || y != y:
+		return F64CanonicalNaN
+	case x == y:
+		return a | b
+	case x < y:
+		return a
+	}
+	return b
+}
+
+// F64Max returns the greater of a and b, a NaN if either is and +0 for -0 and +0
+func (hardFloat) F64Max(a, b uint64) uint64 {
+	x, y := fromF64(a), fromF64(b)
+	switch {
+	case x != x || y != y:
+		return F64CanonicalNaN
+	case x == y:
+		return a & b
+	case x > y:
+		return a
+	}
+	return b
+}
+
+// F64Copysign returns a with the sign of b
+func F64Copysign(a, b uint64) uint64 { return a&^F64SignMask | b&F64SignMask }
+
+// F64Abs returns a without sign, NaNs included
+func F64Abs(a uint64) uint64 { return a &^ F64SignMask }
+
+// F64Neg returns a with the opposite sign, NaNs included
+func F64Neg(a uint64) uint64 { return a ^ F64SignMask }
+
+// F64Ceil rounds a toward +inf
+func (hardFloat) F64Ceil(a uint64) uint64 { return f64(math.Ceil(fromF64(a))) }
+
+// F64Floor rounds a toward -inf
+func (hardFloat) F64Floor(a uint64) uint64 { return f64(math.Floor(fromF64(a))) }
+
+// F64Trunc rounds a toward zero
+func (hardFloat) F64Trunc(a uint64) uint64 { return f64(math.Trunc(fromF64(a))) }
+
+// F64Nearest rounds a to the nearest integer, ties to even, keeping the sign of zero
+func (hardFloat) F64Nearest(a uint64) uint64 { return f64(math.RoundToEven(fromF64(a))) }
+
+// F64Sqrt returns the square root of a
+func (hardFloat) F64Sqrt(a uint64) uint64 { return f64(math.Sqrt(fromF64(a))) }
+
+func (hardFloat) F32DemoteF64(a uint64) uint32 { return f32(float32(fromF64(a))) }
+
+func (hardFloat) F64PromoteF32(a uint32) uint64 { return f64(float64(fromF32(a))) }
+
+func (hardFloat) F32ConvertInt(v uint64, signed bool) uint32 {
+	if signed {
+		return math.Float32bits(float32(int64(v)))
+	}
+	return math.Float32bits(float32(v))
+}
+
+func (hardFloat) F64ConvertInt(v uint64, signed bool) uint64 {
+	if signed {
+		return math.Float64bits(float64(int64(v)))
+	}
+	return math.Float64bits(float64(v))
+}
+
+func (hardFloat) F32Eq(a, b uint32) bool { return fromF32(a) == fromF32(b) }
+func (hardFloat) F32Lt(a, b uint32) bool { return fromF32(a) < fromF32(b) }
+func (hardFloat) F32Le(a, b uint32) bool { return fromF32(a) <= fromF32(b) }
+func (hardFloat) F64Eq(a, b uint64) bool { return fromF64(a) == fromF64(b) }
+func (hardFloat) F64Lt(a, b uint64) bool { return fromF64(a) < fromF64(b) }
+func (hardFloat) F64Le(a, b uint64) bool { return fromF64(a) <= fromF64(b) }
+
+func (hardFloat) Truncate(from Type, to Type, floatBits uint64) (uint64, TrapCode) {
+	return FloatTruncate(from, to, floatBits)
+}
@@ -0,0 +1,99 @@
+package number
+
+import "testing"
+
+const (
+	f32NegZero = uint32(0x80000000)
+	f32One     = uint32(0x3f800000)
+	f32Inf     = uint32(0x7f800000)
+	f32NegInf  = uint32(0xff800000)
+	f32NaN     = uint32(0xffa00001) // negative signaling NaN with a payload
+	f64NegZero = uint64(0x8000000000000000)
+	f64Inf     = uint64(0x7ff0000000000000)
+	f64NegInf  = uint64(0xfff0000000000000)
+	f64NaN     = uint64(0x7ff4000000000001)
+)
+
+// floats are the implementations tested
+var floats = []struct {
+	name string
+	f    Float
+}{
+	{"hard", HardFloat},
+	{"soft", SoftFloat},
+}
+
+func TestF32(t *testing.T) {
+	for _, float := range floats {
+		testF32(t, float.name, float.f)
+	}
+}
+
+func testF32(t *testing.T, name string, f Float) {
+	tests := []struct {
+		name     string
+		got      uint32
+		expected uint32
+	}{
+		{"min(-0, +0)", f.F32Min(0, f32NegZero), f32NegZero},
+		{"min(+0, -0)", f.F32Min(f32NegZero, 0), f32NegZero},
+		{"max(-0, +0)", f.F32Max(f32NegZero, 0), 0},
+		{"min(-inf, nan)", f.F32Min(f32NegInf, f32NaN), F32CanonicalNaN},
+		{"max(nan, inf)", f.F32Max(f32NaN, f32Inf), F32CanonicalNaN},
+		{"min(1, inf)", f.F32Min(f32One, f32Inf), f32One},
+		{"max(1, -inf)", f.F32Max(f32One, f32NegInf), f32One},
+		{"add(nan, 1)", f.F32Add(f32NaN, f32One), F32CanonicalNaN},
+		{"sub(inf, inf)", f.F32Sub(f32Inf, f32Inf), F32CanonicalNaN},
+		{"neg(nan)", F32Neg(f32NaN), f32NaN &^ F32SignMask},
+		{"abs(nan)", F32Abs(f32NaN), f32NaN &^ F32SignMask},
+		{"copysign(nan, -0)", F32Copysign(F32CanonicalNaN, f32NegZero), F32CanonicalNaN | F32SignMask},
+		{"nearest(2.5)", f.F32Nearest(0x40200000), 0x40000000},
+		{"nearest(-0.5)", f.F32Nearest(0xbf000000), f32NegZero},
+		{"nearest(nan)", f.F32Nearest(f32NaN), F32CanonicalNaN},
+		{"ceil(-0.5)", f.F32Ceil(0xbf000000), f32NegZero},
+		{"sqrt(-1)", f.F32Sqrt(0xbf800000), F32CanonicalNaN},
+		// 1 + 2^-24 + 2^-53 rounds up, the half way 1 + 2^-24 rounds to even
+		{"demote(1+2^-24+2^-53)", f.F32DemoteF64(0x3ff0000010000001), 0x3f800001},
+		{"demote(1+2^-24)", f.F32DemoteF64(0x3ff0000010000000), f32One},
+		{"demote(max float64)", f.F32DemoteF64(0x7fefffffffffffff), f32Inf},
+		{"demote(nan)", f.F32DemoteF64(f64NaN), F32CanonicalNaN},
+	}
+	for _, test := range tests {
+		if test.got != test.expected {
+			t.Errorf("%s %s: expect %#08x, got %#08x", name, test.name, test.expected, test.got)
+		}
+	}
+}
+
+func TestF64(t *testing.T) {
+	for _, float := range floats {
+		testF64(t, float.name, float.f)
+	}
+}
+
+func testF64(t *testing.T, name string, f Float) {
+	tests := []struct {
+		name     string
+		got      uint64
+		expected uint64
+	}{
+		{"min(-0, +0)", f.F64Min(0, f64NegZero), f64NegZero},
+		{"max(+0, -0)", f.F64Max(0, f64NegZero), 0},
+		{"min(-inf, nan)", f.F64Min(f64NegInf, f64NaN), F64CanonicalNaN},
+		{"max(inf, nan)", f.F64Max(f64Inf, f64NaN), F64CanonicalNaN},
+		{"div(0, 0)", f.F64Div(0, 0), F64CanonicalNaN},
+		{"mul(inf, 0)", f.F64Mul(f64Inf, 0), F64CanonicalNaN},
+		{"nearest(-1.5)", f.F64Nearest(0xbff8000000000000), 0xc000000000000000},
+		{"nearest(4503599627370497)", f.F64Nearest(0x4330000000000001), 0x4330000000000001},
+		{"floor(-0)", f.F64Floor(f64NegZero), f64NegZero},
+		{"trunc(nan)", f.F64Trunc(f64NaN), F64CanonicalNaN},
+		{"promote(nan)", f.F64PromoteF32(f32NaN), F64CanonicalNaN},
+		{"promote(-inf)", f.F64PromoteF32(f32NegInf), f64NegInf},
+		{"promote(smallest subnormal)", f.F64PromoteF32(1), 0x36a0000000000000},
+	}
+	for _, test := range tests {
+		if test.got != test.expected {
+			t.Errorf("%s %s: expect %#016x, got %#016x", name, test.name, test.expected, test.got)
+		}
+	}
+}
@@ -0,0 +1,491 @@
+package number
+
+import "math/bits"
+
+// SoftFloat computes with integer arithmetic, rounding to nearest with ties
+// to even as IEEE 754 does by default
+var SoftFloat Float = softFloat{}
+
+type softFloat struct{}
+
+// format describes an IEEE 754 binary format, values are held in the low bits
+// of an uint64
+type format struct {
+	fracBits uint
+	expBits  uint
+	bias     int
+	nan      uint64 // canonical NaN
+}
+
+var (
+	binary32 = &format{fracBits: 23, expBits: 8, bias: 127, nan: uint64(F32CanonicalNaN)}
+	binary64 = &format{fracBits: 52, expBits: 11, bias: 1023, nan: F64CanonicalNaN}
+)
+
+func (f *format) signBit() uint64 { return 1 << (f.fracBits + f.expBits) }
+func (f *format) expMax() uint64  { return 1<<f.expBits - 1 }
+func (f *format) inf() uint64     { return f.expMax() << f.fracBits }
+func (f *format) one() uint64     { return uint64(f.bias) << f.fracBits }
+
+// class of an unpacked value
+const (
+	classZero = iota
+	classFinite
+	classInf
+	classNaN
+)
+
+// unpacked is a value sign * mant * 2^exp
+type unpacked struct {
+	sign  uint64 // 0 or the sign bit
+	exp   int
+	mant  uint64
+	class int
+}
+
+func (f *format) unpack(a uint64) unpacked {
+	u := unpacked{sign: a & f.signBit()}
+	exp := a >> f.fracBits & f.expMax()
+	frac := a & (1<<f.fracBits - 1)
+	switch {
+	case exp == f.expMax() && frac != 0:
+		u.class = classNaN
+	case exp == f.expMax():
+		u.class = classInf
+	case exp == 0 && frac == 0:
+		u.class = classZero
+	case exp == 0: // subnormal
+		u.class, u.mant, u.exp = classFinite, frac, 1-f.bias-int(f.fracBits)
+	default:
+		u.class, u.mant, u.exp = classFinite, frac|1<<f.fracBits, int(exp)-f.bias-int(f.fracBits)
+	}
+	return u
+}
+
+// round returns the value nearest to sign * (mant + sticky) * 2^exp, where
+// sticky stands for nonzero bits below mant
+func (f *format) round(sign uint64, exp int, mant uint64, sticky bool) uint64 {
+	if mant == 0 {
+		return sign
+	}
+	// put the leading bit at 62
+	if lz := bits.LeadingZeros64(mant); lz == 0 {
+		sticky = sticky || mant&1 != 0
+		mant >>= 1
+		exp++
+	} else {
+		mant <<= uint(lz - 1)
+		exp -= lz - 1
+	}
+	biased := exp + 62 + f.bias
+	shift := 62 - f.fracBits
+	if biased < 1 {
+		// subnormal, the exponent field stays 0
+		shift += uint(1 - biased)
+		biased = 1
+	}
+	var q uint64
+	if shift < 64 {
+		q = mant >> shift
+		rest, half := mant&(1<<shift-1), uint64(1)<<(shift-1)
+		if rest > half || rest == half && (sticky || q&1 == 1) {
+			q++
+		}
+	}
+	// the leading bit of q adds one to the exponent field, as does a rounding carry
+	r := uint64(biased-1)<<f.fracBits + q
+	if r >= f.inf() {
+		return sign | f.inf()
+	}
+	return sign | r
+}
+
+func (f *format) add(a, b uint64) uint64 {
+	x, y := f.unpack(a), f.unpack(b)
+	switch {
+	case x.class == classNaN || y.class == classNaN:
+		return f.nan
+	case x.class == classInf && y.class == classInf:
+		if x.sign != y.sign {
+			return f.nan
+		}
+		return a
+	case x.class == classInf:
+		return a
+	case y.class == classInf:
+		return b
+	case x.class == classZero && y.class == classZero:
+		return x.sign & y.sign // -0 only for -0 + -0
+	case x.class == classZero:
+		return b
+	case y.class == classZero:
+		return a
+	}
+	// leading bits at 60, leaving room for a carry
+	x.mant <<= 60 - f.fracBits
+	x.exp -= int(60 - f.fracBits)
+	y.mant <<= 60 - f.fracBits
+	y.exp -= int(60 - f.fracBits)
+	if x.exp < y.exp || x.exp == y.exp && x.mant < y.mant {
+		x, y = y, x
+	}
+	sticky := false
+	if d := uint(x.exp - y.exp); d >= 64 {
+		sticky, y.mant = true, 0
+	} else if d > 0 {
+		sticky = y.mant&(1<<d-1) != 0
+		y.mant >>= d
+	}
+	if x.sign == y.sign {
+		return f.round(x.sign, x.exp, x.mant+y.mant, sticky)
+	}
+	mant := x.mant - y.mant
+	if sticky {
+		// the bits shifted out are subtracted too
+		mant--
+	}
+	if mant == 0 && !sticky {
+		return 0 // x - x is +0
+	}
+	return f.round(x.sign, x.exp, mant, sticky)
+}
+
+func (f *format) mul(a, b uint64) uint64 {
+	x, y := f.unpack(a), f.unpack(b)
+	sign := x.sign ^ y.sign
+	switch {
+	case x.class == classNaN || y.class == classNaN:
+		return f.nan
+	case x.class == classInf || y.class == classInf:
+		if x.class == classZero || y.class == classZero {
+			return f.nan
+		}
+		return sign | f.inf()
+	case x.class == classZero || y.class == classZero:
+		return sign
+	}
+	hi, lo := bits.Mul64(x.mant, y.mant)
+	exp := x.exp + y.exp
+	if hi == 0 {
+		return f.round(sign, exp, lo, false)
+	}
+	n := uint(64 - bits.LeadingZeros64(hi))
+	return f.round(sign, exp+int(n), hi<<(64-n)|lo>>n, lo&(1<<n-1) != 0)
+}
+
+func (f *format) div(a, b uint64) uint64 {
+	x, y := f.unpack(a), f.unpack(b)
+	sign := x.sign ^ y.sign
+	switch {
+	case x.class == classNaN || y.class == classNaN:
+		return f.nan
+	case x.class == classInf && y.class == classInf, x.class == classZero && y.class == classZero:
+		return f.nan
+	case x.class == classInf || y.class == classZero:
+		return sign | f.inf()
+	case x.class == classZero || y.class == classInf:
+		return sign
+	}
+	// x.mant < y.mant so the quotient of x.mant * 2^64 fits 64 bits
+	lx, ly := bits.LeadingZeros64(x.mant), bits.LeadingZeros64(y.mant)
+	xm, ym := x.mant<<uint(lx-1), y.mant<<uint(ly)
+	q, r := bits.Div64(xm, 0, ym)
+	exp := x.exp - (lx - 1) - y.exp + ly - 64
+	return f.round(sign, exp, q, r != 0)
+}
+
+func (f *format) sqrt(a uint64) uint64 {
+	x := f.unpack(a)
+	switch {
+	case x.class == classNaN || x.sign != 0 && x.class != classZero:
+		return f.nan
+	case x.class == classZero || x.class == classInf:
+		return a
+	}
+	// x.mant * 2^64 with an even exponent and the leading bit at 61 or 62
+	lz := bits.LeadingZeros64(x.mant)
+	shift := lz - 2
+	if (x.exp-shift)%2 != 0 {
+		shift++
+	}
+	hi, exp := x.mant<<uint(shift), x.exp-shift-64
+	var root uint64
+	for bit := uint64(1) << 63; bit != 0; bit >>= 1 {
+		c := root | bit
+		if shi, slo := bits.Mul64(c, c); shi < hi || shi == hi && slo == 0 {
+			root = c
+		}
+	}
+	shi, slo := bits.Mul64(root, root)
+	return f.round(0, exp/2, root, shi != hi || slo != 0)
+}
+
+// compare returns -1, 0 or 1 as a is less, equal or greater than b and false
+// when they are unordered
+func (f *format) compare(a, b uint64) (int, bool) {
+	x, y := f.unpack(a), f.unpack(b)
+	switch {
+	case x.class == classNaN || y.class == classNaN:
+		return 0, false
+	case x.class == classZero && y.class == classZero:
+		return 0, true
+	case x.sign != y.sign:
+		if x.sign != 0 {
+			return -1, true
+		}
+		return 1, true
+	}
+	// same sign, the magnitudes order as their bits
+	ma, mb := a&^f.signBit(), b&^f.signBit()
+	c := 0
+	if ma < mb {
+		c = -1
+	} else if ma > mb {
+		c = 1
+	}
+	if x.sign != 0 {
+		c = -c
+	}
+	return c, true
+}
+
+func (f *format) min(a, b uint64) uint64 {
+	c, ordered := f.compare(a, b)
+	switch {
+	case !ordered:
+		return f.nan
+	case c == 0:
+		return a | b
+	case c < 0:
+		return a
+	}
+	return b
+}
+
+func (f *format) max(a, b uint64) uint64 {
+	c, ordered := f.compare(a, b)
+	switch {
+	case !ordered:
+		return f.nan
+	case c == 0:
+		return a & b
+	case c > 0:
+		return a
+	}
+	return b
+}
+
+// Rounding modes to an integral value
+const (
+	roundTrunc = iota
+	roundFloor
+	roundCeil
+	roundNearest
+)
+
+// roundInt rounds a to an integral value, keeping the sign of zero
+func (f *format) roundInt(a uint64, mode int) uint64 {
+	x := f.unpack(a)
+	switch x.class {
+	case classNaN:
+		return f.nan
+	case classZero, classInf:
+		return a
+	}
+	exp := int(a>>f.fracBits&f.expMax()) - f.bias
+	if exp >= int(f.fracBits) {
+		return a
+	}
+	negative := x.sign != 0
+	if exp < 0 {
+		// |a| < 1 rounds to 0 or 1
+		up := false
+		switch mode {
+		case roundFloor:
+			up = negative
+		case roundCeil:
+			up = !negative
+		case roundNearest:
+			// 0.5 ties to 0, above it rounds to 1
+			up = exp == -1 && a&(1<<f.fracBits-1) != 0
+		}
+		if up {
+			return x.sign | f.one()
+		}
+		return x.sign
+	}
+	mask := uint64(1)<<(f.fracBits-uint(exp)) - 1
+	frac := a & mask
+	if frac == 0 {
+		return a
+	}
+	up := false
+	switch mode {
+	case roundFloor:
+		up = negative
+	case roundCeil:
+		up = !negative
+	case roundNearest:
+		half := (mask + 1) >> 1
+		up = frac > half || frac == half && a&(mask+1) != 0
+	}
+	if up {
+		// the carry may reach the exponent, which stays exact
+		a += mask + 1
+	}
+	return a &^ mask
+}
+
+// fromInt rounds the integer v, signed or not
+func (f *format) fromInt(v uint64, signed bool) uint64 {
+	var sign uint64
+	if signed && int64(v) < 0 {
+		sign, v = f.signBit(), -v
+	}
+	return f.round(sign, 0, v, false)
+}
+
+// convert rounds a of format from to the format f
+func (f *format) convert(from *format, a uint64) uint64 {
+	x := from.unpack(a)
+	sign := uint64(0)
+	if x.sign != 0 {
+		sign = f.signBit()
+	}
+	switch x.class {
+	case classNaN:
+		return f.nan
+	case classInf:
+		return sign | f.inf()
+	case classZero:
+		return sign
+	}
+	return f.round(sign, x.exp, x.mant, false)
+}
+
+// truncate converts a to an integer of type to as FloatTruncate does
+func (f *format) truncate(to Type, a uint64) (uint64, TrapCode) {
+	x := f.unpack(a)
+	negative := x.sign != 0
+	overflow := func() (uint64, TrapCode) {
+		if negative {
+			return Min(to), ConvertTrap
+		}
+		return Max(to), ConvertTrap
+	}
+	var mag uint64
+	switch x.class {
+	case classNaN:
+		return 0, NanTrap
+	case classInf:
+		return overflow()
+	case classFinite:
+		switch {
+		case x.exp <= -64:
+		case x.exp < 0:
+			mag = x.mant >> uint(-x.exp)
+		case bits.Len64(x.mant)+x.exp > 64:
+			return overflow()
+		default:
+			mag = x.mant << uint(x.exp)
+		}
+	}
+	var limit uint64 // largest magnitude allowed
+	switch {
+	case (to == U32 || to == U64) && negative:
+		limit = 0
+	case to == U32:
+		limit = 1<<32 - 1
+	case to == U64:
+		limit = 1<<64 - 1
+	case to == I32 && negative:
+		limit = 1 << 31
+	case to == I32:
+		limit = 1<<31 - 1
+	case to == I64 && negative:
+		limit = 1 << 63
+	case to == I64:
+		limit = 1<<63 - 1
+	default:
+		panic("to must be an int")
+	}
+	if mag > limit {
+		return overflow()
+	}
+	if negative {
+		mag = -mag
+	}
+	if to == I32 {
+		return uint64(int64(int32(mag))), NoTrap
+	}
+	return mag, NoTrap
+}
+
+func (softFloat) F32Add(a, b uint32) uint32 { return uint32(binary32.add(uint64(a), uint64(b))) }
+func (softFloat) F32Sub(a, b uint32) uint32 {
+	return uint32(binary32.add(uint64(a), uint64(b^F32SignMask)))
+}
+func (softFloat) F32Mul(a, b uint32) uint32 { return uint32(binary32.mul(uint64(a), uint64(b))) }
+func (softFloat) F32Div(a, b uint32) uint32 { return uint32(binary32.div(uint64(a), uint64(b))) }
+func (softFloat) F32Min(a, b uint32) uint32 { return uint32(binary32.min(uint64(a), uint64(b))) }
+func (softFloat) F32Max(a, b uint32) uint32 { return uint32(binary32.max(uint64(a), uint64(b))) }
+func (softFloat) F32Sqrt(a uint32) uint32   { return uint32(binary32.sqrt(uint64(a))) }
+func (softFloat) F32Ceil(a uint32) uint32   { return uint32(binary32.roundInt(uint64(a), roundCeil)) }
+func (softFloat) F32Floor(a uint32) uint32  { return uint32(binary32.roundInt(uint64(a), roundFloor)) }
+func (softFloat) F32Trunc(a uint32) uint32  { return uint32(binary32.roundInt(uint64(a), roundTrunc)) }
+func (softFloat) F32Nearest(a uint32) uint32 {
+	return uint32(binary32.roundInt(uint64(a), roundNearest))
+}
+func (softFloat) F32Eq(a, b uint32) bool {
+	c, ordered := binary32.compare(uint64(a), uint64(b))
+	return ordered && c == 0
+}
+func (softFloat) F32Lt(a, b uint32) bool {
+	c, ordered := binary32.compare(uint64(a), uint64(b))
+	return ordered && c < 0
+}
+func (softFloat) F32Le(a, b uint32) bool {
+	c, ordered := binary32.compare(uint64(a), uint64(b))
+	return ordered && c <= 0
+}
+
+func (softFloat) F64Add(a, b uint64) uint64  { return binary64.add(a, b) }
+func (softFloat) F64Sub(a, b uint64) uint64  { return binary64.add(a, b^F64SignMask) }
+func (softFloat) F64Mul(a, b uint64) uint64  { return binary64.mul(a, b) }
+func (softFloat) F64Div(a, b uint64) uint64  { return binary64.div(a, b) }
+func (softFloat) F64Min(a, b uint64) uint64  { return binary64.min(a, b) }
+func (softFloat) F64Max(a, b uint64) uint64  { return binary64.max(a, b) }
+func (softFloat) F64Sqrt(a uint64) uint64    { return binary64.sqrt(a) }
+func (softFloat) F64Ceil(a uint64) uint64    { return binary64.roundInt(a, roundCeil) }
+func (softFloat) F64Floor(a uint64) uint64   { return binary64.roundInt(a, roundFloor) }
+func (softFloat) F64Trunc(a uint64) uint64   { return binary64.roundInt(a, roundTrunc) }
+func (softFloat) F64Nearest(a uint64) uint64 { return binary64.roundInt(a, roundNearest) }
+func (softFloat) F64Eq(a, b uint64) bool {
+	c, ordered := binary64.compare(a, b)
+	return ordered && c == 0
+}
+func (softFloat) F64Lt(a, b uint64) bool {
+	c, ordered := binary64.compare(a, b)
+	return ordered && c < 0
+}
+func (softFloat) F64Le(a, b uint64) bool {
+	c, ordered := binary64.compare(a, b)
+	return ordered && c <= 0
+}
+
+func (softFloat) F32DemoteF64(a uint64) uint32  { return uint32(binary32.convert(binary64, a)) }
+func (softFloat) F64PromoteF32(a uint32) uint64 { return binary64.convert(binary32, uint64(a)) }
+func (softFloat) F32ConvertInt(v uint64, signed bool) uint32 {
+	return uint32(binary32.fromInt(v, signed))
+}
+func (softFloat) F64ConvertInt(v uint64, signed bool) uint64 { return binary64.fromInt(v, signed) }
+
+func (softFloat) Truncate(from Type, to Type, floatBits uint64) (uint64, TrapCode) {
+	switch from {
+	case F32:
+		return binary32.truncate(to, floatBits&(1<<32-1))
+	case F64:
+		return binary64.truncate(to, floatBits)
+	}
+	panic("from must be a float")
+}
@@ -0,0 +1,152 @@
+package number
+
+import (
+	"math/rand"
+	"testing"
+)
+
+var f32Edges = []uint32{
+	0, f32NegZero, 1, 0x80000001, 0x007fffff, 0x00800000, 0x00800001, 0x3f000000, 0xbf000000,
+	0x3fc00000, 0x40200000, f32One, 0x3f800001, 0x3f7fffff, 0x4b000000, 0x4b000001, 0x4effffff,
+	0x4f000000, 0xcf000000, 0xcf000001, 0x4f800000, 0x5f000000, 0xdf000000, 0x5f800000, 0x7f7fffff,
+	0xff7fffff, f32Inf, f32NegInf, F32CanonicalNaN, f32NaN,
+}
+
+var f64Edges = []uint64{
+	0, f64NegZero, 1, 0x8000000000000001, 0x000fffffffffffff, 0x0010000000000000, 0x3fe0000000000000,
+	0xbfe0000000000000, 0x3ff8000000000000, 0xbff8000000000000, 0x3ff0000000000000, 0x3ff0000000000001,
+	0x3fefffffffffffff, 0x4330000000000000, 0x4330000000000001, 0x41dfffffffc00000, 0x41e0000000000000,
+	0xc1e0000000000000, 0xc1e0000000200000, 0x41f0000000000000, 0x43e0000000000000, 0xc3e0000000000000,
+	0x43f0000000000000, 0x3ff0000010000000, 0x3ff0000010000001, 0x36a0000000000000, 0x7fefffffffffffff,
+	0xffefffffffffffff, f64Inf, f64NegInf, F64CanonicalNaN, f64NaN,
+}
+
+// f32Values returns the edges and n random bits, half of them close to 1 so
+// their operations cancel and round at ties
+func f32Values(r *rand.Rand, n int) []uint32 {
+	values := append([]uint32{}, f32Edges...)
+	for i := 0; i < n; i++ {
+		v := r.Uint32()
+		if i%2 == 0 {
+			v = v&0x80ffffff | uint32(0x3c+r.Intn(8))<<24
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func f64Values(r *rand.Rand, n int) []uint64 {
+	values := append([]uint64{}, f64Edges...)
+	for i := 0; i < n; i++ {
+		v := r.Uint64()
+		if i%2 == 0 {
+			v = v&0x800fffffffffffff | uint64(0x3f8+r.Intn(16))<<52
+		}
+		values = append(values, v)
+	}
+	return values
+}
+
+func TestSoftF32(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	values := f32Values(r, 300)
+	binary := map[string]func(f Float, a, b uint32) uint32{
+		"add": Float.F32Add, "sub": Float.F32Sub, "mul": Float.F32Mul, "div": Float.F32Div,
+		"min": Float.F32Min, "max": Float.F32Max,
+	}
+	compare := map[string]func(f Float, a, b uint32) bool{"eq": Float.F32Eq, "lt": Float.F32Lt, "le": Float.F32Le}
+	unary := map[string]func(f Float, a uint32) uint32{
+		"sqrt": Float.F32Sqrt, "ceil": Float.F32Ceil, "floor": Float.F32Floor, "trunc": Float.F32Trunc,
+		"nearest": Float.F32Nearest,
+	}
+	for _, a := range values {
+		for _, b := range values {
+			for name, op := range binary {
+				if hard, soft := op(HardFloat, a, b), op(SoftFloat, a, b); hard != soft {
+					t.Errorf("%s(%#08x, %#08x): expect %#08x, got %#08x", name, a, b, hard, soft)
+				}
+			}
+			for name, op := range compare {
+				if hard, soft := op(HardFloat, a, b), op(SoftFloat, a, b); hard != soft {
+					t.Errorf("%s(%#08x, %#08x): expect %v, got %v", name, a, b, hard, soft)
+				}
+			}
+		}
+		for name, op := range unary {
+			if hard, soft := op(HardFloat, a), op(SoftFloat, a); hard != soft {
+				t.Errorf("%s(%#08x): expect %#08x, got %#08x", name, a, hard, soft)
+			}
+		}
+		if hard, soft := HardFloat.F64PromoteF32(a), SoftFloat.F64PromoteF32(a); hard != soft {
+			t.Errorf("promote(%#08x): expect %#016x, got %#016x", a, hard, soft)
+		}
+		testSoftTruncate(t, F32, uint64(a))
+	}
+}
+
+func TestSoftF64(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	values := f64Values(r, 300)
+	binary := map[string]func(f Float, a, b uint64) uint64{
+		"add": Float.F64Add, "sub": Float.F64Sub, "mul": Float.F64Mul, "div": Float.F64Div,
+		"min": Float.F64Min, "max": Float.F64Max,
+	}
+	compare := map[string]func(f Float, a, b uint64) bool{"eq": Float.F64Eq, "lt": Float.F64Lt, "le": Float.F64Le}
+	unary := map[string]func(f Float, a uint64) uint64{
+		"sqrt": Float.F64Sqrt, "ceil": Float.F64Ceil, "floor": Float.F64Floor, "trunc": Float.F64Trunc,
+		"nearest": Float.F64Nearest,
+	}
+	for _, a := range values {
+		for _, b := range values {
+			for name, op := range binary {
+				if hard, soft := op(HardFloat, a, b), op(SoftFloat, a, b); hard != soft {
+					t.Errorf("%s(%#016x, %#016x): expect %#016x, got %#016x", name, a, b, hard, soft)
+				}
+			}
+			for name, op := range compare {
+				if hard, soft := op(HardFloat, a, b), op(SoftFloat, a, b); hard != soft {
+					t.Errorf("%s(%#016x, %#016x): expect %v, got %v", name, a, b, hard, soft)
+				}
+			}
+		}
+		for name, op := range unary {
+			if hard, soft := op(HardFloat, a), op(SoftFloat, a); hard != soft {
+				t.Errorf("%s(%#016x): expect %#016x, got %#016x", name, a, hard, soft)
+			}
+		}
+		if hard, soft := HardFloat.F32DemoteF64(a), SoftFloat.F32DemoteF64(a); hard != soft {
+			t.Errorf("demote(%#016x): expect %#08x, got %#08x", a, hard, soft)
+		}
+		testSoftTruncate(t, F64, a)
+	}
+}
+
+func testSoftTruncate(t *testing.T, from Type, a uint64) {
+	for _, to := range []Type{I32, I64, U32, U64} {
+		hard, hardTrap := HardFloat.Truncate(from, to, a)
+		soft, softTrap := SoftFloat.Truncate(from, to, a)
+		if hard != soft || hardTrap != softTrap {
+			t.Errorf("truncate %v %#x to %v: expect %#x %v, got %#x %v", from, a, to, hard, hardTrap, soft, softTrap)
+		}
+	}
+}
+
+func TestSoftConvertInt(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	values := []uint64{0, 1, 1<<63 - 1, 1 << 63, 1<<64 - 1, 1<<24 + 1, 1<<25 + 3, 1<<53 + 1, 1<<54 + 3, 0xffffff7fffffffff}
+	for i := 0; i < 1000; i++ {
+		// random widths so that the values are exact, rounded and tied
+		v := r.Uint64() >> uint(r.Intn(64))
+		values = append(values, v, -v, v&^(1<<uint(r.Intn(40))-1))
+	}
+	for _, v := range values {
+		for _, signed := range []bool{false, true} {
+			if hard, soft := HardFloat.F32ConvertInt(v, signed), SoftFloat.F32ConvertInt(v, signed); hard != soft {
+				t.Errorf("f32 convert %#x signed %v: expect %#08x, got %#08x", v, signed, hard, soft)
+			}
+			if hard, soft := HardFloat.F64ConvertInt(v, signed), SoftFloat.F64ConvertInt(v, signed); hard != soft {
+				t.Errorf("f64 convert %#x signed %v: expect %#016x, got %#016x", v, signed, hard, soft)
+			}
+		}
+	}
+}
@@ -0,0 +1,118 @@
+//go:build ignore
+// +build ignore
+
+// gen generates table.go from opcodes.txt
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+)
+
+var immediates = map[string]string{
+	"blocktype": "ImmBlockType", "label": "ImmLabel", "labels": "ImmLabelTable", "func": "ImmFunc",
+	"type": "ImmType", "local": "ImmLocal", "global": "ImmGlobal", "memarg": "ImmMemArg",
+	"reserved": "ImmReserved", "i32": "ImmI32", "i64": "ImmI64", "f32": "ImmF32", "f64": "ImmF64",
+	"subop": "ImmSubOpcode",
+}
+
+var valueTypes = map[string]string{"i32": "ValueI32", "i64": "ValueI64", "f32": "ValueF32", "f64": "ValueF64"}
+
+var proposals = map[string]string{
+	"mvp": "ProposalMVP", "sign-extension": "ProposalSignExtension",
+	"nontrapping-fptoint": "ProposalNontrappingFloatToInt",
+}
+
+func list(field string, names map[string]string, typ string) string {
+	if field == "-" || field == "*" {
+		return ""
+	}
+	var items []string
+	for _, item := range strings.Split(field, ",") {
+		name, ok := names[item]
+		if !ok {
+			log.Fatalf("unknown %s %q", typ, item)
+		}
+		items = append(items, name)
+	}
+	return fmt.Sprintf("[]%s{%s}", typ, strings.Join(items, ", "))
+}
+
+// info formats the Info literal of the fields following the opcode
+func info(fields []string) string {
+	name, imms, params, results, proposal := fields[0], fields[1], fields[2], fields[3], fields[4]
+	if (params == "*") != (results == "*") {
+		log.Fatalf("%s: params and results must both be polymorphic", name)
+	}
+	p, ok := proposals[proposal]
+	if !ok {
+		log.Fatalf("%s: unknown proposal %q", name, proposal)
+	}
+	parts := []string{fmt.Sprintf("Name: %q", name)}
+	if s := list(imms, immediates, "Immediate"); s != "" {
+		parts = append(parts, "Immediates: "+s)
+	}
+	if s := list(params, valueTypes, "ValueType"); s != "" {
+		parts = append(parts, "Params: "+s)
+	}
+	if s := list(results, valueTypes, "ValueType"); s != "" {
+		parts = append(parts, "Results: "+s)
+	}
+	if params == "*" {
+		parts = append(parts, "Polymorphic: true")
+	}
+	parts = append(parts, "Proposal: "+p)
+	return "{" + strings.Join(parts, ", ") + "}"
+}
+
+func main() {
+	f, err := os.Open("opcodes.txt")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+
+	var ops, subops, mnemonics bytes.Buffer
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if strings.HasPrefix(fields[0], "fc.") {
+			if len(fields) != 6 {
+				log.Fatalf("invalid line %q", line)
+			}
+			fmt.Fprintf(&subops, "%s: %s,\n", strings.TrimPrefix(fields[0], "fc."), info(fields[1:]))
+			continue
+		}
+		if len(fields) != 7 {
+			log.Fatalf("invalid line %q", line)
+		}
+		fmt.Fprintf(&ops, "%s: %s,\n", fields[1], info(fields[2:]))
+		fmt.Fprintf(&mnemonics, "%q: %s,\n", fields[2], fields[1])
+	}
+	if err := scanner.Err(); err != nil {
+		log.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	fmt.Fprintf(&out, "// Code generated by gen.go from opcodes.txt; DO NOT EDIT.\n\npackage opcode\n\n")
+	fmt.Fprintf(&out, "var infos = [256]*Info{\n%s}\n\n", ops.String())
+	fmt.Fprintf(&out, "var subInfos = [...]*Info{\n%s}\n\n", subops.String())
+	fmt.Fprintf(&out, "var mnemonics = map[string]Opcode{\n%s}\n", mnemonics.String())
+	src, err := format.Source(out.Bytes())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := ioutil.WriteFile("table.go", src, 0644); err != nil {
+		log.Fatal(err)
+	}
+}
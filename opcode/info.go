@@ -0,0 +1,114 @@
+package opcode
+
+import "fmt"
+
+//go:generate go run gen.go
+
+// ValueType is an operand type, encoded as in the binary format
+type ValueType byte
+
+// Operand types
+const (
+	ValueI32 ValueType = 0x7f
+	ValueI64 ValueType = 0x7e
+	ValueF32 ValueType = 0x7d
+	ValueF64 ValueType = 0x7c
+)
+
+var valueTypeNames = map[ValueType]string{ValueI32: "i32", ValueI64: "i64", ValueF32: "f32", ValueF64: "f64"}
+
+func (t ValueType) String() string {
+	if name, ok := valueTypeNames[t]; ok {
+		return name
+	}
+	return fmt.Sprintf("<unknown 0x%02x>", byte(t))
+}
+
+// Immediate is the kind of an immediate argument encoded after an opcode
+type Immediate byte
+
+// Immediate kinds
+const (
+	ImmBlockType  Immediate = iota + 1 // one byte block type
+	ImmLabel                           // u32 label depth
+	ImmLabelTable                      // u32 count, count u32 labels and a u32 default label
+	ImmFunc                            // u32 function index
+	ImmType                            // u32 type index
+	ImmLocal                           // u32 local index
+	ImmGlobal                          // u32 global index
+	ImmMemArg                          // u32 alignment exponent followed by u32 offset
+	ImmReserved                        // zero byte reserved for a table or memory index
+	ImmI32                             // s32 constant
+	ImmI64                             // s64 constant
+	ImmF32                             // 4 bytes little endian
+	ImmF64                             // 8 bytes little endian
+	ImmSubOpcode                       // u32 opcode following a prefix
+)
+
+var immediateNames = [...]string{"", "blocktype", "label", "labels", "func", "type", "local", "global", "memarg",
+	"reserved", "i32", "i64", "f32", "f64", "subop"}
+
+func (imm Immediate) String() string {
+	if int(imm) < len(immediateNames) && imm != 0 {
+		return immediateNames[imm]
+	}
+	return fmt.Sprintf("<unknown %d>", byte(imm))
+}
+
+// Proposal is the feature proposal an opcode was introduced by
+type Proposal byte
+
+// Feature proposals
+const (
+	ProposalMVP Proposal = iota
+	ProposalSignExtension
+	ProposalNontrappingFloatToInt
+)
+
+var proposalNames = [...]string{"mvp", "sign-extension", "nontrapping-fptoint"}
+
+func (p Proposal) String() string {
+	if int(p) < len(proposalNames) {
+		return proposalNames[p]
+	}
+	return fmt.Sprintf("<unknown %d>", byte(p))
+}
+
+// Info describes an opcode
+type Info struct {
+	Name       string // text format mnemonic
+	Immediates []Immediate
+	Params     []ValueType // popped operands, deepest first
+	Results    []ValueType
+	// Polymorphic is set when the stack effect depends on the module or the
+	// instruction context, Params and Results are then empty
+	Polymorphic bool
+	Proposal    Proposal
+}
+
+// Info returns the metadata of op, nil for unknown opcodes
+func (op Opcode) Info() *Info {
+	return infos[op]
+}
+
+// String returns the text format mnemonic of op
+func (op Opcode) String() string {
+	if info := infos[op]; info != nil {
+		return info.Name
+	}
+	return fmt.Sprintf("<unknown 0x%02x>", byte(op))
+}
+
+// SubInfo returns the metadata of an opcode prefixed by ITruncSatF, nil for unknown ones
+func SubInfo(subop uint32) *Info {
+	if int(subop) < len(subInfos) {
+		return subInfos[subop]
+	}
+	return nil
+}
+
+// Lookup returns the opcode of a text format mnemonic
+func Lookup(name string) (Opcode, bool) {
+	op, ok := mnemonics[name]
+	return op, ok
+}
@@ -0,0 +1,39 @@
+package opcode
+
+import "testing"
+
+func TestInfo(t *testing.T) {
+	count := 0
+	for i := 0; i < 256; i++ {
+		op := Opcode(i)
+		info := op.Info()
+		if info == nil {
+			continue
+		}
+		count++
+		if found, ok := Lookup(info.Name); !ok || found != op {
+			t.Errorf("Expect mnemonic %s to map back to 0x%02x, got 0x%02x", info.Name, i, byte(found))
+		}
+		if info.Polymorphic && (info.Params != nil || info.Results != nil) {
+			t.Errorf("Expect no stack types for polymorphic %s", info.Name)
+		}
+		if isMem := len(info.Immediates) == 1 && info.Immediates[0] == ImmMemArg; isMem != (op.MemAccessSize() > 0) {
+			t.Errorf("Expect memarg immediate for memory access %s", info.Name)
+		}
+	}
+	if count != 178 {
+		t.Errorf("Expect 178 opcodes, got %d", count)
+	}
+	if I32Add.String() != "i32.add" || Opcode(0xff).String() != "<unknown 0xff>" {
+		t.Errorf("Unexpected names %s %s", I32Add, Opcode(0xff))
+	}
+	if info := I64Store32.Info(); len(info.Params) != 2 || info.Params[1] != ValueI64 || info.Results != nil {
+		t.Errorf("Unexpected i64.store32 stack types %v -> %v", info.Params, info.Results)
+	}
+	if info := SubInfo(6); info == nil || info.Name != "i64.trunc_sat_f64_s" || info.Proposal != ProposalNontrappingFloatToInt {
+		t.Errorf("Unexpected sub opcode info %+v", info)
+	}
+	if SubInfo(8) != nil {
+		t.Error("Expect no sub opcode 8")
+	}
+}
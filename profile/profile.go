@@ -0,0 +1,313 @@
+// Package profile attributes instruction counts, gas and wall time of a VM
+// execution to wasm functions and opcodes, and writes them as a pprof profile.
+package profile
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// noOp marks time spent outside of an instruction, such as in host functions
+const noOp = -1
+
+// Stats holds the cost attributed to a function or an opcode
+type Stats struct {
+	Instructions uint64
+	Gas          uint64
+	Time         time.Duration
+}
+
+func (s *Stats) add(o *Stats) {
+	s.Instructions += o.Instructions
+	s.Gas += o.Gas
+	s.Time += o.Time
+}
+
+type sampleKey struct {
+	stack string // comma separated function indices, caller first
+	op    int
+}
+
+// Profiler is a vm.Tracer attributing execution cost to call stacks
+type Profiler struct {
+	machine   *vm.VM
+	names     map[int]string
+	stack     []int
+	stackKey  string
+	pending   sampleKey
+	lastGas   uint64
+	lastTime  time.Time
+	startTime time.Time
+	duration  time.Duration
+	samples   map[sampleKey]*Stats
+}
+
+// Start installs a new Profiler as the tracer of machine
+func Start(machine *vm.VM) *Profiler {
+	p := &Profiler{
+		machine:   machine,
+		names:     functionNames(machine.Module),
+		pending:   sampleKey{op: noOp},
+		samples:   make(map[sampleKey]*Stats),
+		startTime: time.Now(),
+	}
+	p.lastTime = p.startTime
+	p.lastGas = machine.GetGasUsed()
+	machine.SetTracer(p)
+	return p
+}
+
+// Stop uninstalls the profiler from its VM
+func (p *Profiler) Stop() {
+	p.flush()
+	p.duration = time.Since(p.startTime)
+	p.machine.SetTracer(nil)
+}
+
+func functionNames(m *wasm.Module) map[int]string {
+	names := make(map[int]string)
+	importCount := 0
+	if m.ImportSec != nil {
+		for _, entry := range m.ImportSec.Imports {
+			if entry.ImportDesc.Kind == wasm.ExternalFunction {
+				names[importCount] = entry.ModuleName + "." + entry.FieldName
+				importCount++
+			}
+		}
+	}
+	for i := range m.FunctionIndexSpace {
+		names[importCount+i] = fmt.Sprintf("func[%d]", importCount+i)
+	}
+	if m.NameSec != nil {
+		for idx, name := range m.NameSec.FunctionNames {
+			names[int(idx)] = name
+		}
+	}
+	return names
+}
+
+// FunctionName returns the name section name of function fidx or a generated one
+func (p *Profiler) FunctionName(fidx int) string {
+	if name, ok := p.names[fidx]; ok {
+		return name
+	}
+	return fmt.Sprintf("func[%d]", fidx)
+}
+
+// flush attributes the gas and time elapsed since the last event to the pending sample
+func (p *Profiler) flush() {
+	now := time.Now()
+	gas := p.machine.GetGasUsed()
+	if p.pending.stack != "" {
+		s := p.sample(p.pending)
+		if gas > p.lastGas {
+			s.Gas += gas - p.lastGas
+		}
+		s.Time += now.Sub(p.lastTime)
+	}
+	p.lastGas = gas
+	p.lastTime = now
+}
+
+func (p *Profiler) sample(key sampleKey) *Stats {
+	s, ok := p.samples[key]
+	if !ok {
+		s = &Stats{}
+		p.samples[key] = s
+	}
+	return s
+}
+
+func (p *Profiler) setStack(stack []int) {
+	p.stack = stack
+	keys := make([]string, len(stack))
+	for i, fidx := range stack {
+		keys[i] = strconv.Itoa(fidx)
+	}
+	p.stackKey = strings.Join(keys, ",")
+	p.pending = sampleKey{stack: p.stackKey, op: noOp}
+}
+
+// OnInstruction attributes one instruction to the current stack
+func (p *Profiler) OnInstruction(machine *vm.VM, frame *vm.Frame, ip int, op opcode.Opcode, stack []uint64) {
+	p.flush()
+	if p.stackKey == "" {
+		// started in the middle of a call
+		p.setStack([]int{frame.FunctionIndex()})
+	}
+	p.pending = sampleKey{stack: p.stackKey, op: int(op)}
+	p.sample(p.pending).Instructions++
+}
+
+// OnCall pushes fidx on the profiled call stack
+func (p *Profiler) OnCall(machine *vm.VM, fidx int, args []uint64) {
+	p.flush()
+	p.setStack(append(p.stack, fidx))
+}
+
+// OnReturn pops the profiled call stack
+func (p *Profiler) OnReturn(machine *vm.VM, fidx int, results []uint64) {
+	p.flush()
+	if len(p.stack) > 0 {
+		p.setStack(p.stack[:len(p.stack)-1])
+	}
+}
+
+// OnMemoryAccess is a no-op
+func (p *Profiler) OnMemoryAccess(machine *vm.VM, address int, size int, write bool) {}
+
+// OnTrap unwinds the profiled call stack
+func (p *Profiler) OnTrap(machine *vm.VM, err error) {
+	p.flush()
+	p.setStack(nil)
+}
+
+func parseStack(key string) []int {
+	if key == "" {
+		return nil
+	}
+	parts := strings.Split(key, ",")
+	stack := make([]int, len(parts))
+	for i, part := range parts {
+		stack[i], _ = strconv.Atoi(part)
+	}
+	return stack
+}
+
+// Functions returns the cost of each function excluding its callees
+func (p *Profiler) Functions() map[int]Stats {
+	stats := make(map[int]Stats)
+	for key, s := range p.samples {
+		stack := parseStack(key.stack)
+		fidx := stack[len(stack)-1]
+		fs := stats[fidx]
+		fs.add(s)
+		stats[fidx] = fs
+	}
+	return stats
+}
+
+// Opcodes returns the cost of each opcode over all functions
+func (p *Profiler) Opcodes() map[opcode.Opcode]Stats {
+	stats := make(map[opcode.Opcode]Stats)
+	for key, s := range p.samples {
+		if key.op == noOp {
+			continue
+		}
+		os := stats[opcode.Opcode(key.op)]
+		os.add(s)
+		stats[opcode.Opcode(key.op)] = os
+	}
+	return stats
+}
+
+// WriteProfile writes the profile in gzipped pprof protobuf format, readable by go tool pprof
+func (p *Profiler) WriteProfile(w io.Writer) error {
+	strs := []string{""}
+	strIndex := map[string]int64{"": 0}
+	str := func(s string) int64 {
+		if i, ok := strIndex[s]; ok {
+			return i
+		}
+		strIndex[s] = int64(len(strs))
+		strs = append(strs, s)
+		return strIndex[s]
+	}
+
+	keys := make([]sampleKey, 0, len(p.samples))
+	for key := range p.samples {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].stack != keys[j].stack {
+			return keys[i].stack < keys[j].stack
+		}
+		return keys[i].op < keys[j].op
+	})
+
+	var b protoBuffer
+	valueTypes := [][2]string{{"instructions", "count"}, {"gas", "units"}, {"time", "nanoseconds"}}
+	for _, vt := range valueTypes {
+		typ, unit := str(vt[0]), str(vt[1])
+		b.message(1, func(b *protoBuffer) {
+			b.int64(1, typ)
+			b.int64(2, unit)
+		})
+	}
+
+	functions := make(map[int]bool)
+	for _, key := range keys {
+		s := p.samples[key]
+		stack := parseStack(key.stack)
+		locations := make([]uint64, len(stack))
+		for i, fidx := range stack {
+			// pprof lists the leaf location first
+			locations[len(stack)-1-i] = uint64(fidx) + 1
+			functions[fidx] = true
+		}
+		opKey, opValue := str("opcode"), int64(0)
+		if key.op != noOp {
+			opValue = str(opcode.Opcode(key.op).String())
+		}
+		b.message(2, func(b *protoBuffer) {
+			b.packed(1, locations)
+			b.packed(2, []uint64{s.Instructions, s.Gas, uint64(s.Time.Nanoseconds())})
+			if opValue != 0 {
+				b.message(3, func(b *protoBuffer) {
+					b.int64(1, opKey)
+					b.int64(2, opValue)
+				})
+			}
+		})
+	}
+
+	fidxs := make([]int, 0, len(functions))
+	for fidx := range functions {
+		fidxs = append(fidxs, fidx)
+	}
+	sort.Ints(fidxs)
+	for _, fidx := range fidxs {
+		id := uint64(fidx) + 1
+		b.message(4, func(b *protoBuffer) {
+			b.uint64(1, id)
+			b.message(4, func(b *protoBuffer) {
+				b.uint64(1, id)
+			})
+		})
+	}
+	for _, fidx := range fidxs {
+		id, name := uint64(fidx)+1, str(p.FunctionName(fidx))
+		b.message(5, func(b *protoBuffer) {
+			b.uint64(1, id)
+			b.int64(2, name)
+			b.int64(3, name)
+		})
+	}
+
+	periodType, periodUnit := str("instructions"), str("count")
+	for _, s := range strs {
+		b.string(6, s)
+	}
+	b.int64(9, p.startTime.UnixNano())
+	b.int64(10, p.duration.Nanoseconds())
+	b.message(11, func(b *protoBuffer) {
+		b.int64(1, periodType)
+		b.int64(2, periodUnit)
+	})
+	b.int64(12, 1)
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(b.data); err != nil {
+		return err
+	}
+	return zw.Close()
+}
@@ -0,0 +1,121 @@
+package profile
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/vm"
+)
+
+func section(id byte, content ...byte) []byte {
+	return append([]byte{id, byte(len(content))}, content...)
+}
+
+// testModule exports "main" which calls "helper" 3 times, helper is named by the name section
+var testModule = bytes.Join([][]byte{
+	{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00},
+	section(1, 0x01, 0x60, 0x00, 0x01, 0x7f),
+	section(3, 0x02, 0x00, 0x00),
+	section(7, 0x01, 0x04, 'm', 'a', 'i', 'n', 0x00, 0x00),
+	section(10, 0x02,
+		0x0a, 0x00, 0x10, 0x01, 0x10, 0x01, 0x6a, 0x10, 0x01, 0x6a, 0x0b,
+		0x07, 0x00, 0x41, 0x02, 0x41, 0x03, 0x6c, 0x0b),
+	section(0, 0x04, 'n', 'a', 'm', 'e',
+		0x01, 0x09, 0x01, 0x01, 0x06, 'h', 'e', 'l', 'p', 'e', 'r'),
+}, nil)
+
+func readProtoStrings(t *testing.T, data []byte, field uint64) []string {
+	var strs []string
+	for i := 0; i < len(data); {
+		key, n := decodeVarint(data[i:])
+		i += n
+		switch key & 7 {
+		case 0:
+			_, n = decodeVarint(data[i:])
+			i += n
+		case 2:
+			l, n := decodeVarint(data[i:])
+			i += n
+			if key>>3 == field {
+				strs = append(strs, string(data[i:i+int(l)]))
+			}
+			i += int(l)
+		default:
+			t.Fatalf("Unexpected wire type %d", key&7)
+		}
+	}
+	return strs
+}
+
+func decodeVarint(b []byte) (uint64, int) {
+	var x uint64
+	for i, c := range b {
+		x |= uint64(c&0x7f) << (7 * uint(i))
+		if c < 0x80 {
+			return x, i + 1
+		}
+	}
+	return 0, len(b)
+}
+
+func TestProfiler(t *testing.T) {
+	machine, err := vm.NewVM(testModule, &vm.SimpleGasPolicy{}, &vm.Gas{Limit: 100}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fidx, ok := machine.GetFunctionIndex("main")
+	if !ok {
+		t.Fatal("cannot get function export")
+	}
+	p := Start(machine)
+	ret, err := machine.Invoke(fidx)
+	p.Stop()
+	if err != nil || ret != 18 {
+		t.Fatalf("Expect main to return 18, got %d %v", ret, err)
+	}
+
+	funcs := p.Functions()
+	if funcs[0].Instructions != 5 || funcs[0].Gas != 5 {
+		t.Errorf("Expect main to execute 5 instructions, got %+v", funcs[0])
+	}
+	if funcs[1].Instructions != 9 || funcs[1].Gas != 9 {
+		t.Errorf("Expect helper to execute 9 instructions, got %+v", funcs[1])
+	}
+	if ops := p.Opcodes(); ops[opcode.I32Const].Instructions != 6 || ops[opcode.Call].Instructions != 3 {
+		t.Errorf("Unexpected opcode stats %+v", ops)
+	}
+	if p.FunctionName(0) != "func[0]" || p.FunctionName(1) != "helper" {
+		t.Errorf("Unexpected function names %s %s", p.FunctionName(0), p.FunctionName(1))
+	}
+
+	var out bytes.Buffer
+	if err := p.WriteProfile(&out); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	strs := readProtoStrings(t, data, 6)
+	expected := map[string]bool{"": false, "instructions": false, "gas": false, "helper": false, "func[0]": false, "i32.mul": false}
+	for _, s := range strs {
+		if _, ok := expected[s]; ok {
+			expected[s] = true
+		}
+	}
+	for s, found := range expected {
+		if !found {
+			t.Errorf("Expect string table to contain %q, got %v", s, strs)
+		}
+	}
+	if samples := readProtoStrings(t, data, 2); len(samples) != 6 {
+		t.Errorf("Expect 6 samples, got %d", len(samples))
+	}
+}
@@ -0,0 +1,62 @@
+package profile
+
+// protoBuffer encodes the subset of protocol buffers wire format used by profile.proto
+type protoBuffer struct {
+	data []byte
+}
+
+const (
+	wireVarint = 0
+	wireBytes  = 2
+)
+
+func (b *protoBuffer) varint(x uint64) {
+	for x >= 0x80 {
+		b.data = append(b.data, byte(x)|0x80)
+		x >>= 7
+	}
+	b.data = append(b.data, byte(x))
+}
+
+func (b *protoBuffer) key(tag int, wireType int) {
+	b.varint(uint64(tag)<<3 | uint64(wireType))
+}
+
+func (b *protoBuffer) uint64(tag int, x uint64) {
+	if x == 0 {
+		return
+	}
+	b.key(tag, wireVarint)
+	b.varint(x)
+}
+
+func (b *protoBuffer) int64(tag int, x int64) {
+	b.uint64(tag, uint64(x))
+}
+
+func (b *protoBuffer) string(tag int, s string) {
+	b.key(tag, wireBytes)
+	b.varint(uint64(len(s)))
+	b.data = append(b.data, s...)
+}
+
+func (b *protoBuffer) packed(tag int, xs []uint64) {
+	if len(xs) == 0 {
+		return
+	}
+	var inner protoBuffer
+	for _, x := range xs {
+		inner.varint(x)
+	}
+	b.key(tag, wireBytes)
+	b.varint(uint64(len(inner.data)))
+	b.data = append(b.data, inner.data...)
+}
+
+func (b *protoBuffer) message(tag int, encode func(*protoBuffer)) {
+	var inner protoBuffer
+	encode(&inner)
+	b.key(tag, wireBytes)
+	b.varint(uint64(len(inner.data)))
+	b.data = append(b.data, inner.data...)
+}
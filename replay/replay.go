@@ -0,0 +1,343 @@
+// Package replay records the host calls of an execution and serves them back
+// to re-execute it offline, without the host functions and their backends.
+//
+// The Recorder wraps an ImportResolver and writes one JSON object per host
+// call with its arguments, result, error, the gas it burnt and the bytes of
+// memory it changed. The Replayer is an ImportResolver reading the log which
+// applies the recorded effects instead of calling the host, and reports a
+// divergence when the execution makes a different call.
+//
+// Only the effects on memory and gas are replayed, a host function changing
+// globals or tables through a nested invocation is not reproduced. Calls made
+// by nested invocations are logged with their depth, their effects are part
+// of the enclosing call and they are not served on replay.
+package replay
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/vertexdlt/vertexvm/vm"
+)
+
+// Call is a recorded host call
+type Call struct {
+	Module           string   `json:"module"`
+	Name             string   `json:"name"`
+	Args             []uint64 `json:"args"`
+	Result           uint64   `json:"result"`
+	Error            string   `json:"error,omitempty"`
+	Gas              uint64   `json:"gas"`                // gas burnt by the host function
+	Writes           []Write  `json:"writes,omitempty"`   // memory changed by the call
+	MemorySize       int      `json:"memory_size"`        // memory size in bytes after the call
+	MemorySizeBefore int      `json:"memory_size_before"` // memory size in bytes before the call
+	Depth            int      `json:"depth,omitempty"`    // nested invocations the call was made in
+}
+
+// Write is a range of memory changed by a host call
+type Write struct {
+	Offset int    `json:"offset"`
+	Data   []byte `json:"data"`
+}
+
+// knownErrors are the VM errors given back with their identity on replay,
+// other errors are replayed with their message only
+var knownErrors = []error{
+	vm.ErrOutOfGas,
+	vm.ErrYield,
+	vm.ErrOutOfBoundMemoryAccess,
+	vm.ErrReentrancyDepth,
+	vm.ErrWrongNumberOfArgs,
+}
+
+// Recorder is an ImportResolver logging the calls of the host functions it
+// wraps. It keeps a copy of the memory as it was before the outermost call,
+// updated from the chunks written by the module, and finds the changes of a
+// call in the chunks written during it.
+type Recorder struct {
+	resolver vm.ImportResolver
+	enc      *json.Encoder
+	shadows  map[*vm.Memory][]byte
+	calls    []*recording // calls in progress, the outermost first
+	err      error
+}
+
+// recording is a host call in progress
+type recording struct {
+	before  map[int][]byte // chunks written by the enclosing calls, as the call started
+	written map[int]bool
+}
+
+// NewRecorder creates a Recorder logging the calls of the functions resolved by resolver to w
+func NewRecorder(resolver vm.ImportResolver, w io.Writer) *Recorder {
+	return &Recorder{resolver: resolver, enc: json.NewEncoder(w), shadows: make(map[*vm.Memory][]byte)}
+}
+
+// Err returns the first write error, calls are not logged after it
+func (r *Recorder) Err() error {
+	return r.err
+}
+
+// GetFunction returns the host function resolved by the wrapped resolver,
+// recording its calls
+func (r *Recorder) GetFunction(module, name string) vm.HostFunction {
+	hf := r.resolver.GetFunction(module, name)
+	if hf == nil {
+		return nil
+	}
+	return func(machine *vm.VM, args ...uint64) (uint64, error) {
+		size := machine.MemSize()
+		rec := r.start(machine.Memory())
+		used := machine.GetGasUsed()
+		ret, err := hf(machine, args...)
+		writes := r.end(machine.Memory(), rec)
+		call := &Call{
+			Module:           module,
+			Name:             name,
+			Args:             append([]uint64{}, args...),
+			Result:           ret,
+			Gas:              machine.GetGasUsed() - used,
+			MemorySize:       machine.MemSize(),
+			MemorySizeBefore: size,
+			Writes:           writes,
+			Depth:            len(r.calls),
+		}
+		if err != nil {
+			call.Error = err.Error()
+		}
+		if r.err == nil {
+			r.err = r.enc.Encode(call)
+		}
+		return ret, err
+	}
+}
+
+// start begins recording a call. The chunks written since the last call are
+// copied to the shadow of the memory by an outermost call, a nested call
+// counts them as written by the enclosing calls and copies their content.
+func (r *Recorder) start(mem *vm.Memory) *recording {
+	shadow, ok := r.shadows[mem]
+	if !ok {
+		mem.TrackWrites()
+		shadow, _ = mem.ReadBytes(0, uint32(mem.Size()))
+	}
+	chunks := mem.WrittenChunks()
+	rec := &recording{before: make(map[int][]byte), written: make(map[int]bool)}
+	if len(r.calls) == 0 {
+		shadow = grow(shadow, mem.Size())
+		for _, i := range chunks {
+			copy(shadow[i*vm.MerkleChunkSize:], chunk(mem, i))
+		}
+	} else {
+		r.markWritten(chunks)
+		for i := range r.calls[0].written {
+			rec.before[i] = chunk(mem, i)
+		}
+	}
+	r.shadows[mem] = shadow
+	r.calls = append(r.calls, rec)
+	return rec
+}
+
+// end finishes recording the call rec and returns the memory it changed
+func (r *Recorder) end(mem *vm.Memory, rec *recording) []Write {
+	r.markWritten(mem.WrittenChunks())
+	r.calls = r.calls[:len(r.calls)-1]
+	shadow := r.shadows[mem]
+	indexes := make([]int, 0, len(rec.written))
+	for i := range rec.written {
+		indexes = append(indexes, i)
+	}
+	sort.Ints(indexes)
+	var writes []Write
+	for _, i := range indexes {
+		before, ok := rec.before[i]
+		if !ok && i*vm.MerkleChunkSize < len(shadow) {
+			before = shadow[i*vm.MerkleChunkSize : (i+1)*vm.MerkleChunkSize]
+		}
+		for _, w := range diff(before, chunk(mem, i)) {
+			w.Offset += i * vm.MerkleChunkSize
+			if n := len(writes); n > 0 && writes[n-1].Offset+len(writes[n-1].Data) == w.Offset {
+				writes[n-1].Data = append(writes[n-1].Data, w.Data...)
+			} else {
+				writes = append(writes, w)
+			}
+		}
+	}
+	if len(r.calls) == 0 {
+		shadow = grow(shadow, mem.Size())
+		for _, i := range indexes {
+			copy(shadow[i*vm.MerkleChunkSize:], chunk(mem, i))
+		}
+		r.shadows[mem] = shadow
+	}
+	return writes
+}
+
+// grow zero extends shadow to the size of a grown memory
+func grow(shadow []byte, size int) []byte {
+	return append(shadow, make([]byte, size-len(shadow))...)
+}
+
+// markWritten adds the chunks to the calls in progress
+func (r *Recorder) markWritten(chunks []int) {
+	for _, rec := range r.calls {
+		for _, i := range chunks {
+			rec.written[i] = true
+		}
+	}
+}
+
+// chunk returns a copy of chunk i of the memory
+func chunk(mem *vm.Memory, i int) []byte {
+	b, _ := mem.ReadBytes(uint32(i*vm.MerkleChunkSize), vm.MerkleChunkSize)
+	return b
+}
+
+// diff returns the ranges of after differing from before, bytes past the end
+// of before are compared to zero as in grown memory
+func diff(before, after []byte) []Write {
+	var writes []Write
+	start := -1
+	for i := 0; i <= len(after); i++ {
+		changed := false
+		if i < len(after) {
+			if i < len(before) {
+				changed = after[i] != before[i]
+			} else {
+				changed = after[i] != 0
+			}
+		}
+		switch {
+		case changed && start < 0:
+			start = i
+		case !changed && start >= 0:
+			writes = append(writes, Write{Offset: start, Data: append([]byte{}, after[start:i]...)})
+			start = -1
+		}
+	}
+	return writes
+}
+
+// ErrLogExhausted is the divergence of a call made after the recorded ones
+var ErrLogExhausted = errors.New("replay: no more recorded calls")
+
+// DivergenceError reports a call differing from the recorded one
+type DivergenceError struct {
+	Index    int   // index of the recorded call in the log
+	Expected *Call // recorded call, nil when the log is exhausted
+	Module   string
+	Name     string
+	Args     []uint64
+	Err      error // reason of the divergence
+}
+
+func (e *DivergenceError) Error() string {
+	return fmt.Sprintf("replay: call %d %s.%s%v diverges: %v", e.Index, e.Module, e.Name, e.Args, e.Err)
+}
+
+// Replayer is an ImportResolver serving recorded host calls
+type Replayer struct {
+	calls      []*Call
+	next       int
+	divergence *DivergenceError
+}
+
+// NewReplayer reads a log written by a Recorder
+func NewReplayer(r io.Reader) (*Replayer, error) {
+	p := &Replayer{}
+	dec := json.NewDecoder(r)
+	for {
+		call := &Call{}
+		if err := dec.Decode(call); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("replay: call %d: %v", len(p.calls), err)
+		}
+		if call.Depth == 0 {
+			p.calls = append(p.calls, call)
+		}
+	}
+	return p, nil
+}
+
+// Divergence returns the first divergence, execution fails when it occurs
+func (p *Replayer) Divergence() *DivergenceError {
+	return p.divergence
+}
+
+// Remaining returns the number of recorded calls not replayed yet
+func (p *Replayer) Remaining() int {
+	return len(p.calls) - p.next
+}
+
+// GetFunction returns a host function serving the recorded calls of module.name
+func (p *Replayer) GetFunction(module, name string) vm.HostFunction {
+	return func(machine *vm.VM, args ...uint64) (uint64, error) {
+		diverge := func(expected *Call, err error) error {
+			if p.divergence == nil {
+				p.divergence = &DivergenceError{Index: p.next, Expected: expected, Module: module, Name: name, Args: args, Err: err}
+			}
+			return p.divergence
+		}
+		if p.divergence != nil {
+			return 0, p.divergence
+		}
+		if p.next >= len(p.calls) {
+			return 0, diverge(nil, ErrLogExhausted)
+		}
+		call := p.calls[p.next]
+		if call.Module != module || call.Name != name || !equalArgs(call.Args, args) {
+			return 0, diverge(call, fmt.Errorf("recorded %s.%s%v", call.Module, call.Name, call.Args))
+		}
+		if call.MemorySizeBefore != machine.MemSize() {
+			return 0, diverge(call, fmt.Errorf("recorded memory size %d, got %d", call.MemorySizeBefore, machine.MemSize()))
+		}
+		p.next++
+		if err := machine.BurnGas(call.Gas); err != nil {
+			return 0, err
+		}
+		// the memory grown by a nested invocation, its gas is part of call.Gas
+		for machine.MemSize() < call.MemorySize {
+			if machine.Memory().Grow(1) < 0 {
+				return 0, diverge(call, fmt.Errorf("cannot grow the memory to %d bytes", call.MemorySize))
+			}
+		}
+		if call.MemorySize != machine.MemSize() {
+			return 0, diverge(call, fmt.Errorf("recorded memory size %d after the call, got %d", call.MemorySize, machine.MemSize()))
+		}
+		for _, w := range call.Writes {
+			if err := machine.Memory().WriteBytes(uint32(w.Offset), w.Data); err != nil {
+				return 0, diverge(call, err)
+			}
+		}
+		if call.Error != "" {
+			return call.Result, replayedError(call.Error)
+		}
+		return call.Result, nil
+	}
+}
+
+func equalArgs(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func replayedError(message string) error {
+	for _, err := range knownErrors {
+		if err.Error() == message {
+			return err
+		}
+	}
+	return errors.New(message)
+}
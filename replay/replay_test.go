@@ -0,0 +1,234 @@
+package replay
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+type hostResolver map[string]vm.HostFunction
+
+func (r hostResolver) GetFunction(module, name string) vm.HostFunction {
+	return r[name]
+}
+
+// replayModule sums the bytes fetched from the host, then checks them
+const replayModule = `(module
+  (import "env" "fetch" (func $fetch (param i32 i32) (result i32)))
+  (import "env" "check" (func $check (param i32)))
+  (import "env" "callback" (func $callback))
+  (memory 1)
+  (func (export "run") (param $key i32) (result i32)
+    (local $n i32) (local $sum i32)
+    (local.set $n (call $fetch (local.get $key) (i32.const 16)))
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $n)))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (local.set $sum (i32.add (local.get $sum) (i32.load8_u (i32.add (i32.const 16) (local.get $n)))))
+        (br $next)))
+    (call $callback)
+    (call $check (local.get $sum))
+    (local.get $sum))
+  (func (export "inner") (drop (call $fetch (i32.const 1) (i32.const 64)))))`
+
+var errTooLarge = errors.New("sum too large")
+
+func newHost() hostResolver {
+	return hostResolver{
+		"fetch": func(machine *vm.VM, args ...uint64) (uint64, error) {
+			data := bytes.Repeat([]byte{byte(args[0])}, int(args[0]))
+			if err := machine.BurnGas(uint64(len(data))); err != nil {
+				return 0, err
+			}
+			return uint64(len(data)), machine.Memory().WriteBytes(uint32(args[1]), data)
+		},
+		"check": func(machine *vm.VM, args ...uint64) (uint64, error) {
+			if args[0] > 50 {
+				return 0, errTooLarge
+			}
+			return 0, nil
+		},
+		"callback": func(machine *vm.VM, args ...uint64) (uint64, error) {
+			_, err := machine.InvokeByName("inner")
+			return 0, err
+		},
+	}
+}
+
+func run(t *testing.T, resolver vm.ImportResolver, key int32) (*vm.VM, []vm.Value, error) {
+	code, err := wat.Compile([]byte(replayModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	machine, err := vm.NewVM(code, &vm.SimpleGasPolicy{}, &vm.Gas{Limit: 1 << 20}, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	results, err := machine.InvokeByName("run", vm.I32(key))
+	return machine, results, err
+}
+
+func TestReplay(t *testing.T) {
+	for _, key := range []int32{5, 8} {
+		var log bytes.Buffer
+		recorder := NewRecorder(newHost(), &log)
+		recorded, expected, expectedErr := run(t, recorder, key)
+		if recorder.Err() != nil {
+			t.Fatal(recorder.Err())
+		}
+		if lines := strings.Count(log.String(), "\n"); lines != 4 {
+			t.Errorf("Expect 4 calls logged including the nested one, got %d", lines)
+		}
+
+		replayer, err := NewReplayer(&log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		replayed, results, err := run(t, replayer, key)
+		if err != expectedErr && (err == nil || expectedErr == nil || err.Error() != expectedErr.Error()) {
+			t.Errorf("key %d: expect %v, got %v", key, expectedErr, err)
+		}
+		if len(results) != len(expected) || (len(results) != 0 && results[0] != expected[0]) {
+			t.Errorf("key %d: expect %v, got %v", key, expected, results)
+		}
+		if replayer.Divergence() != nil || replayer.Remaining() != 0 {
+			t.Errorf("key %d: expect an exact replay, got %v with %d calls left", key, replayer.Divergence(), replayer.Remaining())
+		}
+		a, _ := recorded.Memory().ReadBytes(0, 128)
+		b, _ := replayed.Memory().ReadBytes(0, 128)
+		if !bytes.Equal(a, b) || recorded.GetGasUsed() != replayed.GetGasUsed() {
+			t.Errorf("key %d: expect the same memory and gas, got %d and %d gas", key, recorded.GetGasUsed(), replayed.GetGasUsed())
+		}
+	}
+}
+
+func TestDivergence(t *testing.T) {
+	var log bytes.Buffer
+	run(t, NewRecorder(newHost(), &log), 5)
+	replayer, err := NewReplayer(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _, err = run(t, replayer, 6)
+	d := replayer.Divergence()
+	if d == nil || err != d || d.Index != 0 || d.Name != "fetch" || d.Expected.Args[0] != 5 {
+		t.Errorf("Expect the first fetch to diverge, got %v", err)
+	}
+
+	if _, err := NewReplayer(strings.NewReader(`{"module": 1}`)); err == nil {
+		t.Error("Expect a malformed log to be rejected")
+	}
+	replayer, _ = NewReplayer(strings.NewReader(""))
+	if _, _, err := run(t, replayer, 5); replayer.Divergence() == nil || replayer.Divergence().Err != ErrLogExhausted {
+		t.Errorf("Expect %v, got %v", ErrLogExhausted, err)
+	}
+}
+
+// growModule reads a byte the host writes past the memory it grows
+const growModule = `(module
+  (import "env" "expand" (func $expand (result i32)))
+  (memory 1)
+  (func (export "grow") (drop (memory.grow (i32.const 1))))
+  (func (export "run") (result i32) (i32.load8_u (call $expand))))`
+
+func TestReplayGrownMemory(t *testing.T) {
+	code, err := wat.Compile([]byte(growModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := hostResolver{
+		"expand": func(machine *vm.VM, args ...uint64) (uint64, error) {
+			if _, err := machine.InvokeByName("grow"); err != nil {
+				return 0, err
+			}
+			return 70000, machine.Memory().WriteUint8(70000, 42)
+		},
+	}
+	replay := func(resolver vm.ImportResolver) (*vm.VM, []vm.Value, error) {
+		machine, err := vm.NewVM(code, &vm.SimpleGasPolicy{}, &vm.Gas{Limit: 1 << 20}, resolver)
+		if err != nil {
+			t.Fatal(err)
+		}
+		results, err := machine.InvokeByName("run")
+		return machine, results, err
+	}
+
+	var log bytes.Buffer
+	recorded, expected, err := replay(NewRecorder(host, &log))
+	if err != nil || expected[0] != vm.I32(42) {
+		t.Fatalf("Expect 42, got %v %v", expected, err)
+	}
+	replayer, err := NewReplayer(&log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	replayed, results, err := replay(replayer)
+	if err != nil || results[0] != expected[0] {
+		t.Errorf("Expect %v, got %v %v", expected, results, err)
+	}
+	if replayed.Memory().Pages() != 2 || recorded.GetGasUsed() != replayed.GetGasUsed() {
+		t.Errorf("Expect the grown memory and the same gas, got %d pages and %d gas instead of %d",
+			replayed.Memory().Pages(), replayed.GetGasUsed(), recorded.GetGasUsed())
+	}
+}
+
+// writeModule writes to memory it grew before and between host writes
+const writeModule = `(module
+  (import "env" "touch" (func $touch (param i32)))
+  (memory 1)
+  (func (export "run")
+    (drop (memory.grow (i32.const 1)))
+    (i32.store8 (i32.const 70000) (i32.const 1))
+    (call $touch (i32.const 70001))
+    (i32.store8 (i32.const 5) (i32.const 3))
+    (call $touch (i32.const 70000))))`
+
+func TestRecordedWrites(t *testing.T) {
+	code, err := wat.Compile([]byte(writeModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	host := hostResolver{
+		"touch": func(machine *vm.VM, args ...uint64) (uint64, error) {
+			return 0, machine.Memory().WriteUint8(uint32(args[0]), 2)
+		},
+	}
+	var log bytes.Buffer
+	machine, err := vm.NewVM(code, &vm.SimpleGasPolicy{}, &vm.Gas{Limit: 1 << 20}, NewRecorder(host, &log))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := machine.InvokeByName("run"); err != nil {
+		t.Fatal(err)
+	}
+	expected := []Write{{70001, []byte{2}}, {70000, []byte{2}}}
+	dec := json.NewDecoder(&log)
+	for i := range expected {
+		var call Call
+		if err := dec.Decode(&call); err != nil {
+			t.Fatal(err)
+		}
+		if len(call.Writes) != 1 || call.Writes[0].Offset != expected[i].Offset || !bytes.Equal(call.Writes[0].Data, expected[i].Data) {
+			t.Errorf("Expect call %d to write only %v, got %v", i, expected[i], call.Writes)
+		}
+	}
+}
+
+func TestDiff(t *testing.T) {
+	writes := diff([]byte{1, 2, 3, 4, 5}, []byte{1, 9, 9, 4, 6, 0, 7})
+	expected := []Write{{1, []byte{9, 9}}, {4, []byte{6}}, {6, []byte{7}}}
+	if len(writes) != len(expected) {
+		t.Fatalf("Expect %v, got %v", expected, writes)
+	}
+	for i := range writes {
+		if writes[i].Offset != expected[i].Offset || !bytes.Equal(writes[i].Data, expected[i].Data) {
+			t.Errorf("Expect %v, got %v", expected[i], writes[i])
+		}
+	}
+}
@@ -5,8 +5,3 @@ wget -O - -q https://raw.githubusercontent.com/golangci/golangci-lint/master/ins
 # Move golangci-lint to path
 sudo mv ./bin/* /usr/local/bin/
 
-# Download and extract wabt
-wget -c https://github.com/WebAssembly/wabt/releases/download/1.0.20/wabt-1.0.20-ubuntu.tar.gz -O - | tar -xz && ls
-
-# Move wat2wasm to path
-sudo mv ./wabt-1.0.20/bin/* /usr/local/bin/
@@ -0,0 +1,114 @@
+package vm
+
+import (
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// allocatorExports are the export names of the supported allocators. The
+// allocation function has type (i32) -> i32 and returns 0 on failure, the
+// deallocation function takes the pointer and optionally the length.
+var allocatorExports = [][2]string{
+	{"malloc", "free"},
+	{"allocate", "deallocate"},
+}
+
+var allocType = &wasm.FuncType{
+	ParamTypes:  []wasm.ValueType{wasm.ValueTypeI32},
+	ReturnTypes: []wasm.ValueType{wasm.ValueTypeI32},
+}
+
+// allocator returns the allocation and deallocation functions exported by the module
+func (vm *VM) allocator() (alloc, free *FuncRef, err error) {
+	for _, names := range allocatorExports {
+		allocExtern, ok := vm.GetExport(names[0])
+		if !ok {
+			continue
+		}
+		freeExtern, ok := vm.GetExport(names[1])
+		if !ok {
+			continue
+		}
+		alloc, ok = allocExtern.(*FuncRef)
+		if !ok || !equalFuncTypes(alloc.Type(), allocType) {
+			continue
+		}
+		free, ok = freeExtern.(*FuncRef)
+		if !ok {
+			continue
+		}
+		if isFreeType(free.Type()) {
+			return alloc, free, nil
+		}
+	}
+	return nil, nil, ErrNoAllocator
+}
+
+// isFreeType tells if sig is (i32) -> () or (i32, i32) -> ()
+func isFreeType(sig *wasm.FuncType) bool {
+	if len(sig.ReturnTypes) != 0 || len(sig.ParamTypes) == 0 || len(sig.ParamTypes) > 2 {
+		return false
+	}
+	for _, t := range sig.ParamTypes {
+		if t != wasm.ValueTypeI32 {
+			return false
+		}
+	}
+	return true
+}
+
+// PassBytes copies b into a buffer allocated by the module allocator and returns
+// its address and length. The buffer then belongs to the module, which frees it
+// unless the host does with FreeBytes. The allocation runs and the copy is
+// charged against the VM gas.
+func (vm *VM) PassBytes(b []byte) (ptr, length uint32, err error) {
+	alloc, _, err := vm.allocator()
+	if err != nil {
+		return 0, 0, err
+	}
+	length = uint32(len(b))
+	ret, err := alloc.Call(uint64(length))
+	if err != nil {
+		return 0, 0, err
+	}
+	ptr = uint32(ret)
+	if ptr == 0 {
+		return 0, 0, ErrAllocationFailed
+	}
+	if err := vm.burnGas(GasCategoryMemory, vm.gasPolicy.GetCostForMemCopy(len(b))); err != nil {
+		return 0, 0, err
+	}
+	if err := vm.memory.WriteBytes(ptr, b); err != nil {
+		return 0, 0, err
+	}
+	return ptr, length, nil
+}
+
+// ReadReturnedBytes copies the buffer of length bytes at ptr allocated by the
+// module and returned to the host, then frees it with the module allocator
+func (vm *VM) ReadReturnedBytes(ptr, length uint32) ([]byte, error) {
+	if _, _, err := vm.allocator(); err != nil {
+		return nil, err
+	}
+	if err := vm.burnGas(GasCategoryMemory, vm.gasPolicy.GetCostForMemCopy(int(length))); err != nil {
+		return nil, err
+	}
+	b, err := vm.memory.ReadBytes(ptr, length)
+	if err != nil {
+		return nil, err
+	}
+	if err := vm.FreeBytes(ptr, length); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// FreeBytes releases a buffer allocated by the module allocator
+func (vm *VM) FreeBytes(ptr, length uint32) error {
+	_, free, err := vm.allocator()
+	if err != nil {
+		return err
+	}
+	args := []uint64{uint64(ptr), uint64(length)}
+	_, err = free.Call(args[:len(free.Type().ParamTypes)]...)
+	return err
+}
@@ -0,0 +1,108 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+// allocModule exports a bump allocator counting frees and upper, which returns
+// an upper cased copy of a buffer allocated with the same allocator
+const allocModule = `(module
+  (memory 1)
+  (global $next (mut i32) (i32.const 1024))
+  (global $frees (export "frees") (mut i32) (i32.const 0))
+  (func $malloc (export "malloc") (param $n i32) (result i32)
+    (local $p i32)
+    (if (i32.gt_u (i32.add (global.get $next) (local.get $n)) (i32.const 65536))
+      (then (return (i32.const 0))))
+    (local.set $p (global.get $next))
+    (global.set $next (i32.add (global.get $next) (local.get $n)))
+    (local.get $p))
+  (func (export "free") (param i32)
+    (global.set $frees (i32.add (global.get $frees) (i32.const 1))))
+  (func (export "upper") (param $p i32) (param $n i32) (result i32)
+    (local $q i32) (local $i i32) (local $c i32)
+    (local.set $q (call $malloc (local.get $n)))
+    (block $done
+      (loop $next
+        (br_if $done (i32.ge_u (local.get $i) (local.get $n)))
+        (local.set $c (i32.load8_u (i32.add (local.get $p) (local.get $i))))
+        (if (i32.and (i32.ge_u (local.get $c) (i32.const 97)) (i32.le_u (local.get $c) (i32.const 122)))
+          (then (local.set $c (i32.sub (local.get $c) (i32.const 32)))))
+        (i32.store8 (i32.add (local.get $q) (local.get $i)) (local.get $c))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        (br $next)))
+    (local.get $q)))`
+
+func newAllocVM(t *testing.T, src string, gasPolicy GasPolicy) *VM {
+	code, err := wat.Compile([]byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := NewVM(code, gasPolicy, &Gas{Limit: 1 << 20}, hostResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func TestPassBytes(t *testing.T) {
+	vm := newAllocVM(t, allocModule, &TableGasPolicy{MemCopyWordCost: 3})
+	ptr, length, err := vm.PassBytes([]byte("hello, world"))
+	if err != nil || ptr != 1024 || length != 12 {
+		t.Fatalf("Expect buffer at 1024 of 12 bytes, got %d %d %v", ptr, length, err)
+	}
+	if used := vm.GetGasUsed(); used != 6 {
+		t.Errorf("Expect 2 words copied in to cost 6, got %d", used)
+	}
+	results, err := vm.InvokeByName("upper", I32(int32(ptr)), I32(int32(length)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := vm.ReadReturnedBytes(uint32(results[0].I32()), length)
+	if err != nil || string(b) != "HELLO, WORLD" {
+		t.Errorf("Expect HELLO, WORLD, got %q %v", b, err)
+	}
+	if used := vm.GetGasUsed(); used != 12 {
+		t.Errorf("Expect 2 words copied out to cost 6 more, got %d", used)
+	}
+	if frees, _ := vm.GetGlobal("frees"); frees != I32(1) {
+		t.Errorf("Expect the returned buffer to be freed once, got %v", frees)
+	}
+
+	if _, _, err := vm.PassBytes(make([]byte, 65536)); err != ErrAllocationFailed {
+		t.Errorf("Expect %v, got %v", ErrAllocationFailed, err)
+	}
+	if _, err := vm.ReadReturnedBytes(65530, 8); err != ErrOutOfBoundMemoryAccess {
+		t.Errorf("Expect %v, got %v", ErrOutOfBoundMemoryAccess, err)
+	}
+}
+
+func TestAllocatorDiscovery(t *testing.T) {
+	vm := newAllocVM(t, `(module
+  (memory 1)
+  (global $freed (export "freed") (mut i32) (i32.const 0))
+  (func (export "malloc") (param i64) (result i32) (i32.const 8))
+  (func (export "allocate") (param i32) (result i32) (i32.const 16))
+  (func (export "deallocate") (param i32 i32)
+    (global.set $freed (local.get 1))))`, &FreeGasPolicy{})
+	ptr, length, err := vm.PassBytes([]byte{1, 2, 3})
+	if err != nil || ptr != 16 || length != 3 {
+		t.Fatalf("Expect allocate to be used, got %d %d %v", ptr, length, err)
+	}
+	if err := vm.FreeBytes(ptr, length); err != nil {
+		t.Fatal(err)
+	}
+	if freed, _ := vm.GetGlobal("freed"); freed != I32(3) {
+		t.Errorf("Expect deallocate to get the length, got %v", freed)
+	}
+
+	vm = newAllocVM(t, `(module (memory 1) (func (export "malloc") (param i32) (result i32) (i32.const 8)))`, &FreeGasPolicy{})
+	if _, _, err := vm.PassBytes([]byte{1}); err != ErrNoAllocator {
+		t.Errorf("Expect %v, got %v", ErrNoAllocator, err)
+	}
+	if _, err := vm.ReadReturnedBytes(8, 1); err != ErrNoAllocator {
+		t.Errorf("Expect %v, got %v", ErrNoAllocator, err)
+	}
+}
@@ -0,0 +1,212 @@
+package vm
+
+import (
+	"github.com/vertexdlt/vertexvm/opcode"
+)
+
+// StopReason tells why a debugged execution stopped
+type StopReason int
+
+// Stop reasons
+const (
+	StopBreakpoint StopReason = iota + 1
+	StopStep
+	StopDone
+)
+
+// Breakpoint locates an instruction by function index and offset in the function code
+type Breakpoint struct {
+	FunctionIndex int
+	Offset        int
+}
+
+// DebugStop describes the state a debugged execution stopped at
+type DebugStop struct {
+	Reason StopReason
+	Frame  *Frame        // frame about to execute Op, nil when done
+	Op     opcode.Opcode // next instruction, not executed yet
+	Result uint64        // invocation result when done
+	Err    error         // invocation error when done
+}
+
+type debugCommand int
+
+const (
+	debugStep debugCommand = iota
+	debugContinue
+	debugAbort
+)
+
+// Debugger runs an invocation of its VM in a goroutine which pauses before
+// instructions on breakpoints or single steps. The VM state may be inspected
+// while paused and must not be accessed otherwise.
+// The debugger installs itself as the VM tracer.
+type Debugger struct {
+	vm          *VM
+	breakpoints map[Breakpoint]bool
+	stepping    bool
+	running     bool
+	commands    chan debugCommand
+	stops       chan *DebugStop
+	stop        *DebugStop
+}
+
+// NewDebugger creates a debugger for vm
+func NewDebugger(vm *VM) *Debugger {
+	return &Debugger{
+		vm:          vm,
+		breakpoints: make(map[Breakpoint]bool),
+		commands:    make(chan debugCommand),
+		stops:       make(chan *DebugStop),
+	}
+}
+
+// SetBreakpoint pauses execution before the instruction at offset of function fidx
+func (d *Debugger) SetBreakpoint(fidx int, offset int) {
+	d.breakpoints[Breakpoint{fidx, offset}] = true
+}
+
+// ClearBreakpoint removes a breakpoint set by SetBreakpoint
+func (d *Debugger) ClearBreakpoint(fidx int, offset int) {
+	delete(d.breakpoints, Breakpoint{fidx, offset})
+}
+
+// Breakpoints returns the active breakpoints
+func (d *Debugger) Breakpoints() []Breakpoint {
+	bps := make([]Breakpoint, 0, len(d.breakpoints))
+	for bp := range d.breakpoints {
+		bps = append(bps, bp)
+	}
+	return bps
+}
+
+// Start invokes function fidx and runs until a breakpoint is hit or the invocation ends
+func (d *Debugger) Start(fidx uint64, args ...uint64) (*DebugStop, error) {
+	return d.start(false, fidx, args)
+}
+
+// StartPaused invokes function fidx and pauses before its first instruction
+func (d *Debugger) StartPaused(fidx uint64, args ...uint64) (*DebugStop, error) {
+	return d.start(true, fidx, args)
+}
+
+func (d *Debugger) start(stepping bool, fidx uint64, args []uint64) (*DebugStop, error) {
+	if d.running {
+		return nil, ErrDebuggerRunning
+	}
+	d.running = true
+	d.stepping = stepping
+	d.vm.SetTracer(d)
+	go func() {
+		ret, err := d.vm.Invoke(fidx, args...)
+		d.stops <- &DebugStop{Reason: StopDone, Result: ret, Err: err}
+	}()
+	return d.wait(), nil
+}
+
+func (d *Debugger) wait() *DebugStop {
+	d.stop = <-d.stops
+	if d.stop.Reason == StopDone {
+		d.running = false
+		d.vm.SetTracer(nil)
+	}
+	return d.stop
+}
+
+func (d *Debugger) resume(cmd debugCommand) (*DebugStop, error) {
+	if !d.running {
+		return nil, ErrDebuggerNotStarted
+	}
+	d.commands <- cmd
+	return d.wait(), nil
+}
+
+// Step executes the next instruction and pauses before the following one
+func (d *Debugger) Step() (*DebugStop, error) {
+	return d.resume(debugStep)
+}
+
+// Continue runs until a breakpoint is hit or the invocation ends
+func (d *Debugger) Continue() (*DebugStop, error) {
+	return d.resume(debugContinue)
+}
+
+// Abort ends the paused invocation with ErrAborted
+func (d *Debugger) Abort() (*DebugStop, error) {
+	return d.resume(debugAbort)
+}
+
+// Running tells if an invocation is paused
+func (d *Debugger) Running() bool {
+	return d.running
+}
+
+// Frames returns the active call frames, innermost last
+func (d *Debugger) Frames() []*Frame {
+	if !d.running {
+		return nil
+	}
+	frames := make([]*Frame, d.vm.framesIndex)
+	copy(frames, d.vm.frames[:d.vm.framesIndex])
+	return frames
+}
+
+// Locals returns the params followed by the locals of frame
+func (d *Debugger) Locals(frame *Frame) []uint64 {
+	return d.vm.stack[frame.basePointer : frame.basePointer+frame.localCount()]
+}
+
+// Stack returns the operand stack of the innermost frame, top last
+func (d *Debugger) Stack() []uint64 {
+	if !d.running || d.vm.framesIndex == 0 {
+		return nil
+	}
+	frame := d.vm.currentFrame()
+	return d.vm.stack[frame.basePointer+frame.localCount() : d.vm.sp]
+}
+
+// Globals returns the global values
+func (d *Debugger) Globals() []uint64 {
+	return d.vm.globalValues()
+}
+
+// Memory returns a copy of length bytes of linear memory at offset
+func (d *Debugger) Memory(offset int, length int) ([]byte, error) {
+	if offset < 0 || length < 0 || offset+length > d.vm.MemSize() {
+		return nil, ErrOutOfBoundMemoryAccess
+	}
+	b := make([]byte, length)
+	copy(b, d.vm.memory.data[offset:offset+length])
+	return b, nil
+}
+
+// OnInstruction pauses the execution on breakpoints and steps
+func (d *Debugger) OnInstruction(vm *VM, frame *Frame, ip int, op opcode.Opcode, stack []uint64) {
+	reason := StopStep
+	if d.breakpoints[Breakpoint{frame.fidx, ip}] {
+		reason = StopBreakpoint
+	} else if !d.stepping {
+		return
+	}
+	d.stops <- &DebugStop{Reason: reason, Frame: frame, Op: op}
+	switch <-d.commands {
+	case debugStep:
+		d.stepping = true
+	case debugContinue:
+		d.stepping = false
+	case debugAbort:
+		panic(ErrAborted)
+	}
+}
+
+// OnCall is a no-op
+func (d *Debugger) OnCall(vm *VM, fidx int, args []uint64) {}
+
+// OnReturn is a no-op
+func (d *Debugger) OnReturn(vm *VM, fidx int, results []uint64) {}
+
+// OnMemoryAccess is a no-op
+func (d *Debugger) OnMemoryAccess(vm *VM, address int, size int, write bool) {}
+
+// OnTrap is a no-op, the trap is reported when the invocation is done
+func (d *Debugger) OnTrap(vm *VM, err error) {}
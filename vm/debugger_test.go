@@ -0,0 +1,90 @@
+package vm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+)
+
+func TestDebugger(t *testing.T) {
+	vm, err := NewVM(tracedModule(), &FreeGasPolicy{}, &Gas{}, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fidx, _ := vm.GetFunctionIndex("calc")
+	d := NewDebugger(vm)
+	d.SetBreakpoint(2, 8) // i32.store in the callee
+
+	stop, err := d.Start(fidx, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Reason != StopBreakpoint || stop.Frame.FunctionIndex() != 2 || stop.Frame.IP() != 8 || stop.Op != opcode.I32Store {
+		t.Fatalf("Expect to stop on breakpoint, got %+v", stop)
+	}
+	if frames := d.Frames(); len(frames) != 2 || frames[0].FunctionIndex() != 1 {
+		t.Errorf("Expect 2 frames, got %v", frames)
+	}
+	if locals := d.Locals(stop.Frame); !reflect.DeepEqual(locals, []uint64{8}) {
+		t.Errorf("Expect locals [8], got %v", locals)
+	}
+	if stack := d.Stack(); !reflect.DeepEqual(stack, []uint64{8, 9}) {
+		t.Errorf("Expect operand stack [8 9], got %v", stack)
+	}
+	if _, err := d.Start(fidx, 8); err != ErrDebuggerRunning {
+		t.Errorf("Expect running debugger to refuse start, got %v", err)
+	}
+
+	stop, err = d.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Reason != StopStep || stop.Frame.IP() != 11 || stop.Op != opcode.GetLocal {
+		t.Fatalf("Expect to step to local.get, got %+v", stop)
+	}
+	mem, err := d.Memory(8, 4)
+	if err != nil || !reflect.DeepEqual(mem, []byte{9, 0, 0, 0}) {
+		t.Errorf("Expect stored value in memory, got %v %v", mem, err)
+	}
+	if _, err := d.Memory(65535, 4); err != ErrOutOfBoundMemoryAccess {
+		t.Errorf("Expect out of bound memory inspection to fail, got %v", err)
+	}
+
+	stop, err = d.Continue()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Reason != StopDone || stop.Result != 9 || stop.Err != nil {
+		t.Fatalf("Expect invocation to return 9, got %+v", stop)
+	}
+	if _, err := d.Step(); err != ErrDebuggerNotStarted {
+		t.Errorf("Expect step after completion to fail, got %v", err)
+	}
+}
+
+func TestDebuggerAbort(t *testing.T) {
+	vm, err := NewVM(tracedModule(), &FreeGasPolicy{}, &Gas{}, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	fidx, _ := vm.GetFunctionIndex("calc")
+	d := NewDebugger(vm)
+	stop, err := d.StartPaused(fidx, 8)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stop.Reason != StopStep || stop.Frame.FunctionIndex() != 1 || stop.Frame.IP() != 0 {
+		t.Fatalf("Expect to pause on first instruction, got %+v", stop)
+	}
+	stop, err = d.Abort()
+	if err != nil || stop.Reason != StopDone || stop.Err != ErrAborted {
+		t.Fatalf("Expect aborted invocation, got %+v %v", stop, err)
+	}
+
+	// the VM is left usable after abort
+	ret, err := vm.Invoke(fidx, 8)
+	if err != nil || ret != 9 {
+		t.Errorf("Expect invocation after abort to return 9, got %d %v", ret, err)
+	}
+}
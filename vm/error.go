@@ -29,6 +29,7 @@ var (
 	ErrMismatchedFuncSig      = NewExecError("indirect call type mismatch")
 	ErrNoMatchingIfBlock      = NewExecError("no matching If for Else block")
 	ErrOutOfBoundTableAccess  = NewExecError("out of bounds table access")
+	ErrUninitializedElement   = NewExecError("uninitialized element")
 	ErrOutOfBoundMemoryAccess = NewExecError("out of bounds memory access")
 	ErrUnknownOpcode          = NewExecError("unknown opcode")
 	ErrUnknownReturnType      = NewExecError("unknown block return type")
@@ -43,6 +44,7 @@ var (
 	ErrBlockUnderflow = NewExecError("cannot find matching block open")
 
 	ErrUnreachable = NewExecError("unreachable")
+	ErrAborted     = NewExecError("execution aborted")
 )
 
 // Non-panic errors
@@ -51,4 +53,66 @@ var (
 	ErrInvalidBlockType  = errors.New("invalid block type")
 	ErrOutOfGas          = errors.New("out of gas")
 	ErrWrongNumberOfArgs = errors.New("wrong number of arguments")
+	ErrReentrancyDepth   = errors.New("re-entrancy depth exceeded")
+)
+
+// Debugger errors
+var (
+	ErrDebuggerRunning    = errors.New("debugger: execution already started")
+	ErrDebuggerNotStarted = errors.New("debugger: no execution in progress")
+)
+
+// Link errors
+var (
+	ErrUnknownImport          = errors.New("unknown import")
+	ErrIncompatibleImportType = errors.New("incompatible import type")
+	ErrDataSegmentDoesNotFit  = errors.New("data segment does not fit")
+	ErrElemSegmentDoesNotFit  = errors.New("elements segment does not fit")
+)
+
+// Typed export access errors
+var (
+	ErrExportNotFound       = errors.New("export not found")
+	ErrExportNotFunction    = errors.New("export is not a function")
+	ErrMismatchedResultType = errors.New("host function result type mismatch")
+	ErrExportNotGlobal      = errors.New("export is not a global")
+	ErrImmutableGlobal      = errors.New("global is immutable")
+	ErrMismatchedGlobalType = errors.New("global type mismatch")
+)
+
+// Memory access errors, out of range accesses return ErrOutOfBoundMemoryAccess
+var (
+	ErrInvalidUTF8      = errors.New("invalid utf-8 string")
+	ErrUnterminatedCStr = errors.New("unterminated c string")
+)
+
+// Allocator errors
+var (
+	ErrNoAllocator      = errors.New("no exported allocator")
+	ErrAllocationFailed = errors.New("allocation failed")
+)
+
+// Suspension errors
+var (
+	ErrYield                = errors.New("yield")
+	ErrYieldNotAllowed      = errors.New("host function cannot yield in a nested invocation")
+	ErrSuspended            = errors.New("execution suspended")
+	ErrNotSuspended         = errors.New("no suspended execution")
+	ErrHostResultExpected   = errors.New("host result expected")
+	ErrUnexpectedHostResult = errors.New("unexpected host result")
+)
+
+// Merkle errors
+var (
+	ErrNotMerkleized = errors.New("state is not merkleized")
+	ErrEmptyRange    = errors.New("empty memory range")
+)
+
+// Step errors
+var (
+	ErrStepReached    = errors.New("step reached")
+	ErrNotAtStep      = errors.New("execution is not stopped at a step")
+	ErrStateMismatch  = errors.New("machine state does not match the VM")
+	ErrInvalidState   = errors.New("invalid machine state")
+	ErrTruncatedState = errors.New("truncated machine state")
 )
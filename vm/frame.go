@@ -10,6 +10,7 @@ import (
 // Frame or call frame holds the relevant execution information of a function
 type Frame struct {
 	fn             *wasm.Function
+	fidx           int
 	ip             int
 	basePointer    int
 	baseBlockIndex int
@@ -26,6 +27,35 @@ func NewFrame(fn *wasm.Function, basePointer int, baseBlockIndex int) *Frame {
 	return f
 }
 
+// FunctionIndex returns the index of the executing function including imports
+func (frame *Frame) FunctionIndex() int {
+	return frame.fidx
+}
+
+// Function returns the executing function
+func (frame *Frame) Function() *wasm.Function {
+	return frame.fn
+}
+
+// IP returns the offset of the current instruction in the function code
+func (frame *Frame) IP() int {
+	return frame.ip
+}
+
+// BasePointer returns the stack index of the first param of the frame, locals follow params
+func (frame *Frame) BasePointer() int {
+	return frame.basePointer
+}
+
+// localCount returns the number of params and locals of the frame
+func (frame *Frame) localCount() int {
+	n := len(frame.fn.Type.ParamTypes)
+	for _, entry := range frame.fn.Code.Locals {
+		n += int(entry.Count)
+	}
+	return n
+}
+
 func (frame *Frame) readLEB(maxbit uint32, hasSign bool) int64 {
 	ins := frame.instructions()
 	bytecnt, result, err := leb128.Read(ins[frame.ip+1:], maxbit, hasSign)
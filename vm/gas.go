@@ -2,10 +2,87 @@ package vm
 
 import "github.com/vertexdlt/vertexvm/opcode"
 
+// GasCategory classifies burnt gas for reporting
+type GasCategory int
+
+// Gas categories
+const (
+	GasCategoryOp       GasCategory = iota // instructions including br_table targets
+	GasCategoryMemory                      // memory pages and bytes copied by the host
+	GasCategoryCall                        // call frames
+	GasCategoryHostCall                    // imported host function calls
+	GasCategoryHost                        // gas burnt by host functions
+	GasCategorySubCall                     // gas reserved for sub-calls
+	NumGasCategories
+)
+
 // Gas consist used and limit for vm execution
 type Gas struct {
-	Used  uint64
-	Limit uint64
+	Used      uint64
+	Limit     uint64
+	Refund    uint64 // pending refund, credited against Used when the call completes
+	Refunded  uint64 // refund already credited, Used is the Breakdown total minus Refunded
+	Breakdown [NumGasCategories]uint64
+}
+
+// Remaining returns the gas left before the limit
+func (g *Gas) Remaining() uint64 {
+	if g.Used > g.Limit {
+		return 0
+	}
+	return g.Limit - g.Used
+}
+
+// Burn charges cost to category or returns ErrOutOfGas leaving g untouched
+func (g *Gas) Burn(category GasCategory, cost uint64) error {
+	if cost > 0 {
+		if g.Remaining() < cost {
+			return ErrOutOfGas
+		}
+		g.Used += cost
+		g.Breakdown[category] += cost
+	}
+	return nil
+}
+
+// Reserve carves a sub-limit of at most limit from the remaining gas.
+// The reserved gas counts as used until the sub gas is given back by Release.
+func (g *Gas) Reserve(limit uint64) *Gas {
+	if remaining := g.Remaining(); limit > remaining {
+		limit = remaining
+	}
+	g.Used += limit
+	g.Breakdown[GasCategorySubCall] += limit
+	return &Gas{Limit: limit}
+}
+
+// Release returns the unused gas of a sub gas obtained from Reserve, merges its
+// breakdown and carries over its pending refund
+func (g *Gas) Release(sub *Gas) {
+	unused := sub.Remaining()
+	g.Used -= unused
+	g.Breakdown[GasCategorySubCall] -= sub.Limit
+	for category, cost := range sub.Breakdown {
+		g.Breakdown[category] += cost
+	}
+	g.Refund += sub.Refund
+	g.Refunded += sub.Refunded
+	sub.Refund = 0
+}
+
+// ApplyRefund credits the pending refund capped by maxRefund and returns the credited amount
+func (g *Gas) ApplyRefund(maxRefund uint64) uint64 {
+	refund := g.Refund
+	if refund > maxRefund {
+		refund = maxRefund
+	}
+	if refund > g.Used {
+		refund = g.Used
+	}
+	g.Used -= refund
+	g.Refunded += refund
+	g.Refund = 0
+	return refund
 }
 
 // GasPolicy is the interface for vm cost table
@@ -14,6 +91,56 @@ type GasPolicy interface {
 	GetCostForMalloc(pages int) uint64
 }
 
+// ExtendedGasPolicy is a GasPolicy with additional hooks for operations whose
+// cost does not depend on the opcode alone
+type ExtendedGasPolicy interface {
+	GasPolicy
+	// GetCostForHostCall is charged before an imported host function is invoked
+	GetCostForHostCall(module, name string) uint64
+	// GetCostForBrTable is charged on top of the br_table op cost for its targets
+	GetCostForBrTable(targets int) uint64
+	// GetCostForMemCopy is charged for bytes copied in or out of linear memory by the host
+	GetCostForMemCopy(bytes int) uint64
+	// GetCostForCall is charged when a call frame of frameSize slots (params and locals) is set up
+	GetCostForCall(frameSize int) uint64
+	// GetMaxRefund caps the refund credited at the end of an invocation which used gas
+	GetMaxRefund(used uint64) uint64
+}
+
+// ExtendGasPolicy adapts a GasPolicy to an ExtendedGasPolicy.
+// Policies not implementing the extended hooks are charged nothing for them
+// and get no refund.
+func ExtendGasPolicy(p GasPolicy) ExtendedGasPolicy {
+	if ep, ok := p.(ExtendedGasPolicy); ok {
+		return ep
+	}
+	return &gasPolicyAdapter{p}
+}
+
+type gasPolicyAdapter struct {
+	GasPolicy
+}
+
+func (p *gasPolicyAdapter) GetCostForHostCall(module, name string) uint64 {
+	return 0
+}
+
+func (p *gasPolicyAdapter) GetCostForBrTable(targets int) uint64 {
+	return 0
+}
+
+func (p *gasPolicyAdapter) GetCostForMemCopy(bytes int) uint64 {
+	return 0
+}
+
+func (p *gasPolicyAdapter) GetCostForCall(frameSize int) uint64 {
+	return 0
+}
+
+func (p *gasPolicyAdapter) GetMaxRefund(used uint64) uint64 {
+	return 0
+}
+
 // FreeGasPolicy free cost
 type FreeGasPolicy struct{}
 
@@ -0,0 +1,133 @@
+package vm
+
+import "github.com/vertexdlt/vertexvm/opcode"
+
+// memCopyWordSize is the number of bytes charged as one word by TableGasPolicy
+const memCopyWordSize = 8
+
+// TableGasPolicy charges gas from a per opcode cost table and flat rates for
+// the extended hooks. The zero value charges nothing.
+type TableGasPolicy struct {
+	OpCosts           [256]uint64
+	PageCost          uint64
+	HostCallCost      uint64            // default for host imports not listed in HostCallCosts
+	HostCallCosts     map[string]uint64 // keyed by "module.name"
+	BrTableTargetCost uint64
+	MemCopyWordCost   uint64 // per started 8-byte word
+	CallCost          uint64
+	FrameSlotCost     uint64
+	RefundQuotient    uint64 // refunds are capped to used gas divided by RefundQuotient, 0 disables them
+}
+
+// GetCostForOp returns the table cost of op
+func (p *TableGasPolicy) GetCostForOp(op opcode.Opcode) uint64 {
+	return p.OpCosts[op]
+}
+
+// GetCostForMalloc returns PageCost per page
+func (p *TableGasPolicy) GetCostForMalloc(pages int) uint64 {
+	return uint64(pages) * p.PageCost
+}
+
+// GetCostForHostCall returns the cost registered for module.name or HostCallCost
+func (p *TableGasPolicy) GetCostForHostCall(module, name string) uint64 {
+	if cost, ok := p.HostCallCosts[module+"."+name]; ok {
+		return cost
+	}
+	return p.HostCallCost
+}
+
+// GetCostForBrTable returns BrTableTargetCost per target
+func (p *TableGasPolicy) GetCostForBrTable(targets int) uint64 {
+	return uint64(targets) * p.BrTableTargetCost
+}
+
+// GetCostForMemCopy returns MemCopyWordCost per started 8-byte word
+func (p *TableGasPolicy) GetCostForMemCopy(bytes int) uint64 {
+	words := (uint64(bytes) + memCopyWordSize - 1) / memCopyWordSize
+	return words * p.MemCopyWordCost
+}
+
+// GetCostForCall returns CallCost plus FrameSlotCost per param and local
+func (p *TableGasPolicy) GetCostForCall(frameSize int) uint64 {
+	return p.CallCost + uint64(frameSize)*p.FrameSlotCost
+}
+
+// GetMaxRefund returns used gas divided by RefundQuotient
+func (p *TableGasPolicy) GetMaxRefund(used uint64) uint64 {
+	if p.RefundQuotient == 0 {
+		return 0
+	}
+	return used / p.RefundQuotient
+}
+
+// NewDefaultGasPolicy returns a TableGasPolicy with costs relative to i32.add,
+// calibrated with BenchmarkOpCost as described at defaultOpCosts
+func NewDefaultGasPolicy() *TableGasPolicy {
+	p := &TableGasPolicy{
+		PageCost:          231, // page 230.7
+		HostCallCost:      1,   // host call 1.94, less the call op
+		HostCallCosts:     map[string]uint64{},
+		BrTableTargetCost: 1, // br_table target 0.53
+		MemCopyWordCost:   1, // memory copy word 0.10
+		CallCost:          3, // call 3.73, less the call op
+		FrameSlotCost:     1, // call frame slot 0.05
+		RefundQuotient:    2,
+	}
+	for i := range p.OpCosts {
+		p.OpCosts[i] = 1
+	}
+	for op, cost := range defaultOpCosts {
+		p.OpCosts[op] = cost
+	}
+	return p
+}
+
+// defaultOpCosts overrides the unit cost of the ops measured slower than
+// i32.add. The costs are the medians of the gas metric of 5 runs of
+//
+//	go test ./vm -run NONE -bench OpCost -benchtime 50000x
+//
+// on a virtualized 1 vCPU Intel Xeon, linux/amd64, go1.27.1, rounded to the
+// nearest integer and to at least 1. The medians are noted next to each cost.
+// Ops without a bench take the mean of the benches of their row, the call ops
+// and hooks deduct CallCost from their bench.
+var defaultOpCosts = map[opcode.Opcode]uint64{
+	opcode.Block:        2, // 2.33
+	opcode.Loop:         2, // 2.37
+	opcode.If:           2, // 2.28
+	opcode.Br:           2, // 2.34
+	opcode.BrIf:         3, // 2.88
+	opcode.BrTable:      3, // 3.32
+	opcode.Return:       2, // jumps as br
+	opcode.CallIndirect: 2, // 4.83
+	opcode.MemoryGrow:   2, // 2.00
+
+	// i32.load 1.99, i64.load8_s 1.88
+	opcode.I32Load: 2, opcode.I64Load: 2, opcode.F32Load: 2, opcode.F64Load: 2,
+	opcode.I32Load8S: 2, opcode.I32Load8U: 2, opcode.I32Load16S: 2, opcode.I32Load16U: 2,
+	opcode.I64Load8S: 2, opcode.I64Load8U: 2, opcode.I64Load16S: 2, opcode.I64Load16U: 2,
+	opcode.I64Load32S: 2, opcode.I64Load32U: 2,
+	// i32.store 2.22, i64.store8 2.15
+	opcode.I32Store: 2, opcode.I64Store: 2, opcode.F32Store: 2, opcode.F64Store: 2,
+	opcode.I32Store8: 2, opcode.I32Store16: 2, opcode.I64Store8: 2, opcode.I64Store16: 2,
+	opcode.I64Store32: 2,
+
+	// i32.mul 1.50, i64.mul 1.70
+	opcode.I32Mul: 2, opcode.I64Mul: 2,
+	// f32.div 1.64, f64.div 1.77
+	opcode.F32Div: 2, opcode.F64Div: 2,
+	// f64.min 1.92
+	opcode.F32Min: 2, opcode.F32Max: 2, opcode.F64Min: 2, opcode.F64Max: 2,
+	// f32.sqrt 1.56, f64.sqrt 1.54
+	opcode.F32Sqrt: 2, opcode.F64Sqrt: 2,
+	// i32.trunc_f64_s 2.02, i64.trunc_f64_u 1.39
+	opcode.I32TruncSF32: 2, opcode.I32TruncUF32: 2, opcode.I32TruncSF64: 2, opcode.I32TruncUF64: 2,
+	opcode.I64TruncSF32: 2, opcode.I64TruncUF32: 2, opcode.I64TruncSF64: 2, opcode.I64TruncUF64: 2,
+
+	// left at 1: nop 0.49, memory.size 1.15, i64.add 1.05, i32.shl 1.09,
+	// i32.popcnt 1.16, div and rem 1.30 (i32.div_s 1.56, i32.rem_u 1.12,
+	// i64.div_s 1.30, i64.rem_u 1.22), f32.add 1.26, f64.add 1.13, f64.mul 1.27,
+	// f64.floor 1.46, f64.nearest 1.49, i32.trunc_sat_f64_s 1.496,
+	// f64.convert_i64_u 1.17
+}
@@ -0,0 +1,540 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/wasm"
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+type testFunc struct {
+	params  []wasm.ValueType
+	results []wasm.ValueType
+	locals  []wasm.Local
+	body    []byte
+	export  string
+}
+
+type testImport struct {
+	module, name    string
+	params, results []wasm.ValueType
+}
+
+func appendULEB(b []byte, v uint32) []byte {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			b = append(b, c|0x80)
+		} else {
+			return append(b, c)
+		}
+	}
+}
+
+func appendSection(b []byte, id byte, content []byte) []byte {
+	b = append(b, id)
+	b = appendULEB(b, uint32(len(content)))
+	return append(b, content...)
+}
+
+func appendName(b []byte, name string) []byte {
+	b = appendULEB(b, uint32(len(name)))
+	return append(b, name...)
+}
+
+func appendFuncType(b []byte, params, results []wasm.ValueType) []byte {
+	b = append(b, wasm.FuncTypeForm)
+	b = appendULEB(b, uint32(len(params)))
+	for _, t := range params {
+		b = append(b, byte(t))
+	}
+	b = appendULEB(b, uint32(len(results)))
+	for _, t := range results {
+		b = append(b, byte(t))
+	}
+	return b
+}
+
+// buildTestModule assembles a module with one type per function, one page of memory
+// and the given host imports placed before the functions in the index space
+func buildTestModule(imports []testImport, funcs []testFunc) []byte {
+	m := []byte{0x00, 0x61, 0x73, 0x6d, 0x01, 0x00, 0x00, 0x00}
+
+	types := appendULEB(nil, uint32(len(imports)+len(funcs)))
+	for _, imp := range imports {
+		types = appendFuncType(types, imp.params, imp.results)
+	}
+	for _, fn := range funcs {
+		types = appendFuncType(types, fn.params, fn.results)
+	}
+	m = appendSection(m, 1, types)
+
+	if len(imports) > 0 {
+		imps := appendULEB(nil, uint32(len(imports)))
+		for i, imp := range imports {
+			imps = appendName(imps, imp.module)
+			imps = appendName(imps, imp.name)
+			imps = append(imps, wasm.ExternalFunction)
+			imps = appendULEB(imps, uint32(i))
+		}
+		m = appendSection(m, 2, imps)
+	}
+
+	fsec := appendULEB(nil, uint32(len(funcs)))
+	for i := range funcs {
+		fsec = appendULEB(fsec, uint32(len(imports)+i))
+	}
+	m = appendSection(m, 3, fsec)
+	m = appendSection(m, 5, []byte{0x01, 0x00, 0x01})
+
+	var exports []byte
+	exportCount := 0
+	for i, fn := range funcs {
+		if fn.export != "" {
+			exports = appendName(exports, fn.export)
+			exports = append(exports, wasm.ExternalFunction)
+			exports = appendULEB(exports, uint32(len(imports)+i))
+			exportCount++
+		}
+	}
+	m = appendSection(m, 7, append(appendULEB(nil, uint32(exportCount)), exports...))
+
+	code := appendULEB(nil, uint32(len(funcs)))
+	for _, fn := range funcs {
+		body := appendULEB(nil, uint32(len(fn.locals)))
+		for _, l := range fn.locals {
+			body = appendULEB(body, l.Count)
+			body = append(body, byte(l.ValueType))
+		}
+		body = append(body, fn.body...)
+		body = append(body, byte(opcode.End))
+		code = appendULEB(code, uint32(len(body)))
+		code = append(code, body...)
+	}
+	return appendSection(m, 10, code)
+}
+
+// compileWAT compiles the text format module src of a test
+func compileWAT(src string) []byte {
+	code, err := wat.Compile([]byte(src))
+	if err != nil {
+		panic(err)
+	}
+	return code
+}
+
+// brTableModule exports calc running a br_table of targets labels before returning 7
+func brTableModule(targets int) []byte {
+	return compileWAT(fmt.Sprintf(`(module
+  (memory 1)
+  (func (export "calc") (param i32) (result i32)
+    (block (br_table %s(local.get 0)))
+    (i32.const 7)))`, strings.Repeat("0 ", targets+1)))
+}
+
+func invokeExport(t *testing.T, vm *VM, name string, args ...uint64) (uint64, error) {
+	fnIndex, ok := vm.GetFunctionIndex(name)
+	if !ok {
+		t.Fatalf("Cannot get export fn index of %s", name)
+	}
+	return vm.Invoke(fnIndex, args...)
+}
+
+func TestExtendGasPolicy(t *testing.T) {
+	simple := ExtendGasPolicy(&SimpleGasPolicy{})
+	if simple.GetCostForHostCall("env", "add") != 0 || simple.GetCostForBrTable(10) != 0 ||
+		simple.GetCostForMemCopy(10) != 0 || simple.GetCostForCall(10) != 0 {
+		t.Errorf("Expect adapted policy to charge nothing for extended hooks")
+	}
+	if simple.GetCostForOp(opcode.I32Add) != 1 {
+		t.Errorf("Expect adapted policy to keep op cost, got %d", simple.GetCostForOp(opcode.I32Add))
+	}
+	table := NewDefaultGasPolicy()
+	if ExtendGasPolicy(table) != table {
+		t.Errorf("Expect extended policy to be used as is")
+	}
+}
+
+func TestBrTableGas(t *testing.T) {
+	for _, targets := range []int{1, 100, 1000} {
+		code := brTableModule(targets)
+		gas := &Gas{Limit: 1 << 32}
+		vm, err := NewVM(code, &TableGasPolicy{BrTableTargetCost: 2}, gas, &TestResolver{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ret, err := invokeExport(t, vm, "calc", 0)
+		if err != nil || ret != 7 {
+			t.Fatalf("Expect br_table to return 7, got %d %v", ret, err)
+		}
+		if gas.Used != uint64(2*targets) {
+			t.Errorf("Expect br_table with %d targets to use %d gas, got %d", targets, 2*targets, gas.Used)
+		}
+	}
+}
+
+func TestHostCallGas(t *testing.T) {
+	code := compileWAT(`(module
+  (import "env" "add" (func $add (param i32 i32) (result i32)))
+  (func (export "calc") (result i32) (call $add (i32.const 1) (i32.const 2))))`)
+	policy := &TableGasPolicy{HostCallCost: 5, HostCallCosts: map[string]uint64{"env.add": 100}}
+	gas := &Gas{Limit: 1000}
+	vm, err := NewVM(code, policy, gas, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := invokeExport(t, vm, "calc")
+	if err != nil || ret != 3 {
+		t.Fatalf("Expect host call to return 3, got %d %v", ret, err)
+	}
+	if gas.Used != 100 {
+		t.Errorf("Expect host call to use 100 gas, got %d", gas.Used)
+	}
+
+	vm, err = NewVM(code, policy, &Gas{Limit: 99}, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invokeExport(t, vm, "calc"); err != ErrOutOfGas {
+		t.Errorf("Expect host call to be out of gas, got %v", err)
+	}
+}
+
+func TestCallFrameGas(t *testing.T) {
+	code := compileWAT(`(module
+  (func (export "calc") (result i32) (call $id (i32.const 4)))
+  (func $id (param i32) (result i32) (local i64 i64 i64) (local.get 0)))`)
+	gas := &Gas{Limit: 1000}
+	vm, err := NewVM(code, &TableGasPolicy{CallCost: 10, FrameSlotCost: 2}, gas, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := invokeExport(t, vm, "calc")
+	if err != nil || ret != 4 {
+		t.Fatalf("Expect call to return 4, got %d %v", ret, err)
+	}
+	// entry frame has no slot, callee has 1 param and 3 locals
+	if expected := uint64(10 + 10 + 4*2); gas.Used != expected {
+		t.Errorf("Expect calls to use %d gas, got %d", expected, gas.Used)
+	}
+}
+
+func TestMemCopyGas(t *testing.T) {
+	code := brTableModule(1)
+	gas := &Gas{Limit: 3}
+	vm, err := NewVM(code, &TableGasPolicy{MemCopyWordCost: 1}, gas, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := vm.MemWrite(make([]byte, 17), 0); err != nil {
+		t.Errorf("Expect MemWrite to go through, got %v", err)
+	}
+	if gas.Used != 3 {
+		t.Errorf("Expect 17 bytes to cost 3 words, got %d", gas.Used)
+	}
+	if _, err := vm.MemRead(make([]byte, 1), 0); err != ErrOutOfGas {
+		t.Errorf("Expect MemRead to be out of gas, got %v", err)
+	}
+}
+
+// BenchmarkOpCost reports the gas of instructions and of the extended hooks
+// relative to i32.add as the gas metric, which is how NewDefaultGasPolicy is
+// calibrated. Each function body repeats an op with its operands 100 times,
+// the other instructions of a repetition are deducted as one i32.add each.
+// Operands are read from params set to 7, so they cost the same for every type.
+// Costs per target, slot, word or page deduct the run of the sub bench instead.
+// Modules without a bench export are timed by instantiation.
+func BenchmarkOpCost(b *testing.B) {
+	const reps = 100
+	binary := func(op string) string { return "local.get 0 local.get 1 " + op + " drop" }
+	unary := func(op string) string { return "local.get 0 " + op + " drop" }
+	targets, slots := strings.Repeat("0 ", 64), strings.Repeat("i64 ", 32)
+	benches := []struct {
+		name, params, body string
+		others             int    // instructions of a repetition besides the op
+		units              int    // measured units per run, reps when 0
+		sub                string // bench deducted instead of the other instructions
+		module             string // replaces the generated module
+	}{
+		{name: "i32.add", params: "i32 i32", body: binary("i32.add"), others: 3},
+		{name: "nop", body: "nop"},
+		{name: "block", body: "block end", others: 1},
+		{name: "loop", body: "loop end", others: 1},
+		{name: "if", params: "i32", body: "local.get 0 if end", others: 2},
+		{name: "br", body: "block br 0 end", others: 2},
+		{name: "br_if", params: "i32", body: "block local.get 0 br_if 0 end", others: 3},
+		{name: "br_table", params: "i32", body: "block local.get 0 br_table 0 end", others: 3},
+		{name: "br_table target", params: "i32", body: "block i32.const 64 br_table " + targets + "0 end",
+			units: reps * 64, sub: "br_table"},
+		{name: "call", body: "call $callee", others: 1},
+		{name: "call frame slot", body: "call $slots", units: reps * 32, sub: "call"},
+		{name: "call_indirect", body: "i32.const 0 call_indirect (type $void)", others: 2},
+		{name: "host call", body: "call $nop"},
+		{name: "memory copy", body: "i32.const 0 call $read", others: 1},
+		{name: "memory copy word", body: "i32.const 4096 call $read", units: reps * 4096 / memCopyWordSize, sub: "memory copy"},
+		{name: "memory.size", body: "memory.size drop", others: 1},
+		{name: "memory.grow", body: "i32.const 0 memory.grow drop", others: 2},
+		{name: "instantiate", module: "(module (memory 0))"},
+		{name: "page", module: "(module (memory 64))", units: 64, sub: "instantiate"},
+		{name: "i32.load", params: "i32", body: unary("i32.load"), others: 2},
+		{name: "i64.load8_s", params: "i32", body: unary("i64.load8_s"), others: 2},
+		{name: "i32.store", params: "i32 i32", body: "local.get 0 local.get 1 i32.store", others: 2},
+		{name: "i64.store8", params: "i32 i64", body: "local.get 0 local.get 1 i64.store8", others: 2},
+		{name: "i64.add", params: "i64 i64", body: binary("i64.add"), others: 3},
+		{name: "i32.shl", params: "i32 i32", body: binary("i32.shl"), others: 3},
+		{name: "i32.popcnt", params: "i32", body: unary("i32.popcnt"), others: 2},
+		{name: "i32.mul", params: "i32 i32", body: binary("i32.mul"), others: 3},
+		{name: "i64.mul", params: "i64 i64", body: binary("i64.mul"), others: 3},
+		{name: "i32.div_s", params: "i32 i32", body: binary("i32.div_s"), others: 3},
+		{name: "i32.rem_u", params: "i32 i32", body: binary("i32.rem_u"), others: 3},
+		{name: "i64.div_s", params: "i64 i64", body: binary("i64.div_s"), others: 3},
+		{name: "i64.rem_u", params: "i64 i64", body: binary("i64.rem_u"), others: 3},
+		{name: "f32.add", params: "f32 f32", body: binary("f32.add"), others: 3},
+		{name: "f64.add", params: "f64 f64", body: binary("f64.add"), others: 3},
+		{name: "f64.mul", params: "f64 f64", body: binary("f64.mul"), others: 3},
+		{name: "f32.div", params: "f32 f32", body: binary("f32.div"), others: 3},
+		{name: "f64.div", params: "f64 f64", body: binary("f64.div"), others: 3},
+		{name: "f64.min", params: "f64 f64", body: binary("f64.min"), others: 3},
+		{name: "f64.floor", params: "f64", body: unary("f64.floor"), others: 2},
+		{name: "f64.nearest", params: "f64", body: unary("f64.nearest"), others: 2},
+		{name: "f32.sqrt", params: "f32", body: unary("f32.sqrt"), others: 2},
+		{name: "f64.sqrt", params: "f64", body: unary("f64.sqrt"), others: 2},
+		{name: "i32.trunc_f64_s", params: "f64", body: unary("i32.trunc_f64_s"), others: 2},
+		{name: "i64.trunc_f64_u", params: "f64", body: unary("i64.trunc_f64_u"), others: 2},
+		{name: "i32.trunc_sat_f64_s", params: "f64", body: unary("i32.trunc_sat_f64_s"), others: 2},
+		{name: "f64.convert_i64_u", params: "i64", body: unary("f64.convert_i64_u"), others: 2},
+	}
+	var base float64 // ns of an i32.add, measured first
+	runs := map[string]float64{}
+	for _, bench := range benches {
+		module := bench.module
+		if module == "" {
+			module = fmt.Sprintf(`(module
+  (type $void (func))
+  (import "env" "nop" (func $nop))
+  (import "env" "read" (func $read (param i32)))
+  (memory 1)
+  (table anyfunc (elem $callee))
+  (func (export "bench") (param %s) %s)
+  (func $callee)
+  (func $slots (local %s)))`, bench.params, strings.Repeat(bench.body+"\n", reps), slots)
+		}
+		code := compileWAT(module)
+		vm, err := NewVM(code, &FreeGasPolicy{}, &Gas{}, &gasResolver{})
+		if err != nil {
+			b.Fatal(err)
+		}
+		fnIndex, invoke := vm.GetFunctionIndex("bench")
+		var args []uint64
+		for _, typ := range strings.Fields(bench.params) {
+			switch typ {
+			case "f32":
+				args = append(args, uint64(math.Float32bits(7)))
+			case "f64":
+				args = append(args, math.Float64bits(7))
+			default:
+				args = append(args, 7)
+			}
+		}
+		b.Run(bench.name, func(b *testing.B) {
+			start := time.Now()
+			for i := 0; i < b.N; i++ {
+				if invoke {
+					_, err = vm.Invoke(fnIndex, args...)
+				} else {
+					_, err = NewVM(code, &FreeGasPolicy{}, &Gas{}, &gasResolver{})
+				}
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+			run := float64(time.Since(start).Nanoseconds()) / float64(b.N)
+			runs[bench.name] = run
+			units := bench.units
+			if units == 0 {
+				units = reps
+			}
+			if bench.name == "i32.add" {
+				base = run / float64(units*(bench.others+1))
+			}
+			if bench.sub != "" {
+				run -= runs[bench.sub]
+			} else {
+				run -= float64(units*bench.others) * base
+			}
+			b.ReportMetric(run/float64(units)/base, "gas")
+		})
+	}
+}
+
+type gasResolver struct{}
+
+func (r *gasResolver) GetFunction(module, name string) HostFunction {
+	switch name {
+	case "nop":
+		return func(vm *VM, args ...uint64) (uint64, error) { return 0, nil }
+	case "read":
+		return func(vm *VM, args ...uint64) (uint64, error) {
+			if err := vm.BurnMemCopyGas(int(args[0])); err != nil {
+				return 0, err
+			}
+			_, err := vm.Memory().ReadBytes(0, uint32(args[0]))
+			return 0, err
+		}
+	case "burn":
+		return func(vm *VM, args ...uint64) (uint64, error) {
+			return 0, vm.BurnGas(args[0])
+		}
+	case "refund":
+		return func(vm *VM, args ...uint64) (uint64, error) {
+			vm.RefundGas(args[0])
+			return 0, nil
+		}
+	case "subcall":
+		return func(vm *VM, args ...uint64) (uint64, error) {
+			sub := vm.ReserveGas(args[0])
+			err := sub.Burn(GasCategoryOp, sub.Limit/2)
+			sub.Refund = 1
+			vm.ReleaseGas(sub)
+			return sub.Limit, err
+		}
+	}
+	return nil
+}
+
+func gasHostModule(name string) []byte {
+	return compileWAT(fmt.Sprintf(`(module
+  (import "env" %q (func $host (param i32) (result i32)))
+  (func (export "calc") (param i32) (result i32) (call $host (local.get 0))))`, name))
+}
+
+func TestGasReserveRelease(t *testing.T) {
+	gas := &Gas{Used: 10, Limit: 100}
+	sub := gas.Reserve(50)
+	if sub.Limit != 50 || gas.Used != 60 {
+		t.Fatalf("Expect 50 gas reserved, got limit %d used %d", sub.Limit, gas.Used)
+	}
+	if err := sub.Burn(GasCategoryOp, 20); err != nil {
+		t.Fatal(err)
+	}
+	if err := sub.Burn(GasCategoryOp, 31); err != ErrOutOfGas {
+		t.Errorf("Expect sub gas to be limited, got %v", err)
+	}
+	sub.Refund = 5
+	gas.Release(sub)
+	if gas.Used != 30 || gas.Refund != 5 {
+		t.Errorf("Expect unused sub gas to be returned, got used %d refund %d", gas.Used, gas.Refund)
+	}
+	if gas.Breakdown[GasCategorySubCall] != 0 || gas.Breakdown[GasCategoryOp] != 20 {
+		t.Errorf("Expect sub gas breakdown to be merged, got %v", gas.Breakdown)
+	}
+
+	sub = gas.Reserve(1000)
+	if sub.Limit != 70 || gas.Remaining() != 0 {
+		t.Errorf("Expect reservation to be capped to remaining gas, got %d", sub.Limit)
+	}
+}
+
+func TestGasRefund(t *testing.T) {
+	code := gasHostModule("refund")
+	gas := &Gas{Limit: 1000}
+	vm, err := NewVM(code, &TableGasPolicy{CallCost: 100, RefundQuotient: 4}, gas, &gasResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invokeExport(t, vm, "calc", 10); err != nil {
+		t.Fatal(err)
+	}
+	if gas.Used != 90 || gas.Refunded != 10 || gas.Refund != 0 {
+		t.Errorf("Expect refund of 10 to be credited, got used %d refunded %d", gas.Used, gas.Refunded)
+	}
+	if _, err := invokeExport(t, vm, "calc", 1000); err != nil {
+		t.Fatal(err)
+	}
+	// the second invocation used 100, its refund is capped to 25
+	if gas.Used != 165 || gas.Refunded != 35 {
+		t.Errorf("Expect refund to be capped to a quarter of the gas used by the invocation, got used %d refunded %d", gas.Used, gas.Refunded)
+	}
+}
+
+func TestGasBreakdown(t *testing.T) {
+	gas := &Gas{Limit: 1000}
+	policy := &TableGasPolicy{CallCost: 7, HostCallCost: 3}
+	policy.OpCosts[opcode.GetLocal] = 1
+	policy.OpCosts[opcode.Call] = 2
+	vm, err := NewVM(gasHostModule("burn"), policy, gas, &gasResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invokeExport(t, vm, "calc", 11); err != nil {
+		t.Fatal(err)
+	}
+	expected := [NumGasCategories]uint64{GasCategoryOp: 3, GasCategoryCall: 7, GasCategoryHostCall: 3, GasCategoryHost: 11}
+	if gas.Breakdown != expected || gas.Used != 24 {
+		t.Errorf("Expect breakdown %v, got %v", expected, gas.Breakdown)
+	}
+
+	gas = &Gas{Limit: 1000}
+	vm, err = NewVM(gasHostModule("subcall"), &FreeGasPolicy{}, gas, &gasResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ret, err := invokeExport(t, vm, "calc", 400); err != nil || ret != 400 {
+		t.Fatalf("Expect sub call to reserve 400 gas, got %d %v", ret, err)
+	}
+	// sub call refund is carried over, a policy without GetMaxRefund credits none of it
+	if gas.Used != 200 || gas.Refunded != 0 || gas.Breakdown[GasCategoryOp] != 200 || gas.Breakdown[GasCategorySubCall] != 0 {
+		t.Errorf("Expect sub call gas to be accounted, got used %d breakdown %v", gas.Used, gas.Breakdown)
+	}
+}
+
+func TestExternCallGas(t *testing.T) {
+	spin := compileWAT(`(module
+  (func (export "spin") (param $n i32)
+    (loop $next (br_if $next (local.tee $n (i32.sub (local.get $n) (i32.const 1)))))))`)
+	direct, err := NewVM(spin, &SimpleGasPolicy{}, &Gas{Limit: 1 << 20}, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invokeExport(t, direct, "spin", 5); err != nil {
+		t.Fatal(err)
+	}
+
+	callee, err := NewVM(spin, &SimpleGasPolicy{}, &Gas{Limit: 1 << 20}, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := compileWAT(`(module
+  (import "callee" "spin" (func $spin (param i32)))
+  (func (export "run") (param i32) (call $spin (local.get 0))))`)
+	gas := &Gas{Limit: 1 << 20}
+	caller, err := NewVM(code, &FreeGasPolicy{}, gas, externResolver{"callee": callee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invokeExport(t, caller, "run", 5); err != nil {
+		t.Fatal(err)
+	}
+	if gas.Used != direct.GetGasUsed() || callee.GetGasUsed() != 0 {
+		t.Errorf("Expect the caller to be charged %d, got %d and the callee %d", direct.GetGasUsed(), gas.Used, callee.GetGasUsed())
+	}
+
+	// the callee cannot run past the limit of a metered caller
+	gas = &Gas{Limit: 100}
+	caller, err = NewVM(code, &FreeGasPolicy{}, gas, externResolver{"callee": callee})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := invokeExport(t, caller, "run", 1000); err != ErrOutOfGas {
+		t.Errorf("Expect %v, got %v", ErrOutOfGas, err)
+	}
+	if gas.Used > gas.Limit || callee.GetGasUsed() != 0 {
+		t.Errorf("Expect the caller limit to bound the callee, got caller %d callee %d", gas.Used, callee.GetGasUsed())
+	}
+}
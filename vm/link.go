@@ -0,0 +1,350 @@
+package vm
+
+import (
+	"encoding/binary"
+	"fmt"
+
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// ImportError is returned by NewVM when an import cannot be linked
+type ImportError struct {
+	Module string
+	Name   string
+	Err    error
+}
+
+func (e *ImportError) Error() string {
+	return fmt.Sprintf("%v %s.%s", e.Err, e.Module, e.Name)
+}
+
+// SegmentError is returned by NewVM when a data or elements segment is out of
+// the bounds of the memory or table, nothing is written then
+type SegmentError struct {
+	Err    error // ErrDataSegmentDoesNotFit or ErrElemSegmentDoesNotFit
+	Index  int   // segment index
+	Offset uint64
+	Length int
+	Size   int // memory size in bytes or table size in elements
+}
+
+func (e *SegmentError) Error() string {
+	return fmt.Sprintf("out of bounds: %v, segment %d at %d of length %d exceeds size %d", e.Err, e.Index, e.Offset, e.Length, e.Size)
+}
+
+// Extern is a function, global, memory or table instance which can be
+// exported by a VM and imported by another: *FuncRef, *Global, *Memory or *Table
+type Extern interface {
+	externKind() byte
+}
+
+// ExternResolver is an ImportResolver also linking imports to instances
+// exported by other VMs. Functions not resolved by GetExtern fall back to GetFunction.
+type ExternResolver interface {
+	ImportResolver
+	GetExtern(module, name string) Extern
+}
+
+// FuncRef references a function of a VM, it is called in the VM it belongs to.
+// A call through an import burns the gas of the calling VM.
+type FuncRef struct {
+	vm   *VM
+	fidx int
+}
+
+func (f *FuncRef) externKind() byte { return wasm.ExternalFunction }
+
+// Type returns the function signature
+func (f *FuncRef) Type() *wasm.FuncType {
+	return f.vm.funcType(f.fidx)
+}
+
+// Call invokes the function in the VM it belongs to
+func (f *FuncRef) Call(args ...uint64) (uint64, error) {
+	return f.vm.Invoke(uint64(f.fidx), args...)
+}
+
+// Global is a global variable, an imported global is shared with the exporting VM
+type Global struct {
+	typ   wasm.GlobalType
+	value uint64
+}
+
+// NewGlobal creates a global to be imported by VMs
+func NewGlobal(typ wasm.GlobalType, value uint64) *Global {
+	return &Global{typ: typ, value: value}
+}
+
+func (g *Global) externKind() byte { return wasm.ExternalGlobalType }
+
+// Type returns the value type and mutability of the global
+func (g *Global) Type() wasm.GlobalType {
+	return g.typ
+}
+
+// Value returns the raw bits of the global value
+func (g *Global) Value() uint64 {
+	return g.value
+}
+
+// Table is a table of function references, an imported table is shared with the exporting VM
+type Table struct {
+	elements []*FuncRef
+	limits   wasm.Limits
+}
+
+// NewTable creates a table of limits.Min uninitialized elements to be imported by VMs
+func NewTable(limits wasm.Limits) *Table {
+	return &Table{elements: make([]*FuncRef, limits.Min), limits: limits}
+}
+
+func (t *Table) externKind() byte { return wasm.ExternalTable }
+
+// Len returns the number of table elements
+func (t *Table) Len() int {
+	return len(t.elements)
+}
+
+// GetExport returns the instance exported as name
+func (vm *VM) GetExport(name string) (Extern, bool) {
+	if vm.Module.ExportSec == nil {
+		return nil, false
+	}
+	entry, ok := vm.Module.ExportSec.ExportMap[name]
+	if !ok {
+		return nil, false
+	}
+	switch entry.Desc.Kind {
+	case wasm.ExternalFunction:
+		return &FuncRef{vm, int(entry.Desc.Idx)}, true
+	case wasm.ExternalGlobalType:
+		return vm.globals[entry.Desc.Idx], true
+	case wasm.ExternalMemory:
+		return vm.memory, true
+	case wasm.ExternalTable:
+		return vm.table, true
+	}
+	return nil, false
+}
+
+// funcType returns the signature of function fidx including imports
+func (vm *VM) funcType(fidx int) *wasm.FuncType {
+	if fidx < len(vm.functionImports) {
+		return vm.functionImports[fidx].signature
+	}
+	return &vm.GetFunction(fidx).Type
+}
+
+// resolveImports links the module imports, globals are resolved ahead of the
+// module globals in the global index space
+func (vm *VM) resolveImports() error {
+	m := vm.Module
+	if m.ImportSec == nil {
+		return nil
+	}
+	resolver, linking := vm.importResolver.(ExternResolver)
+	for _, entry := range m.ImportSec.Imports {
+		desc := entry.ImportDesc
+		importErr := func(err error) error {
+			return &ImportError{Module: entry.ModuleName, Name: entry.FieldName, Err: err}
+		}
+		var extern Extern
+		if linking {
+			extern = resolver.GetExtern(entry.ModuleName, entry.FieldName)
+		}
+		if extern != nil && extern.externKind() != desc.Kind {
+			return importErr(ErrIncompatibleImportType)
+		}
+		switch desc.Kind {
+		case wasm.ExternalFunction:
+			fi := FunctionImport{
+				module:    entry.ModuleName,
+				name:      entry.FieldName,
+				signature: &m.TypeSec.FuncTypes[desc.TypeIdx],
+			}
+			if ref, ok := extern.(*FuncRef); ok {
+				if !equalFuncTypes(ref.Type(), fi.signature) {
+					return importErr(ErrIncompatibleImportType)
+				}
+				fi.ref = ref
+			} else if linking {
+				hf := vm.importResolver.GetFunction(entry.ModuleName, entry.FieldName)
+				if hf == nil {
+					return importErr(ErrUnknownImport)
+				}
+				fi.function = &hf
+			}
+			vm.functionImports = append(vm.functionImports, fi)
+		case wasm.ExternalGlobalType:
+			global, ok := extern.(*Global)
+			if !ok {
+				return importErr(ErrUnknownImport)
+			}
+			if global.typ != *desc.GlobalType {
+				return importErr(ErrIncompatibleImportType)
+			}
+			vm.globals = append(vm.globals, global)
+		case wasm.ExternalMemory:
+			memory, ok := extern.(*Memory)
+			if !ok {
+				return importErr(ErrUnknownImport)
+			}
+			if !matchLimits(uint32(memory.Pages()), memory.limits, desc.Mem.Limits) {
+				return importErr(ErrIncompatibleImportType)
+			}
+			vm.memory = memory
+		case wasm.ExternalTable:
+			table, ok := extern.(*Table)
+			if !ok {
+				return importErr(ErrUnknownImport)
+			}
+			if !matchLimits(uint32(table.Len()), table.limits, desc.Table.Limits) {
+				return importErr(ErrIncompatibleImportType)
+			}
+			vm.table = table
+		}
+	}
+	return nil
+}
+
+// matchLimits checks an instance of size with limits can be imported as expected
+func matchLimits(size uint32, limits wasm.Limits, expected wasm.Limits) bool {
+	if size < expected.Min {
+		return false
+	}
+	if expected.Flag == 1 {
+		return limits.Flag == 1 && limits.Max <= expected.Max
+	}
+	return true
+}
+
+func equalFuncTypes(a, b *wasm.FuncType) bool {
+	if len(a.ParamTypes) != len(b.ParamTypes) || len(a.ReturnTypes) != len(b.ReturnTypes) {
+		return false
+	}
+	for i, t := range a.ParamTypes {
+		if t != b.ParamTypes[i] {
+			return false
+		}
+	}
+	for i, t := range a.ReturnTypes {
+		if t != b.ReturnTypes[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// initGlobals evaluates the initializers of the module globals
+func (vm *VM) initGlobals() error {
+	if vm.Module.GlobalSec == nil {
+		return nil
+	}
+	for _, global := range vm.Module.GlobalSec.Globals {
+		val, err := vm.execConstExpr(global.Init)
+		if err != nil {
+			return err
+		}
+		vm.globals = append(vm.globals, &Global{typ: global.Type, value: val})
+	}
+	return nil
+}
+
+// execConstExpr evaluates a validated constant expression
+func (vm *VM) execConstExpr(expr []byte) (uint64, error) {
+	frame := &Frame{fn: &wasm.Function{Code: wasm.Code{Exprs: expr}}, ip: 0}
+	switch expr[0] {
+	case byte(0x41): // i32.const
+		return uint64(uint32(frame.readLEB(32, true))), nil
+	case byte(0x42): // i64.const
+		return uint64(frame.readLEB(64, true)), nil
+	case byte(0x43): // f32.const
+		return uint64(binary.LittleEndian.Uint32(expr[1:])), nil
+	case byte(0x44): // f64.const
+		return binary.LittleEndian.Uint64(expr[1:]), nil
+	case byte(0x23): // global.get
+		idx := int(frame.readLEB(32, false))
+		if idx >= len(vm.globals) {
+			return 0, fmt.Errorf("unknown global %d", idx)
+		}
+		return vm.globals[idx].value, nil
+	}
+	return 0, fmt.Errorf("constant expression required, got 0x%02x", expr[0])
+}
+
+// initSegments allocates the module table and memory and writes the element and
+// data segments once all of them are known to fit the table and memory, which
+// may be imported. Offsets may read imported globals.
+func (vm *VM) initSegments() error {
+	m := vm.Module
+	if m.TableSec != nil && len(m.TableSec.Tables) != 0 {
+		vm.table = NewTable(m.TableSec.Tables[0].Limits)
+	}
+	if m.MemSec != nil && len(m.MemSec.Mems) != 0 {
+		limits := m.MemSec.Mems[0].Limits
+		if err := vm.burnGas(GasCategoryMemory, vm.gasPolicy.GetCostForMalloc(int(limits.Min))); err != nil {
+			return err
+		}
+		vm.memory = NewMemory(limits)
+	}
+	if vm.memory == nil {
+		vm.memory = NewMemory(wasm.Limits{Min: 1})
+	}
+
+	var elemOffsets, dataOffsets []uint64
+	if m.ElementSec != nil {
+		for i, elem := range m.ElementSec.Elements {
+			offset, err := vm.execConstExpr(elem.Init)
+			if err != nil {
+				return err
+			}
+			offset = uint64(uint32(offset))
+			size := 0
+			if vm.table != nil {
+				size = vm.table.Len()
+			}
+			if offset+uint64(len(elem.Offset)) > uint64(size) {
+				return &SegmentError{Err: ErrElemSegmentDoesNotFit, Index: i, Offset: offset, Length: len(elem.Offset), Size: size}
+			}
+			elemOffsets = append(elemOffsets, offset)
+		}
+	}
+	if m.DataSec != nil {
+		dataSize := 0
+		for i, data := range m.DataSec.DataSegments {
+			offset, err := vm.execConstExpr(data.Offset)
+			if err != nil {
+				return err
+			}
+			offset = uint64(uint32(offset))
+			if offset+uint64(len(data.Init)) > uint64(len(vm.memory.data)) {
+				return &SegmentError{Err: ErrDataSegmentDoesNotFit, Index: i, Offset: offset, Length: len(data.Init), Size: len(vm.memory.data)}
+			}
+			dataOffsets = append(dataOffsets, offset)
+			dataSize += len(data.Init)
+		}
+		if err := vm.burnGas(GasCategoryMemory, vm.gasPolicy.GetCostForMemCopy(dataSize)); err != nil {
+			return err
+		}
+	}
+
+	for i, offset := range elemOffsets {
+		for j, fidx := range m.ElementSec.Elements[i].Offset {
+			vm.table.elements[offset+uint64(j)] = &FuncRef{vm, int(fidx)}
+		}
+	}
+	for i, offset := range dataOffsets {
+		copy(vm.memory.data[offset:], m.DataSec.DataSegments[i].Init)
+		vm.memory.touch(int(offset), len(m.DataSec.DataSegments[i].Init))
+	}
+	return nil
+}
+
+// globalValues returns the raw values of the globals in the global index space
+func (vm *VM) globalValues() []uint64 {
+	values := make([]uint64, len(vm.globals))
+	for i, global := range vm.globals {
+		values[i] = global.value
+	}
+	return values
+}
@@ -8,8 +8,8 @@ import (
 
 func TestMemSize(t *testing.T) {
 	vm := GetTestVM("i32", &FreeGasPolicy{}, 0)
-	if len(vm.memory) != vm.MemSize() {
-		t.Errorf("Expect MemSize to be %d, got %d", len(vm.memory), vm.MemSize())
+	if len(vm.memory.data) != vm.MemSize() {
+		t.Errorf("Expect MemSize to be %d, got %d", len(vm.memory.data), vm.MemSize())
 	}
 }
 
@@ -53,7 +53,7 @@ func TestMemRead(t *testing.T) {
 	vm := GetTestVM("i32", &FreeGasPolicy{}, 0)
 	sample := []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}
 	offset := vm.MemSize() - len(sample)
-	copy(vm.memory[offset:offset+len(sample)], sample)
+	copy(vm.memory.data[offset:offset+len(sample)], sample)
 	readBuffer := make([]byte, 10)
 	readSize, err := vm.MemRead(readBuffer, offset)
 	if readSize != len(sample) {
@@ -92,8 +92,8 @@ func TestMemWrite(t *testing.T) {
 	if err != nil {
 		t.Errorf("Expect MemWrite err to be nil, got %d", err)
 	}
-	if !reflect.DeepEqual(sample, vm.memory[offset:offset+len(sample)]) {
-		t.Errorf("Expect MemWrite result to be %v, got %v", sample, vm.memory[offset:offset+len(sample)])
+	if !reflect.DeepEqual(sample, vm.memory.data[offset:offset+len(sample)]) {
+		t.Errorf("Expect MemWrite result to be %v, got %v", sample, vm.memory.data[offset:offset+len(sample)])
 	}
 
 	sample = []byte{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12}
@@ -106,7 +106,124 @@ func TestMemWrite(t *testing.T) {
 	if err != io.ErrShortWrite {
 		t.Errorf("Expect MemWrite err to be io.ErrShortWrite, got %d", err)
 	}
-	if !reflect.DeepEqual(sample[:writeSize], vm.memory[offset:]) {
-		t.Errorf("Expect MemWrite result to be %v, got %v", sample[:writeSize], vm.memory[offset:])
+	if !reflect.DeepEqual(sample[:writeSize], vm.memory.data[offset:]) {
+		t.Errorf("Expect MemWrite result to be %v, got %v", sample[:writeSize], vm.memory.data[offset:])
+	}
+}
+
+func TestMemOutOfBounds(t *testing.T) {
+	vm := GetTestVM("i32", &FreeGasPolicy{}, 0)
+	for _, offset := range []int{-1, vm.MemSize() + 1} {
+		if n, err := vm.MemRead(make([]byte, 4), offset); n != 0 || err != ErrOutOfBoundMemoryAccess {
+			t.Errorf("MemRead at %d: expect %v, got %d %v", offset, ErrOutOfBoundMemoryAccess, n, err)
+		}
+		if n, err := vm.MemWrite(make([]byte, 4), offset); n != 0 || err != ErrOutOfBoundMemoryAccess {
+			t.Errorf("MemWrite at %d: expect %v, got %d %v", offset, ErrOutOfBoundMemoryAccess, n, err)
+		}
+	}
+}
+
+func TestMemoryView(t *testing.T) {
+	mem := GetTestVM("i32", &FreeGasPolicy{}, 0).Memory()
+	end := uint32(mem.Size())
+
+	if err := mem.WriteUint32(8, 0xdeadbeef); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mem.ReadUint32(8); err != nil || v != 0xdeadbeef {
+		t.Errorf("Expect 0xdeadbeef, got 0x%x %v", v, err)
+	}
+	if v, err := mem.ReadUint16(10); err != nil || v != 0xdead {
+		t.Errorf("Expect little endian 0xdead, got 0x%x %v", v, err)
+	}
+	if err := mem.WriteFloat64(end-8, -2.5); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mem.ReadFloat64(end - 8); err != nil || v != -2.5 {
+		t.Errorf("Expect -2.5, got %v %v", v, err)
+	}
+	if err := mem.WriteFloat32(16, 0.5); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mem.ReadFloat32(16); err != nil || v != 0.5 {
+		t.Errorf("Expect 0.5, got %v %v", v, err)
+	}
+	if err := mem.WriteUint64(24, 1<<40); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := mem.ReadUint64(24); err != nil || v != 1<<40 {
+		t.Errorf("Expect 1<<40, got %v %v", v, err)
+	}
+
+	if err := mem.WriteCString(32, "héllo"); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := mem.ReadCString(32); err != nil || s != "héllo" {
+		t.Errorf("Expect héllo, got %q %v", s, err)
+	}
+	if s, err := mem.ReadString(32, 3); err != nil || s != "hé" {
+		t.Errorf("Expect hé, got %q %v", s, err)
+	}
+	if _, err := mem.ReadString(32, 2); err != ErrInvalidUTF8 {
+		t.Errorf("Expect %v, got %v", ErrInvalidUTF8, err)
+	}
+	if err := mem.WriteBytes(end-2, []byte{1, 2}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := mem.ReadCString(end - 2); err != ErrUnterminatedCStr {
+		t.Errorf("Expect %v, got %v", ErrUnterminatedCStr, err)
+	}
+
+	b, err := mem.ReadBytes(8, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b[0] = 0
+	view, err := mem.Slice(8, 4)
+	if err != nil || view[0] != 0xef {
+		t.Errorf("Expect ReadBytes to copy, got % x %v", view, err)
+	}
+	view[0] = 0x11
+	if v, _ := mem.ReadUint8(8); v != 0x11 {
+		t.Errorf("Expect Slice to alias the memory, got 0x%x", v)
+	}
+
+	outOfBounds := []error{
+		mem.WriteUint32(end-3, 0),
+		mem.WriteUint8(end, 0),
+		mem.WriteString(end-1, "ab"),
+	}
+	_, err = mem.ReadUint64(end - 7)
+	outOfBounds = append(outOfBounds, err)
+	_, err = mem.ReadBytes(^uint32(0), 2)
+	outOfBounds = append(outOfBounds, err)
+	_, err = mem.ReadCString(end + 1)
+	outOfBounds = append(outOfBounds, err)
+	for i, err := range outOfBounds {
+		if err != ErrOutOfBoundMemoryAccess {
+			t.Errorf("Access %d: expect %v, got %v", i, ErrOutOfBoundMemoryAccess, err)
+		}
+	}
+	if v, _ := mem.ReadUint8(end - 1); v != 2 {
+		t.Errorf("Expect failed writes to leave the memory unchanged, got %d", v)
+	}
+}
+
+func TestTrackWrites(t *testing.T) {
+	mem := GetTestVM("i32", &FreeGasPolicy{}, 0).Memory()
+	mem.WriteUint8(0, 1)
+	if chunks := mem.WrittenChunks(); chunks != nil {
+		t.Errorf("Expect no chunks before tracking, got %v", chunks)
+	}
+	mem.TrackWrites()
+	mem.WriteUint32(3*MerkleChunkSize-2, 1)
+	mem.WriteUint8(0, 2)
+	mem.ReadBytes(MerkleChunkSize, 8)
+	mem.Grow(1)
+	if chunks := mem.WrittenChunks(); !reflect.DeepEqual(chunks, []int{0, 2, 3}) {
+		t.Errorf("Expect chunks [0 2 3], got %v", chunks)
+	}
+	if chunks := mem.WrittenChunks(); len(chunks) != 0 {
+		t.Errorf("Expect the chunks to be taken, got %v", chunks)
 	}
 }
@@ -0,0 +1,228 @@
+package vm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"unicode/utf8"
+
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// Memory is a linear memory, an imported memory is shared with the exporting VM.
+//
+// The accessors are bounds checked and return errors instead of trapping, they
+// do not charge gas: host functions copying large buffers should burn gas for
+// it with VM.BurnMemCopyGas or use VM.MemRead and VM.MemWrite.
+type Memory struct {
+	data    []byte
+	limits  wasm.Limits
+	tree    *memoryTree // maintained when merkleized
+	written *chunkSet   // collected when tracking writes
+}
+
+// NewMemory creates a zeroed memory of limits.Min pages to be imported by VMs
+func NewMemory(limits wasm.Limits) *Memory {
+	return &Memory{data: make([]byte, int(limits.Min)*wasmPageSize), limits: limits}
+}
+
+func (m *Memory) externKind() byte { return wasm.ExternalMemory }
+
+// Pages returns the memory size in pages
+func (m *Memory) Pages() int {
+	return len(m.data) / wasmPageSize
+}
+
+// Size returns the memory size in bytes
+func (m *Memory) Size() int {
+	return len(m.data)
+}
+
+// Grow appends n zeroed pages and returns the previous size in pages, or -1
+// when the memory would exceed its maximum. Unlike memory.grow it burns no gas.
+func (m *Memory) Grow(n int) int {
+	return m.grow(n)
+}
+
+// grow appends n pages and returns the previous size in pages, or -1 when the
+// memory would exceed its maximum
+func (m *Memory) grow(n int) int {
+	if !m.canGrow(n) {
+		return -1
+	}
+	pages := m.Pages()
+	m.data = append(m.data, make([]byte, n*wasmPageSize)...)
+	if m.tree != nil {
+		m.tree.dirty.add(pages*wasmPageSize/MerkleChunkSize, len(m.data)/MerkleChunkSize)
+	}
+	return pages
+}
+
+// canGrow tells if n pages can be added within the memory limits
+func (m *Memory) canGrow(n int) bool {
+	maxPages := maxSize / wasmPageSize
+	if m.limits.Flag == 1 && maxPages > int(m.limits.Max) {
+		maxPages = int(m.limits.Max)
+	}
+	return n >= 0 && m.Pages()+n <= maxPages
+}
+
+// Slice returns the length bytes at ptr without copying them. The slice aliases
+// the memory until it grows: memory.grow may move the memory, after which the
+// slice neither sees nor makes changes, so it must not be kept across calls
+// into the VM. A merkleized memory counts the range as written.
+func (m *Memory) Slice(ptr, length uint32) ([]byte, error) {
+	b, err := m.slice(ptr, length)
+	if err == nil {
+		m.touch(int(ptr), int(length))
+	}
+	return b, err
+}
+
+// slice is Slice for reads
+func (m *Memory) slice(ptr, length uint32) ([]byte, error) {
+	if uint64(ptr)+uint64(length) > uint64(len(m.data)) {
+		return nil, ErrOutOfBoundMemoryAccess
+	}
+	return m.data[ptr : ptr+length : ptr+length], nil
+}
+
+// ReadBytes returns a copy of the length bytes at ptr
+func (m *Memory) ReadBytes(ptr, length uint32) ([]byte, error) {
+	b, err := m.slice(ptr, length)
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(nil), b...), nil
+}
+
+// ReadString returns the UTF-8 string of length bytes at ptr
+func (m *Memory) ReadString(ptr, length uint32) (string, error) {
+	b, err := m.slice(ptr, length)
+	if err != nil {
+		return "", err
+	}
+	if !utf8.Valid(b) {
+		return "", ErrInvalidUTF8
+	}
+	return string(b), nil
+}
+
+// ReadCString returns the NUL terminated UTF-8 string at ptr
+func (m *Memory) ReadCString(ptr uint32) (string, error) {
+	if uint64(ptr) > uint64(len(m.data)) {
+		return "", ErrOutOfBoundMemoryAccess
+	}
+	n := bytes.IndexByte(m.data[ptr:], 0)
+	if n < 0 {
+		return "", ErrUnterminatedCStr
+	}
+	return m.ReadString(ptr, uint32(n))
+}
+
+// ReadUint8 reads the byte at ptr
+func (m *Memory) ReadUint8(ptr uint32) (uint8, error) {
+	b, err := m.slice(ptr, 1)
+	if err != nil {
+		return 0, err
+	}
+	return b[0], nil
+}
+
+// ReadUint16 reads the little endian uint16 at ptr
+func (m *Memory) ReadUint16(ptr uint32) (uint16, error) {
+	b, err := m.slice(ptr, 2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(b), nil
+}
+
+// ReadUint32 reads the little endian uint32 at ptr
+func (m *Memory) ReadUint32(ptr uint32) (uint32, error) {
+	b, err := m.slice(ptr, 4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(b), nil
+}
+
+// ReadUint64 reads the little endian uint64 at ptr
+func (m *Memory) ReadUint64(ptr uint32) (uint64, error) {
+	b, err := m.slice(ptr, 8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(b), nil
+}
+
+// ReadFloat32 reads the little endian float32 at ptr
+func (m *Memory) ReadFloat32(ptr uint32) (float32, error) {
+	v, err := m.ReadUint32(ptr)
+	return math.Float32frombits(v), err
+}
+
+// ReadFloat64 reads the little endian float64 at ptr
+func (m *Memory) ReadFloat64(ptr uint32) (float64, error) {
+	v, err := m.ReadUint64(ptr)
+	return math.Float64frombits(v), err
+}
+
+// WriteBytes writes b at ptr, nothing is written if b does not fit
+func (m *Memory) WriteBytes(ptr uint32, b []byte) error {
+	if uint64(len(b)) > math.MaxUint32 {
+		return ErrOutOfBoundMemoryAccess
+	}
+	dst, err := m.Slice(ptr, uint32(len(b)))
+	if err != nil {
+		return err
+	}
+	copy(dst, b)
+	return nil
+}
+
+// WriteString writes the bytes of s at ptr
+func (m *Memory) WriteString(ptr uint32, s string) error {
+	return m.WriteBytes(ptr, []byte(s))
+}
+
+// WriteCString writes s followed by a NUL byte at ptr
+func (m *Memory) WriteCString(ptr uint32, s string) error {
+	return m.WriteBytes(ptr, append([]byte(s), 0))
+}
+
+// WriteUint8 writes v at ptr
+func (m *Memory) WriteUint8(ptr uint32, v uint8) error {
+	return m.WriteBytes(ptr, []byte{v})
+}
+
+// WriteUint16 writes v in little endian at ptr
+func (m *Memory) WriteUint16(ptr uint32, v uint16) error {
+	b := make([]byte, 2)
+	binary.LittleEndian.PutUint16(b, v)
+	return m.WriteBytes(ptr, b)
+}
+
+// WriteUint32 writes v in little endian at ptr
+func (m *Memory) WriteUint32(ptr uint32, v uint32) error {
+	b := make([]byte, 4)
+	binary.LittleEndian.PutUint32(b, v)
+	return m.WriteBytes(ptr, b)
+}
+
+// WriteUint64 writes v in little endian at ptr
+func (m *Memory) WriteUint64(ptr uint32, v uint64) error {
+	b := make([]byte, 8)
+	binary.LittleEndian.PutUint64(b, v)
+	return m.WriteBytes(ptr, b)
+}
+
+// WriteFloat32 writes v in little endian at ptr
+func (m *Memory) WriteFloat32(ptr uint32, v float32) error {
+	return m.WriteUint32(ptr, math.Float32bits(v))
+}
+
+// WriteFloat64 writes v in little endian at ptr
+func (m *Memory) WriteFloat64(ptr uint32, v float64) error {
+	return m.WriteUint64(ptr, math.Float64bits(v))
+}
@@ -0,0 +1,311 @@
+package vm
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"sort"
+)
+
+// MerkleChunkSize is the size of the memory chunks hashed as Merkle tree leaves
+const MerkleChunkSize = 4096
+
+// Hash prefixes separating the node kinds of the state tree
+const (
+	merkleLeaf byte = iota
+	merkleNode
+	merkleMemory
+	merkleGlobals
+	merkleTable
+	merkleState
+)
+
+// memoryTree is a binary Merkle tree over the memory chunks, padded with zero
+// hashes to a power of two leaves. Written chunks are marked dirty and their
+// paths are rehashed when the root is needed.
+type memoryTree struct {
+	levels [][][32]byte // leaf hashes first, root last
+	dirty  chunkSet     // chunks written since the last update
+}
+
+// chunkSet is a set of memory chunk indexes
+type chunkSet struct {
+	in      []bool
+	indexes []int // in insertion order
+}
+
+// add inserts the chunks from first up to end
+func (s *chunkSet) add(first, end int) {
+	for len(s.in) < end {
+		s.in = append(s.in, false)
+	}
+	for i := first; i < end; i++ {
+		if !s.in[i] {
+			s.in[i] = true
+			s.indexes = append(s.indexes, i)
+		}
+	}
+}
+
+// take empties the set and returns its chunks in increasing order
+func (s *chunkSet) take() []int {
+	indexes := s.indexes
+	sort.Ints(indexes)
+	for _, i := range indexes {
+		s.in[i] = false
+	}
+	s.indexes = nil
+	return indexes
+}
+
+// merkleize starts maintaining the memory tree, it is shared by the VMs
+// importing the memory
+func (m *Memory) merkleize() {
+	if m.tree != nil {
+		return
+	}
+	m.tree = &memoryTree{}
+	m.tree.dirty.add(0, len(m.data)/MerkleChunkSize)
+}
+
+// touch marks the chunks of the length bytes at ptr as written
+func (m *Memory) touch(ptr, length int) {
+	if length <= 0 {
+		return
+	}
+	first, end := ptr/MerkleChunkSize, (ptr+length-1)/MerkleChunkSize+1
+	if m.tree != nil {
+		m.tree.dirty.add(first, end)
+	}
+	if m.written != nil {
+		m.written.add(first, end)
+	}
+}
+
+// TrackWrites starts collecting the chunks of MerkleChunkSize bytes written to
+// the memory by instructions, data segments and the host accessors, to be
+// returned by WrittenChunks
+func (m *Memory) TrackWrites() {
+	if m.written == nil {
+		m.written = &chunkSet{}
+	}
+}
+
+// WrittenChunks returns the indexes of the chunks written since the last call
+// in increasing order. Pages added by growing the memory are zeroed and are
+// not counted as written.
+func (m *Memory) WrittenChunks() []int {
+	if m.written == nil {
+		return nil
+	}
+	return m.written.take()
+}
+
+// root rehashes the dirty chunks and returns the memory commitment, which
+// covers the tree root and the number of pages
+func (m *Memory) root() [32]byte {
+	t := m.tree
+	n := len(m.data) / MerkleChunkSize
+	width := 1
+	for width < n {
+		width <<= 1
+	}
+	rebuild := len(t.levels) == 0 || len(t.levels[0]) != width
+	if rebuild {
+		// the memory only grows, the known leaves keep their index
+		leaves := make([][32]byte, width)
+		if len(t.levels) != 0 {
+			copy(leaves, t.levels[0])
+		}
+		t.levels = [][][32]byte{leaves}
+		for w := width / 2; w >= 1; w /= 2 {
+			t.levels = append(t.levels, make([][32]byte, w))
+		}
+	}
+
+	changed := t.dirty.take()
+	for _, i := range changed {
+		t.levels[0][i] = leafHash(m.data[i*MerkleChunkSize : (i+1)*MerkleChunkSize])
+	}
+	for l := 1; l < len(t.levels); l++ {
+		if rebuild {
+			for p := range t.levels[l] {
+				t.levels[l][p] = nodeHash(t.levels[l-1][2*p], t.levels[l-1][2*p+1])
+			}
+			continue
+		}
+		parents := changed[:0]
+		for _, i := range changed {
+			if p := i / 2; len(parents) == 0 || parents[len(parents)-1] != p {
+				parents = append(parents, p)
+			}
+		}
+		for _, p := range parents {
+			t.levels[l][p] = nodeHash(t.levels[l-1][2*p], t.levels[l-1][2*p+1])
+		}
+		changed = parents
+	}
+	return memoryCommitment(uint32(m.Pages()), t.levels[len(t.levels)-1][0])
+}
+
+// path returns the sibling hashes of chunk i from the leaf up, root must have
+// been called since the last write
+func (t *memoryTree) path(i int) [][32]byte {
+	path := make([][32]byte, 0, len(t.levels)-1)
+	for l := 0; l < len(t.levels)-1; l++ {
+		path = append(path, t.levels[l][i^1])
+		i /= 2
+	}
+	return path
+}
+
+func leafHash(chunk []byte) [32]byte {
+	return sha256.Sum256(append([]byte{merkleLeaf}, chunk...))
+}
+
+func nodeHash(left, right [32]byte) [32]byte {
+	b := make([]byte, 0, 65)
+	b = append(b, merkleNode)
+	b = append(b, left[:]...)
+	return sha256.Sum256(append(b, right[:]...))
+}
+
+func memoryCommitment(pages uint32, treeRoot [32]byte) [32]byte {
+	b := []byte{merkleMemory, 0, 0, 0, 0}
+	binary.LittleEndian.PutUint32(b[1:], pages)
+	return sha256.Sum256(append(b, treeRoot[:]...))
+}
+
+func stateCommitment(memory, globals, table [32]byte) [32]byte {
+	b := make([]byte, 0, 97)
+	b = append(b, merkleState)
+	b = append(b, memory[:]...)
+	b = append(b, globals[:]...)
+	return sha256.Sum256(append(b, table[:]...))
+}
+
+// globalsRoot hashes the type and value of the globals in the global index space
+func (vm *VM) globalsRoot() [32]byte {
+	b := []byte{merkleGlobals}
+	for _, global := range vm.globals {
+		b = append(b, byte(global.typ.ValueType), byte(global.typ.Mutability), 0, 0, 0, 0, 0, 0, 0, 0)
+		binary.LittleEndian.PutUint64(b[len(b)-8:], global.value)
+	}
+	return sha256.Sum256(b)
+}
+
+// tableRoot hashes the table elements as a kind, 0 when uninitialized, 1 for a
+// function of the VM and 2 for a function of another VM, and a function index
+func (vm *VM) tableRoot() [32]byte {
+	b := []byte{merkleTable}
+	if vm.table != nil {
+		for _, ref := range vm.table.elements {
+			kind, fidx := byte(0), 0
+			if ref != nil {
+				kind, fidx = 1, ref.fidx
+				if ref.vm != vm {
+					kind = 2
+				}
+			}
+			b = append(b, kind, 0, 0, 0, 0)
+			binary.LittleEndian.PutUint32(b[len(b)-4:], uint32(fidx))
+		}
+	}
+	return sha256.Sum256(b)
+}
+
+// StateRoot returns the commitment to the memory, globals and table of a VM
+// created with Config.Merkleized
+func (vm *VM) StateRoot() ([32]byte, error) {
+	if vm.memory.tree == nil {
+		return [32]byte{}, ErrNotMerkleized
+	}
+	return stateCommitment(vm.memory.root(), vm.globalsRoot(), vm.tableRoot()), nil
+}
+
+// MemoryProof proves the content of a memory range against a state root
+type MemoryProof struct {
+	Offset  uint32
+	Length  uint32
+	Pages   uint32       // memory size
+	Chunks  [][]byte     // chunks covering the range, from the one holding Offset
+	Paths   [][][32]byte // sibling hashes of each chunk from the leaf up
+	Globals [32]byte     // commitment to the globals
+	Table   [32]byte     // commitment to the table
+}
+
+// ProveMemory returns the inclusion proof of the length bytes at offset
+func (vm *VM) ProveMemory(offset, length uint32) (*MemoryProof, error) {
+	m := vm.memory
+	if m.tree == nil {
+		return nil, ErrNotMerkleized
+	}
+	if length == 0 {
+		return nil, ErrEmptyRange
+	}
+	if uint64(offset)+uint64(length) > uint64(len(m.data)) {
+		return nil, ErrOutOfBoundMemoryAccess
+	}
+	m.root()
+	proof := &MemoryProof{
+		Offset:  offset,
+		Length:  length,
+		Pages:   uint32(m.Pages()),
+		Globals: vm.globalsRoot(),
+		Table:   vm.tableRoot(),
+	}
+	first, end := int(offset)/MerkleChunkSize, (int(offset)+int(length)-1)/MerkleChunkSize+1
+	for i := first; i < end; i++ {
+		proof.Chunks = append(proof.Chunks, append([]byte(nil), m.data[i*MerkleChunkSize:(i+1)*MerkleChunkSize]...))
+		proof.Paths = append(proof.Paths, m.tree.path(i))
+	}
+	return proof, nil
+}
+
+// Verify checks the proof against a state root
+func (p *MemoryProof) Verify(root [32]byte) bool {
+	if p.Length == 0 || uint64(p.Offset)+uint64(p.Length) > uint64(p.Pages)*wasmPageSize {
+		return false
+	}
+	n := int(p.Pages) * wasmPageSize / MerkleChunkSize
+	depth := 0
+	for 1<<uint(depth) < n {
+		depth++
+	}
+	first, end := int(p.Offset)/MerkleChunkSize, (int(p.Offset)+int(p.Length)-1)/MerkleChunkSize+1
+	if len(p.Chunks) != end-first || len(p.Paths) != end-first {
+		return false
+	}
+	var treeRoot [32]byte
+	for k, chunk := range p.Chunks {
+		if len(chunk) != MerkleChunkSize || len(p.Paths[k]) != depth {
+			return false
+		}
+		i, h := first+k, leafHash(chunk)
+		for _, sibling := range p.Paths[k] {
+			if i%2 == 0 {
+				h = nodeHash(h, sibling)
+			} else {
+				h = nodeHash(sibling, h)
+			}
+			i /= 2
+		}
+		if k != 0 && h != treeRoot {
+			return false
+		}
+		treeRoot = h
+	}
+	return stateCommitment(memoryCommitment(p.Pages, treeRoot), p.Globals, p.Table) == root
+}
+
+// Data returns the proven bytes, the proof must be verified first
+func (p *MemoryProof) Data() []byte {
+	var b []byte
+	for _, chunk := range p.Chunks {
+		b = append(b, chunk...)
+	}
+	start := int(p.Offset) % MerkleChunkSize
+	if start+int(p.Length) > len(b) {
+		return nil
+	}
+	return b[start : start+int(p.Length)]
+}
@@ -0,0 +1,139 @@
+package vm
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+const merkleModule = `(module
+  (memory 1 8)
+  (global $counter (mut i32) (i32.const 0))
+  (table 2 anyfunc)
+  (elem (i32.const 1) $store)
+  (data (i32.const 4090) "spans two chunks")
+  (func $store (export "store") (param $ptr i32) (param $v i64)
+    (i64.store (local.get $ptr) (local.get $v)))
+  (func (export "grow") (param $n i32) (result i32)
+    (memory.grow (local.get $n)))
+  (func (export "count")
+    (global.set $counter (i32.add (global.get $counter) (i32.const 1)))))`
+
+func newMerkleVM(t *testing.T, config Config) *VM {
+	code, err := wat.Compile([]byte(merkleModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := NewVMWithConfig(code, &FreeGasPolicy{}, &Gas{Limit: 1 << 20}, nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+// rebuiltRoot computes the state root from scratch
+func rebuiltRoot(t *testing.T, vm *VM) [32]byte {
+	tree := vm.memory.tree
+	vm.memory.tree = nil
+	vm.memory.merkleize()
+	root, err := vm.StateRoot()
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.memory.tree = tree
+	return root
+}
+
+func TestStateRoot(t *testing.T) {
+	if _, err := newMerkleVM(t, Config{}).StateRoot(); err != ErrNotMerkleized {
+		t.Errorf("Expect %v, got %v", ErrNotMerkleized, err)
+	}
+
+	vm := newMerkleVM(t, Config{Merkleized: true})
+	roots := map[[32]byte]bool{}
+	steps := []struct {
+		name string
+		args []Value
+	}{
+		{"store", []Value{I32(100), I64(1)}},
+		{"store", []Value{I32(8190), I64(-1)}},
+		{"grow", []Value{I32(2)}},
+		{"store", []Value{I32(2*65536 + 8), I64(7)}},
+		{"grow", []Value{I32(0)}},
+		{"count", nil},
+	}
+	for _, step := range steps {
+		if _, err := vm.InvokeByName(step.name, step.args...); err != nil {
+			t.Fatal(err)
+		}
+		root, err := vm.StateRoot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if expected := rebuiltRoot(t, vm); root != expected {
+			t.Errorf("%s%v: expect the incremental root %x to be %x", step.name, step.args, root, expected)
+		}
+		roots[root] = true
+	}
+	if len(roots) != len(steps)-1 {
+		t.Errorf("Expect every step but the empty grow to change the root, got %d roots", len(roots))
+	}
+
+	vm.Memory().WriteUint8(70000, 1)
+	root, _ := vm.StateRoot()
+	if roots[root] || root != rebuiltRoot(t, vm) {
+		t.Error("Expect writes by the host to update the root")
+	}
+}
+
+func TestMemoryProof(t *testing.T) {
+	vm := newMerkleVM(t, Config{Merkleized: true})
+	vm.InvokeByName("grow", I32(3))
+	root, _ := vm.StateRoot()
+
+	if _, err := vm.ProveMemory(0, 0); err != ErrEmptyRange {
+		t.Errorf("Expect %v, got %v", ErrEmptyRange, err)
+	}
+	if _, err := vm.ProveMemory(4*65536-1, 2); err != ErrOutOfBoundMemoryAccess {
+		t.Errorf("Expect %v, got %v", ErrOutOfBoundMemoryAccess, err)
+	}
+	proof, err := vm.ProveMemory(4090, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !proof.Verify(root) || !bytes.Equal(proof.Data(), []byte("spans two chunks")) {
+		t.Errorf("Expect a valid proof of the data segment, got %q", proof.Data())
+	}
+	if len(proof.Chunks) != 2 || len(proof.Paths[0]) != 6 {
+		t.Errorf("Expect 2 chunks with paths of 6 siblings, got %d and %d", len(proof.Chunks), len(proof.Paths[0]))
+	}
+
+	tampered := *proof
+	tampered.Chunks = [][]byte{proof.Chunks[0], append([]byte{'X'}, proof.Chunks[1][1:]...)}
+	if tampered.Verify(root) {
+		t.Error("Expect a tampered chunk to be rejected")
+	}
+	tampered = *proof
+	tampered.Offset += MerkleChunkSize
+	if tampered.Verify(root) {
+		t.Error("Expect a proof of another offset to be rejected")
+	}
+	tampered = *proof
+	tampered.Pages = 8
+	if tampered.Verify(root) {
+		t.Error("Expect a proof of another memory size to be rejected")
+	}
+
+	vm.InvokeByName("count")
+	if !proof.Verify(root) {
+		t.Error("Expect the proof to stay valid for its root")
+	}
+	newRoot, _ := vm.StateRoot()
+	if proof.Verify(newRoot) {
+		t.Error("Expect the proof to be rejected once the globals changed")
+	}
+	if proof, _ := vm.ProveMemory(4090, 16); proof == nil || !proof.Verify(newRoot) {
+		t.Error("Expect a new proof to verify against the new root")
+	}
+}
@@ -0,0 +1,381 @@
+package vm
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// Steps returns the number of instructions executed by the VM since its
+// creation. Only the instructions of top level invocations are counted, a call
+// to a host function is one step whatever it runs.
+func (vm *VM) Steps() uint64 {
+	return vm.steps
+}
+
+// BreakAt suspends the execution with ErrStepReached once Steps reaches step,
+// before the next instruction runs. It continues with Resume or Step.
+func (vm *VM) BreakAt(step uint64) {
+	vm.breakStep, vm.breaking = step, true
+}
+
+// breakReached tells if a top level invocation must stop before its next instruction
+func (vm *VM) breakReached() bool {
+	if !vm.breaking || vm.steps < vm.breakStep {
+		return false
+	}
+	vm.breaking = false
+	return true
+}
+
+// Step runs the single instruction following the step the execution is
+// stopped at and returns the hash of the machine state after it. The
+// execution is then stopped at the next step, unless it completed or failed
+// in which case the error of the invocation is returned.
+func (vm *VM) Step() ([32]byte, error) {
+	if vm.suspended == nil || vm.suspended.reason != ErrStepReached {
+		return [32]byte{}, ErrNotAtStep
+	}
+	vm.BreakAt(vm.steps + 1)
+	_, err := vm.Resume(0)
+	vm.breaking = false
+	if err == ErrStepReached {
+		err = nil
+	}
+	if err != nil && vm.suspended != nil {
+		return [32]byte{}, err
+	}
+	state, stateErr := vm.MachineState()
+	if stateErr != nil {
+		return [32]byte{}, stateErr
+	}
+	return state.Hash(), err
+}
+
+// MachineState is the state of a VM between two steps, the memory and table
+// are only committed to. An idle VM has no frames.
+type MachineState struct {
+	Steps      uint64
+	GasUsed    uint64
+	GasLimit   uint64
+	GasRefund  uint64
+	GasStart   uint64 // gas used when the invocation started, refunds are capped on the gas used since
+	BaseSP     uint32 // operand stack height when the invocation started
+	BreakDepth int32  // blocks left to skip, -1 when executing
+	Stack      []uint64
+	Frames     []FrameState
+	Blocks     []BlockState
+	Globals    []uint64
+	Memory     [32]byte // memory commitment, see StateRoot
+	Table      [32]byte // table commitment
+}
+
+// FrameState is a call frame of a MachineState
+type FrameState struct {
+	Function       uint32 // function index including imports
+	IP             int32  // offset of the last instruction read, -1 before the first one
+	BasePointer    uint32
+	BaseBlockIndex uint32
+}
+
+// BlockState is a block of a MachineState
+type BlockState struct {
+	Type         uint8
+	ReturnType   uint8
+	ExecuteElse  bool
+	LabelPointer int32
+	BasePointer  uint32
+}
+
+// MachineState returns the state of a merkleized VM stopped at a step or idle
+func (vm *VM) MachineState() (*MachineState, error) {
+	if vm.memory.tree == nil {
+		return nil, ErrNotMerkleized
+	}
+	if vm.invocations != 0 || (vm.suspended != nil && vm.suspended.reason != ErrStepReached) {
+		return nil, ErrNotAtStep
+	}
+	s := &MachineState{
+		Steps:      vm.steps,
+		GasUsed:    vm.gas.Used,
+		GasLimit:   vm.gas.Limit,
+		GasRefund:  vm.gas.Refund,
+		GasStart:   vm.gasStart,
+		BreakDepth: int32(vm.breakDepth),
+		Stack:      append([]uint64{}, vm.stack[:vm.sp]...),
+		Globals:    vm.globalValues(),
+		Memory:     vm.memory.root(),
+		Table:      vm.tableRoot(),
+	}
+	if vm.suspended != nil {
+		s.BaseSP = uint32(vm.suspended.baseSP)
+	} else {
+		s.BaseSP = uint32(vm.sp)
+	}
+	for _, frame := range vm.frames[:vm.framesIndex] {
+		s.Frames = append(s.Frames, FrameState{
+			Function:       uint32(frame.fidx),
+			IP:             int32(frame.ip),
+			BasePointer:    uint32(frame.basePointer),
+			BaseBlockIndex: uint32(frame.baseBlockIndex),
+		})
+	}
+	for _, block := range vm.blocks[:vm.blocksIndex] {
+		s.Blocks = append(s.Blocks, BlockState{
+			Type:         uint8(block.blockType),
+			ReturnType:   uint8(block.returnType),
+			ExecuteElse:  block.executeElse,
+			LabelPointer: int32(block.labelPointer),
+			BasePointer:  uint32(block.basePointer),
+		})
+	}
+	return s, nil
+}
+
+// RestoreState loads s in a merkleized VM of the same module with the memory
+// and table s commits to, the VM is then stopped at the step of s
+func (vm *VM) RestoreState(s *MachineState) error {
+	if vm.memory.tree == nil {
+		return ErrNotMerkleized
+	}
+	if vm.invocations != 0 {
+		return ErrNotAtStep
+	}
+	if s.Memory != vm.memory.root() || s.Table != vm.tableRoot() || len(s.Globals) != len(vm.globals) {
+		return ErrStateMismatch
+	}
+	if err := vm.validateState(s); err != nil {
+		return err
+	}
+	frames := make([]*Frame, len(s.Frames))
+	for i, f := range s.Frames {
+		frames[i] = NewFrame(vm.GetFunction(int(f.Function)), int(f.BasePointer), int(f.BaseBlockIndex))
+		frames[i].fidx = int(f.Function)
+		frames[i].ip = int(f.IP)
+	}
+	blocks := make([]*Block, len(s.Blocks))
+	for i, b := range s.Blocks {
+		blocks[i] = NewBlock(int(b.LabelPointer), BlockType(b.Type), wasm.ValueType(b.ReturnType), int(b.BasePointer))
+		blocks[i].executeElse = b.ExecuteElse
+	}
+
+	vm.steps = s.Steps
+	vm.gas.Used, vm.gas.Limit, vm.gas.Refund = s.GasUsed, s.GasLimit, s.GasRefund
+	vm.gasStart = s.GasStart
+	vm.sp = copy(vm.stack, s.Stack)
+	vm.framesIndex = copy(vm.frames, frames)
+	vm.blocksIndex = copy(vm.blocks, blocks)
+	vm.breakDepth = int(s.BreakDepth)
+	for i, value := range s.Globals {
+		vm.globals[i].value = value
+	}
+	vm.suspended = nil
+	if len(frames) != 0 {
+		vm.suspended = &suspension{reason: ErrStepReached, baseSP: int(s.BaseSP)}
+	}
+	return nil
+}
+
+// validateState checks the frames and blocks of s can be executed by the VM:
+// the locals of each frame fit in the stack, each frame stops between two
+// instructions of its function and its loops branch after a block instruction
+func (vm *VM) validateState(s *MachineState) error {
+	if len(s.Stack) > StackSize || len(s.Frames) > MaxFrames || len(s.Blocks) > MaxBlocks ||
+		int(s.BaseSP) > len(s.Stack) || s.BreakDepth < -1 || int(s.BreakDepth) >= len(s.Blocks)+1 || s.GasStart > s.GasUsed {
+		return ErrInvalidState
+	}
+	if (len(s.Frames) == 0 && len(s.Blocks) != 0) || (len(s.Frames) != 0 && s.Frames[0].BaseBlockIndex != 0) {
+		return ErrInvalidState
+	}
+	blockIndex := 0
+	ends := make(map[uint32]map[int]opcode.Opcode)
+	for i, f := range s.Frames {
+		fn := vm.GetFunction(int(f.Function))
+		if int(f.Function) < len(vm.functionImports) || fn == nil {
+			return ErrInvalidState
+		}
+		if ends[f.Function] == nil {
+			ends[f.Function] = instructionEnds(fn)
+		}
+		if _, ok := ends[f.Function][int(f.IP)]; (!ok && f.IP != -1) || int(f.BaseBlockIndex) < blockIndex || int(f.BaseBlockIndex) > len(s.Blocks) {
+			return ErrInvalidState
+		}
+		numLocals := len(fn.Type.ParamTypes)
+		for _, entry := range fn.Code.Locals {
+			numLocals += int(entry.Count)
+		}
+		if uint64(f.BasePointer)+uint64(numLocals) > uint64(len(s.Stack)) {
+			return ErrInvalidState
+		}
+		blockIndex = int(f.BaseBlockIndex)
+		end := len(s.Blocks)
+		if i+1 < len(s.Frames) && int(s.Frames[i+1].BaseBlockIndex) <= end {
+			end = int(s.Frames[i+1].BaseBlockIndex)
+		}
+		for j := blockIndex; j < end; j++ {
+			switch ends[f.Function][int(s.Blocks[j].LabelPointer)] {
+			case opcode.Block, opcode.Loop, opcode.If:
+			default:
+				return ErrInvalidState
+			}
+		}
+	}
+	for _, b := range s.Blocks {
+		if b.Type < uint8(typeBlock) || b.Type > uint8(typeIf) || int(b.BasePointer) > len(s.Stack) {
+			return ErrInvalidState
+		}
+		switch uint32(b.ReturnType) {
+		case wasm.BlockTypeEmpty, uint32(wasm.ValueTypeI32), uint32(wasm.ValueTypeI64), uint32(wasm.ValueTypeF32), uint32(wasm.ValueTypeF64):
+		default:
+			return ErrInvalidState
+		}
+	}
+	return nil
+}
+
+// instructionEnds maps the offset of the last byte of each instruction of fn,
+// where a frame stops between instructions, to the opcode of the instruction
+func instructionEnds(fn *wasm.Function) map[int]opcode.Opcode {
+	ends := make(map[int]opcode.Opcode)
+	frame := NewFrame(fn, 0, 0)
+	for frame.ip < len(fn.Code.Exprs)-1 {
+		frame.ip++
+		op := opcode.Opcode(fn.Code.Exprs[frame.ip])
+		if info := op.Info(); info != nil {
+			for _, imm := range info.Immediates {
+				skipImmediate(frame, imm)
+			}
+		}
+		ends[frame.ip] = op
+	}
+	return ends
+}
+
+// Encode returns the canonical encoding of the state, integers are little
+// endian and lists are prefixed with their uint32 length
+func (s *MachineState) Encode() []byte {
+	var b []byte
+	u32 := func(v uint32) { b = append(b, 0, 0, 0, 0); binary.LittleEndian.PutUint32(b[len(b)-4:], v) }
+	u64 := func(v uint64) { b = append(b, 0, 0, 0, 0, 0, 0, 0, 0); binary.LittleEndian.PutUint64(b[len(b)-8:], v) }
+	u64s := func(values []uint64) {
+		u32(uint32(len(values)))
+		for _, v := range values {
+			u64(v)
+		}
+	}
+
+	u64(s.Steps)
+	u64(s.GasUsed)
+	u64(s.GasLimit)
+	u64(s.GasRefund)
+	u64(s.GasStart)
+	u32(s.BaseSP)
+	u32(uint32(s.BreakDepth))
+	u64s(s.Stack)
+	u32(uint32(len(s.Frames)))
+	for _, f := range s.Frames {
+		u32(f.Function)
+		u32(uint32(f.IP))
+		u32(f.BasePointer)
+		u32(f.BaseBlockIndex)
+	}
+	u32(uint32(len(s.Blocks)))
+	for _, block := range s.Blocks {
+		executeElse := byte(0)
+		if block.ExecuteElse {
+			executeElse = 1
+		}
+		b = append(b, block.Type, block.ReturnType, executeElse)
+		u32(uint32(block.LabelPointer))
+		u32(block.BasePointer)
+	}
+	u64s(s.Globals)
+	b = append(b, s.Memory[:]...)
+	return append(b, s.Table[:]...)
+}
+
+// Hash returns the SHA-256 hash of the state encoding
+func (s *MachineState) Hash() [32]byte {
+	return sha256.Sum256(s.Encode())
+}
+
+// stateDecoder reads an encoded MachineState, the first error sticks
+type stateDecoder struct {
+	b   []byte
+	err error
+}
+
+func (d *stateDecoder) next(n int) []byte {
+	if d.err != nil || len(d.b) < n {
+		d.err = ErrTruncatedState
+		return make([]byte, n)
+	}
+	v := d.b[:n]
+	d.b = d.b[n:]
+	return v
+}
+
+func (d *stateDecoder) u32() uint32 { return binary.LittleEndian.Uint32(d.next(4)) }
+func (d *stateDecoder) u64() uint64 { return binary.LittleEndian.Uint64(d.next(8)) }
+
+// count reads a list length, checked against the bytes left so a corrupted
+// length cannot allocate much
+func (d *stateDecoder) count(itemSize int) int {
+	n := int(d.u32())
+	if d.err == nil && n > len(d.b)/itemSize {
+		d.err = ErrTruncatedState
+		return 0
+	}
+	return n
+}
+
+func (d *stateDecoder) u64s() []uint64 {
+	values := make([]uint64, d.count(8))
+	for i := range values {
+		values[i] = d.u64()
+	}
+	return values
+}
+
+// DecodeMachineState parses a state encoded by MachineState.Encode
+func DecodeMachineState(b []byte) (*MachineState, error) {
+	d := &stateDecoder{b: b}
+	s := &MachineState{
+		Steps:      d.u64(),
+		GasUsed:    d.u64(),
+		GasLimit:   d.u64(),
+		GasRefund:  d.u64(),
+		GasStart:   d.u64(),
+		BaseSP:     d.u32(),
+		BreakDepth: int32(d.u32()),
+		Stack:      d.u64s(),
+	}
+	if n := d.count(16); n != 0 {
+		s.Frames = make([]FrameState, n)
+	}
+	for i := range s.Frames {
+		s.Frames[i] = FrameState{Function: d.u32(), IP: int32(d.u32()), BasePointer: d.u32(), BaseBlockIndex: d.u32()}
+	}
+	if n := d.count(11); n != 0 {
+		s.Blocks = make([]BlockState, n)
+	}
+	for i := range s.Blocks {
+		flags := d.next(3)
+		if flags[2] > 1 {
+			return nil, ErrInvalidState
+		}
+		s.Blocks[i] = BlockState{Type: flags[0], ReturnType: flags[1], ExecuteElse: flags[2] == 1, LabelPointer: int32(d.u32()), BasePointer: d.u32()}
+	}
+	s.Globals = d.u64s()
+	copy(s.Memory[:], d.next(32))
+	copy(s.Table[:], d.next(32))
+	if d.err != nil {
+		return nil, d.err
+	}
+	if len(d.b) != 0 {
+		return nil, ErrInvalidState
+	}
+	return s, nil
+}
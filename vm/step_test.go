@@ -0,0 +1,202 @@
+package vm
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+// stepModule stores the squares of 0 to n-1 and returns their sum
+const stepModule = `(module
+  (memory 1)
+  (global $calls (mut i32) (i32.const 0))
+  (func $square (param $x i32) (result i32)
+    (global.set $calls (i32.add (global.get $calls) (i32.const 1)))
+    (i32.mul (local.get $x) (local.get $x)))
+  (func (export "squares") (param $n i32) (result i32)
+    (local $i i32) (local $sum i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.ge_u (local.get $i) (local.get $n)))
+        (i32.store (i32.mul (local.get $i) (i32.const 4)) (call $square (local.get $i)))
+        (local.set $sum (i32.add (local.get $sum) (i32.load (i32.mul (local.get $i) (i32.const 4)))))
+        (local.set $i (i32.add (local.get $i) (i32.const 1)))
+        (br $next)))
+    (local.get $sum)))`
+
+func newStepVM(t *testing.T) *VM {
+	code, err := wat.Compile([]byte(stepModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := NewVMWithConfig(code, &SimpleGasPolicy{}, &Gas{Limit: 1 << 20}, nil, Config{Merkleized: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func TestStep(t *testing.T) {
+	full := newStepVM(t)
+	if ret, err := full.InvokeByName("squares", I32(5)); err != nil || ret[0] != I32(30) {
+		t.Fatalf("Expect 30, got %v %v", ret, err)
+	}
+	total := full.Steps()
+	if total == 0 {
+		t.Fatal("Expect steps to be counted")
+	}
+
+	// stepping to completion matches the full run
+	vm := newStepVM(t)
+	vm.BreakAt(0)
+	if _, err := vm.InvokeByName("squares", I32(5)); err != ErrStepReached {
+		t.Fatalf("Expect %v, got %v", ErrStepReached, err)
+	}
+	var hashes [][32]byte
+	for vm.Suspended() {
+		hash, err := vm.Step()
+		if err != nil {
+			t.Fatal(err)
+		}
+		hashes = append(hashes, hash)
+	}
+	if uint64(len(hashes)) != total || vm.Steps() != total || vm.GetGasUsed() != full.GetGasUsed() {
+		t.Errorf("Expect %d steps burning %d gas, got %d steps burning %d gas", total, full.GetGasUsed(), len(hashes), vm.GetGasUsed())
+	}
+	expected, _ := full.MachineState()
+	if hashes[len(hashes)-1] != expected.Hash() {
+		t.Error("Expect the last step to end in the state of the full run")
+	}
+	if _, err := vm.Step(); err != ErrNotAtStep {
+		t.Errorf("Expect %v, got %v", ErrNotAtStep, err)
+	}
+
+	// a state serialized at step n steps to the hash of step n+1 in another VM
+	n := total / 2
+	a := newStepVM(t)
+	a.BreakAt(n)
+	if _, err := a.InvokeByName("squares", I32(5)); err != ErrStepReached {
+		t.Fatalf("Expect %v, got %v", ErrStepReached, err)
+	}
+	state, err := a.MachineState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if state.Steps != n || state.Hash() != hashes[n-1] {
+		t.Errorf("Expect the state at step %d to be deterministic", n)
+	}
+	decoded, err := DecodeMachineState(state.Encode())
+	if err != nil || !reflect.DeepEqual(decoded, state) {
+		t.Fatalf("Expect the state to round trip, got %v", err)
+	}
+
+	b := newStepVM(t)
+	b.BreakAt(n)
+	b.InvokeByName("squares", I32(5))
+	b.Abort()
+	if err := b.RestoreState(decoded); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := b.Step(); err != nil || hash != hashes[n] {
+		t.Errorf("Expect step %d to hash as in the first run, got %v", n+1, err)
+	}
+	if ret, err := b.Resume(0); err != nil || ret != uint64(30) {
+		t.Errorf("Expect the restored execution to complete with 30, got %d %v", ret, err)
+	}
+
+	fresh := newStepVM(t)
+	fresh.Memory().WriteUint32(0, 1)
+	if err := fresh.RestoreState(decoded); err != ErrStateMismatch {
+		t.Errorf("Expect %v, got %v", ErrStateMismatch, err)
+	}
+	if _, err := DecodeMachineState(state.Encode()[:40]); err != ErrTruncatedState {
+		t.Errorf("Expect %v, got %v", ErrTruncatedState, err)
+	}
+	decoded.Frames[len(decoded.Frames)-1].IP = 1 << 20
+	if err := a.RestoreState(decoded); err != ErrInvalidState {
+		t.Errorf("Expect an invalid state to be rejected, got %v", err)
+	}
+}
+
+func TestRestoreInvalidState(t *testing.T) {
+	vm := newStepVM(t)
+	vm.BreakAt(20)
+	if _, err := vm.InvokeByName("squares", I32(5)); err != ErrStepReached {
+		t.Fatalf("Expect %v, got %v", ErrStepReached, err)
+	}
+	state, err := vm.MachineState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(state.Blocks) == 0 {
+		t.Fatal("Expect the state to be inside the loop")
+	}
+	vm.Abort()
+	code := vm.GetFunction(int(state.Frames[0].Function)).Code.Exprs
+	ends := instructionEnds(vm.GetFunction(int(state.Frames[0].Function)))
+	inside := 0
+	for _, ok := ends[inside]; ok; _, ok = ends[inside] {
+		inside++
+	}
+	tests := []struct {
+		name   string
+		modify func(s *MachineState)
+	}{
+		{"label pointer out of the code", func(s *MachineState) { s.Blocks[len(s.Blocks)-1].LabelPointer = 1 << 20 }},
+		{"negative label pointer", func(s *MachineState) { s.Blocks[0].LabelPointer = -1 }},
+		{"label pointer after an end", func(s *MachineState) { s.Blocks[0].LabelPointer = int32(len(code) - 1) }},
+		{"ip inside an immediate", func(s *MachineState) { s.Frames[0].IP = int32(inside) }},
+		{"locals out of the stack", func(s *MachineState) { s.Frames[0].BasePointer = uint32(len(s.Stack)) }},
+		{"unknown return type", func(s *MachineState) { s.Blocks[0].ReturnType = 0x7b }},
+		{"blocks before the first frame", func(s *MachineState) { s.Frames[0].BaseBlockIndex = 1 }},
+		{"blocks without frames", func(s *MachineState) { s.Frames = nil }},
+	}
+	for _, test := range tests {
+		invalid, _ := DecodeMachineState(state.Encode())
+		test.modify(invalid)
+		if err := vm.RestoreState(invalid); err != ErrInvalidState {
+			t.Errorf("%s: expect %v, got %v", test.name, ErrInvalidState, err)
+		}
+	}
+	if err := vm.RestoreState(state); err != nil {
+		t.Fatal(err)
+	}
+	if ret, err := vm.Resume(0); err != nil || ret != 30 {
+		t.Errorf("Expect the valid state to complete with 30, got %d %v", ret, err)
+	}
+}
+
+// TestVerifyStep follows a verifier given the state at a step and the memory
+// it commits to, which restores them in a VM of its own
+func TestVerifyStep(t *testing.T) {
+	full := newStepVM(t)
+	full.InvokeByName("squares", I32(5))
+	prover := newStepVM(t)
+	// late enough for the squares stored to change the memory
+	prover.BreakAt(full.Steps() - 10)
+	if _, err := prover.InvokeByName("squares", I32(5)); err != ErrStepReached {
+		t.Fatalf("Expect %v, got %v", ErrStepReached, err)
+	}
+	state, err := prover.MachineState()
+	if err != nil {
+		t.Fatal(err)
+	}
+	memory, _ := prover.Memory().ReadBytes(0, uint32(prover.MemSize()))
+	expected, err := prover.Step()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	verifier := newStepVM(t)
+	if err := verifier.RestoreState(state); err != ErrStateMismatch {
+		t.Errorf("Expect the initial memory not to match the committed root, got %v", err)
+	}
+	verifier.Memory().WriteBytes(0, memory)
+	if err := verifier.RestoreState(state); err != nil {
+		t.Fatal(err)
+	}
+	if hash, err := verifier.Step(); err != nil || hash != expected {
+		t.Errorf("Expect the verifier to step to the hash of the prover, got %v", err)
+	}
+}
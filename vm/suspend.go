@@ -0,0 +1,111 @@
+package vm
+
+import (
+	"math"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+)
+
+// suspension is the state of a suspended invocation. Only an invocation
+// started with no active frame is suspended, it resumes with the frames,
+// blocks and operand stack left as they were.
+type suspension struct {
+	reason  error // ErrYield, ErrOutOfGas or ErrStepReached
+	results int   // results expected from the yielding host function
+	baseSP  int
+}
+
+// checkpoint is the state before an instruction. A failed charge leaves the
+// gas untouched, so only the cost of op, once charged, is undone when a later
+// charge of the same instruction runs out of gas.
+type checkpoint struct {
+	ip      int
+	sp      int
+	steps   uint64
+	op      opcode.Opcode
+	charged bool
+}
+
+// suspend records a suspension when err allows one and returns err. A host
+// function returning ErrYield suspends after its call, running out of gas in
+// pausable mode suspends back at the checkpoint before the instruction, which
+// runs again on resume. A host function running out of gas may have had side
+// effects, it ends the invocation as in non pausable mode.
+func (vm *VM) suspend(err error, baseFrame, baseSP int, frame *Frame, cp *checkpoint) error {
+	if baseFrame != 0 {
+		// the host function running the nested invocation cannot be resumed
+		if err == ErrYield {
+			return ErrYieldNotAllowed
+		}
+		return err
+	}
+	switch {
+	case err == ErrYield:
+		vm.suspended = &suspension{reason: err, results: vm.yieldResults, baseSP: baseSP}
+	case err == ErrOutOfGas && vm.config.PausableGas && vm.hostErr == nil:
+		frame.ip, vm.sp, vm.steps = cp.ip, cp.sp, cp.steps
+		if cp.charged {
+			cost := vm.gasPolicy.GetCostForOp(cp.op)
+			vm.gas.Used -= cost
+			vm.gas.Breakdown[GasCategoryOp] -= cost
+		}
+		vm.suspended = &suspension{reason: err, baseSP: baseSP}
+	}
+	return err
+}
+
+// Suspended tells if an invocation is suspended waiting for Resume or Abort
+func (vm *VM) Suspended() bool {
+	return vm.suspended != nil
+}
+
+// Resume continues the suspended invocation with moreGas added to the gas
+// limit and returns its result like Invoke, it may suspend again. A yielding
+// host function with a result is resumed with ResumeWithResult instead.
+func (vm *VM) Resume(moreGas uint64) (uint64, error) {
+	s := vm.suspended
+	if s == nil {
+		return 0, ErrNotSuspended
+	}
+	if s.results != 0 {
+		return 0, ErrHostResultExpected
+	}
+	if moreGas > math.MaxUint64-vm.gas.Limit {
+		moreGas = math.MaxUint64 - vm.gas.Limit
+	}
+	vm.gas.Limit += moreGas
+	return vm.resume(s)
+}
+
+// ResumeWithResult continues the invocation suspended by a yielding host
+// function with result as the host function result
+func (vm *VM) ResumeWithResult(result uint64) (uint64, error) {
+	s := vm.suspended
+	if s == nil {
+		return 0, ErrNotSuspended
+	}
+	if s.results == 0 {
+		return 0, ErrUnexpectedHostResult
+	}
+	vm.push(result)
+	return vm.resume(s)
+}
+
+func (vm *VM) resume(s *suspension) (uint64, error) {
+	vm.suspended = nil
+	return vm.run(s.baseSP, 0, 0, func() (uint64, error) {
+		return vm.interpret(0, s.baseSP)
+	})
+}
+
+// Abort discards the suspended invocation
+func (vm *VM) Abort() error {
+	s := vm.suspended
+	if s == nil {
+		return ErrNotSuspended
+	}
+	vm.suspended = nil
+	vm.sp, vm.framesIndex, vm.blocksIndex, vm.breakDepth = s.baseSP, 0, 0, -1
+	vm.gas.Refund = 0
+	return nil
+}
@@ -0,0 +1,186 @@
+package vm
+
+import (
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+// suspendModule sums the values read from the host and grows its memory by a
+// page per iteration in grow
+const suspendModule = `(module
+  (import "env" "read" (func $read (result i32)))
+  (import "env" "wait" (func $wait))
+  (import "env" "nested" (func $nested))
+  (import "env" "burn" (func $burn))
+  (memory 1)
+  (func (export "sum") (param $n i32) (result i32)
+    (local $sum i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $n)))
+        (local.set $sum (i32.add (local.get $sum) (call $read)))
+        (call $wait)
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br $next)))
+    (local.get $sum))
+  (func (export "grow") (param $n i32) (result i32)
+    (block $done
+      (loop $next
+        (br_if $done (i32.eqz (local.get $n)))
+        (drop (memory.grow (i32.const 1)))
+        (local.set $n (i32.sub (local.get $n) (i32.const 1)))
+        (br $next)))
+    (memory.size))
+  (func (export "nested") (call $nested))
+  (func (export "burn") (call $burn)))`
+
+func newSuspendVM(t *testing.T, config Config, gas *Gas) *VM {
+	code, err := wat.Compile([]byte(suspendModule))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := hostResolver{
+		"read": func(vm *VM, args ...uint64) (uint64, error) { return 0, ErrYield },
+		"wait": func(vm *VM, args ...uint64) (uint64, error) { return 0, ErrYield },
+		"nested": func(vm *VM, args ...uint64) (uint64, error) {
+			fidx, _ := vm.GetFunctionIndex("sum")
+			return vm.Invoke(fidx, 1)
+		},
+		"burn": func(vm *VM, args ...uint64) (uint64, error) { return 0, vm.BurnGas(1 << 40) },
+	}
+	vm, err := NewVMWithConfig(code, &SimpleGasPolicy{}, gas, resolver, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return vm
+}
+
+func TestYield(t *testing.T) {
+	vm := newSuspendVM(t, Config{}, &Gas{Limit: 1 << 20})
+	fidx, _ := vm.GetFunctionIndex("sum")
+	if _, err := vm.Resume(0); err != ErrNotSuspended {
+		t.Errorf("Expect %v, got %v", ErrNotSuspended, err)
+	}
+	ret, err := vm.Invoke(fidx, 3)
+	for value := uint64(1); err == ErrYield; value++ {
+		if !vm.Suspended() {
+			t.Fatal("Expect the invocation to be suspended")
+		}
+		if _, err := vm.Invoke(fidx, 1); err != ErrSuspended {
+			t.Errorf("Expect %v, got %v", ErrSuspended, err)
+		}
+		if _, err := vm.Resume(0); err != ErrHostResultExpected {
+			t.Errorf("Expect %v, got %v", ErrHostResultExpected, err)
+		}
+		// read then wait
+		if ret, err = vm.ResumeWithResult(value * 10); err != ErrYield {
+			t.Fatalf("Expect wait to yield, got %v", err)
+		}
+		if _, err := vm.ResumeWithResult(0); err != ErrUnexpectedHostResult {
+			t.Errorf("Expect %v, got %v", ErrUnexpectedHostResult, err)
+		}
+		ret, err = vm.Resume(0)
+	}
+	if err != nil || ret != 60 || vm.Suspended() {
+		t.Fatalf("Expect 60, got %d %v", ret, err)
+	}
+
+	if _, err := vm.Invoke(fidx, 2); err != ErrYield {
+		t.Fatalf("Expect %v, got %v", ErrYield, err)
+	}
+	if err := vm.Abort(); err != nil || vm.Suspended() || vm.sp != 0 || vm.framesIndex != 0 || vm.blocksIndex != 0 {
+		t.Errorf("Expect abort to unwind, got %v", err)
+	}
+	if _, err := vm.Invoke(fidx, 0); err != nil {
+		t.Errorf("Expect a new invocation after abort, got %v", err)
+	}
+
+	nested, _ := vm.GetFunctionIndex("nested")
+	if _, err := vm.Invoke(nested); err != ErrYieldNotAllowed || vm.Suspended() {
+		t.Errorf("Expect %v, got %v", ErrYieldNotAllowed, err)
+	}
+}
+
+func TestPausableGas(t *testing.T) {
+	vm := newSuspendVM(t, Config{}, &Gas{Limit: 1 << 20})
+	fidx, _ := vm.GetFunctionIndex("grow")
+	expected, err := vm.Invoke(fidx, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	used := vm.GetGasUsed()
+
+	gas := &Gas{Limit: 1 << 20}
+	vm = newSuspendVM(t, Config{}, gas)
+	gas.Limit = gas.Used + 100
+	if _, err := vm.Invoke(fidx, 5); err != ErrOutOfGas || vm.Suspended() {
+		t.Errorf("Expect out of gas to fail without pausable gas, got %v", err)
+	}
+
+	gas = &Gas{Limit: 1 << 20}
+	vm = newSuspendVM(t, Config{PausableGas: true}, gas)
+	gas.Limit = gas.Used + 100
+	ret, err := vm.Invoke(fidx, 5)
+	suspensions := 0
+	for ; err == ErrOutOfGas && vm.Suspended(); suspensions++ {
+		if pages := vm.Memory().Pages(); pages != 1+suspensions {
+			t.Fatalf("Expect the memory grown only once gas is paid, got %d pages", pages)
+		}
+		ret, err = vm.Resume(1100)
+	}
+	if err != nil || ret != expected || suspensions != 5 {
+		t.Errorf("Expect %d after 5 suspensions, got %d %v after %d", expected, ret, err, suspensions)
+	}
+	if gas.Used != used {
+		t.Errorf("Expect suspensions to cost no gas, used %d instead of %d", gas.Used, used)
+	}
+
+	burn, _ := vm.GetFunctionIndex("burn")
+	if _, err := vm.Invoke(burn); err != ErrOutOfGas || vm.Suspended() {
+		t.Errorf("Expect a host function out of gas to end the invocation, got %v", err)
+	}
+}
+
+type externResolver map[string]*VM
+
+func (r externResolver) GetFunction(module, name string) HostFunction {
+	return nil
+}
+
+func (r externResolver) GetExtern(module, name string) Extern {
+	extern, _ := r[module].GetExport(name)
+	return extern
+}
+
+func TestPausableGasExternCall(t *testing.T) {
+	code, err := wat.Compile([]byte(`(module
+  (global (export "n") (mut i32) (i32.const 0))
+  (func (export "bump") (global.set 0 (i32.add (global.get 0) (i32.const 1))) (loop $spin (br $spin))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	callee, err := NewVMWithConfig(code, &SimpleGasPolicy{}, &Gas{Limit: 1000}, &TestResolver{}, Config{PausableGas: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err = wat.Compile([]byte(`(module
+  (import "callee" "bump" (func $bump))
+  (func (export "run") (call $bump)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	caller, err := NewVMWithConfig(code, &SimpleGasPolicy{}, &Gas{Limit: 1000}, externResolver{"callee": callee}, Config{PausableGas: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := caller.InvokeByName("run"); err != ErrOutOfGas || caller.Suspended() || callee.Suspended() {
+		t.Errorf("Expect the callee out of gas to end the invocation, got %v", err)
+	}
+	if _, err := caller.Resume(1000); err != ErrNotSuspended {
+		t.Errorf("Expect %v, got %v", ErrNotSuspended, err)
+	}
+	if n, _ := callee.GetGlobal("n"); n != I32(1) {
+		t.Errorf("Expect the callee to have run once, got %v", n)
+	}
+}
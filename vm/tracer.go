@@ -0,0 +1,102 @@
+package vm
+
+import (
+	"encoding/json"
+	"io"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+)
+
+// Tracer receives execution events from the interpreter. Callbacks run
+// synchronously and must not modify the VM state they are given.
+type Tracer interface {
+	// OnInstruction is called before an executed instruction is charged,
+	// ip is its offset in the frame code and stack is the operand stack with top last
+	OnInstruction(vm *VM, frame *Frame, ip int, op opcode.Opcode, stack []uint64)
+	// OnCall is called before a function or host import is entered
+	OnCall(vm *VM, fidx int, args []uint64)
+	// OnReturn is called after a function or host import returned its results
+	OnReturn(vm *VM, fidx int, results []uint64)
+	// OnMemoryAccess is called after a load or store address passed bounds check
+	OnMemoryAccess(vm *VM, address int, size int, write bool)
+	// OnTrap is called when an invocation fails
+	OnTrap(vm *VM, err error)
+}
+
+// SetTracer installs t to receive execution events, nil disables tracing
+func (vm *VM) SetTracer(t Tracer) {
+	vm.tracer = t
+}
+
+// JSONTracer writes one JSON object per event, suitable for diffing traces between nodes
+type JSONTracer struct {
+	enc        *json.Encoder
+	stackDepth int
+	err        error
+}
+
+// TraceEvent is the JSON line written by JSONTracer
+type TraceEvent struct {
+	Event   string   `json:"event"`
+	Func    int      `json:"func"`
+	IP      int      `json:"ip,omitempty"`
+	Op      *byte    `json:"op,omitempty"`
+	Stack   []uint64 `json:"stack,omitempty"`
+	Values  []uint64 `json:"values,omitempty"`
+	Address int      `json:"address,omitempty"`
+	Size    int      `json:"size,omitempty"`
+	Write   bool     `json:"write,omitempty"`
+	Gas     uint64   `json:"gas"`
+	Error   string   `json:"error,omitempty"`
+}
+
+// NewJSONTracer creates a JSONTracer writing to w which records at most
+// stackDepth operand stack values from the top for each instruction
+func NewJSONTracer(w io.Writer, stackDepth int) *JSONTracer {
+	return &JSONTracer{enc: json.NewEncoder(w), stackDepth: stackDepth}
+}
+
+// Err returns the first write error, events are dropped after it
+func (t *JSONTracer) Err() error {
+	return t.err
+}
+
+func (t *JSONTracer) write(vm *VM, event *TraceEvent) {
+	if t.err != nil {
+		return
+	}
+	event.Gas = vm.GetGasUsed()
+	if vm.framesIndex > 0 && event.Event != "call" && event.Event != "return" {
+		event.Func = vm.currentFrame().fidx
+	}
+	t.err = t.enc.Encode(event)
+}
+
+// OnInstruction writes an instruction event
+func (t *JSONTracer) OnInstruction(vm *VM, frame *Frame, ip int, op opcode.Opcode, stack []uint64) {
+	if len(stack) > t.stackDepth {
+		stack = stack[len(stack)-t.stackDepth:]
+	}
+	b := byte(op)
+	t.write(vm, &TraceEvent{Event: "instruction", IP: ip, Op: &b, Stack: stack})
+}
+
+// OnCall writes a call event
+func (t *JSONTracer) OnCall(vm *VM, fidx int, args []uint64) {
+	t.write(vm, &TraceEvent{Event: "call", Func: fidx, Values: args})
+}
+
+// OnReturn writes a return event
+func (t *JSONTracer) OnReturn(vm *VM, fidx int, results []uint64) {
+	t.write(vm, &TraceEvent{Event: "return", Func: fidx, Values: results})
+}
+
+// OnMemoryAccess writes a memory event
+func (t *JSONTracer) OnMemoryAccess(vm *VM, address int, size int, write bool) {
+	t.write(vm, &TraceEvent{Event: "memory", Address: address, Size: size, Write: write})
+}
+
+// OnTrap writes a trap event
+func (t *JSONTracer) OnTrap(vm *VM, err error) {
+	t.write(vm, &TraceEvent{Event: "trap", Error: err.Error()})
+}
@@ -0,0 +1,151 @@
+package vm
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+type recordingTracer struct {
+	events []string
+}
+
+func (t *recordingTracer) OnInstruction(vm *VM, frame *Frame, ip int, op opcode.Opcode, stack []uint64) {
+	t.events = append(t.events, fmt.Sprintf("ins %d:%d 0x%x %v", frame.FunctionIndex(), ip, byte(op), stack))
+}
+
+func (t *recordingTracer) OnCall(vm *VM, fidx int, args []uint64) {
+	t.events = append(t.events, fmt.Sprintf("call %d %v", fidx, args))
+}
+
+func (t *recordingTracer) OnReturn(vm *VM, fidx int, results []uint64) {
+	t.events = append(t.events, fmt.Sprintf("return %d %v", fidx, results))
+}
+
+func (t *recordingTracer) OnMemoryAccess(vm *VM, address int, size int, write bool) {
+	t.events = append(t.events, fmt.Sprintf("mem %d %d %t", address, size, write))
+}
+
+func (t *recordingTracer) OnTrap(vm *VM, err error) {
+	t.events = append(t.events, fmt.Sprintf("trap %s", err))
+}
+
+func tracedModule() []byte {
+	imports := []testImport{{
+		module:  "env",
+		name:    "add",
+		params:  []wasm.ValueType{wasm.ValueTypeI32, wasm.ValueTypeI32},
+		results: []wasm.ValueType{wasm.ValueTypeI32},
+	}}
+	funcs := []testFunc{
+		{
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			body: []byte{
+				byte(opcode.GetLocal), 0x00,
+				byte(opcode.Call), 0x02,
+				byte(opcode.I32Load), 0x02, 0x00,
+			},
+			export: "calc",
+		},
+		{
+			params:  []wasm.ValueType{wasm.ValueTypeI32},
+			results: []wasm.ValueType{wasm.ValueTypeI32},
+			body: []byte{
+				byte(opcode.GetLocal), 0x00,
+				byte(opcode.GetLocal), 0x00,
+				byte(opcode.I32Const), 0x01,
+				byte(opcode.Call), 0x00,
+				byte(opcode.I32Store), 0x02, 0x00,
+				byte(opcode.GetLocal), 0x00,
+			},
+		},
+	}
+	return buildTestModule(imports, funcs)
+}
+
+func TestTracer(t *testing.T) {
+	vm, err := NewVM(tracedModule(), &FreeGasPolicy{}, &Gas{}, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tracer := &recordingTracer{}
+	vm.SetTracer(tracer)
+	ret, err := invokeExport(t, vm, "calc", 8)
+	if err != nil || ret != 9 {
+		t.Fatalf("Expect traced call to return 9, got %d %v", ret, err)
+	}
+	expected := []string{
+		"call 1 [8]",
+		"ins 1:0 0x20 [8]",
+		"ins 1:2 0x10 [8 8]",
+		"call 2 [8]",
+		"ins 2:0 0x20 [8 8]",
+		"ins 2:2 0x20 [8 8 8]",
+		"ins 2:4 0x41 [8 8 8 8]",
+		"ins 2:6 0x10 [8 8 8 8 1]",
+		"call 0 [8 1]",
+		"return 0 [9]",
+		"ins 2:8 0x36 [8 8 8 9]",
+		"mem 8 4 true",
+		"ins 2:11 0x20 [8 8]",
+		"return 2 [8]",
+		"ins 1:4 0x28 [8 8]",
+		"mem 8 4 false",
+		"return 1 [9]",
+	}
+	if !reflect.DeepEqual(tracer.events, expected) {
+		t.Errorf("Expect trace\n%v\ngot\n%v", expected, tracer.events)
+	}
+
+	tracer.events = nil
+	if _, err := invokeExport(t, vm, "calc", 65535); err != ErrOutOfBoundMemoryAccess {
+		t.Fatalf("Expect out of bound access, got %v", err)
+	}
+	if last := tracer.events[len(tracer.events)-1]; last != "trap out of bounds memory access" {
+		t.Errorf("Expect trap event last, got %s", last)
+	}
+}
+
+func TestJSONTracer(t *testing.T) {
+	gas := &Gas{Limit: 2000}
+	vm, err := NewVM(tracedModule(), &SimpleGasPolicy{}, gas, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	tracer := NewJSONTracer(&out, 2)
+	vm.SetTracer(tracer)
+	if _, err := invokeExport(t, vm, "calc", 8); err != nil {
+		t.Fatal(err)
+	}
+	if tracer.Err() != nil {
+		t.Fatal(tracer.Err())
+	}
+	var events []TraceEvent
+	scanner := bufio.NewScanner(&out)
+	for scanner.Scan() {
+		var event TraceEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Expect JSON line, got %s: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	if len(events) != 17 {
+		t.Fatalf("Expect 17 trace lines, got %d", len(events))
+	}
+	ins := events[6]
+	if ins.Event != "instruction" || ins.Func != 2 || ins.IP != 4 || *ins.Op != byte(opcode.I32Const) ||
+		!reflect.DeepEqual(ins.Stack, []uint64{8, 8}) || ins.Gas != 1024+4 {
+		t.Errorf("Unexpected instruction event %+v", ins)
+	}
+	if ret := events[16]; ret.Event != "return" || ret.Func != 1 || !reflect.DeepEqual(ret.Values, []uint64{9}) || ret.Gas != 1024+9 {
+		t.Errorf("Unexpected return event %+v", ret)
+	}
+}
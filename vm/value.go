@@ -0,0 +1,211 @@
+package vm
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// Value is a wasm value tagged with its type
+type Value struct {
+	Type wasm.ValueType
+	bits uint64
+}
+
+// I32 returns an i32 value
+func I32(v int32) Value {
+	return Value{wasm.ValueTypeI32, uint64(uint32(v))}
+}
+
+// I64 returns an i64 value
+func I64(v int64) Value {
+	return Value{wasm.ValueTypeI64, uint64(v)}
+}
+
+// F32 returns an f32 value
+func F32(v float32) Value {
+	return Value{wasm.ValueTypeF32, uint64(math.Float32bits(v))}
+}
+
+// F64 returns an f64 value
+func F64(v float64) Value {
+	return Value{wasm.ValueTypeF64, math.Float64bits(v)}
+}
+
+// ValueOf returns the value of type t held in raw bits as found on the VM stack,
+// the upper bits of 32-bit values are ignored
+func ValueOf(t wasm.ValueType, bits uint64) Value {
+	if t == wasm.ValueTypeI32 || t == wasm.ValueTypeF32 {
+		bits = uint64(uint32(bits))
+	}
+	return Value{t, bits}
+}
+
+// I32 returns the value as an i32, the bits of other types are reinterpreted
+func (v Value) I32() int32 {
+	return int32(v.bits)
+}
+
+// I64 returns the value as an i64, the bits of other types are reinterpreted
+func (v Value) I64() int64 {
+	return int64(v.bits)
+}
+
+// F32 returns the value as an f32, the bits of other types are reinterpreted
+func (v Value) F32() float32 {
+	return math.Float32frombits(uint32(v.bits))
+}
+
+// F64 returns the value as an f64, the bits of other types are reinterpreted
+func (v Value) F64() float64 {
+	return math.Float64frombits(v.bits)
+}
+
+// Bits returns the raw bits of the value as passed to Invoke and host functions
+func (v Value) Bits() uint64 {
+	return v.bits
+}
+
+// String formats the value as type:value, NaNs are printed with their payload
+func (v Value) String() string {
+	var s string
+	switch v.Type {
+	case wasm.ValueTypeI32:
+		s = strconv.FormatInt(int64(v.I32()), 10)
+	case wasm.ValueTypeI64:
+		s = strconv.FormatInt(v.I64(), 10)
+	case wasm.ValueTypeF32:
+		s = formatFloat(float64(v.F32()), v.bits, 32, 23)
+	case wasm.ValueTypeF64:
+		s = formatFloat(v.F64(), v.bits, 64, 52)
+	default:
+		s = "0x" + strconv.FormatUint(v.bits, 16)
+	}
+	return fmt.Sprintf("%s:%s", v.Type, s)
+}
+
+// formatFloat prints NaNs as the text format does, with the payload unless canonical
+func formatFloat(f float64, bits uint64, bitSize, mantissaBits uint) string {
+	if !math.IsNaN(f) {
+		return strconv.FormatFloat(f, 'g', -1, int(bitSize))
+	}
+	s := "nan"
+	if bits&(1<<(bitSize-1)) != 0 {
+		s = "-nan"
+	}
+	if payload := bits & (1<<mantissaBits - 1); payload != 1<<(mantissaBits-1) {
+		s += ":0x" + strconv.FormatUint(payload, 16)
+	}
+	return s
+}
+
+// Values returns the values of types held in raw bits, such as the arguments of a host function
+func Values(types []wasm.ValueType, bits []uint64) []Value {
+	values := make([]Value, len(types))
+	for i, t := range types {
+		values[i] = ValueOf(t, bits[i])
+	}
+	return values
+}
+
+// ArgumentError reports an argument of InvokeByName whose type does not match the function signature
+type ArgumentError struct {
+	Index    int
+	Expected wasm.ValueType
+	Got      wasm.ValueType
+}
+
+func (e *ArgumentError) Error() string {
+	return fmt.Sprintf("argument %d: expect %s, got %s", e.Index, e.Expected, e.Got)
+}
+
+// InvokeByName calls the exported function name with typed arguments checked
+// against its signature and returns its typed results
+func (vm *VM) InvokeByName(name string, args ...Value) ([]Value, error) {
+	extern, ok := vm.GetExport(name)
+	if !ok {
+		return nil, ErrExportNotFound
+	}
+	fn, ok := extern.(*FuncRef)
+	if !ok {
+		return nil, ErrExportNotFunction
+	}
+	sig := fn.Type()
+	if len(args) != len(sig.ParamTypes) {
+		return nil, ErrWrongNumberOfArgs
+	}
+	params := make([]uint64, len(args))
+	for i, arg := range args {
+		if arg.Type != sig.ParamTypes[i] {
+			return nil, &ArgumentError{Index: i, Expected: sig.ParamTypes[i], Got: arg.Type}
+		}
+		params[i] = arg.bits
+	}
+	ret, err := fn.Call(params...)
+	if err != nil {
+		return nil, err
+	}
+	return Values(sig.ReturnTypes, []uint64{ret}), nil
+}
+
+// GetGlobal returns the value of the exported global name
+func (vm *VM) GetGlobal(name string) (Value, error) {
+	global, err := vm.exportedGlobal(name)
+	if err != nil {
+		return Value{}, err
+	}
+	return ValueOf(global.typ.ValueType, global.value), nil
+}
+
+// SetGlobal sets the exported mutable global name to a value of its type
+func (vm *VM) SetGlobal(name string, value Value) error {
+	global, err := vm.exportedGlobal(name)
+	if err != nil {
+		return err
+	}
+	if global.typ.Mutability != wasm.Mutable {
+		return ErrImmutableGlobal
+	}
+	if value.Type != global.typ.ValueType {
+		return ErrMismatchedGlobalType
+	}
+	global.value = value.bits
+	return nil
+}
+
+func (vm *VM) exportedGlobal(name string) (*Global, error) {
+	extern, ok := vm.GetExport(name)
+	if !ok {
+		return nil, ErrExportNotFound
+	}
+	global, ok := extern.(*Global)
+	if !ok {
+		return nil, ErrExportNotGlobal
+	}
+	return global, nil
+}
+
+// NewHostFunction adapts fn taking and returning typed values to a HostFunction
+// imported with signature sig, results of the wrong type fail the call
+func NewHostFunction(sig wasm.FuncType, fn func(vm *VM, args []Value) ([]Value, error)) HostFunction {
+	return func(vm *VM, args ...uint64) (uint64, error) {
+		results, err := fn(vm, Values(sig.ParamTypes, args))
+		if err != nil {
+			return 0, err
+		}
+		if len(results) != len(sig.ReturnTypes) {
+			return 0, ErrMismatchedResultType
+		}
+		for i, result := range results {
+			if result.Type != sig.ReturnTypes[i] {
+				return 0, ErrMismatchedResultType
+			}
+		}
+		if len(results) == 0 {
+			return 0, nil
+		}
+		return results[0].bits, nil
+	}
+}
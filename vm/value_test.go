@@ -0,0 +1,135 @@
+package vm
+
+import (
+	"math"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/wasm"
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+type hostResolver map[string]HostFunction
+
+func (r hostResolver) GetFunction(module, name string) HostFunction {
+	return r[name]
+}
+
+func TestValue(t *testing.T) {
+	tests := []struct {
+		v    Value
+		bits uint64
+		s    string
+	}{
+		{I32(-1), 0xffffffff, "i32:-1"},
+		{I64(-1), 0xffffffffffffffff, "i64:-1"},
+		{F32(1.5), 0x3fc00000, "f32:1.5"},
+		{F64(-0.25), 0xbfd0000000000000, "f64:-0.25"},
+		{ValueOf(wasm.ValueTypeI32, 0xffffffff00000007), 7, "i32:7"},
+		{ValueOf(wasm.ValueTypeF32, 0x7fc00000), 0x7fc00000, "f32:nan"},
+		{ValueOf(wasm.ValueTypeF32, 0xff800001), 0xff800001, "f32:-nan:0x1"},
+		{ValueOf(wasm.ValueTypeF64, 0x7ff4000000000000), 0x7ff4000000000000, "f64:nan:0x4000000000000"},
+	}
+	for _, test := range tests {
+		if test.v.Bits() != test.bits || test.v.String() != test.s {
+			t.Errorf("Expect %s 0x%x, got %s 0x%x", test.s, test.bits, test.v, test.v.Bits())
+		}
+	}
+	if v := F64(2.5); v.F64() != 2.5 || v.I64() != int64(math.Float64bits(2.5)) {
+		t.Errorf("Unexpected accessors of %v", v)
+	}
+}
+
+func TestInvokeByName(t *testing.T) {
+	code, err := wat.Compile([]byte(`(module
+  (import "env" "scale" (func $scale (param f64 i32) (result f64)))
+  (global (export "g") i32 (i32.const 0))
+  (func (export "scale") (param f64 i32) (result f64) (call $scale (local.get 0) (local.get 1)))
+  (func (export "noop")))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := wasm.FuncType{ParamTypes: []wasm.ValueType{wasm.ValueTypeF64, wasm.ValueTypeI32}, ReturnTypes: []wasm.ValueType{wasm.ValueTypeF64}}
+	resolver := hostResolver{"scale": NewHostFunction(sig, func(vm *VM, args []Value) ([]Value, error) {
+		if args[1].I32() < 0 {
+			return []Value{I32(0)}, nil
+		}
+		return []Value{F64(args[0].F64() * float64(args[1].I32()))}, nil
+	})}
+	vm, err := NewVM(code, &FreeGasPolicy{}, &Gas{}, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results, err := vm.InvokeByName("scale", F64(1.5), I32(3))
+	if err != nil || len(results) != 1 || results[0] != F64(4.5) {
+		t.Errorf("Expect [f64:4.5], got %v %v", results, err)
+	}
+	results, err = vm.InvokeByName("noop")
+	if err != nil || len(results) != 0 {
+		t.Errorf("Expect no result, got %v %v", results, err)
+	}
+
+	errTests := []struct {
+		name string
+		args []Value
+		err  string
+	}{
+		{"scale", []Value{I32(1), I32(3)}, "argument 0: expect f64, got i32"},
+		{"scale", []Value{F64(1)}, ErrWrongNumberOfArgs.Error()},
+		{"scale", []Value{F64(1), I32(-1)}, ErrMismatchedResultType.Error()},
+		{"g", nil, ErrExportNotFunction.Error()},
+		{"missing", nil, ErrExportNotFound.Error()},
+	}
+	for _, test := range errTests {
+		if _, err := vm.InvokeByName(test.name, test.args...); err == nil || err.Error() != test.err {
+			t.Errorf("%s%v: expect %q, got %v", test.name, test.args, test.err, err)
+		}
+	}
+}
+
+func TestGlobals(t *testing.T) {
+	code, err := wat.Compile([]byte(`(module
+  (global (export "__heap_base") i32 (i32.const 66560))
+  (global $counter (export "counter") (mut i64) (i64.const 1))
+  (func (export "next") (result i64)
+    (global.set $counter (i64.add (global.get $counter) (i64.const 1)))
+    (global.get $counter)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := NewVM(code, &FreeGasPolicy{}, &Gas{}, hostResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := vm.GetGlobal("__heap_base"); err != nil || v != I32(66560) {
+		t.Errorf("Expect i32:66560, got %v %v", v, err)
+	}
+	if err := vm.SetGlobal("counter", I64(41)); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := vm.InvokeByName("next"); err != nil || results[0] != I64(42) {
+		t.Errorf("Expect the VM to see the global set by the host, got %v %v", results, err)
+	}
+	if v, err := vm.GetGlobal("counter"); err != nil || v != I64(42) {
+		t.Errorf("Expect i64:42, got %v %v", v, err)
+	}
+
+	errTests := []struct {
+		name  string
+		value Value
+		err   error
+	}{
+		{"__heap_base", I32(0), ErrImmutableGlobal},
+		{"counter", I32(0), ErrMismatchedGlobalType},
+		{"next", I64(0), ErrExportNotGlobal},
+		{"missing", I64(0), ErrExportNotFound},
+	}
+	for _, test := range errTests {
+		if err := vm.SetGlobal(test.name, test.value); err != test.err {
+			t.Errorf("%s: expect %v, got %v", test.name, test.err, err)
+		}
+	}
+	if _, err := vm.GetGlobal("next"); err != ErrExportNotGlobal {
+		t.Errorf("Expect %v, got %v", ErrExportNotGlobal, err)
+	}
+}
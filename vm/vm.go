@@ -21,20 +21,16 @@ const MaxFrames = 1024
 // MaxBlocks is the maximum of nested blocks supported
 const MaxBlocks = 1024
 
+// DefaultMaxReentrancy is the default number of nested invocations allowed
+const DefaultMaxReentrancy = 16
+
 // MaxBrTableSize is the maximum number of br_table targets
 const MaxBrTableSize = 64 * 1024
 
-const f32SignMask = 1 << 31
-
-const f64SignMask = 1 << 63
-
 const wasmPageSize = 64 * 1024
 
 const maxSize = math.MaxUint32
 
-const f32CanonicalNaNBits = uint64(0x7fc00000)
-const f64CanonicalNaNBits = uint64(0x7ff8000000000000)
-
 // HostFunction defines imported functions defined in host
 type HostFunction func(vm *VM, args ...uint64) (uint64, error)
 
@@ -48,7 +44,8 @@ type FunctionImport struct {
 	module    string
 	name      string
 	signature *wasm.FuncType
-	function  *HostFunction //nolint:structcheck,unused
+	function  *HostFunction // resolved when linking, otherwise looked up on each call
+	ref       *FuncRef      // function imported from another VM
 }
 
 // VM virtual machine
@@ -58,23 +55,51 @@ type VM struct {
 	sp              int //point to the next available slot
 	frames          []*Frame
 	framesIndex     int
-	globals         []uint64
+	globals         []*Global
 	blocks          []*Block
 	blocksIndex     int
 	breakDepth      int
-	memory          []byte
+	memory          *Memory
+	table           *Table
 	functionImports []FunctionImport
 	importResolver  ImportResolver
-	gasPolicy       GasPolicy
+	gasPolicy       ExtendedGasPolicy
 	gas             *Gas
+	tracer          Tracer
+	config          Config
+	suspended       *suspension
+	invocations     int    // active invocations, more than one when re-entered
+	gasStart        uint64 // gas used when the outermost invocation started
+	hostErr         error  // error of the last host function call
+	yieldResults    int    // results of the host function returning ErrYield
+	steps           uint64
+	breakStep       uint64 // step to stop at when breaking
+	breaking        bool
+	float           number.Float
+}
+
+// Config holds the optional VM behaviours
+type Config struct {
+	PausableGas   bool // suspend instead of failing when gas runs out, see Resume
+	MaxReentrancy int  // nested invocations allowed, DefaultMaxReentrancy when 0 and none when negative
+	Merkleized    bool // maintain a Merkle tree of the memory, see StateRoot
+	SoftFloat     bool // compute floats with number.SoftFloat, identical on every architecture
 }
 
 // NewVM initializes a new VM
 func NewVM(code []byte, gasPolicy GasPolicy, gas *Gas, importResolver ImportResolver) (*VM, error) {
+	return NewVMWithConfig(code, gasPolicy, gas, importResolver, Config{})
+}
+
+// NewVMWithConfig initializes a new VM with config
+func NewVMWithConfig(code []byte, gasPolicy GasPolicy, gas *Gas, importResolver ImportResolver, config Config) (*VM, error) {
 	m, err := wasm.ReadModule(code)
 	if err != nil {
 		return nil, err
 	}
+	if err := m.Validate(); err != nil {
+		return nil, err
+	}
 
 	if gas.Used > gas.Limit {
 		return nil, ErrOutOfGas
@@ -84,46 +109,32 @@ func NewVM(code []byte, gasPolicy GasPolicy, gas *Gas, importResolver ImportReso
 		Module:         m,
 		stack:          make([]uint64, StackSize),
 		frames:         make([]*Frame, MaxFrames),
-		globals:        make([]uint64, len(m.GlobalIndexSpace)),
 		framesIndex:    0,
 		sp:             0,
 		blocks:         make([]*Block, MaxBlocks),
 		blocksIndex:    0,
 		breakDepth:     -1,
-		memory:         make([]byte, wasmPageSize),
 		importResolver: importResolver,
-		gasPolicy:      gasPolicy,
+		gasPolicy:      ExtendGasPolicy(gasPolicy),
 		gas:            gas,
+		config:         config,
+		float:          number.HardFloat,
 	}
-	if m.MemSec != nil && len(m.MemSec.Mems) != 0 {
-		n := int(m.MemSec.Mems[0].Limits.Min)
-		vm.memory = make([]byte, n*wasmPageSize)
-		copy(vm.memory, m.LinearMemoryIndexSpace[0])
-		if err := vm.BurnGas(vm.gasPolicy.GetCostForMalloc(n)); err != nil {
-			return nil, err
-		}
+	if config.SoftFloat {
+		vm.float = number.SoftFloat
 	}
-
-	functionImports := make([]FunctionImport, 0)
-	if m.ImportSec != nil {
-		for _, entry := range m.ImportSec.Imports {
-			switch entry.ImportDesc.Kind {
-			case wasm.ExternalFunction:
-				typeIndex := entry.ImportDesc.TypeIdx
-				functionImports = append(functionImports, FunctionImport{
-					module:    entry.ModuleName,
-					name:      entry.FieldName,
-					signature: &m.TypeSec.FuncTypes[typeIndex],
-				})
-			default:
-				log.Printf("Import type %v not supported\n", entry.ImportDesc.Kind)
-			}
-		}
+	if err := vm.resolveImports(); err != nil {
+		return nil, err
 	}
-	vm.functionImports = functionImports
 	if err := vm.initGlobals(); err != nil {
 		return nil, err
 	}
+	if err := vm.initSegments(); err != nil {
+		return nil, err
+	}
+	if config.Merkleized {
+		vm.memory.merkleize()
+	}
 	if m.StartSec != nil { // called after module loading
 		_, err := vm.Invoke(uint64(m.StartSec.FuncIdx)) // start does not take args or return
 		if err != nil {
@@ -133,9 +144,46 @@ func NewVM(code []byte, gasPolicy GasPolicy, gas *Gas, importResolver ImportReso
 	return vm, nil
 }
 
-// Invoke triggers a WASM function
+// Invoke triggers a WASM function. It may be called by a host function or a
+// function of another VM while vm is running, the invocation then runs on top
+// of the active frames, shares the gas and returns when its own frame does.
 func (vm *VM) Invoke(fidx uint64, args ...uint64) (ret uint64, err error) {
+	if vm.suspended != nil {
+		return 0, ErrSuspended
+	}
+	if vm.invocations > vm.maxReentrancy() {
+		return 0, ErrReentrancyDepth
+	}
+	if vm.invocations == 0 {
+		vm.gasStart = vm.gas.Used
+	}
+	sp, framesIndex, blocksIndex := vm.sp, vm.framesIndex, vm.blocksIndex
+	return vm.run(sp, framesIndex, blocksIndex, func() (uint64, error) {
+		if err := vm.validateFuncArgs(int(fidx), args); err != nil {
+			return 0, err
+		}
+		for _, arg := range args {
+			vm.push(arg)
+		}
+		if err := vm.CallFunction(int(fidx)); err != nil {
+			if err == ErrYield {
+				err = ErrYieldNotAllowed
+			}
+			return 0, err
+		}
+		return vm.interpret(framesIndex, sp)
+	})
+}
+
+// run executes an invocation started from the given state. A failed
+// invocation is unwound to that state unless it got suspended, the outermost
+// one is credited the gas refund once completed, capped on the gas it used.
+func (vm *VM) run(sp, framesIndex, blocksIndex int, exec func() (uint64, error)) (ret uint64, err error) {
+	refund := vm.gas.Refund
+	vm.hostErr = nil
+	vm.invocations++
 	defer func() {
+		vm.invocations--
 		if r := recover(); r != nil {
 			switch r.(type) {
 			case *ExecError:
@@ -144,18 +192,37 @@ func (vm *VM) Invoke(fidx uint64, args ...uint64) (ret uint64, err error) {
 				panic(r)
 			}
 		}
+		if err != nil && vm.suspended == nil {
+			// unwind the failed invocation
+			vm.sp, vm.framesIndex, vm.blocksIndex, vm.breakDepth = sp, framesIndex, blocksIndex, -1
+			if vm.invocations == 0 {
+				vm.gas.Refund = 0
+			} else {
+				vm.gas.Refund = refund
+			}
+			if vm.tracer != nil {
+				vm.tracer.OnTrap(vm, err)
+			}
+		}
 	}()
-	if err := vm.validateFuncArgs(int(fidx), args); err != nil {
+	ret, err = exec()
+	if err != nil {
 		return 0, err
 	}
-
-	for _, arg := range args {
-		vm.push(arg)
+	if vm.invocations == 1 {
+		vm.gas.ApplyRefund(vm.gasPolicy.GetMaxRefund(vm.gas.Used - vm.gasStart))
 	}
-	if err := vm.CallFunction(int(fidx)); err != nil {
-		return 0, err
+	return ret, nil
+}
+
+func (vm *VM) maxReentrancy() int {
+	switch {
+	case vm.config.MaxReentrancy == 0:
+		return DefaultMaxReentrancy
+	case vm.config.MaxReentrancy < 0:
+		return 0
 	}
-	return vm.interpret()
+	return vm.config.MaxReentrancy
 }
 
 // GetFunctionIndex look up a function export index by its name
@@ -168,28 +235,42 @@ func (vm *VM) GetFunctionIndex(name string) (uint64, bool) {
 	return 0, false
 }
 
-// BurnGas for burning gas internal vm and external call
+// BurnGas for burning gas from external call
 func (vm *VM) BurnGas(cost uint64) error {
-	if cost > 0 {
-		// log.Printf("Gas limit: %d, used: %d", vm.gasLimit, vm.gasUsed)
-		remainingGas := vm.gas.Limit - vm.gas.Used
-		if remainingGas < cost {
-			return ErrOutOfGas
-		}
-		vm.gas.Used = vm.gas.Used + cost
-	}
-	return nil
+	return vm.burnGas(GasCategoryHost, cost)
+}
+
+func (vm *VM) burnGas(category GasCategory, cost uint64) error {
+	return vm.gas.Burn(category, cost)
 }
 
 func (vm *VM) burnGasForOp(op opcode.Opcode) error {
-	return vm.BurnGas(vm.gasPolicy.GetCostForOp(op))
+	return vm.burnGas(GasCategoryOp, vm.gasPolicy.GetCostForOp(op))
+}
+
+// RefundGas adds amount to the refund credited when the current call completes
+func (vm *VM) RefundGas(amount uint64) {
+	vm.gas.Refund += amount
+}
+
+// ReserveGas carves a gas limit of at most limit from the remaining gas for a
+// sub-call, the unused part is given back by ReleaseGas
+func (vm *VM) ReserveGas(limit uint64) *Gas {
+	return vm.gas.Reserve(limit)
 }
 
-func (vm *VM) interpret() (uint64, error) {
+// ReleaseGas gives back the unused gas of a sub-call reserved with ReserveGas
+func (vm *VM) ReleaseGas(sub *Gas) {
+	vm.gas.Release(sub)
+}
+
+// interpret runs until the frames return to baseFrame, the result if any is
+// left above baseSP
+func (vm *VM) interpret(baseFrame, baseSP int) (uint64, error) {
 	for {
 		for {
-			if vm.framesIndex == 0 {
-				if vm.sp > 0 {
+			if vm.framesIndex == baseFrame {
+				if vm.sp > baseSP {
 					return vm.pop(), nil
 				}
 				return 0, nil
@@ -201,15 +282,26 @@ func (vm *VM) interpret() (uint64, error) {
 			}
 		}
 		frame := vm.currentFrame()
+		cp := checkpoint{ip: frame.ip, sp: vm.sp, steps: vm.steps}
+		if baseFrame == 0 {
+			if vm.breakReached() {
+				vm.suspended = &suspension{reason: ErrStepReached, baseSP: baseSP}
+				return 0, ErrStepReached
+			}
+			vm.steps++
+		}
 		frame.ip++
 		op := opcode.Opcode(frame.instructions()[frame.ip])
-		// fmt.Printf("op %d 0x%x\n", op, op)
 		if !vm.operative() && vm.skipInstructions(op) {
 			continue
 		}
+		if vm.tracer != nil {
+			vm.tracer.OnInstruction(vm, frame, frame.ip, op, vm.stack[:vm.sp])
+		}
 		if err := vm.burnGasForOp(op); err != nil {
-			return 0, err
+			return 0, vm.suspend(err, baseFrame, baseSP, frame, &cp)
 		}
+		cp.op, cp.charged = op, true
 		switch {
 		case op == opcode.Unreachable:
 			log.Println("unreachable")
@@ -284,6 +376,9 @@ func (vm *VM) interpret() (uint64, error) {
 			if targetCount > MaxBrTableSize {
 				panic(ErrTooManyBrTableTarget)
 			}
+			if err := vm.burnGas(GasCategoryOp, vm.gasPolicy.GetCostForBrTable(targetCount)); err != nil {
+				return 0, vm.suspend(err, baseFrame, baseSP, frame, &cp)
+			}
 			for i := 0; i < targetCount+1; i++ { // +1 for default target
 				depth := int(frame.readLEB(32, false))
 				if i == targetIndex || i == targetCount {
@@ -300,23 +395,30 @@ func (vm *VM) interpret() (uint64, error) {
 		case op == opcode.Call:
 			fidx := int(frame.readLEB(32, false))
 			if err := vm.CallFunction(fidx); err != nil {
-				return 0, err
+				return 0, vm.suspend(err, baseFrame, baseSP, frame, &cp)
 			}
 		case op == opcode.CallIndirect:
 			sigIndex := frame.readLEB(32, false)
-			expectedFuncSig := wasm.FuncType(vm.Module.TypeSec.FuncTypes[sigIndex])
+			expectedFuncSig := &vm.Module.TypeSec.FuncTypes[sigIndex]
 
 			frame.readLEB(1, false) // reserve as per https://github.com/WebAssembly/design/blob/master/BinaryEncoding.md#call-operators-described-here
 			eidx := vm.pop()
-			if int(eidx) >= len(vm.Module.TableIndexSpace[0]) || int(eidx) < 0 {
+			if eidx >= uint64(vm.table.Len()) {
 				panic(ErrOutOfBoundTableAccess)
 			}
-			fidx := int(vm.Module.TableIndexSpace[0][eidx])
-			if err := vm.CallFunction(fidx); err != nil {
-				return 0, err
+			ref := vm.table.elements[eidx]
+			if ref == nil {
+				panic(ErrUninitializedElement)
+			}
+			if !equalFuncTypes(ref.Type(), expectedFuncSig) {
+				panic(ErrMismatchedFuncSig)
 			}
-			if fidx >= len(vm.functionImports) {
-				vm.assertFuncSig(fidx, &expectedFuncSig)
+			if ref.vm != vm {
+				if err := vm.callExtern(ref); err != nil {
+					return 0, vm.suspend(err, baseFrame, baseSP, frame, &cp)
+				}
+			} else if err := vm.CallFunction(ref.fidx); err != nil {
+				return 0, vm.suspend(err, baseFrame, baseSP, frame, &cp)
 			}
 		case op == opcode.Drop:
 			vm.pop()
@@ -343,17 +445,20 @@ func (vm *VM) interpret() (uint64, error) {
 			vm.stack[frame.basePointer+int(arg)] = vm.peek()
 		case op == opcode.GetGlobal:
 			arg := frame.readLEB(32, false)
-			vm.push(vm.globals[arg])
+			vm.push(vm.globals[arg].value)
 		case op == opcode.SetGlobal:
 			arg := frame.readLEB(32, false)
-			vm.globals[arg] = vm.pop()
+			vm.globals[arg].value = vm.pop()
 		case opcode.I32Load <= op && op <= opcode.I64Load32U:
 			frame.readLEB(32, false) // alignment
 			offset := int(frame.readLEB(32, false))
 			address := int(vm.pop())
 			address += offset
 			vm.assertInbound(address, op.MemAccessSize())
-			curMem := vm.memory[address:]
+			if vm.tracer != nil {
+				vm.tracer.OnMemoryAccess(vm, address, op.MemAccessSize(), false)
+			}
+			curMem := vm.memory.data[address:]
 			switch op {
 			case opcode.I32Load, opcode.F32Load:
 				v := binary.LittleEndian.Uint32(curMem)
@@ -362,9 +467,9 @@ func (vm *VM) interpret() (uint64, error) {
 				v := binary.LittleEndian.Uint64(curMem)
 				vm.push(v)
 			case opcode.I32Load8S, opcode.I64Load8S:
-				vm.push(uint64(int8(vm.memory[address])))
+				vm.push(uint64(int8(vm.memory.data[address])))
 			case opcode.I32Load8U, opcode.I64Load8U:
-				vm.push(uint64(vm.memory[address]))
+				vm.push(uint64(vm.memory.data[address]))
 			case opcode.I32Load16S, opcode.I64Load16S:
 				v := binary.LittleEndian.Uint16(curMem)
 				vm.push(uint64(int16(v)))
@@ -385,14 +490,18 @@ func (vm *VM) interpret() (uint64, error) {
 			address := int(vm.pop())
 			address += offset
 			vm.assertInbound(address, op.MemAccessSize())
-			curMem := vm.memory[address:]
+			if vm.tracer != nil {
+				vm.tracer.OnMemoryAccess(vm, address, op.MemAccessSize(), true)
+			}
+			vm.memory.touch(address, op.MemAccessSize())
+			curMem := vm.memory.data[address:]
 			switch op {
 			case opcode.I32Store, opcode.F32Store:
 				binary.LittleEndian.PutUint32(curMem, uint32(v))
 			case opcode.I64Store, opcode.F64Store:
 				binary.LittleEndian.PutUint64(curMem, v)
 			case opcode.I32Store8, opcode.I64Store8:
-				vm.memory[address] = byte(v)
+				vm.memory.data[address] = byte(v)
 			case opcode.I32Store16, opcode.I64Store16:
 				binary.LittleEndian.PutUint16(curMem, uint16(v))
 			case opcode.I64Store32:
@@ -400,24 +509,16 @@ func (vm *VM) interpret() (uint64, error) {
 			}
 		case op == opcode.MemorySize:
 			frame.readLEB(1, false) // reserve as per https://github.com/WebAssembly/design/blob/master/BinaryEncoding.md#memory-related-operators-described-here
-			pages := len(vm.memory) / wasmPageSize
-			vm.push(uint64(pages))
+			vm.push(uint64(vm.memory.Pages()))
 		case op == opcode.MemoryGrow:
 			frame.readLEB(1, false) // reserve as per https://github.com/WebAssembly/design/blob/master/BinaryEncoding.md#memory-related-operators-described-here
-			pages := len(vm.memory) / wasmPageSize
-			n := int(vm.pop())
-			limit := vm.Module.MemSec.Mems[0].Limits
-			maxPages := maxSize / wasmPageSize
-			if limit.Flag == 1 && maxPages > int(limit.Max) {
-				maxPages = int(limit.Max)
-			}
-			if pages+n >= pages && pages+n <= maxPages {
-				vm.memory = append(vm.memory, make([]byte, n*wasmPageSize)...)
-				if err := vm.BurnGas(vm.gasPolicy.GetCostForMalloc(n)); err != nil {
-					return 0, err
+			n := int(uint32(vm.pop()))
+			pages := -1
+			if vm.memory.canGrow(n) {
+				if err := vm.burnGas(GasCategoryMemory, vm.gasPolicy.GetCostForMalloc(n)); err != nil {
+					return 0, vm.suspend(err, baseFrame, baseSP, frame, &cp)
 				}
-			} else {
-				pages = -1
+				pages = vm.memory.grow(n)
 			}
 			vm.push(uint64(uint32(pages)))
 		// I32 Ops
@@ -684,42 +785,42 @@ func (vm *VM) interpret() (uint64, error) {
 			val := frame.readUint32()
 			vm.push(uint64(val))
 		case opcode.F32Eq <= op && op <= opcode.F32Ge:
-			b := math.Float32frombits(uint32(vm.pop()))
-			a := math.Float32frombits(uint32(vm.pop()))
+			b := uint32(vm.pop())
+			a := uint32(vm.pop())
 			var c uint64
 			switch op {
 			case opcode.F32Eq:
-				if a == b {
+				if vm.float.F32Eq(a, b) {
 					c = 1
 				} else {
 					c = 0
 				}
 			case opcode.F32Ne:
-				if a == b {
+				if vm.float.F32Eq(a, b) {
 					c = 0
 				} else {
 					c = 1
 				}
 			case opcode.F32Lt:
-				if a < b {
+				if vm.float.F32Lt(a, b) {
 					c = 1
 				} else {
 					c = 0
 				}
 			case opcode.F32Gt:
-				if a > b {
+				if vm.float.F32Lt(b, a) {
 					c = 1
 				} else {
 					c = 0
 				}
 			case opcode.F32Le:
-				if a <= b {
+				if vm.float.F32Le(a, b) {
 					c = 1
 				} else {
 					c = 0
 				}
 			case opcode.F32Ge:
-				if a >= b {
+				if vm.float.F32Le(b, a) {
 					c = 1
 				} else {
 					c = 0
@@ -727,99 +828,90 @@ func (vm *VM) interpret() (uint64, error) {
 			}
 			vm.push(c)
 
-		case opcode.F32Add <= op && op <= opcode.F32Max:
-			bBits := uint32(vm.pop())
-			b := math.Float32frombits(bBits)
-			aBits := uint32(vm.pop())
-			a := math.Float32frombits(aBits)
-			var c float32
+		case opcode.F32Add <= op && op <= opcode.F32Copysign:
+			b := uint32(vm.pop())
+			a := uint32(vm.pop())
+			var c uint32
 			switch op {
 			case opcode.F32Add:
-				c = a + b
+				c = vm.float.F32Add(a, b)
 			case opcode.F32Sub:
-				c = a - b
+				c = vm.float.F32Sub(a, b)
 			case opcode.F32Mul:
-				c = a * b
+				c = vm.float.F32Mul(a, b)
 			case opcode.F32Div:
-				c = a / b
+				c = vm.float.F32Div(a, b)
 			case opcode.F32Min:
-				c = float32(math.Min(float64(a), float64(b)))
+				c = vm.float.F32Min(a, b)
 			case opcode.F32Max:
-				c = float32(math.Max(float64(a), float64(b)))
+				c = vm.float.F32Max(a, b)
+			case opcode.F32Copysign:
+				c = number.F32Copysign(a, b)
 			}
-			vm.pushFloat32(c)
-
-		// copysign, abs, neg use bitwise to ensure arch independent
-		case op == opcode.F32Copysign:
-			bBits := uint32(vm.pop())
-			aBits := uint32(vm.pop())
-			cBits := aBits&^f32SignMask | bBits&f32SignMask
-			vm.push(uint64(cBits))
-
-		case op == opcode.F32Neg:
-			vm.push(uint64(uint32(vm.pop()) ^ f32SignMask))
-
-		case op == opcode.F32Abs:
-			vm.push(uint64(uint32(vm.pop()) &^ f32SignMask))
+			vm.push(uint64(c))
 
-		case opcode.F32Ceil <= op && op <= opcode.F32Sqrt:
-			f := float64(math.Float32frombits(uint32(vm.pop())))
-			var r float64
+		case opcode.F32Abs <= op && op <= opcode.F32Sqrt:
+			a := uint32(vm.pop())
+			var r uint32
 			switch op {
+			case opcode.F32Abs:
+				r = number.F32Abs(a)
+			case opcode.F32Neg:
+				r = number.F32Neg(a)
 			case opcode.F32Ceil:
-				r = math.Ceil(f)
+				r = vm.float.F32Ceil(a)
 			case opcode.F32Floor:
-				r = math.Floor(f)
+				r = vm.float.F32Floor(a)
 			case opcode.F32Trunc:
-				r = math.Trunc(f)
+				r = vm.float.F32Trunc(a)
 			case opcode.F32Nearest:
-				r = math.RoundToEven(f)
+				r = vm.float.F32Nearest(a)
 			case opcode.F32Sqrt:
-				r = math.Sqrt(f)
+				r = vm.float.F32Sqrt(a)
 			}
-			vm.pushFloat32(float32(r))
+			vm.push(uint64(r))
 
 		// F64 Ops
 		case op == opcode.F64Const:
 			val := frame.readUint64()
 			vm.push(uint64(val))
 		case opcode.F64Eq <= op && op <= opcode.F64Ge:
-			b := math.Float64frombits(vm.pop())
-			a := math.Float64frombits(vm.pop())
+			b := vm.pop()
+			a := vm.pop()
 			var c uint64
 			switch op {
 			case opcode.F64Eq:
-				if a == b {
+				if vm.float.F64Eq(a, b) {
 					c = 1
 				} else {
 					c = 0
 				}
 			case opcode.F64Ne:
-				if a == b {
+				if vm.float.F64Eq(a, b) {
 					c = 0
 				} else {
 					c = 1
 				}
 			case opcode.F64Lt:
-				if a < b {
+				if vm.float.F64Lt(a, b) {
 					c = 1
 				} else {
 					c = 0
 				}
 			case opcode.F64Gt:
-				if a > b {
+				if vm.float.F64Lt(b, a) {
 					c = 1
 				} else {
 					c = 0
 				}
 			case opcode.F64Le:
-				if a <= b {
+				if vm.float.F64Le(a, b) {
 					c = 1
 				} else {
 					c = 0
 				}
 			case opcode.F64Ge:
-				if a >= b {
+				if vm.float.F64Le(b, a) {
 					c = 1
 				} else {
 					c = 0
@@ -827,55 +919,48 @@ func (vm *VM) interpret() (uint64, error) {
 			}
 			vm.push(c)
 
-		case opcode.F64Add <= op && op <= opcode.F64Max:
-			b := math.Float64frombits(vm.pop())
-			a := math.Float64frombits(vm.pop())
-			var c float64
+		case opcode.F64Add <= op && op <= opcode.F64Copysign:
+			b := vm.pop()
+			a := vm.pop()
+			var c uint64
 			switch op {
 			case opcode.F64Add:
-				c = a + b
+				c = vm.float.F64Add(a, b)
 			case opcode.F64Sub:
-				c = a - b
+				c = vm.float.F64Sub(a, b)
 			case opcode.F64Mul:
-				c = a * b
+				c = vm.float.F64Mul(a, b)
 			case opcode.F64Div:
-				c = a / b
+				c = vm.float.F64Div(a, b)
 			case opcode.F64Min:
-				c = math.Min(a, b)
+				c = vm.float.F64Min(a, b)
 			case opcode.F64Max:
-				c = math.Max(a, b)
+				c = vm.float.F64Max(a, b)
+			case opcode.F64Copysign:
+				c = number.F64Copysign(a, b)
 			}
-			vm.pushFloat64(c)
-
-		// copysign, abs, neg use bitwise to ensure arch independent
-		case op == opcode.F64Copysign:
-			bBits := vm.pop()
-			aBits := vm.pop()
-			cBits := aBits&^f64SignMask | bBits&f64SignMask
-			vm.push(cBits)
-
-		case op == opcode.F64Neg:
-			vm.push(vm.pop() ^ f64SignMask)
-
-		case op == opcode.F64Abs:
-			vm.push(vm.pop() &^ f64SignMask)
+			vm.push(c)
 
-		case opcode.F64Ceil <= op && op <= opcode.F64Sqrt:
-			f := math.Float64frombits(vm.pop())
-			var r float64
+		case opcode.F64Abs <= op && op <= opcode.F64Sqrt:
+			a := vm.pop()
+			var r uint64
 			switch op {
+			case opcode.F64Abs:
+				r = number.F64Abs(a)
+			case opcode.F64Neg:
+				r = number.F64Neg(a)
 			case opcode.F64Ceil:
-				r = math.Ceil(f)
+				r = vm.float.F64Ceil(a)
 			case opcode.F64Floor:
-				r = math.Floor(f)
+				r = vm.float.F64Floor(a)
 			case opcode.F64Trunc:
-				r = math.Trunc(f)
+				r = vm.float.F64Trunc(a)
 			case opcode.F64Nearest:
-				r = math.RoundToEven(f)
+				r = vm.float.F64Nearest(a)
 			case opcode.F64Sqrt:
-				r = math.Sqrt(f)
+				r = vm.float.F64Sqrt(a)
 			}
-			vm.pushFloat64(r)
+			vm.push(r)
 
 		// Conversion
 		case op == opcode.I32WrapI64:
@@ -885,13 +970,13 @@ func (vm *VM) interpret() (uint64, error) {
 			var trapCode number.TrapCode
 			switch op {
 			case opcode.I32TruncSF32:
-				r, trapCode = number.FloatTruncate(number.F32, number.I32, vm.pop())
+				r, trapCode = vm.float.Truncate(number.F32, number.I32, vm.pop())
 			case opcode.I32TruncUF32:
-				r, trapCode = number.FloatTruncate(number.F32, number.U32, vm.pop())
+				r, trapCode = vm.float.Truncate(number.F32, number.U32, vm.pop())
 			case opcode.I32TruncSF64:
-				r, trapCode = number.FloatTruncate(number.F64, number.I32, vm.pop())
+				r, trapCode = vm.float.Truncate(number.F64, number.I32, vm.pop())
 			case opcode.I32TruncUF64:
-				r, trapCode = number.FloatTruncate(number.F64, number.U32, vm.pop())
+				r, trapCode = vm.float.Truncate(number.F64, number.U32, vm.pop())
 			}
 			if trapCode == number.NanTrap {
 				panic(ErrInvalidIntConversion)
@@ -908,13 +993,13 @@ func (vm *VM) interpret() (uint64, error) {
 			var trapCode number.TrapCode
 			switch op {
 			case opcode.I64TruncSF32:
-				r, trapCode = number.FloatTruncate(number.F32, number.I64, vm.pop())
+				r, trapCode = vm.float.Truncate(number.F32, number.I64, vm.pop())
 			case opcode.I64TruncUF32:
-				r, trapCode = number.FloatTruncate(number.F32, number.U64, vm.pop())
+				r, trapCode = vm.float.Truncate(number.F32, number.U64, vm.pop())
 			case opcode.I64TruncSF64:
-				r, trapCode = number.FloatTruncate(number.F64, number.I64, vm.pop())
+				r, trapCode = vm.float.Truncate(number.F64, number.I64, vm.pop())
 			case opcode.I64TruncUF64:
-				r, trapCode = number.FloatTruncate(number.F64, number.U64, vm.pop())
+				r, trapCode = vm.float.Truncate(number.F64, number.U64, vm.pop())
 			}
 			if trapCode == number.NanTrap {
 				panic(ErrInvalidIntConversion)
@@ -923,38 +1008,28 @@ func (vm *VM) interpret() (uint64, error) {
 			}
 			vm.push(r)
 		case op == opcode.F32ConvertSI32:
-			i := int32(uint32(vm.pop()))
-			vm.push(uint64(math.Float32bits(float32(i))))
+			vm.push(uint64(vm.float.F32ConvertInt(uint64(int32(vm.pop())), true)))
 		case op == opcode.F32ConvertUI32:
-			i := uint32(vm.pop())
-			vm.push(uint64(math.Float32bits(float32(i))))
+			vm.push(uint64(vm.float.F32ConvertInt(uint64(uint32(vm.pop())), false)))
 		case op == opcode.F32ConvertSI64:
-			i := int64(vm.pop())
-			vm.push(uint64(math.Float32bits(float32(i))))
+			vm.push(uint64(vm.float.F32ConvertInt(vm.pop(), true)))
 		case op == opcode.F32ConvertUI64:
-			i := uint64(vm.pop())
-			vm.push(uint64(math.Float32bits(float32(i))))
+			vm.push(uint64(vm.float.F32ConvertInt(vm.pop(), false)))
 
 		case op == opcode.F64ConvertSI32:
-			i := int32(uint32(vm.pop()))
-			vm.push(uint64(math.Float64bits(float64(i))))
+			vm.push(vm.float.F64ConvertInt(uint64(int32(vm.pop())), true))
 		case op == opcode.F64ConvertUI32:
-			i := uint32(vm.pop())
-			vm.push(uint64(math.Float64bits(float64(i))))
+			vm.push(vm.float.F64ConvertInt(uint64(uint32(vm.pop())), false))
 		case op == opcode.F64ConvertSI64:
-			i := int64(vm.pop())
-			vm.push(uint64(math.Float64bits(float64(i))))
+			vm.push(vm.float.F64ConvertInt(vm.pop(), true))
 		case op == opcode.F64ConvertUI64:
-			i := uint64(vm.pop())
-			vm.push(uint64(math.Float64bits(float64(i))))
+			vm.push(vm.float.F64ConvertInt(vm.pop(), false))
 
 		case op == opcode.F32DemoteF64:
-			f := math.Float64frombits(vm.pop())
-			vm.pushFloat32(float32(f))
+			vm.push(uint64(vm.float.F32DemoteF64(vm.pop())))
 
 		case op == opcode.F64PromoteF32:
-			f := math.Float32frombits(uint32(vm.pop()))
-			vm.pushFloat64(float64(f))
+			vm.push(vm.float.F64PromoteF32(uint32(vm.pop())))
 
 		case opcode.I32ReinterpretF32 <= op && op <= opcode.F64ReinterpretI64:
 			// Do nothing
@@ -965,31 +1040,31 @@ func (vm *VM) interpret() (uint64, error) {
 		case op == opcode.I64Extend32S:
 			vm.push(uint64(int32(vm.pop())))
 		case op == opcode.ITruncSatF:
-			subop := frame.readLEB(1, false)
+			subop := frame.readLEB(32, false)
 			switch subop {
 			case 0: //I32TruncSatF32S
-				r, _ := number.FloatTruncate(number.F32, number.I32, vm.pop())
+				r, _ := vm.float.Truncate(number.F32, number.I32, vm.pop())
 				vm.push(r)
 			case 1: //I32TruncSatF32U
-				r, _ := number.FloatTruncate(number.F32, number.U32, vm.pop())
+				r, _ := vm.float.Truncate(number.F32, number.U32, vm.pop())
 				vm.push(r)
 			case 2: //I32TruncSatF64S
-				r, _ := number.FloatTruncate(number.F64, number.I32, vm.pop())
+				r, _ := vm.float.Truncate(number.F64, number.I32, vm.pop())
 				vm.push(r)
 			case 3: //I32TruncSatF64U
-				r, _ := number.FloatTruncate(number.F64, number.U32, vm.pop())
+				r, _ := vm.float.Truncate(number.F64, number.U32, vm.pop())
 				vm.push(r)
 			case 4: //I64TruncSatF32S
-				r, _ := number.FloatTruncate(number.F32, number.I64, vm.pop())
+				r, _ := vm.float.Truncate(number.F32, number.I64, vm.pop())
 				vm.push(r)
 			case 5: //I64TruncSatF32U
-				r, _ := number.FloatTruncate(number.F32, number.U64, vm.pop())
+				r, _ := vm.float.Truncate(number.F32, number.U64, vm.pop())
 				vm.push(r)
 			case 6: //I64TruncSatF64S
-				r, _ := number.FloatTruncate(number.F64, number.I64, vm.pop())
+				r, _ := vm.float.Truncate(number.F64, number.I64, vm.pop())
 				vm.push(r)
 			case 7: //I64TruncSatF64U
-				r, _ := number.FloatTruncate(number.F64, number.U64, vm.pop())
+				r, _ := vm.float.Truncate(number.F64, number.U64, vm.pop())
 				vm.push(r)
 			}
 		default:
@@ -1000,41 +1075,48 @@ func (vm *VM) interpret() (uint64, error) {
 
 func (vm *VM) skipInstructions(op opcode.Opcode) bool {
 	frame := vm.currentFrame()
-	switch {
-	case op == opcode.End || op == opcode.Else: // control end
+	switch op {
+	case opcode.End, opcode.Else: // control end
 		return false
-	case op == opcode.Block || op == opcode.Loop || op == opcode.If:
+	case opcode.Block, opcode.Loop, opcode.If:
 		returnType := wasm.ValueType(frame.readLEB(32, true))
 		block := NewBlock(frame.ip, getBlockType(op), returnType, vm.sp)
 		vm.pushBlock(block)
 		vm.breakDepth++
-	case op == opcode.Br || op == opcode.BrIf || op == opcode.Call:
-		fallthrough
-	case opcode.GetLocal <= op && op <= opcode.SetGlobal:
-		fallthrough
-	case op == opcode.I32Const:
-		frame.readLEB(32, false)
-	case op == opcode.I64Const:
-		frame.readLEB(64, false)
-	case op == opcode.F32Const:
-		frame.readUint32()
-	case op == opcode.F64Const:
-		frame.readUint64()
-	case opcode.I32Load <= op && op <= opcode.I64Store32:
-		frame.readLEB(32, false)
-		frame.readLEB(32, false)
-	case op == opcode.MemorySize || op == opcode.MemoryGrow:
-		frame.readLEB(1, false)
-	case op == opcode.CallIndirect:
-		frame.readLEB(32, false)
-		frame.readLEB(1, false)
-	case op == opcode.BrTable:
+		return true
+	}
+	if info := op.Info(); info != nil {
+		for _, imm := range info.Immediates {
+			skipImmediate(frame, imm)
+		}
+	}
+	return true
+}
+
+// skipImmediate moves the frame instruction pointer past an immediate of kind imm
+func skipImmediate(frame *Frame, imm opcode.Immediate) {
+	switch imm {
+	case opcode.ImmLabelTable:
 		targetCount := int(frame.readLEB(32, false))
 		for i := 0; i < targetCount+1; i++ {
 			frame.readLEB(32, false)
 		}
+	case opcode.ImmMemArg:
+		frame.readLEB(32, false)
+		frame.readLEB(32, false)
+	case opcode.ImmReserved:
+		frame.readLEB(1, false)
+	case opcode.ImmI32:
+		frame.readLEB(32, true)
+	case opcode.ImmI64:
+		frame.readLEB(64, true)
+	case opcode.ImmF32:
+		frame.readUint32()
+	case opcode.ImmF64:
+		frame.readUint64()
+	default:
+		frame.readLEB(32, false)
 	}
-	return true
 }
 
 // inoperative vm skips instructions if there is at least 1 level of block to break out of
@@ -1066,12 +1148,22 @@ func (vm *VM) setupFrame(fidx int) error {
 	if fn == nil {
 		return ErrFuncNotFound
 	}
-	frame := NewFrame(fn, vm.sp-len(fn.Type.ParamTypes), vm.blocksIndex)
-	vm.pushFrame(frame)
 	numLocals := 0
 	for _, entry := range fn.Code.Locals {
 		numLocals += int(entry.Count)
 	}
+	if err := vm.burnGas(GasCategoryCall, vm.gasPolicy.GetCostForCall(len(fn.Type.ParamTypes)+numLocals)); err != nil {
+		return err
+	}
+	if vm.sp+numLocals > StackSize {
+		return ErrStackOverflow
+	}
+	frame := NewFrame(fn, vm.sp-len(fn.Type.ParamTypes), vm.blocksIndex)
+	frame.fidx = fidx
+	vm.pushFrame(frame)
+	if vm.tracer != nil {
+		vm.tracer.OnCall(vm, fidx, vm.stack[frame.basePointer:vm.sp])
+	}
 	// leave some space for locals
 	vm.sp = frame.basePointer + len(fn.Type.ParamTypes) + numLocals
 	// uninitialize locals
@@ -1094,22 +1186,6 @@ func (vm *VM) push(val uint64) {
 	vm.sp++
 }
 
-func (vm *VM) pushFloat32(val float32) {
-	if math.IsNaN(float64(val)) {
-		vm.push(f32CanonicalNaNBits)
-	} else {
-		vm.push(uint64(math.Float32bits(val)))
-	}
-}
-
-func (vm *VM) pushFloat64(val float64) {
-	if math.IsNaN(val) {
-		vm.push(f64CanonicalNaNBits)
-	} else {
-		vm.push(math.Float64bits(val))
-	}
-}
-
 func (vm *VM) pop() uint64 {
 	if vm.sp == 0 {
 		panic(ErrStackUnderflow)
@@ -1147,6 +1223,9 @@ func (vm *VM) popFrame() *Frame {
 		vm.sp = vm.currentFrame().basePointer
 		vm.blocksIndex = vm.currentFrame().baseBlockIndex
 	}
+	if vm.tracer != nil {
+		vm.tracer.OnReturn(vm, vm.currentFrame().fidx, vm.stack[vm.currentFrame().basePointer:vm.sp])
+	}
 	vm.breakDepth = -1 // return reset
 	vm.framesIndex--
 	return vm.frames[vm.framesIndex]
@@ -1168,44 +1247,6 @@ func (vm *VM) popBlock() *Block {
 	return vm.blocks[vm.blocksIndex]
 }
 
-func (vm *VM) initGlobals() error {
-	for i, global := range vm.Module.GlobalIndexSpace {
-		val, err := vm.Module.ExecInitExpr(global.Init)
-		if err != nil {
-			return err
-		}
-		switch v := val.(type) {
-		case int32:
-			vm.globals[i] = uint64(v)
-		case int64:
-			vm.globals[i] = uint64(v)
-		case float32:
-			vm.globals[i] = uint64(math.Float32bits(v))
-		case float64:
-			vm.globals[i] = uint64(math.Float64bits(v))
-		}
-	}
-	return nil
-}
-
-func (vm *VM) assertFuncSig(fidx int, expectedSignature *wasm.FuncType) {
-	signature := vm.GetFunction(fidx).Type
-	if len(signature.ParamTypes) != len(expectedSignature.ParamTypes) ||
-		len(signature.ReturnTypes) != len(expectedSignature.ReturnTypes) {
-		panic(ErrMismatchedFuncSig)
-	}
-	for i, paramType := range signature.ParamTypes {
-		if paramType != expectedSignature.ParamTypes[i] {
-			panic(ErrMismatchedFuncSig)
-		}
-	}
-	for i, returnType := range signature.ReturnTypes {
-		if returnType != expectedSignature.ReturnTypes[i] {
-			panic(ErrMismatchedFuncSig)
-		}
-	}
-}
-
 func (vm *VM) assertInbound(address, accessSize int) {
 	if address > vm.MemSize()-accessSize {
 		panic(ErrOutOfBoundMemoryAccess)
@@ -1241,43 +1282,127 @@ func (vm *VM) validateFuncArgs(fidx int, args []uint64) error {
 func (vm *VM) CallFunction(fidx int) error {
 	if fidx < len(vm.functionImports) {
 		fi := vm.functionImports[fidx]
-		hf := vm.importResolver.GetFunction(fi.module, fi.name)
-		argSize := len(fi.signature.ParamTypes)
-		args := make([]uint64, argSize)
-		for i := argSize - 1; i >= 0; i-- {
-			args[i] = vm.pop()
+		if err := vm.burnGas(GasCategoryHostCall, vm.gasPolicy.GetCostForHostCall(fi.module, fi.name)); err != nil {
+			return err
+		}
+		if fi.ref != nil {
+			return vm.callExtern(fi.ref)
+		}
+		var hf HostFunction
+		if fi.function != nil {
+			hf = *fi.function
+		} else {
+			hf = vm.importResolver.GetFunction(fi.module, fi.name)
+		}
+		args := vm.popArgs(len(fi.signature.ParamTypes))
+		if vm.tracer != nil {
+			vm.tracer.OnCall(vm, fidx, args)
 		}
 		ret, err := hf(vm, args...)
-		vm.push(ret)
-		return err
+		vm.hostErr = err
+		if err != nil {
+			if err == ErrYield {
+				vm.yieldResults = len(fi.signature.ReturnTypes)
+			}
+			return err
+		}
+		if len(fi.signature.ReturnTypes) != 0 {
+			vm.push(ret)
+		}
+		if vm.tracer != nil {
+			vm.tracer.OnReturn(vm, fidx, vm.stack[vm.sp-len(fi.signature.ReturnTypes):vm.sp])
+		}
+		return nil
 	}
 	return vm.setupFrame(fidx)
 }
 
+// callExtern invokes a function of another VM with arguments popped from the stack
+func (vm *VM) callExtern(ref *FuncRef) error {
+	sig := ref.Type()
+	// the callee burns the gas of the caller with its own gas policy, its refund
+	// is credited on its own usage and the pending refund of the caller kept aside
+	calleeGas, refund := ref.vm.gas, vm.gas.Refund
+	ref.vm.gas, vm.gas.Refund = vm.gas, 0
+	ret, err := ref.vm.Invoke(uint64(ref.fidx), vm.popArgs(len(sig.ParamTypes))...)
+	ref.vm.gas = calleeGas
+	vm.gas.Refund += refund
+	// the callee may have changed its memory, globals and tables before failing,
+	// like a host function it ends the invocation instead of being retried on resume
+	vm.hostErr = err
+	if err != nil {
+		if ref.vm.suspended != nil {
+			ref.vm.Abort()
+		}
+		if err == ErrYield {
+			return ErrYieldNotAllowed
+		}
+		return err
+	}
+	if len(sig.ReturnTypes) != 0 {
+		vm.push(ret)
+	}
+	return nil
+}
+
+func (vm *VM) popArgs(n int) []uint64 {
+	args := make([]uint64, n)
+	for i := n - 1; i >= 0; i-- {
+		args[i] = vm.pop()
+	}
+	return args
+}
+
 // MemSize gets the current vm memory size
 func (vm *VM) MemSize() int {
-	return len(vm.memory)
+	return len(vm.memory.data)
 }
 
-// MemWrite write a byte buffer to vm memory at a specific offset
+// Memory returns the memory of the VM, imported or defined by the module
+func (vm *VM) Memory() *Memory {
+	return vm.memory
+}
+
+// BurnMemCopyGas charges the gas policy cost of bytes copied in or out of the
+// memory by a host function using the Memory accessors
+func (vm *VM) BurnMemCopyGas(bytes int) error {
+	return vm.burnGas(GasCategoryMemory, vm.gasPolicy.GetCostForMemCopy(bytes))
+}
+
+// MemWrite write a byte buffer to vm memory at a specific offset, the write
+// stops at the end of the memory with io.ErrShortWrite
 func (vm *VM) MemWrite(b []byte, offset int) (int, error) {
+	if offset < 0 || offset > vm.MemSize() {
+		return 0, ErrOutOfBoundMemoryAccess
+	}
+	if err := vm.burnGas(GasCategoryMemory, vm.gasPolicy.GetCostForMemCopy(len(b))); err != nil {
+		return 0, err
+	}
 	var err error
 	if offset+len(b) > vm.MemSize() {
 		b = b[:vm.MemSize()-offset]
 		err = io.ErrShortWrite
 	}
-	copy(vm.memory[offset:], b)
+	copy(vm.memory.data[offset:], b)
+	vm.memory.touch(offset, len(b))
 	return len(b), err
 }
 
-// MemRead copy a vm memory segment to a given placeholder
+// MemRead copy a vm memory segment to a given placeholder, the read stops at
+// the end of the memory with io.ErrShortBuffer
 func (vm *VM) MemRead(b []byte, offset int) (int, error) {
+	if offset < 0 || offset > vm.MemSize() {
+		return 0, ErrOutOfBoundMemoryAccess
+	}
+	if err := vm.burnGas(GasCategoryMemory, vm.gasPolicy.GetCostForMemCopy(len(b))); err != nil {
+		return 0, err
+	}
 	var err error
 	if offset+len(b) > vm.MemSize() {
 		b = b[:vm.MemSize()-offset]
 		err = io.ErrShortBuffer
 	}
-	copy(b, vm.memory[offset:offset+len(b)])
+	copy(b, vm.memory.data[offset:offset+len(b)])
 	return len(b), err
 }
 
@@ -1285,3 +1410,8 @@ func (vm *VM) MemRead(b []byte, offset int) (int, error) {
 func (vm *VM) GetGasUsed() uint64 {
 	return vm.gas.Used
 }
+
+// GetGasRemaining exposes the amount of gas left for execution
+func (vm *VM) GetGasRemaining() uint64 {
+	return vm.gas.Remaining()
+}
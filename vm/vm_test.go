@@ -1,42 +1,17 @@
 package vm
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"log"
-	"os/exec"
 	"testing"
-)
-
-type TestSuite struct {
-	SourceFilename string    `json:"source_filename"`
-	Commands       []Command `json:"commands"`
-}
-
-type Command struct {
-	Type       string      `json:"type"`
-	Line       int         `json:"line"`
-	Filename   string      `json:"filename"`
-	Name       string      `json:"name"`
-	Action     Action      `json:"action"`
-	Text       string      `json:"text"`
-	ModuleType string      `json:"module_type"`
-	Expected   []ValueInfo `json:"expected"`
-}
 
-type Action struct {
-	Type     string      `json:"type"`
-	Module   string      `json:"module"`
-	Field    string      `json:"field"`
-	Args     []ValueInfo `json:"args"`
-	Expected []ValueInfo `json:"expected"`
-}
-
-type ValueInfo struct {
-	Type  string `json:"type"`
-	Value string `json:"value"`
-}
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/wasm"
+	"github.com/vertexdlt/vertexvm/wat"
+)
 
 type vmTest struct {
 	name        string
@@ -59,6 +34,8 @@ func (r *TestResolver) GetFunction(module, name string) HostFunction {
 				y := int(args[1])
 				return uint64(x + y), nil
 			}
+		case "after", "before":
+			return func(vm *VM, args ...uint64) (uint64, error) { return 0, nil }
 		default:
 			log.Fatalf("Unknown import name: %s", name)
 		}
@@ -100,22 +77,27 @@ func (r *TestResolver) GetFunction(module, name string) HostFunction {
 	return nil
 }
 
-func GetTestVM(name string, gasPolicy GasPolicy, gasLimit uint64) *VM {
-	wat := fmt.Sprintf("./test_data/%s.wat", name)
-	wasm := fmt.Sprintf("./test_data/%s.wasm", name)
-	cmd := exec.Command("wat2wasm", wat, "-o", wasm)
-	err := cmd.Start()
-	if err != nil {
-		panic(err)
+func (r *TestResolver) GetExtern(module, name string) Extern {
+	if module == "env" && name == "mglobal" {
+		return NewGlobal(wasm.GlobalType{ValueType: wasm.ValueTypeI32}, 42)
 	}
-	err = cmd.Wait()
+	return nil
+}
+
+func compileTestData(name string) []byte {
+	src, err := ioutil.ReadFile(fmt.Sprintf("./test_data/%s.wat", name))
 	if err != nil {
 		panic(err)
 	}
-	data, err := ioutil.ReadFile(wasm)
+	data, err := wat.Compile(src)
 	if err != nil {
-		panic(err)
+		panic(fmt.Errorf("%s.wat:%v", name, err))
 	}
+	return data
+}
+
+func GetTestVM(name string, gasPolicy GasPolicy, gasLimit uint64) *VM {
+	data := compileTestData(name)
 	vm, err := NewVM(data, gasPolicy, &Gas{Limit: gasLimit}, &TestResolver{})
 	if err != nil {
 		panic(err)
@@ -195,6 +177,7 @@ func TestVM(t *testing.T) {
 		{name: "br_table", entry: "calc", params: []uint64{100}, expected: 16},
 		{name: "return", entry: "calc", params: []uint64{}, expected: 9},
 		{name: "import_env", entry: "calc", params: []uint64{}, expected: 3},
+		{name: "import_env", entry: "getglobal", params: []uint64{}, expected: 42},
 		{name: "trunc", entry: "main", params: []uint64{}, expected: 4294967295},
 		{name: "trunc_trap", entry: "main", params: []uint64{}, trapText: "integer overflow"},
 		{name: "trunc_edge", entry: "main", params: []uint64{}, expected: 0},
@@ -244,13 +227,207 @@ func TestOutOfGas(t *testing.T) {
 }
 
 func TestOutOfGasVMCreation(t *testing.T) {
-	GetTestVM("i32", &FreeGasPolicy{}, 0)
-	data, err := ioutil.ReadFile("./test_data/i32.wasm")
-	if err != nil {
-		panic(err)
-	}
-	_, err = NewVM(data, &FreeGasPolicy{}, &Gas{Limit: 10, Used: 20}, &TestResolver{})
+	data := compileTestData("i32")
+	_, err := NewVM(data, &FreeGasPolicy{}, &Gas{Limit: 10, Used: 20}, &TestResolver{})
 	if err == nil || err != ErrOutOfGas {
 		t.Errorf("Expect out of gas error: %d", err)
 	}
 }
+
+func TestSkipImmediates(t *testing.T) {
+	// every skipped instruction carries immediates that look like opcodes if misread
+	body := []byte{
+		byte(opcode.Block), 0x7f,
+		byte(opcode.I32Const), 0x07,
+		byte(opcode.Br), 0x00,
+		byte(opcode.I64Const), 0x8b, 0x01,
+		byte(opcode.F32Const), 0x0b, 0x0b, 0x0b, 0x0b,
+		byte(opcode.F64Const), 0x0b, 0x0b, 0x0b, 0x0b, 0x0b, 0x0b, 0x0b, 0x0b,
+		byte(opcode.ITruncSatF), 0x05,
+		byte(opcode.I32Load), 0x02, 0x0b,
+		byte(opcode.BrTable), 0x01, 0x0b, 0x0b,
+		byte(opcode.CallIndirect), 0x0b, 0x00,
+		byte(opcode.MemorySize), 0x00,
+		byte(opcode.End),
+	}
+	// the skipped instructions do not validate, the body is swapped in once instantiated
+	code, err := wat.Compile([]byte(`(module (func (export "skip") (result i32) (i32.const 0)))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm, err := NewVM(code, &FreeGasPolicy{}, &Gas{}, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	vm.Module.FunctionIndexSpace[0].Code.Exprs = body
+	ret, err := invokeExport(t, vm, "skip")
+	if err != nil || ret != 7 {
+		t.Errorf("Expect skipped block to return 7, got %d %v", ret, err)
+	}
+}
+
+func TestSkipValidatedImmediates(t *testing.T) {
+	// the skipped instructions of a valid module carry immediates that look like opcodes if misread
+	code, err := wat.Compile([]byte(`(module
+  (type (func)) (type (func)) (type (func)) (type (func)) (type (func)) (type (func))
+  (type (func)) (type (func)) (type (func)) (type (func)) (type (func)) (type (func))
+  (table 1 funcref)
+  (memory 1)
+  (func (export "skip") (result i32)
+    block (result i32)
+      i32.const 7
+      br 0
+      block block block block block block block block block block block
+        i64.const 139
+        drop
+        f32.const 0x1.161616p-105
+        i64.trunc_sat_f32_u
+        drop
+        f64.const 0x1.b0b0b0b0b0b0bp-847
+        drop
+        i32.const 0
+        i32.load offset=11
+        drop
+        i32.const 0
+        call_indirect (type 11)
+        memory.size
+        drop
+        i32.const 0
+        i32.const 0
+        br_table 11 11
+      end end end end end end end end end end end
+    end))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	immediates := []byte{
+		byte(opcode.I64Const), 0x8b, 0x01, byte(opcode.Drop),
+		byte(opcode.F32Const), 0x0b, 0x0b, 0x0b, 0x0b,
+		byte(opcode.ITruncSatF), 0x05, byte(opcode.Drop),
+		byte(opcode.F64Const), 0x0b, 0x0b, 0x0b, 0x0b, 0x0b, 0x0b, 0x0b, 0x0b, byte(opcode.Drop),
+		byte(opcode.I32Const), 0x00, byte(opcode.I32Load), 0x02, 0x0b, byte(opcode.Drop),
+		byte(opcode.I32Const), 0x00, byte(opcode.CallIndirect), 0x0b, 0x00,
+		byte(opcode.MemorySize), 0x00, byte(opcode.Drop),
+		byte(opcode.I32Const), 0x00, byte(opcode.I32Const), 0x00, byte(opcode.BrTable), 0x01, 0x0b, 0x0b,
+	}
+	if !bytes.Contains(code, immediates) {
+		t.Fatalf("Expect the skipped code to contain % x", immediates)
+	}
+	vm, err := NewVM(code, &FreeGasPolicy{}, &Gas{}, &TestResolver{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ret, err := invokeExport(t, vm, "skip")
+	if err != nil || ret != 7 {
+		t.Errorf("Expect skipped block to return 7, got %d %v", ret, err)
+	}
+}
+
+func TestReentrancy(t *testing.T) {
+	// sum returns n + sum(n - 1) computed by a host callback into the same VM
+	code, err := wat.Compile([]byte(`(module
+  (import "env" "callback" (func $callback (param i32) (result i32)))
+  (func (export "sum") (param $n i32) (result i32)
+    (if (result i32) (i32.eqz (local.get $n))
+      (then (i32.const 0))
+      (else (i32.add (local.get $n) (call $callback (i32.sub (local.get $n) (i32.const 1))))))))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resolver := hostResolver{"callback": func(vm *VM, args ...uint64) (uint64, error) {
+		fidx, _ := vm.GetFunctionIndex("sum")
+		return vm.Invoke(fidx, args[0])
+	}}
+	tests := []struct {
+		maxReentrancy int
+		n             uint64
+		err           error
+	}{
+		{0, DefaultMaxReentrancy, nil},
+		{0, DefaultMaxReentrancy + 1, ErrReentrancyDepth},
+		{2, 2, nil},
+		{2, 3, ErrReentrancyDepth},
+		{-1, 0, nil},
+		{-1, 1, ErrReentrancyDepth},
+	}
+	for _, test := range tests {
+		vm, err := NewVMWithConfig(code, &SimpleGasPolicy{}, &Gas{Limit: 1 << 20}, resolver, Config{MaxReentrancy: test.maxReentrancy})
+		if err != nil {
+			t.Fatal(err)
+		}
+		fidx, _ := vm.GetFunctionIndex("sum")
+		ret, err := vm.Invoke(fidx, test.n)
+		if err != test.err || (err == nil && ret != test.n*(test.n+1)/2) {
+			t.Errorf("sum(%d) with depth %d: expect %d %v, got %d %v", test.n, test.maxReentrancy, test.n*(test.n+1)/2, test.err, ret, err)
+		}
+		if vm.sp != 0 || vm.framesIndex != 0 || vm.blocksIndex != 0 || vm.invocations != 0 {
+			t.Errorf("sum(%d) with depth %d: expect the stacks to be unwound", test.n, test.maxReentrancy)
+		}
+	}
+
+	// nested invocations burn the gas of the outer one
+	gas := &Gas{Limit: 1 << 20}
+	vm, err := NewVM(code, &SimpleGasPolicy{}, gas, resolver)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fidx, _ := vm.GetFunctionIndex("sum")
+	if _, err := vm.Invoke(fidx, 0); err != nil {
+		t.Fatal(err)
+	}
+	used := gas.Used
+	if _, err := vm.Invoke(fidx, 3); err != nil {
+		t.Fatal(err)
+	}
+	gas.Limit = 2*gas.Used - used - 1
+	if _, err := vm.Invoke(fidx, 3); err != ErrOutOfGas {
+		t.Errorf("Expect the nested invocations to run out of the shared gas, got %v", err)
+	}
+}
+
+type linkResolver map[string]Extern
+
+func (r linkResolver) GetFunction(module, name string) HostFunction { return nil }
+func (r linkResolver) GetExtern(module, name string) Extern         { return r[name] }
+
+func TestSegmentBounds(t *testing.T) {
+	source := `(module
+  (import "env" "memory" (memory 1))
+  (import "env" "offset" (global i32))
+  (data (global.get 0) "ab")
+  (data (i32.const %d) "xy")
+  (func $start (i32.store8 (i32.const 100) (i32.const 1)))
+  (start $start))`
+	memory := NewMemory(wasm.Limits{Min: 1})
+	resolver := linkResolver{
+		"memory": memory,
+		"offset": NewGlobal(wasm.GlobalType{ValueType: wasm.ValueTypeI32}, 10),
+	}
+
+	code, err := wat.Compile([]byte(fmt.Sprintf(source, 65535)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = NewVM(code, &FreeGasPolicy{}, &Gas{}, resolver)
+	segErr, ok := err.(*SegmentError)
+	if !ok || segErr.Err != ErrDataSegmentDoesNotFit || segErr.Index != 1 || segErr.Size != 65536 {
+		t.Fatalf("Expect the second data segment not to fit the imported memory, got %v", err)
+	}
+	if b, _ := memory.ReadBytes(0, 128); !bytes.Equal(b, make([]byte, 128)) {
+		t.Error("Expect a failed instantiation to neither write segments nor run start")
+	}
+
+	code, err = wat.Compile([]byte(fmt.Sprintf(source, 65534)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewVM(code, &FreeGasPolicy{}, &Gas{}, resolver); err != nil {
+		t.Fatal(err)
+	}
+	if s, _ := memory.ReadString(10, 2); s != "ab" {
+		t.Errorf("Expect the segment at the imported global offset, got %q", s)
+	}
+	if v, _ := memory.ReadUint8(100); v != 1 {
+		t.Error("Expect start to run after the segments")
+	}
+}
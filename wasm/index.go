@@ -39,11 +39,21 @@ type Module struct {
 	ElementSec *ElementSec
 	CodeSec    *CodeSec
 	DataSec    *DataSec
+	CustomSecs []CustomSec
+	NameSec    *NameSec
 
 	FunctionIndexSpace []Function
 	GlobalIndexSpace   []Global
 
-	TableIndexSpace        [][]uint32
+	// TableIndexSpace holds the elements of the segments with a constant offset.
+	//
+	// Deprecated: the VM initializes its tables when it is instantiated, with
+	// the segments offset by imported globals too.
+	TableIndexSpace [][]uint32
+	// LinearMemoryIndexSpace holds the data of the segments with a constant offset.
+	//
+	// Deprecated: the VM initializes its memory when it is instantiated, with
+	// the segments offset by imported globals too.
 	LinearMemoryIndexSpace [][]byte
 }
 
@@ -135,16 +145,30 @@ func (m *Module) populateFunctions() error {
 		return nil
 	}
 
+	importCount := 0
+	if m.ImportSec != nil {
+		for _, entry := range m.ImportSec.Imports {
+			if entry.ImportDesc.Kind == ExternalFunction {
+				importCount++
+			}
+		}
+	}
+
 	for codeIndex, typeIndex := range m.FuncSec.TypeIndices {
 		if int(typeIndex) >= len(m.TypeSec.FuncTypes) {
 			return errors.New("Invalid function index")
 		}
 
+		name := ""
+		if m.NameSec != nil {
+			name = m.NameSec.FunctionNames[uint32(importCount+codeIndex)]
+		}
+
 		// Create the main function structure
 		fn := Function{
 			Type: m.TypeSec.FuncTypes[typeIndex],
 			Code: m.CodeSec.Codes[codeIndex],
-			Name: "",
+			Name: name,
 		}
 
 		m.FunctionIndexSpace = append(m.FunctionIndexSpace, fn)
@@ -181,89 +205,133 @@ func (m *Module) GetGlobal(i int) *Global {
 	return &m.GlobalIndexSpace[i]
 }
 
-func (m *Module) populateTables() error {
-	if m.TableSec == nil || len(m.TableSec.Tables) == 0 || m.ElementSec == nil || len(m.ElementSec.Elements) == 0 {
+// constOffset returns the offset of a segment set by an i32.const expression,
+// segments offset by a global are only known at instantiation
+func (m *Module) constOffset(expr []byte) (uint32, bool) {
+	if len(expr) == 0 || expr[0] != i32Const {
+		return 0, false
+	}
+	val, err := m.ExecInitExpr(expr)
+	if err != nil {
+		return 0, false
+	}
+	off, ok := val.(int32)
+	return uint32(off), ok
+}
+
+// importedLimits returns the limits of the imports of kind, a table or memory
+func (m *Module) importedLimits(kind byte) []Limits {
+	var limits []Limits
+	if m.ImportSec == nil {
 		return nil
 	}
+	for _, imp := range m.ImportSec.Imports {
+		switch {
+		case kind == ExternalTable && imp.ImportDesc.Kind == kind && imp.ImportDesc.Table != nil:
+			limits = append(limits, imp.ImportDesc.Table.Limits)
+		case kind == ExternalMemory && imp.ImportDesc.Kind == kind && imp.ImportDesc.Mem != nil:
+			limits = append(limits, imp.ImportDesc.Mem.Limits)
+		}
+	}
+	return limits
+}
 
-	for _, elem := range m.ElementSec.Elements {
-		// the MVP dictates that index should always be zero, we should
-		// probably check this
-		if elem.TableIdx >= uint32(len(m.TableIndexSpace)) {
-			return errors.New("Invalid Table Index")
+// populateTables writes the elements of the segments with a constant offset
+// fitting in the initial size of their table, the others are skipped as they
+// are checked by the VM at instantiation
+func (m *Module) populateTables() error {
+	if m.ElementSec == nil || len(m.ElementSec.Elements) == 0 {
+		return nil
+	}
+	limits := m.importedLimits(ExternalTable)
+	if m.TableSec != nil {
+		for _, table := range m.TableSec.Tables {
+			limits = append(limits, table.Limits)
 		}
+	}
+	m.TableIndexSpace = make([][]uint32, len(limits))
 
-		val, err := m.ExecInitExpr(elem.Init)
-		if err != nil {
-			return err
+	for _, elem := range m.ElementSec.Elements {
+		if elem.TableIdx >= uint32(len(limits)) {
+			continue
 		}
-		off, ok := val.(int32)
-		if !ok {
-			return errors.New("Invalid Value Type Init Expr")
+		offset, ok := m.constOffset(elem.Init)
+		//use uint64 to avoid overflow
+		if !ok || uint64(offset)+uint64(len(elem.Offset)) > uint64(limits[elem.TableIdx].Min) {
+			continue
 		}
-		offset := uint32(off)
 
 		table := m.TableIndexSpace[elem.TableIdx]
-		//use uint64 to avoid overflow
 		if uint64(offset)+uint64(len(elem.Offset)) > uint64(len(table)) {
 			data := make([]uint32, uint64(offset)+uint64(len(elem.Offset)))
-			copy(data[offset:], elem.Offset)
 			copy(data, table)
+			table = data
 			m.TableIndexSpace[elem.TableIdx] = data
-		} else {
-			copy(table[offset:], elem.Offset)
 		}
+		copy(table[offset:], elem.Offset)
 	}
 
 	return nil
 }
 
+// GetTableElement returns the function index at index in the first table.
+//
+// Deprecated: use the tables of the VM.
 func (m *Module) GetTableElement(index int) (uint32, error) {
-	if index >= len(m.TableIndexSpace[0]) {
+	if len(m.TableIndexSpace) == 0 || index < 0 || index >= len(m.TableIndexSpace[0]) {
 		return 0, errors.New("Invalid table index")
 	}
 
 	return m.TableIndexSpace[0][index], nil
 }
 
+// populateLinearMemory writes the data of the segments with a constant offset
+// fitting in the initial size of the memory, the others are skipped as they
+// are checked by the VM at instantiation
 func (m *Module) populateLinearMemory() error {
+	m.LinearMemoryIndexSpace = make([][]byte, 1)
 	if m.DataSec == nil || len(m.DataSec.DataSegments) == 0 {
 		return nil
 	}
 	// each module can only have a single linear memory in the MVP
+	limits := m.importedLimits(ExternalMemory)
+	if m.MemSec != nil {
+		for _, mem := range m.MemSec.Mems {
+			limits = append(limits, mem.Limits)
+		}
+	}
+	if len(limits) == 0 {
+		return nil
+	}
+	size := uint64(limits[0].Min) * 64 * 1024
 
 	for _, entry := range m.DataSec.DataSegments {
 		if entry.MemIdx != 0 {
-			return errors.New("Invalid Linear Memory Index Error")
-		}
-
-		val, err := m.ExecInitExpr(entry.Offset)
-
-		if err != nil {
-			return err
+			continue
 		}
-		off, ok := val.(int32)
-		if !ok {
-			return errors.New("InvalidValueTypeInitExprError")
+		offset, ok := m.constOffset(entry.Offset)
+		if !ok || uint64(offset)+uint64(len(entry.Init)) > size {
+			continue
 		}
-		offset := uint32(off)
 
-		memory := m.LinearMemoryIndexSpace[entry.MemIdx]
+		memory := m.LinearMemoryIndexSpace[0]
 		if uint64(offset)+uint64(len(entry.Init)) > uint64(len(memory)) {
 			data := make([]byte, uint64(offset)+uint64(len(entry.Init)))
 			copy(data, memory)
-			copy(data[offset:], entry.Init)
-			m.LinearMemoryIndexSpace[int(entry.MemIdx)] = data
-		} else {
-			copy(memory[offset:], entry.Init)
+			memory = data
+			m.LinearMemoryIndexSpace[0] = data
 		}
+		copy(memory[offset:], entry.Init)
 	}
 
 	return nil
 }
 
+// GetLinearMemoryData returns the byte at index in the memory.
+//
+// Deprecated: use the memory of the VM.
 func (m *Module) GetLinearMemoryData(index int) (byte, error) {
-	if index >= len(m.LinearMemoryIndexSpace[0]) {
+	if len(m.LinearMemoryIndexSpace) == 0 || index < 0 || index >= len(m.LinearMemoryIndexSpace[0]) {
 		return 0, errors.New("Invalid linear memory index")
 	}
 
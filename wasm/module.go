@@ -5,7 +5,10 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"math"
 	"unicode/utf8"
+
+	"github.com/vertexdlt/vertexvm/opcode"
 )
 
 // Magic represent Wasm 4-byte magic number (the string ‘\0asm’)
@@ -37,9 +40,30 @@ const ElemTypeFuncRef byte = 0x70
 // ValueType represent ValueType
 type ValueType int8
 
+// String returns the text format name of the value type
+func (t ValueType) String() string {
+	switch t {
+	case ValueTypeI32:
+		return "i32"
+	case ValueTypeI64:
+		return "i64"
+	case ValueTypeF32:
+		return "f32"
+	case ValueTypeF64:
+		return "f64"
+	}
+	return fmt.Sprintf("<unknown 0x%x>", byte(t))
+}
+
 // Mutability represent mutability
 type Mutability uint8
 
+// Global mutabilities
+const (
+	Immutable Mutability = 0x00
+	Mutable   Mutability = 0x01
+)
+
 // Import represent the Import component
 // https://webassembly.github.io/spec/core/binary/modules.html#binary-import
 type Import struct {
@@ -219,6 +243,20 @@ type DataSec struct {
 	DataSegments []Data
 }
 
+// CustomSec represent a Custom Section
+// https://webassembly.github.io/spec/core/binary/modules.html#custom-section
+type CustomSec struct {
+	Name string
+	Data []byte
+}
+
+// NameSec represent the decoded "name" custom section
+// https://webassembly.github.io/spec/core/appendix/custom.html#name-section
+type NameSec struct {
+	ModuleName    string
+	FunctionNames map[uint32]string // keyed by function index including imports
+}
+
 // ReadModule read a module from Reader r and return a constructed Module
 func ReadModule(wasmBytes []byte) (*Module, error) {
 	wr := &wasmReader{wasmBytes, 0}
@@ -243,9 +281,15 @@ func ReadModule(wasmBytes []byte) (*Module, error) {
 				return nil, err
 			}
 
-			m.LinearMemoryIndexSpace = make([][]byte, 1)
-			if m.TableSec != nil {
-				m.TableIndexSpace = make([][]uint32, int(len(m.TableSec.Tables)))
+			funcCount, codeCount := 0, 0
+			if m.FuncSec != nil {
+				funcCount = len(m.FuncSec.TypeIndices)
+			}
+			if m.CodeSec != nil {
+				codeCount = len(m.CodeSec.Codes)
+			}
+			if funcCount != codeCount {
+				return nil, errors.New("wasm: function and code section have inconsistent lengths")
 			}
 
 			for _, fn := range []func() error{
@@ -267,12 +311,12 @@ func ReadModule(wasmBytes []byte) (*Module, error) {
 func readMagic(wr *wasmReader) (err error) {
 	b, err := wr.Read(4)
 	if err != nil {
-		return err
+		return errors.New("wasm: unexpected end")
 	}
 
 	magic := binary.LittleEndian.Uint32(b)
 	if magic != Magic {
-		return errors.New("wasm: invalid magic number")
+		return errors.New("wasm: magic header not detected")
 	}
 	return nil
 }
@@ -280,12 +324,12 @@ func readMagic(wr *wasmReader) (err error) {
 func readVersion(m *Module, wr *wasmReader) (err error) {
 	b, err := wr.Read(4)
 	if err != nil {
-		return err
+		return errors.New("wasm: unexpected end")
 	}
 
 	m.Version = binary.LittleEndian.Uint32(b)
 	if m.Version != Version {
-		return errors.New("wasm: invalid version number")
+		return errors.New("wasm: unknown binary version")
 	}
 
 	return nil
@@ -299,86 +343,60 @@ func readSection(m *Module, wr *wasmReader, lastID *byte) (*byte, error) {
 
 	if lastID != nil && *lastID != 0 {
 		if *lastID >= id && id != 0 {
-			return nil, fmt.Errorf("wasm: sections must occur at most once and in the prescribed order")
+			return nil, errors.New("wasm: junk after last section")
 		}
 	}
 
 	datalen, err := wr.readLeb128Uint32()
 	if err != nil {
-		return nil, err
+		return nil, decodeError(err)
 	}
-
-	b, err := wr.Read(datalen)
-	if err != nil {
-		return nil, err
+	if datalen > uint32(len(wr.b)) {
+		return nil, errors.New("wasm: length out of bounds")
 	}
-	sectionReader := &wasmReader{b, 0}
-	// fmt.Println(id)
-	// fmt.Printf("%s", hex.Dump(sectionReader.b))
+
+	// as the spec decoder does, the content is decoded before checking its
+	// size, a malformed section may run past its end
+	start := wr.curPos
+	sectionReader := &wasmReader{wr.b, start}
 
 	switch id {
 	case 0:
-		// Skip custom section
+		err = readSectionCustom(m, sectionReader, start+datalen)
 	case 1:
-		err := readSectionType(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionType(m, sectionReader)
 	case 2:
-		err := readSectionImport(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionImport(m, sectionReader)
 	case 3:
-		err := readSectionFunction(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionFunction(m, sectionReader)
 	case 4:
-		err := readSectionTable(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionTable(m, sectionReader)
 	case 5:
-		err := readSectionMemory(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionMemory(m, sectionReader)
 	case 6:
-		err := readSectionGlobal(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionGlobal(m, sectionReader)
 	case 7:
-		err := readSectionExport(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionExport(m, sectionReader)
 	case 8:
-		err := readSectionStart(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionStart(m, sectionReader)
 	case 9:
-		err := readSectionElement(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionElement(m, sectionReader)
 	case 10:
-		err := readSectionCode(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionCode(m, sectionReader)
 	case 11:
-		err := readSectionData(m, sectionReader)
-		if err != nil {
-			return nil, err
-		}
+		err = readSectionData(m, sectionReader)
 	default:
-		return nil, fmt.Errorf("wasm: read section error - unknown section id %d", id)
+		return nil, fmt.Errorf("wasm: malformed section id %d", id)
+	}
+	if err != nil {
+		return nil, decodeError(err)
 	}
+	if sectionReader.curPos-start != datalen {
+		return nil, fmt.Errorf("wasm: section size mismatch in section %d", id)
+	}
+	wr.curPos = sectionReader.curPos
 
-	return &id, err
+	return &id, nil
 }
 
 func readSectionType(m *Module, wr *wasmReader) error {
@@ -390,12 +408,13 @@ func readSectionType(m *Module, wr *wasmReader) error {
 	m.TypeSec = &TypeSec{}
 	m.TypeSec.FuncTypes = make([]FuncType, vectorLen)
 	for i := uint32(0); i < vectorLen; i++ {
-		funcTypeForm, err := wr.ReadOne()
+		// the form is a signed LEB128 byte
+		funcTypeForm, err := wr.readLeb128(7, true)
 		if err != nil {
 			return err
 		}
-		if funcTypeForm != FuncTypeForm {
-			return errors.New("wasm: invalid functype signature byte")
+		if byte(funcTypeForm)&0x7f != FuncTypeForm {
+			return errors.New("wasm: malformed function type")
 		}
 
 		paramTypesCount, err := wr.readLeb128Uint32()
@@ -483,7 +502,7 @@ func readSectionImport(m *Module, wr *wasmReader) error {
 			}
 			importDesc.GlobalType = &globalType
 		default:
-			return fmt.Errorf("wasm: invalid external kind %v", kind)
+			return fmt.Errorf("wasm: malformed import kind %d", kind)
 		}
 
 		importDesc.Kind = kind
@@ -593,7 +612,7 @@ func readSectionExport(m *Module, wr *wasmReader) error {
 			return err
 		}
 		if b != 0x00 && b != 0x01 && b != 0x02 && b != 0x03 {
-			return errors.New("wasm: invalid export desc flag")
+			return fmt.Errorf("wasm: malformed export kind %d", b)
 		}
 
 		export.Desc.Kind = b
@@ -602,6 +621,9 @@ func readSectionExport(m *Module, wr *wasmReader) error {
 			return err
 		}
 
+		if _, ok := m.ExportSec.ExportMap[export.Name]; ok {
+			return fmt.Errorf("wasm: duplicate export name %q", export.Name)
+		}
 		m.ExportSec.ExportMap[export.Name] = export
 	}
 
@@ -666,25 +688,97 @@ func readSectionCode(m *Module, wr *wasmReader) error {
 			return err
 		}
 
-		codeBody, err := wr.Read(size)
+		// as for sections, the body is decoded before checking its size
+		start := wr.curPos
+		m.CodeSec.Codes[i].Locals, err = readLocals(wr)
 		if err != nil {
 			return err
 		}
 
-		code := &wasmReader{codeBody, 0}
-		m.CodeSec.Codes[i].Locals, err = readLocals(code)
+		m.CodeSec.Codes[i].Exprs, err = readBody(wr)
 		if err != nil {
 			return err
 		}
-
-		exprs := code.copyAll()
-		m.CodeSec.Codes[i].Exprs = exprs[:len(exprs)-1]
+		if wr.curPos-start != size {
+			return errors.New("wasm: section size mismatch")
+		}
 		m.CodeSec.Codes[i].Size = size
 	}
 
 	return nil
 }
 
+// readBody decodes the instructions of a function body up to the end closing
+// it and returns them without that end, their immediates must be well formed
+func readBody(wr *wasmReader) ([]byte, error) {
+	start, depth := wr.curPos, 0
+	for {
+		b, err := wr.ReadOne()
+		if err != nil {
+			return nil, errUnexpectedEnd
+		}
+		op := opcode.Opcode(b)
+		switch op {
+		case opcode.Block, opcode.Loop, opcode.If:
+			depth++
+		case opcode.End:
+			if depth == 0 {
+				return wr.b[start : wr.curPos-1], nil
+			}
+			depth--
+		}
+		info := op.Info()
+		if info == nil {
+			return nil, fmt.Errorf("wasm: illegal opcode 0x%02x", b)
+		}
+		for _, imm := range info.Immediates {
+			if err := readImmediate(wr, imm); err != nil {
+				return nil, decodeError(err)
+			}
+		}
+	}
+}
+
+// readImmediate moves past an immediate of an instruction
+func readImmediate(wr *wasmReader, imm opcode.Immediate) error {
+	var err error
+	switch imm {
+	case opcode.ImmBlockType:
+		var b byte
+		if b, err = wr.ReadOne(); err == nil && uint32(b) != BlockTypeEmpty {
+			wr.curPos--
+			_, err = readValueType(wr)
+		}
+	case opcode.ImmLabelTable:
+		var count uint32
+		if count, err = wr.readLeb128Uint32(); err == nil {
+			for i := uint64(0); i <= uint64(count) && err == nil; i++ {
+				_, err = wr.readLeb128Uint32()
+			}
+		}
+	case opcode.ImmMemArg:
+		if _, err = wr.readLeb128Uint32(); err == nil {
+			_, err = wr.readLeb128Uint32()
+		}
+	case opcode.ImmReserved:
+		var b byte
+		if b, err = wr.ReadOne(); err == nil && b != 0 {
+			err = errors.New("wasm: zero flag expected")
+		}
+	case opcode.ImmI32:
+		_, err = wr.readLeb128Int32()
+	case opcode.ImmI64:
+		_, err = wr.readLeb128Int64()
+	case opcode.ImmF32:
+		_, err = wr.Read(4)
+	case opcode.ImmF64:
+		_, err = wr.Read(8)
+	default: // label, function, type, local and global indexes and sub opcodes
+		_, err = wr.readLeb128Uint32()
+	}
+	return err
+}
+
 func readSectionData(m *Module, wr *wasmReader) error {
 	dataCount, err := wr.readLeb128Uint32()
 	if err != nil {
@@ -717,6 +811,78 @@ func readSectionData(m *Module, wr *wasmReader) error {
 	return nil
 }
 
+// readSectionCustom reads a custom section ending at end
+func readSectionCustom(m *Module, wr *wasmReader, end uint32) error {
+	name, err := readName(wr)
+	if err != nil {
+		return err
+	}
+	if wr.curPos > end {
+		return errUnexpectedEnd
+	}
+
+	data, err := wr.Read(end - wr.curPos)
+	if err != nil {
+		return err
+	}
+	m.CustomSecs = append(m.CustomSecs, CustomSec{Name: name, Data: data})
+	if name == "name" && m.NameSec == nil {
+		// the name section is debug information, a malformed one does not invalidate the module
+		if nameSec, err := readNameSection(&wasmReader{data, 0}); err == nil {
+			m.NameSec = nameSec
+		}
+	}
+	return nil
+}
+
+func readNameSection(wr *wasmReader) (*NameSec, error) {
+	nameSec := &NameSec{FunctionNames: make(map[uint32]string)}
+	for {
+		id, err := wr.ReadOne()
+		if err == io.EOF {
+			return nameSec, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		size, err := wr.readLeb128Uint32()
+		if err != nil {
+			return nil, err
+		}
+
+		b, err := wr.Read(size)
+		if err != nil {
+			return nil, err
+		}
+		subsection := &wasmReader{b, 0}
+
+		switch id {
+		case 0:
+			nameSec.ModuleName, err = readName(subsection)
+			if err != nil {
+				return nil, err
+			}
+		case 1:
+			count, err := subsection.readLeb128Uint32()
+			if err != nil {
+				return nil, err
+			}
+			for i := uint32(0); i < count; i++ {
+				idx, err := subsection.readLeb128Uint32()
+				if err != nil {
+					return nil, err
+				}
+				nameSec.FunctionNames[idx], err = readName(subsection)
+				if err != nil {
+					return nil, err
+				}
+			}
+		default:
+			// Skip local names and unknown subsections
+		}
+	}
+}
+
 func readElemType(wr *wasmReader) (byte, error) {
 	var elemType byte
 	elemType, err := wr.ReadOne()
@@ -738,10 +904,12 @@ func readLimits(wr *wasmReader) (Limits, error) {
 		limits Limits
 		err    error
 	)
-	limits.Flag, err = wr.ReadOne()
+	// the flag is a 1-bit LEB128 integer
+	flag, err := wr.readLeb128(1, false)
 	if err != nil {
 		return limits, err
 	}
+	limits.Flag = byte(flag)
 
 	switch limits.Flag {
 	case 0x00:
@@ -758,8 +926,6 @@ func readLimits(wr *wasmReader) (Limits, error) {
 		if err != nil {
 			return limits, err
 		}
-	default:
-		return limits, errors.New("wasm: invalid limits flag")
 	}
 
 	return limits, nil
@@ -791,7 +957,7 @@ func readMut(wr *wasmReader) (Mutability, error) {
 		return res, err
 	}
 	if b != 0x00 && b != 0x01 {
-		return res, errors.New("wasm: invalid mutability flag")
+		return res, errors.New("wasm: malformed mutability")
 	}
 
 	res = Mutability(b)
@@ -805,7 +971,7 @@ func readValueType(wr *wasmReader) (ValueType, error) {
 		return res, err
 	}
 	if b != 0x7F && b != 0x7E && b != 0x7D && b != 0x7C {
-		return res, errors.New("wasm: invalid value type")
+		return res, errors.New("wasm: malformed value type")
 	}
 	res = ValueType(b)
 	return res, nil
@@ -817,12 +983,16 @@ func readName(wr *wasmReader) (string, error) {
 		return "", err
 	}
 
+	// as in the spec decoder, the length is bounded by the whole module
+	if byteLen > uint32(len(wr.b)) {
+		return "", errors.New("wasm: length out of bounds")
+	}
 	bytes, err := wr.Read(byteLen)
 	if err != nil {
 		return "", err
 	}
 	if !utf8.Valid(bytes) {
-		return "", errors.New("wasm: invalid utf-8 string")
+		return "", errors.New("wasm: malformed UTF-8 encoding")
 	}
 	return string(bytes), nil
 }
@@ -834,11 +1004,16 @@ func readLocals(wr *wasmReader) ([]Local, error) {
 	}
 
 	locals := make([]Local, localCount)
+	total := uint64(0)
 	for i := uint32(0); i < localCount; i++ {
 		locals[i].Count, err = wr.readLeb128Uint32()
 		if err != nil {
 			return locals, err
 		}
+		total += uint64(locals[i].Count)
+		if total > math.MaxUint32 {
+			return locals, errors.New("wasm: too many locals")
+		}
 
 		locals[i].ValueType, err = readValueType(wr)
 		if err != nil {
@@ -1,11 +1,33 @@
 package wasm
 
 import (
+	"errors"
 	"io"
 
 	"github.com/vertexdlt/vertexvm/leb128"
 )
 
+// lebMessages are the spec messages of the LEB128 decoding errors
+var lebMessages = map[error]string{
+	leb128.ErrUnexpectedEnd: "unexpected end",
+	leb128.ErrTooLong:       "integer representation too long",
+	leb128.ErrTooLarge:      "integer too large",
+}
+
+// errUnexpectedEnd is reported when a section or function body ends early
+var errUnexpectedEnd = errors.New("wasm: unexpected end of section or function")
+
+// decodeError converts the errors of the readers to the spec messages
+func decodeError(err error) error {
+	if err == io.EOF || err == leb128.ErrUnexpectedEnd {
+		return errUnexpectedEnd
+	}
+	if msg, ok := lebMessages[err]; ok {
+		return errors.New("wasm: " + msg)
+	}
+	return err
+}
+
 type wasmReader struct {
 	b      []byte
 	curPos uint32
@@ -31,8 +53,15 @@ func (wr *wasmReader) ReadOne() (b byte, err error) {
 	return b, nil
 }
 
-func (wr *wasmReader) copyAll() (b []byte) {
-	return wr.b[wr.curPos:len(wr.b)]
+// readLeb128 reads a LEB128 integer of maxbit bits
+func (wr *wasmReader) readLeb128(maxbit uint32, signed bool) (int64, error) {
+	bytecnt, res, err := leb128.Read(wr.b[wr.curPos:], maxbit, signed)
+	if err != nil {
+		return 0, err
+	}
+
+	wr.curPos += bytecnt
+	return res, nil
 }
 
 func (wr *wasmReader) readLeb128Uint32() (uint32, error) {
@@ -0,0 +1,740 @@
+package wasm
+
+import (
+	"fmt"
+
+	"github.com/vertexdlt/vertexvm/leb128"
+	"github.com/vertexdlt/vertexvm/opcode"
+)
+
+// MaxPages is the maximum number of 64KiB pages of a linear memory
+const MaxPages = 65536
+
+// valueTypeUnknown is the type of operands popped from an unreachable stack
+const valueTypeUnknown ValueType = 0
+
+// ValidationError reports why a module is invalid
+type ValidationError struct {
+	Func   int // function index including imports, -1 outside of code
+	Offset int // offset in the function code
+	Msg    string
+}
+
+func (e *ValidationError) Error() string {
+	if e.Func < 0 {
+		return "wasm: invalid module: " + e.Msg
+	}
+	return fmt.Sprintf("wasm: invalid function %d at offset %d: %s", e.Func, e.Offset, e.Msg)
+}
+
+func invalid(format string, args ...interface{}) error {
+	return &ValidationError{Func: -1, Msg: fmt.Sprintf(format, args...)}
+}
+
+type moduleContext struct {
+	types           []FuncType
+	funcs           []uint32 // type index of every function including imports
+	globals         []GlobalType
+	importedGlobals int
+	tables          int
+	mems            int
+}
+
+// Validate checks the module is well-typed
+// https://webassembly.github.io/spec/core/valid/index.html
+func (m *Module) Validate() error {
+	c := &moduleContext{}
+	if m.TypeSec != nil {
+		c.types = m.TypeSec.FuncTypes
+		for _, ft := range c.types {
+			if len(ft.ReturnTypes) > 1 {
+				return invalid("invalid result arity")
+			}
+		}
+	}
+	if m.ImportSec != nil {
+		for _, entry := range m.ImportSec.Imports {
+			desc := entry.ImportDesc
+			switch desc.Kind {
+			case ExternalFunction:
+				if int(desc.TypeIdx) >= len(c.types) {
+					return invalid("unknown type %d", desc.TypeIdx)
+				}
+				c.funcs = append(c.funcs, desc.TypeIdx)
+			case ExternalTable:
+				if err := validateLimits(desc.Table.Limits, 0); err != nil {
+					return err
+				}
+				c.tables++
+			case ExternalMemory:
+				if err := validateLimits(desc.Mem.Limits, MaxPages); err != nil {
+					return err
+				}
+				c.mems++
+			case ExternalGlobalType:
+				c.globals = append(c.globals, *desc.GlobalType)
+				c.importedGlobals++
+			}
+		}
+	}
+	if m.FuncSec != nil {
+		for _, typeIdx := range m.FuncSec.TypeIndices {
+			if int(typeIdx) >= len(c.types) {
+				return invalid("unknown type %d", typeIdx)
+			}
+			c.funcs = append(c.funcs, typeIdx)
+		}
+	}
+	if m.TableSec != nil {
+		for _, table := range m.TableSec.Tables {
+			if err := validateLimits(table.Limits, 0); err != nil {
+				return err
+			}
+			c.tables++
+		}
+	}
+	if c.tables > 1 {
+		return invalid("multiple tables")
+	}
+	if m.MemSec != nil {
+		for _, mem := range m.MemSec.Mems {
+			if err := validateLimits(mem.Limits, MaxPages); err != nil {
+				return err
+			}
+			c.mems++
+		}
+	}
+	if c.mems > 1 {
+		return invalid("multiple memories")
+	}
+	if m.GlobalSec != nil {
+		for _, global := range m.GlobalSec.Globals {
+			if err := c.validateConstExpr(global.Init, global.Type.ValueType); err != nil {
+				return err
+			}
+			c.globals = append(c.globals, global.Type)
+		}
+	}
+	if m.ExportSec != nil {
+		for name, export := range m.ExportSec.ExportMap {
+			var count int
+			switch export.Desc.Kind {
+			case ExternalFunction:
+				count = len(c.funcs)
+			case ExternalTable:
+				count = c.tables
+			case ExternalMemory:
+				count = c.mems
+			case ExternalGlobalType:
+				count = len(c.globals)
+			}
+			if int(export.Desc.Idx) >= count {
+				return invalid("unknown %s %d exported as %q", externalKindNames[export.Desc.Kind], export.Desc.Idx, name)
+			}
+		}
+	}
+	if m.StartSec != nil {
+		if int(m.StartSec.FuncIdx) >= len(c.funcs) {
+			return invalid("unknown function %d", m.StartSec.FuncIdx)
+		}
+		sig := c.types[c.funcs[m.StartSec.FuncIdx]]
+		if len(sig.ParamTypes) != 0 || len(sig.ReturnTypes) != 0 {
+			return invalid("start function must not take arguments or return results")
+		}
+	}
+	if m.ElementSec != nil {
+		for _, elem := range m.ElementSec.Elements {
+			if int(elem.TableIdx) >= c.tables {
+				return invalid("unknown table %d", elem.TableIdx)
+			}
+			if err := c.validateConstExpr(elem.Init, ValueTypeI32); err != nil {
+				return err
+			}
+			for _, fidx := range elem.Offset {
+				if int(fidx) >= len(c.funcs) {
+					return invalid("unknown function %d", fidx)
+				}
+			}
+		}
+	}
+	if m.DataSec != nil {
+		for _, data := range m.DataSec.DataSegments {
+			if int(data.MemIdx) >= c.mems {
+				return invalid("unknown memory %d", data.MemIdx)
+			}
+			if err := c.validateConstExpr(data.Offset, ValueTypeI32); err != nil {
+				return err
+			}
+		}
+	}
+	importedFuncs := len(c.funcs) - len(m.FunctionIndexSpace)
+	for i := range m.FunctionIndexSpace {
+		if err := c.validateFunction(importedFuncs+i, &m.FunctionIndexSpace[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+var externalKindNames = map[byte]string{
+	ExternalFunction:   "function",
+	ExternalTable:      "table",
+	ExternalMemory:     "memory",
+	ExternalGlobalType: "global",
+}
+
+// validateLimits checks min does not exceed max, nor bound when it is not zero
+func validateLimits(limits Limits, bound uint32) error {
+	if bound != 0 && (limits.Min > bound || (limits.Flag == 1 && limits.Max > bound)) {
+		return invalid("memory size must be at most %d pages (4GiB)", bound)
+	}
+	if limits.Flag == 1 && limits.Min > limits.Max {
+		return invalid("size minimum must not be greater than maximum")
+	}
+	return nil
+}
+
+// validateConstExpr checks expr is a single constant instruction of type t
+// followed by end, only imported immutable globals are constant
+func (c *moduleContext) validateConstExpr(expr []byte, t ValueType) error {
+	wr := &wasmReader{expr, 0}
+	op, err := wr.ReadOne()
+	if err != nil {
+		return invalid("type mismatch")
+	}
+	var actual ValueType
+	switch op {
+	case i32Const:
+		_, err = wr.readLeb128Int32()
+		actual = ValueTypeI32
+	case i64Const:
+		_, err = wr.readLeb128Int64()
+		actual = ValueTypeI64
+	case f32Const:
+		_, err = wr.Read(4)
+		actual = ValueTypeF32
+	case f64Const:
+		_, err = wr.Read(8)
+		actual = ValueTypeF64
+	case getGlobal:
+		var index uint32
+		index, err = wr.readLeb128Uint32()
+		if err == nil {
+			if int(index) >= c.importedGlobals {
+				return invalid("unknown global %d", index)
+			}
+			if c.globals[index].Mutability != Immutable {
+				return invalid("constant expression required")
+			}
+			actual = c.globals[index].ValueType
+		}
+	case end:
+		return invalid("type mismatch")
+	default:
+		return invalid("constant expression required")
+	}
+	if err != nil {
+		return decodeError(err)
+	}
+	if b, err := wr.ReadOne(); err != nil || b != end {
+		switch {
+		case err != nil:
+			return decodeError(err)
+		case b == i32Const || b == i64Const || b == f32Const || b == f64Const || b == getGlobal:
+			return invalid("type mismatch") // a second constant
+		}
+		return invalid("constant expression required")
+	}
+	if actual != t {
+		return invalid("type mismatch")
+	}
+	return nil
+}
+
+type ctrlFrame struct {
+	op          opcode.Opcode
+	results     []ValueType
+	height      int
+	unreachable bool
+}
+
+// labelTypes are the operands a branch to the frame takes
+func (f *ctrlFrame) labelTypes() []ValueType {
+	if f.op == opcode.Loop {
+		return nil
+	}
+	return f.results
+}
+
+type funcContext struct {
+	*moduleContext
+	fidx   int
+	locals []Local // params as runs of one followed by the declared locals
+	code   []byte
+	pos    int
+	start  int // offset of the instruction being validated
+	vals   []ValueType
+	ctrls  []ctrlFrame
+}
+
+// validateFunction type checks the code of function fidx
+// https://webassembly.github.io/spec/core/appendix/algorithm.html
+func (c *moduleContext) validateFunction(fidx int, fn *Function) error {
+	fc := &funcContext{moduleContext: c, fidx: fidx, code: fn.Code.Exprs}
+	for _, t := range fn.Type.ParamTypes {
+		fc.locals = append(fc.locals, Local{Count: 1, ValueType: t})
+	}
+	fc.locals = append(fc.locals, fn.Code.Locals...)
+	fc.pushCtrl(opcode.Block, fn.Type.ReturnTypes)
+	for fc.pos < len(fc.code) {
+		if len(fc.ctrls) == 0 {
+			return fc.errorf("operators remaining after end of function")
+		}
+		fc.start = fc.pos
+		if err := fc.validateInstruction(); err != nil {
+			return err
+		}
+	}
+	if len(fc.ctrls) != 1 {
+		return fc.errorf("unexpected end of function")
+	}
+	_, err := fc.popCtrl()
+	return err
+}
+
+func (fc *funcContext) errorf(format string, args ...interface{}) error {
+	return &ValidationError{Func: fc.fidx, Offset: fc.start, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (fc *funcContext) pushVal(t ValueType) {
+	fc.vals = append(fc.vals, t)
+}
+
+func (fc *funcContext) pushVals(types []ValueType) {
+	fc.vals = append(fc.vals, types...)
+}
+
+func (fc *funcContext) popVal() (ValueType, error) {
+	frame := &fc.ctrls[len(fc.ctrls)-1]
+	if len(fc.vals) == frame.height {
+		if frame.unreachable {
+			return valueTypeUnknown, nil
+		}
+		return 0, fc.errorf("type mismatch")
+	}
+	t := fc.vals[len(fc.vals)-1]
+	fc.vals = fc.vals[:len(fc.vals)-1]
+	return t, nil
+}
+
+func (fc *funcContext) popExpect(expected ValueType) (ValueType, error) {
+	actual, err := fc.popVal()
+	if err != nil {
+		return 0, err
+	}
+	if actual == valueTypeUnknown {
+		return expected, nil
+	}
+	if expected != valueTypeUnknown && actual != expected {
+		return 0, fc.errorf("type mismatch")
+	}
+	return actual, nil
+}
+
+func (fc *funcContext) popVals(types []ValueType) error {
+	for i := len(types) - 1; i >= 0; i-- {
+		if _, err := fc.popExpect(types[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (fc *funcContext) pushCtrl(op opcode.Opcode, results []ValueType) {
+	fc.ctrls = append(fc.ctrls, ctrlFrame{op: op, results: results, height: len(fc.vals)})
+}
+
+func (fc *funcContext) popCtrl() (ctrlFrame, error) {
+	if len(fc.ctrls) == 0 {
+		return ctrlFrame{}, fc.errorf("unexpected end")
+	}
+	frame := fc.ctrls[len(fc.ctrls)-1]
+	if err := fc.popVals(frame.results); err != nil {
+		return frame, err
+	}
+	if len(fc.vals) != frame.height {
+		return frame, fc.errorf("type mismatch")
+	}
+	fc.ctrls = fc.ctrls[:len(fc.ctrls)-1]
+	return frame, nil
+}
+
+// setUnreachable marks the rest of the current block as unreachable
+func (fc *funcContext) setUnreachable() {
+	frame := &fc.ctrls[len(fc.ctrls)-1]
+	fc.vals = fc.vals[:frame.height]
+	frame.unreachable = true
+}
+
+func (fc *funcContext) label(depth uint32) (*ctrlFrame, error) {
+	if int(depth) >= len(fc.ctrls) {
+		return nil, fc.errorf("unknown label %d", depth)
+	}
+	return &fc.ctrls[len(fc.ctrls)-1-int(depth)], nil
+}
+
+func (fc *funcContext) local(index uint32) (ValueType, error) {
+	n := uint64(index)
+	for _, entry := range fc.locals {
+		if n < uint64(entry.Count) {
+			return entry.ValueType, nil
+		}
+		n -= uint64(entry.Count)
+	}
+	return 0, fc.errorf("unknown local %d", index)
+}
+
+func (fc *funcContext) readByte() (byte, error) {
+	if fc.pos >= len(fc.code) {
+		return 0, fc.errorf("unexpected end")
+	}
+	b := fc.code[fc.pos]
+	fc.pos++
+	return b, nil
+}
+
+func (fc *funcContext) readU32() (uint32, error) {
+	n, v, err := leb128.ReadUint32(fc.code[fc.pos:])
+	if err != nil {
+		return 0, fc.errorf("%s", lebMessages[err])
+	}
+	fc.pos += int(n)
+	return v, nil
+}
+
+// skipImmediate moves past a constant immediate after checking it is well formed
+func (fc *funcContext) skipImmediate(imm opcode.Immediate) error {
+	var err error
+	switch imm {
+	case opcode.ImmI32:
+		var n uint32
+		n, _, err = leb128.ReadInt32(fc.code[fc.pos:])
+		fc.pos += int(n)
+	case opcode.ImmI64:
+		var n uint32
+		n, _, err = leb128.ReadInt64(fc.code[fc.pos:])
+		fc.pos += int(n)
+	case opcode.ImmF32, opcode.ImmF64:
+		size := 4
+		if imm == opcode.ImmF64 {
+			size = 8
+		}
+		if fc.pos+size > len(fc.code) {
+			return fc.errorf("unexpected end")
+		}
+		fc.pos += size
+	}
+	if err != nil {
+		return fc.errorf("%s", lebMessages[err])
+	}
+	return nil
+}
+
+func (fc *funcContext) readBlockType() ([]ValueType, error) {
+	b, err := fc.readByte()
+	if err != nil {
+		return nil, err
+	}
+	switch ValueType(b) {
+	case ValueTypeI32, ValueTypeI64, ValueTypeF32, ValueTypeF64:
+		return []ValueType{ValueType(b)}, nil
+	}
+	if uint32(b) != BlockTypeEmpty {
+		return nil, fc.errorf("invalid block type 0x%02x", b)
+	}
+	return nil, nil
+}
+
+func (fc *funcContext) readReserved() error {
+	b, err := fc.readByte()
+	if err != nil {
+		return err
+	}
+	if b != 0 {
+		return fc.errorf("zero flag expected")
+	}
+	return nil
+}
+
+// readMemArg reads the alignment and offset of an access of size bytes
+func (fc *funcContext) readMemArg(size int) error {
+	if err := fc.checkMemory(); err != nil {
+		return err
+	}
+	align, err := fc.readU32()
+	if err != nil {
+		return err
+	}
+	if align >= 32 || 1<<align > size {
+		return fc.errorf("alignment must not be larger than natural")
+	}
+	_, err = fc.readU32()
+	return err
+}
+
+func (fc *funcContext) checkMemory() error {
+	if fc.mems == 0 {
+		return fc.errorf("unknown memory 0")
+	}
+	return nil
+}
+
+func (fc *funcContext) validateInstruction() error {
+	b, err := fc.readByte()
+	if err != nil {
+		return err
+	}
+	op := opcode.Opcode(b)
+	info := op.Info()
+	if info == nil {
+		return fc.errorf("illegal opcode 0x%02x", b)
+	}
+	switch op {
+	case opcode.Unreachable:
+		fc.setUnreachable()
+	case opcode.Block, opcode.Loop, opcode.If:
+		results, err := fc.readBlockType()
+		if err != nil {
+			return err
+		}
+		if op == opcode.If {
+			if _, err := fc.popExpect(ValueTypeI32); err != nil {
+				return err
+			}
+		}
+		fc.pushCtrl(op, results)
+	case opcode.Else:
+		frame, err := fc.popCtrl()
+		if err != nil {
+			return err
+		}
+		if frame.op != opcode.If {
+			return fc.errorf("else without matching if")
+		}
+		fc.pushCtrl(opcode.Else, frame.results)
+	case opcode.End:
+		frame, err := fc.popCtrl()
+		if err != nil {
+			return err
+		}
+		if frame.op == opcode.If && len(frame.results) != 0 {
+			return fc.errorf("type mismatch")
+		}
+		fc.pushVals(frame.results)
+	case opcode.Br, opcode.BrIf:
+		depth, err := fc.readU32()
+		if err != nil {
+			return err
+		}
+		frame, err := fc.label(depth)
+		if err != nil {
+			return err
+		}
+		if op == opcode.BrIf {
+			if _, err := fc.popExpect(ValueTypeI32); err != nil {
+				return err
+			}
+		}
+		if err := fc.popVals(frame.labelTypes()); err != nil {
+			return err
+		}
+		if op == opcode.Br {
+			fc.setUnreachable()
+		} else {
+			fc.pushVals(frame.labelTypes())
+		}
+	case opcode.BrTable:
+		count, err := fc.readU32()
+		if err != nil {
+			return err
+		}
+		depths := make([]uint32, 0, 16)
+		for i := uint64(0); i <= uint64(count); i++ {
+			depth, err := fc.readU32()
+			if err != nil {
+				return err
+			}
+			depths = append(depths, depth)
+		}
+		target, err := fc.label(depths[len(depths)-1])
+		if err != nil {
+			return err
+		}
+		arity := target.labelTypes()
+		for _, depth := range depths[:len(depths)-1] {
+			frame, err := fc.label(depth)
+			if err != nil {
+				return err
+			}
+			if !equalTypes(frame.labelTypes(), arity) {
+				return fc.errorf("type mismatch")
+			}
+		}
+		if _, err := fc.popExpect(ValueTypeI32); err != nil {
+			return err
+		}
+		if err := fc.popVals(arity); err != nil {
+			return err
+		}
+		fc.setUnreachable()
+	case opcode.Return:
+		if err := fc.popVals(fc.ctrls[0].results); err != nil {
+			return err
+		}
+		fc.setUnreachable()
+	case opcode.Call:
+		fidx, err := fc.readU32()
+		if err != nil {
+			return err
+		}
+		if int(fidx) >= len(fc.funcs) {
+			return fc.errorf("unknown function %d", fidx)
+		}
+		return fc.applySignature(&fc.types[fc.funcs[fidx]])
+	case opcode.CallIndirect:
+		typeIdx, err := fc.readU32()
+		if err != nil {
+			return err
+		}
+		if err := fc.readReserved(); err != nil {
+			return err
+		}
+		if fc.tables == 0 {
+			return fc.errorf("unknown table 0")
+		}
+		if int(typeIdx) >= len(fc.types) {
+			return fc.errorf("unknown type %d", typeIdx)
+		}
+		if _, err := fc.popExpect(ValueTypeI32); err != nil {
+			return err
+		}
+		return fc.applySignature(&fc.types[typeIdx])
+	case opcode.Drop:
+		_, err := fc.popVal()
+		return err
+	case opcode.Select:
+		if _, err := fc.popExpect(ValueTypeI32); err != nil {
+			return err
+		}
+		t1, err := fc.popVal()
+		if err != nil {
+			return err
+		}
+		t2, err := fc.popExpect(t1)
+		if err != nil {
+			return err
+		}
+		fc.pushVal(t2)
+	case opcode.GetLocal, opcode.SetLocal, opcode.TeeLocal:
+		index, err := fc.readU32()
+		if err != nil {
+			return err
+		}
+		t, err := fc.local(index)
+		if err != nil {
+			return err
+		}
+		if op != opcode.GetLocal {
+			if _, err := fc.popExpect(t); err != nil {
+				return err
+			}
+		}
+		if op != opcode.SetLocal {
+			fc.pushVal(t)
+		}
+	case opcode.GetGlobal, opcode.SetGlobal:
+		index, err := fc.readU32()
+		if err != nil {
+			return err
+		}
+		if int(index) >= len(fc.globals) {
+			return fc.errorf("unknown global %d", index)
+		}
+		global := fc.globals[index]
+		if op == opcode.GetGlobal {
+			fc.pushVal(global.ValueType)
+			return nil
+		}
+		if global.Mutability == Immutable {
+			return fc.errorf("global is immutable")
+		}
+		_, err = fc.popExpect(global.ValueType)
+		return err
+	case opcode.MemorySize, opcode.MemoryGrow:
+		if err := fc.readReserved(); err != nil {
+			return err
+		}
+		if err := fc.checkMemory(); err != nil {
+			return err
+		}
+		return fc.applyInfo(info)
+	case opcode.ITruncSatF:
+		subop, err := fc.readU32()
+		if err != nil {
+			return err
+		}
+		info = opcode.SubInfo(subop)
+		if info == nil {
+			return fc.errorf("illegal opcode 0xfc 0x%02x", subop)
+		}
+		return fc.applyInfo(info)
+	default:
+		if info.Polymorphic {
+			return fc.errorf("illegal opcode 0x%02x", b)
+		}
+		for _, imm := range info.Immediates {
+			if imm == opcode.ImmMemArg {
+				err = fc.readMemArg(op.MemAccessSize())
+			} else {
+				err = fc.skipImmediate(imm)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return fc.applyInfo(info)
+	}
+	return nil
+}
+
+func (fc *funcContext) applyInfo(info *opcode.Info) error {
+	for i := len(info.Params) - 1; i >= 0; i-- {
+		if _, err := fc.popExpect(ValueType(info.Params[i])); err != nil {
+			return err
+		}
+	}
+	for _, t := range info.Results {
+		fc.pushVal(ValueType(t))
+	}
+	return nil
+}
+
+func (fc *funcContext) applySignature(sig *FuncType) error {
+	if err := fc.popVals(sig.ParamTypes); err != nil {
+		return err
+	}
+	fc.pushVals(sig.ReturnTypes)
+	return nil
+}
+
+func equalTypes(a, b []ValueType) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
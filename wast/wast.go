@@ -0,0 +1,414 @@
+// Package wast runs WebAssembly spec test scripts.
+//
+// Scripts are sequences of modules, actions and assertions as described in
+// https://github.com/WebAssembly/spec/tree/master/interpreter#scripts. Modules
+// are compiled with the wat package and instantiated in VMs linked to the
+// modules registered by the script and to the spectest module.
+package wast
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"strings"
+
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wasm"
+	"github.com/vertexdlt/vertexvm/wat"
+)
+
+// spectestSource is the host module the spec scripts import from
+const spectestSource = `(module
+  (global (export "global_i32") i32 (i32.const 666))
+  (global (export "global_i64") i64 (i64.const 666))
+  (global (export "global_f32") f32 (f32.const 666.6))
+  (global (export "global_f64") f64 (f64.const 666.6))
+  (table (export "table") 10 20 funcref)
+  (memory (export "memory") 1 2)
+  (func (export "print"))
+  (func (export "print_i32") (param i32))
+  (func (export "print_i64") (param i64))
+  (func (export "print_f32") (param f32))
+  (func (export "print_f64") (param f64))
+  (func (export "print_i32_f32") (param i32 f32))
+  (func (export "print_f64_f64") (param f64 f64)))`
+
+// trapAliases maps spec trap messages to the VM errors wording them differently
+var trapAliases = map[string][]string{
+	"undefined element":    {vm.ErrOutOfBoundTableAccess.Error()},
+	"call stack exhausted": {vm.ErrFrameOverflow.Error(), vm.ErrStackOverflow.Error()},
+}
+
+// Failure is a script command which did not behave as asserted
+type Failure struct {
+	Line    int
+	Command string
+	Err     error
+}
+
+func (f *Failure) Error() string {
+	return fmt.Sprintf("line %d: %s: %v", f.Line, f.Command, f.Err)
+}
+
+// Result summarises a script run
+type Result struct {
+	Commands int // commands run
+	Skipped  int
+	Failures []*Failure
+}
+
+// Reporter receives the failures of Check, *testing.T implements it
+type Reporter interface {
+	Helper()
+	Errorf(format string, args ...interface{})
+}
+
+// Runner runs scripts, every run starts with only the spectest module registered
+type Runner struct {
+	// Skip tells if the command starting at line is skipped
+	Skip func(line int) bool
+	// Config is the configuration of the instantiated modules
+	Config vm.Config
+
+	modules    map[string]*vm.VM // instances named by the script
+	registered linker
+	current    *vm.VM
+}
+
+// RunFile runs the script file at path
+func (r *Runner) RunFile(path string) (*Result, error) {
+	src, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return r.Run(src)
+}
+
+// Run runs a script, the error reports a script which cannot be parsed
+func (r *Runner) Run(src []byte) (*Result, error) {
+	nodes, err := wat.Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	code, err := wat.Compile([]byte(spectestSource))
+	if err != nil {
+		return nil, err
+	}
+	spectest, err := r.instantiate(code, nil)
+	if err != nil {
+		return nil, err
+	}
+	r.modules = make(map[string]*vm.VM)
+	r.registered = linker{"spectest": spectest}
+	r.current = nil
+
+	result := &Result{}
+	for _, n := range inlineModule(nodes) {
+		if r.Skip != nil && r.Skip(n.Line) {
+			result.Skipped++
+			continue
+		}
+		result.Commands++
+		if err := r.exec(n); err != nil {
+			result.Failures = append(result.Failures, &Failure{Line: n.Line, Command: n.Head(), Err: err})
+		}
+	}
+	return result, nil
+}
+
+// Check runs the script file at path and reports every failure to t
+func (r *Runner) Check(t Reporter, path string) {
+	t.Helper()
+	result, err := r.RunFile(path)
+	if err != nil {
+		t.Errorf("%s: %v", path, err)
+		return
+	}
+	for _, failure := range result.Failures {
+		t.Errorf("%s:%v", path, failure)
+	}
+}
+
+// Check runs the script file at path with a new Runner and reports every failure to t
+func Check(t Reporter, path string) {
+	t.Helper()
+	(&Runner{}).Check(t, path)
+}
+
+// linker resolves imports to the exports of the registered instances
+type linker map[string]*vm.VM
+
+func (l linker) GetFunction(module, name string) vm.HostFunction {
+	return nil
+}
+
+func (l linker) GetExtern(module, name string) vm.Extern {
+	if instance, ok := l[module]; ok {
+		if extern, ok := instance.GetExport(name); ok {
+			return extern
+		}
+	}
+	return nil
+}
+
+func (r *Runner) instantiate(code []byte, imports linker) (*vm.VM, error) {
+	return vm.NewVMWithConfig(code, &vm.FreeGasPolicy{}, &vm.Gas{}, imports, r.Config)
+}
+
+// moduleFields are the fields a script may hold without the enclosing module
+var moduleFields = map[string]bool{
+	"type": true, "import": true, "func": true, "table": true, "memory": true,
+	"global": true, "export": true, "start": true, "elem": true, "data": true,
+}
+
+// inlineModule wraps a script made of bare module fields in a module command
+func inlineModule(nodes []*wat.Node) []*wat.Node {
+	for _, n := range nodes {
+		if !moduleFields[n.Head()] {
+			return nodes
+		}
+	}
+	if len(nodes) == 0 {
+		return nodes
+	}
+	head := &wat.Node{Line: nodes[0].Line, Col: nodes[0].Col, Atom: "module"}
+	return []*wat.Node{{Line: nodes[0].Line, Col: nodes[0].Col, List: true, Children: append([]*wat.Node{head}, nodes...)}}
+}
+
+func (r *Runner) exec(n *wat.Node) error {
+	args := n.Children[1:]
+	switch n.Head() {
+	case "module":
+		_, err := r.module(n)
+		return err
+	case "register":
+		if len(args) == 0 || !args[0].String {
+			return errors.New("expect a registration name")
+		}
+		instance, err := r.instance(args[1:])
+		if err != nil {
+			return err
+		}
+		r.registered[args[0].Atom] = instance
+		return nil
+	case "invoke", "get":
+		_, err := r.action(n)
+		return err
+	case "assert_return", "assert_return_canonical_nan", "assert_return_arithmetic_nan":
+		if len(args) == 0 {
+			return errors.New("expect an action")
+		}
+		results, err := r.action(args[0])
+		if err != nil {
+			return err
+		}
+		switch n.Head() {
+		case "assert_return_canonical_nan":
+			return matchNaN(results, "nan:canonical")
+		case "assert_return_arithmetic_nan":
+			return matchNaN(results, "nan:arithmetic")
+		}
+		return matchResults(results, args[1:])
+	case "assert_trap", "assert_exhaustion", "assert_unlinkable", "assert_uninstantiable":
+		if len(args) != 2 || !args[1].String {
+			return errors.New("expect a module or an action and a message")
+		}
+		var err error
+		if args[0].Head() == "module" {
+			if err = compiles(args[0]); err != nil {
+				return fmt.Errorf("expect a valid module, got %v", err)
+			}
+			_, err = r.module(args[0])
+		} else {
+			_, err = r.action(args[0])
+		}
+		if err == nil {
+			return fmt.Errorf("expect %q, got no error", args[1].Atom)
+		}
+		if !matchError(err, args[1].Atom, trapAliases) {
+			return fmt.Errorf("expect %q, got %v", args[1].Atom, err)
+		}
+		return nil
+	case "assert_malformed", "assert_invalid":
+		if len(args) != 2 || args[0].Head() != "module" {
+			return errors.New("expect a module and a message")
+		}
+		err := compiles(args[0])
+		if err == nil {
+			return fmt.Errorf("expect %q, module is accepted", args[1].Atom)
+		}
+		if !matchError(err, args[1].Atom, nil) {
+			return fmt.Errorf("expect %q, got %v", args[1].Atom, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported command %q", n.Head())
+}
+
+// compiles compiles, decodes and validates a module
+func compiles(n *wat.Node) error {
+	code, err := wat.CompileModule(n)
+	if err != nil {
+		return err
+	}
+	m, err := wasm.ReadModule(code)
+	if err != nil {
+		return err
+	}
+	return m.Validate()
+}
+
+// module instantiates a module and makes it the current one
+func (r *Runner) module(n *wat.Node) (*vm.VM, error) {
+	code, err := wat.CompileModule(n)
+	if err != nil {
+		return nil, err
+	}
+	instance, err := r.instantiate(code, r.registered)
+	if err != nil {
+		return nil, err
+	}
+	r.current = instance
+	if id := n.ID(); id != "" {
+		r.modules[id] = instance
+	}
+	return instance, nil
+}
+
+// instance returns the module named by an optional leading $id, or the current one
+func (r *Runner) instance(args []*wat.Node) (*vm.VM, error) {
+	if len(args) > 0 && !args[0].List && !args[0].String && strings.HasPrefix(args[0].Atom, "$") {
+		instance, ok := r.modules[args[0].Atom]
+		if !ok {
+			return nil, fmt.Errorf("unknown module %s", args[0].Atom)
+		}
+		return instance, nil
+	}
+	if r.current == nil {
+		return nil, errors.New("no module instantiated")
+	}
+	return r.current, nil
+}
+
+// action performs an invoke or get action
+func (r *Runner) action(n *wat.Node) ([]vm.Value, error) {
+	if n.Head() != "invoke" && n.Head() != "get" {
+		return nil, fmt.Errorf("expect an action, got %q", n.Head())
+	}
+	args := n.Children[1:]
+	instance, err := r.instance(args)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) > 0 && !args[0].List && !args[0].String {
+		args = args[1:]
+	}
+	if len(args) == 0 || !args[0].String {
+		return nil, errors.New("expect an export name")
+	}
+	name := args[0].Atom
+	if n.Head() == "get" {
+		v, err := instance.GetGlobal(name)
+		if err != nil {
+			return nil, fmt.Errorf("%v %q", err, name)
+		}
+		return []vm.Value{v}, nil
+	}
+	params := make([]vm.Value, len(args)-1)
+	for i, arg := range args[1:] {
+		t, bits, err := wat.Const(arg)
+		if err != nil {
+			return nil, err
+		}
+		params[i] = vm.ValueOf(t, bits)
+	}
+	results, err := instance.InvokeByName(name, params...)
+	if err == vm.ErrExportNotFound || err == vm.ErrExportNotFunction {
+		return nil, fmt.Errorf("%v %q", err, name)
+	}
+	return results, err
+}
+
+// matchResults compares results to the expected constants which may be NaN patterns
+func matchResults(results []vm.Value, expected []*wat.Node) error {
+	if len(results) != len(expected) {
+		return fmt.Errorf("expect %d results, got %v", len(expected), results)
+	}
+	for i, n := range expected {
+		if len(n.Children) == 2 && strings.HasPrefix(n.Children[1].Atom, "nan:") &&
+			(n.Children[1].Atom == "nan:canonical" || n.Children[1].Atom == "nan:arithmetic") {
+			if err := matchNaN(results[i:i+1], n.Children[1].Atom); err != nil {
+				return err
+			}
+			continue
+		}
+		t, bits, err := wat.Const(n)
+		if err != nil {
+			return err
+		}
+		if expect := vm.ValueOf(t, bits); results[i] != expect {
+			return fmt.Errorf("expect %v, got %v", expect, results[i])
+		}
+	}
+	return nil
+}
+
+// matchNaN checks a single float result is a canonical or an arithmetic NaN of either sign
+func matchNaN(results []vm.Value, pattern string) error {
+	if len(results) != 1 {
+		return fmt.Errorf("expect a single result, got %v", results)
+	}
+	var canonical, mask uint64
+	switch results[0].Type {
+	case wasm.ValueTypeF32:
+		canonical, mask = 0x7fc00000, 0x7fffffff
+	case wasm.ValueTypeF64:
+		canonical, mask = 0x7ff8000000000000, 0x7fffffffffffffff
+	default:
+		return fmt.Errorf("expect a float result, got %v", results[0])
+	}
+	bits := results[0].Bits() & mask
+	if bits == canonical || (pattern == "nan:arithmetic" && bits&canonical == canonical) {
+		return nil
+	}
+	return fmt.Errorf("expect %s, got %v", pattern, results[0])
+}
+
+// errorMessage returns the message of err without the position of validation
+// and text format errors, the segment of segment errors and the package
+// prefix of decoding errors
+func errorMessage(err error) string {
+	var validationErr *wasm.ValidationError
+	var textErr *wat.Error
+	var segmentErr *vm.SegmentError
+	switch {
+	case errors.As(err, &validationErr):
+		return validationErr.Msg
+	case errors.As(err, &textErr):
+		return textErr.Msg
+	case errors.As(err, &segmentErr):
+		return segmentErr.Err.Error()
+	}
+	return strings.TrimPrefix(err.Error(), "wasm: ")
+}
+
+// matchError tells if the message of err starts with the expected spec message,
+// or with an alias of a spec message starting with the expected one, messages
+// are compared by prefix as the spec interpreter does
+func matchError(err error, expected string, aliases map[string][]string) bool {
+	msg := errorMessage(err)
+	if strings.HasPrefix(msg, expected) {
+		return true
+	}
+	for specMsg, names := range aliases {
+		if !strings.HasPrefix(specMsg, expected) {
+			continue
+		}
+		for _, name := range names {
+			if strings.HasPrefix(msg, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package wast
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/vm"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+const testScript = `(module $a
+  (global (export "g") (mut i32) (i32.const 1))
+  (func (export "inc") (global.set 0 (i32.add (global.get 0) (i32.const 1))))
+  (func (export "div") (param i32 i32) (result i32) (i32.div_s (local.get 0) (local.get 1))))
+(register "a" $a)
+(module $b
+  (import "a" "g" (global (mut i32)))
+  (import "a" "inc" (func $inc))
+  (func (export "get") (result i32) (call $inc) (global.get 0)))
+(assert_return (invoke $b "get") (i32.const 2))
+(assert_return (get $a "g") (i32.const 2))
+(assert_trap (invoke $a "div" (i32.const 1) (i32.const 0)) "integer divide by zero")
+(assert_invalid (module (func (result i32) (i64.const 0))) "type mismatch")
+(assert_malformed (module binary "\00asm") "unexpected end")
+(assert_unlinkable (module (import "a" "missing" (func))) "unknown import")
+(assert_return (invoke $a "div" (i32.const 7) (i32.const 2)) (i32.const 4))
+(assert_return (invoke "get") (i32.const 0))
+`
+
+func TestRun(t *testing.T) {
+	r := &Runner{Skip: func(line int) bool { return line == 17 }}
+	result, err := r.Run([]byte(testScript))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Commands != 10 || result.Skipped != 1 {
+		t.Errorf("Expect 10 commands and 1 skipped, got %d and %d", result.Commands, result.Skipped)
+	}
+	if len(result.Failures) != 1 {
+		t.Fatalf("Expect a single failure, got %v", result.Failures)
+	}
+	failure := result.Failures[0]
+	if failure.Line != 16 || !strings.Contains(failure.Error(), "expect i32:4, got i32:3") {
+		t.Errorf("Unexpected failure %v", failure)
+	}
+}
+
+func TestInlineModule(t *testing.T) {
+	result, err := (&Runner{}).Run([]byte(`(func (export "f") (result i32) (i32.const 3)) (memory 1)`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Commands != 1 || len(result.Failures) != 0 {
+		t.Errorf("Expect the fields to be run as one module, got %+v", result)
+	}
+}
+
+func TestMatchNaN(t *testing.T) {
+	tests := []struct {
+		v       vm.Value
+		pattern string
+		ok      bool
+	}{
+		{vm.ValueOf(wasm.ValueTypeF32, 0x7fc00000), "nan:canonical", true},
+		{vm.ValueOf(wasm.ValueTypeF32, 0xffc00000), "nan:canonical", true},
+		{vm.ValueOf(wasm.ValueTypeF32, 0x7fc00001), "nan:canonical", false},
+		{vm.ValueOf(wasm.ValueTypeF32, 0x7fc00001), "nan:arithmetic", true},
+		{vm.ValueOf(wasm.ValueTypeF64, 0x7ff0000000000001), "nan:arithmetic", false},
+		{vm.ValueOf(wasm.ValueTypeF64, 0x7ff8000000000000), "nan:canonical", true},
+	}
+	for _, test := range tests {
+		if err := matchNaN([]vm.Value{test.v}, test.pattern); (err == nil) != test.ok {
+			t.Errorf("%v %s: expect match %v, got %v", test.v, test.pattern, test.ok, err)
+		}
+	}
+}
+
+func TestMatchModuleError(t *testing.T) {
+	result, err := (&Runner{}).Run([]byte(`(assert_invalid (module (func (result i32) (i64.const 0))) "type mismatch")
+(assert_invalid (module (func (result i32) (i64.const 0))) "unknown local")
+(assert_malformed (module binary "\00asm\02\00\00\00") "unknown binary version")
+(assert_malformed (module binary "\00asm\02\00\00\00") "magic header not detected")`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Failures) != 2 || result.Failures[0].Line != 2 || result.Failures[1].Line != 4 {
+		t.Fatalf("Expect the mismatching messages on lines 2 and 4 to fail, got %v", result.Failures)
+	}
+	if !strings.Contains(result.Failures[0].Error(), `expect "unknown local", got`) {
+		t.Errorf("Unexpected failure %v", result.Failures[0])
+	}
+}
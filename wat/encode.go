@@ -0,0 +1,74 @@
+package wat
+
+type encoder struct {
+	b []byte
+}
+
+func (e *encoder) byte(b byte) {
+	e.b = append(e.b, b)
+}
+
+func (e *encoder) bytes(b []byte) {
+	e.b = append(e.b, b...)
+}
+
+func (e *encoder) u32(v uint32) {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if v != 0 {
+			c |= 0x80
+		}
+		e.b = append(e.b, c)
+		if v == 0 {
+			return
+		}
+	}
+}
+
+func (e *encoder) s64(v int64) {
+	for {
+		c := byte(v & 0x7f)
+		v >>= 7
+		if (v == 0 && c&0x40 == 0) || (v == -1 && c&0x40 != 0) {
+			e.b = append(e.b, c)
+			return
+		}
+		e.b = append(e.b, c|0x80)
+	}
+}
+
+func (e *encoder) fixed(v uint64, size int) {
+	for i := 0; i < size; i++ {
+		e.b = append(e.b, byte(v>>(8*uint(i))))
+	}
+}
+
+func (e *encoder) name(s string) {
+	e.u32(uint32(len(s)))
+	e.b = append(e.b, s...)
+}
+
+func (e *encoder) limits(l limits) {
+	if l.hasMax {
+		e.byte(1)
+		e.u32(l.min)
+		e.u32(l.max)
+	} else {
+		e.byte(0)
+		e.u32(l.min)
+	}
+}
+
+// section appends a section with the given id and body, skipping empty vectors
+func (e *encoder) section(id byte, count int, body *encoder) {
+	if count == 0 {
+		return
+	}
+	var s encoder
+	s.u32(uint32(count))
+	s.bytes(body.b)
+	e.byte(id)
+	e.u32(uint32(len(s.b)))
+	e.bytes(s.b)
+}
@@ -0,0 +1,472 @@
+package wat
+
+import (
+	"math/bits"
+	"strings"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+)
+
+// instruction is the encoding of a mnemonic
+type instruction struct {
+	op     opcode.Opcode
+	subop  uint32
+	prefix bool // op is the prefix of subop
+}
+
+var instructions = map[string]instruction{}
+
+// legacyNames maps mnemonics renamed since the MVP text format
+var legacyNames = map[string]string{
+	"get_local":      "local.get",
+	"set_local":      "local.set",
+	"tee_local":      "local.tee",
+	"get_global":     "global.get",
+	"set_global":     "global.set",
+	"current_memory": "memory.size",
+	"grow_memory":    "memory.grow",
+}
+
+func init() {
+	for i := 0; i < 256; i++ {
+		op := opcode.Opcode(i)
+		if info := op.Info(); info != nil && op != opcode.ITruncSatF {
+			instructions[info.Name] = instruction{op: op}
+		}
+	}
+	for sub := uint32(0); opcode.SubInfo(sub) != nil; sub++ {
+		instructions[opcode.SubInfo(sub).Name] = instruction{op: opcode.ITruncSatF, subop: sub, prefix: true}
+	}
+}
+
+// lookupInstruction resolves current and legacy mnemonics, such as i32.trunc_s/f32 for i32.trunc_f32_s
+func lookupInstruction(name string) (instruction, bool) {
+	if ins, ok := instructions[name]; ok {
+		return ins, true
+	}
+	if renamed, ok := legacyNames[name]; ok {
+		return instructions[renamed], true
+	}
+	if i := strings.Index(name, "/"); i > 0 {
+		op, operand := name[:i], name[i+1:]
+		sat := strings.HasSuffix(op, ":sat")
+		op = strings.TrimSuffix(op, ":sat")
+		suffix := ""
+		if strings.HasSuffix(op, "_s") || strings.HasSuffix(op, "_u") {
+			op, suffix = op[:len(op)-2], op[len(op)-2:]
+		}
+		if sat {
+			op += "_sat"
+		}
+		if ins, ok := instructions[op+"_"+operand+suffix]; ok {
+			return ins, true
+		}
+	}
+	return instruction{}, false
+}
+
+// unknownInstruction reports name, which is no instruction, as an unknown operator
+// or as an unexpected token for a function field misplaced after the instructions
+func unknownInstruction(n *Node, name string) error {
+	switch name {
+	case "type", "param", "result", "local":
+		return n.errorf("unexpected token %s", name)
+	}
+	return n.errorf("unknown operator %s", name)
+}
+
+type funcContext struct {
+	m          *module
+	locals     map[string]uint32
+	localCount uint32
+	results    []byte
+	labels     []string // innermost last, empty for unnamed blocks
+	e          encoder
+}
+
+func newFuncContext(m *module) *funcContext {
+	return &funcContext{m: m, locals: map[string]uint32{}}
+}
+
+// instrs compiles a sequence of plain and folded instructions
+func (c *funcContext) instrs(nodes []*Node) error {
+	for i := 0; i < len(nodes); {
+		n := nodes[i]
+		if n.List {
+			if err := c.folded(n); err != nil {
+				return err
+			}
+			i++
+			continue
+		}
+		consumed, err := c.plain(nodes[i:])
+		if err != nil {
+			return err
+		}
+		i += consumed
+	}
+	return nil
+}
+
+// label returns the block depth of a label reference
+func (c *funcContext) label(n *Node) (uint32, error) {
+	if n.isID() {
+		for i := len(c.labels) - 1; i >= 0; i-- {
+			if c.labels[i] == n.Atom {
+				return uint32(len(c.labels) - 1 - i), nil
+			}
+		}
+		return 0, n.errorf("unknown label %s", n.Atom)
+	}
+	return parseIndex(n)
+}
+
+func isIndex(n *Node) bool {
+	return !n.List && !n.String && (n.isID() || (len(n.Atom) > 0 && n.Atom[0] >= '0' && n.Atom[0] <= '9'))
+}
+
+// blockHeader parses the optional label and block type of block, loop and if
+func (c *funcContext) blockHeader(nodes []*Node) (string, byte, []*Node, error) {
+	label := ""
+	if len(nodes) > 0 && nodes[0].isID() {
+		label = nodes[0].Atom
+		nodes = nodes[1:]
+	}
+	blockType := byte(0x40)
+	if len(nodes) > 0 && nodes[0].Head() == "type" {
+		idx, _, rest, err := c.m.typeUse(nodes, false)
+		if err != nil {
+			return "", 0, nil, err
+		}
+		ft := c.m.types[idx]
+		if len(ft.params) != 0 || len(ft.results) > 1 {
+			return "", 0, nil, nodes[0].errorf("unsupported block type")
+		}
+		if len(ft.results) == 1 {
+			blockType = ft.results[0]
+		}
+		return label, blockType, rest, nil
+	}
+	for len(nodes) > 0 && nodes[0].Head() == "result" {
+		for _, r := range nodes[0].Children[1:] {
+			if blockType != 0x40 {
+				return "", 0, nil, r.errorf("unsupported block type")
+			}
+			t, err := valueType(r)
+			if err != nil {
+				return "", 0, nil, err
+			}
+			blockType = t
+		}
+		nodes = nodes[1:]
+	}
+	if len(nodes) > 0 && nodes[0].Head() == "param" {
+		return "", 0, nil, nodes[0].errorf("unsupported block type")
+	}
+	return label, blockType, nodes, nil
+}
+
+// closeLabel checks the optional label repeated after else and end
+func (c *funcContext) closeLabel(nodes []*Node) int {
+	if len(nodes) > 0 && nodes[0].isID() {
+		return 1
+	}
+	return 0
+}
+
+func (c *funcContext) checkCloseLabel(n *Node) error {
+	if top := c.labels[len(c.labels)-1]; n.Atom != top {
+		return n.errorf("mismatching label %s", n.Atom)
+	}
+	return nil
+}
+
+// plain compiles the plain instruction starting nodes, returning the number of nodes consumed
+func (c *funcContext) plain(nodes []*Node) (int, error) {
+	n := nodes[0]
+	if n.String {
+		return 0, n.errorf("unexpected string")
+	}
+	switch n.Atom {
+	case "block", "loop", "if":
+		label, blockType, rest, err := c.blockHeader(nodes[1:])
+		if err != nil {
+			return 0, err
+		}
+		c.e.byte(byte(instructions[n.Atom].op))
+		c.e.byte(blockType)
+		c.labels = append(c.labels, label)
+		return len(nodes) - len(rest), nil
+	case "else", "end":
+		if len(c.labels) == 0 {
+			return 0, n.errorf("unexpected %s", n.Atom)
+		}
+		consumed := 1 + c.closeLabel(nodes[1:])
+		if consumed == 2 {
+			if err := c.checkCloseLabel(nodes[1]); err != nil {
+				return 0, err
+			}
+		}
+		if n.Atom == "end" {
+			c.e.byte(byte(opcode.End))
+			c.labels = c.labels[:len(c.labels)-1]
+		} else {
+			c.e.byte(byte(opcode.Else))
+		}
+		return consumed, nil
+	}
+	ins, ok := lookupInstruction(n.Atom)
+	if !ok {
+		return 0, unknownInstruction(n, n.Atom)
+	}
+	imms, consumed, err := c.immediates(n, ins, nodes[1:], false)
+	if err != nil {
+		return 0, err
+	}
+	c.emit(ins, imms)
+	return 1 + consumed, nil
+}
+
+func (c *funcContext) emit(ins instruction, imms []byte) {
+	c.e.byte(byte(ins.op))
+	if ins.prefix {
+		c.e.u32(ins.subop)
+	}
+	c.e.bytes(imms)
+}
+
+// folded compiles a parenthesized instruction, operands first
+func (c *funcContext) folded(n *Node) error {
+	head := n.Head()
+	if head == "" {
+		return n.errorf("expect instruction")
+	}
+	switch head {
+	case "block", "loop":
+		label, blockType, rest, err := c.blockHeader(n.Children[1:])
+		if err != nil {
+			return err
+		}
+		c.e.byte(byte(instructions[head].op))
+		c.e.byte(blockType)
+		c.labels = append(c.labels, label)
+		if err := c.instrs(rest); err != nil {
+			return err
+		}
+		c.labels = c.labels[:len(c.labels)-1]
+		c.e.byte(byte(opcode.End))
+		return nil
+	case "if":
+		label, blockType, rest, err := c.blockHeader(n.Children[1:])
+		if err != nil {
+			return err
+		}
+		for len(rest) > 0 && rest[0].Head() != "then" {
+			if !rest[0].List {
+				return rest[0].errorf("expect folded condition")
+			}
+			if err := c.folded(rest[0]); err != nil {
+				return err
+			}
+			rest = rest[1:]
+		}
+		if len(rest) == 0 {
+			return n.errorf("missing then clause")
+		}
+		c.e.byte(byte(opcode.If))
+		c.e.byte(blockType)
+		c.labels = append(c.labels, label)
+		if err := c.instrs(rest[0].Children[1:]); err != nil {
+			return err
+		}
+		rest = rest[1:]
+		if len(rest) > 0 && rest[0].Head() == "else" {
+			c.e.byte(byte(opcode.Else))
+			if err := c.instrs(rest[0].Children[1:]); err != nil {
+				return err
+			}
+			rest = rest[1:]
+		}
+		if len(rest) > 0 {
+			return rest[0].errorf("unexpected token after if clauses")
+		}
+		c.labels = c.labels[:len(c.labels)-1]
+		c.e.byte(byte(opcode.End))
+		return nil
+	}
+	ins, ok := lookupInstruction(head)
+	if !ok {
+		return unknownInstruction(n.Children[0], head)
+	}
+	imms, consumed, err := c.immediates(n.Children[0], ins, n.Children[1:], true)
+	if err != nil {
+		return err
+	}
+	for _, operand := range n.Children[1+consumed:] {
+		if !operand.List {
+			return operand.errorf("unexpected token %s in folded instruction", operand.Atom)
+		}
+		if err := c.folded(operand); err != nil {
+			return err
+		}
+	}
+	c.emit(ins, imms)
+	return nil
+}
+
+// immediates encodes the immediates of ins read from nodes, returning the number of nodes consumed
+func (c *funcContext) immediates(n *Node, ins instruction, nodes []*Node, folded bool) ([]byte, int, error) {
+	var e encoder
+	info := ins.op.Info()
+	if ins.prefix {
+		info = opcode.SubInfo(ins.subop)
+	}
+	pos := 0
+	next := func() (*Node, error) {
+		if pos >= len(nodes) || nodes[pos].List {
+			return nil, n.errorf("unexpected token, missing immediate for %s", n.Atom)
+		}
+		pos++
+		return nodes[pos-1], nil
+	}
+	for _, kind := range info.Immediates {
+		switch kind {
+		case opcode.ImmLabel:
+			arg, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+			depth, err := c.label(arg)
+			if err != nil {
+				return nil, 0, err
+			}
+			e.u32(depth)
+		case opcode.ImmLabelTable:
+			var depths []uint32
+			for pos < len(nodes) && isIndex(nodes[pos]) {
+				depth, err := c.label(nodes[pos])
+				if err != nil {
+					return nil, 0, err
+				}
+				depths = append(depths, depth)
+				pos++
+			}
+			if len(depths) == 0 {
+				return nil, 0, n.errorf("missing br_table labels")
+			}
+			e.u32(uint32(len(depths) - 1))
+			for _, depth := range depths {
+				e.u32(depth)
+			}
+		case opcode.ImmFunc, opcode.ImmLocal, opcode.ImmGlobal:
+			arg, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+			idx, err := c.index(kind, arg)
+			if err != nil {
+				return nil, 0, err
+			}
+			e.u32(idx)
+		case opcode.ImmType:
+			if pos < len(nodes) && isIndex(nodes[pos]) {
+				if _, err := c.m.tables.resolve(nodes[pos]); err != nil {
+					return nil, 0, err
+				}
+				pos++
+			}
+			idx, _, rest, err := c.m.typeUse(nodes[pos:], false)
+			if err != nil {
+				return nil, 0, err
+			}
+			pos = len(nodes) - len(rest)
+			e.u32(idx)
+		case opcode.ImmReserved:
+			e.byte(0)
+		case opcode.ImmMemArg:
+			if err := c.memArg(&e, ins.op, nodes, &pos); err != nil {
+				return nil, 0, err
+			}
+		case opcode.ImmI32, opcode.ImmI64:
+			arg, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+			size := uint(32)
+			if kind == opcode.ImmI64 {
+				size = 64
+			}
+			v, err := parseInt(arg.Atom, size)
+			if err != nil {
+				return nil, 0, arg.errorf("%v", err)
+			}
+			if size == 32 {
+				e.s64(int64(int32(v)))
+			} else {
+				e.s64(int64(v))
+			}
+		case opcode.ImmF32, opcode.ImmF64:
+			arg, err := next()
+			if err != nil {
+				return nil, 0, err
+			}
+			size := 32
+			if kind == opcode.ImmF64 {
+				size = 64
+			}
+			v, err := parseFloat(arg.Atom, size)
+			if err != nil {
+				return nil, 0, arg.errorf("%v", err)
+			}
+			e.fixed(v, size/8)
+		}
+	}
+	if !folded && pos < len(nodes) && !nodes[pos].List && !nodes[pos].String && nodes[pos].isID() {
+		return nil, 0, nodes[pos].errorf("unexpected identifier %s", nodes[pos].Atom)
+	}
+	return e.b, pos, nil
+}
+
+func (c *funcContext) index(kind opcode.Immediate, n *Node) (uint32, error) {
+	switch kind {
+	case opcode.ImmFunc:
+		return c.m.funcs.resolve(n)
+	case opcode.ImmGlobal:
+		return c.m.globals.resolve(n)
+	}
+	if n.isID() {
+		idx, ok := c.locals[n.Atom]
+		if !ok {
+			return 0, n.errorf("unknown local %s", n.Atom)
+		}
+		return idx, nil
+	}
+	return parseIndex(n)
+}
+
+// memArg encodes the optional offset= and align= of a memory access, alignment defaults to the access size
+func (c *funcContext) memArg(e *encoder, op opcode.Opcode, nodes []*Node, pos *int) error {
+	offset, align := uint64(0), uint64(op.MemAccessSize())
+	if *pos < len(nodes) && !nodes[*pos].List && strings.HasPrefix(nodes[*pos].Atom, "offset=") {
+		v, err := parseNat(strings.TrimPrefix(nodes[*pos].Atom, "offset="))
+		if err == errMalformedNumber {
+			return nodes[*pos].errorf("unknown operator %s", nodes[*pos].Atom)
+		}
+		if err != nil || v > 0xffffffff {
+			return nodes[*pos].errorf("i32 constant out of range %s", nodes[*pos].Atom)
+		}
+		offset = v
+		*pos++
+	}
+	if *pos < len(nodes) && !nodes[*pos].List && strings.HasPrefix(nodes[*pos].Atom, "align=") {
+		v, err := parseNat(strings.TrimPrefix(nodes[*pos].Atom, "align="))
+		if err != nil || v == 0 || v&(v-1) != 0 || v > 0xffffffff {
+			return nodes[*pos].errorf("alignment must be a power of two")
+		}
+		align = v
+		*pos++
+	}
+	e.u32(uint32(bits.TrailingZeros64(align)))
+	e.u32(uint32(offset))
+	return nil
+}
@@ -0,0 +1,210 @@
+package wat
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"strconv"
+	"strings"
+)
+
+var (
+	errMalformedNumber = errors.New("unknown operator: malformed number")
+	errOutOfRange      = errors.New("constant out of range")
+)
+
+// stripUnderscores removes digit separators, which must sit between two digits
+func stripUnderscores(s string, hex bool) (string, error) {
+	if !strings.Contains(s, "_") {
+		return s, nil
+	}
+	isDigit := func(c byte) bool {
+		_, ok := hexDigit(c)
+		return ('0' <= c && c <= '9') || (hex && ok)
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] == '_' && (i == 0 || i == len(s)-1 || !isDigit(s[i-1]) || !isDigit(s[i+1])) {
+			return "", errMalformedNumber
+		}
+	}
+	return strings.Replace(s, "_", "", -1), nil
+}
+
+func splitSign(s string) (bool, string) {
+	if strings.HasPrefix(s, "-") {
+		return true, s[1:]
+	}
+	return false, strings.TrimPrefix(s, "+")
+}
+
+// parseInt parses an integer literal of the given bit size, returning its two's complement bits.
+// Unsigned values up to 2^bits-1 and signed values down to -2^(bits-1) are accepted.
+func parseInt(s string, bits uint) (uint64, error) {
+	neg, digits := splitSign(s)
+	hex := strings.HasPrefix(digits, "0x")
+	if hex {
+		digits = digits[2:]
+	}
+	digits, err := stripUnderscores(digits, hex)
+	if err != nil {
+		return 0, err
+	}
+	if digits == "" || digits[0] == '+' || digits[0] == '-' {
+		return 0, errMalformedNumber
+	}
+	base := 10
+	if hex {
+		base = 16
+	}
+	v, err := strconv.ParseUint(digits, base, 64)
+	if err != nil {
+		if err.(*strconv.NumError).Err == strconv.ErrRange {
+			return 0, errOutOfRange
+		}
+		return 0, errMalformedNumber
+	}
+	mask := uint64(1)<<bits - 1
+	if bits == 64 {
+		mask = math.MaxUint64
+	}
+	if neg {
+		if v > uint64(1)<<(bits-1) {
+			return 0, errOutOfRange
+		}
+		return -v & mask, nil
+	}
+	if v > mask {
+		return 0, errOutOfRange
+	}
+	return v, nil
+}
+
+// parseFloat parses a float literal of the given bit size, returning its bits
+func parseFloat(s string, bits int) (uint64, error) {
+	neg, body := splitSign(s)
+	var (
+		signBit      uint64 = 1 << 63
+		mantBits     uint   = 52
+		expMask      uint64 = 0x7ff << 52
+		canonicalNaN uint64 = 1 << 51
+	)
+	if bits == 32 {
+		signBit, mantBits, expMask, canonicalNaN = 1<<31, 23, 0xff<<23, 1<<22
+	}
+	sign := uint64(0)
+	if neg {
+		sign = signBit
+	}
+	switch {
+	case body == "inf":
+		return sign | expMask, nil
+	case body == "nan":
+		return sign | expMask | canonicalNaN, nil
+	case strings.HasPrefix(body, "nan:0x"):
+		payload, err := parseInt(body[4:], 64)
+		if err != nil {
+			return 0, err
+		}
+		if payload == 0 || payload >= uint64(1)<<mantBits {
+			return 0, errOutOfRange
+		}
+		return sign | expMask | payload, nil
+	}
+	hex := strings.HasPrefix(body, "0x")
+	digits := body
+	if hex {
+		digits = body[2:]
+	}
+	if digits == "" || digits[0] == '.' || digits[0] == '_' {
+		return 0, errMalformedNumber
+	}
+	digits, err := stripUnderscores(digits, hex)
+	if err != nil {
+		return 0, err
+	}
+	for i := 0; i < len(digits); i++ {
+		c := digits[i]
+		_, isHex := hexDigit(c)
+		ok := ('0' <= c && c <= '9') || c == '.' || c == '+' || c == '-' ||
+			(hex && (isHex || c == 'p' || c == 'P')) || (!hex && (c == 'e' || c == 'E'))
+		if !ok {
+			return 0, errMalformedNumber
+		}
+	}
+	var f float64
+	if hex {
+		f, err = parseHexFloat(digits, bits)
+	} else {
+		f, err = strconv.ParseFloat(digits, bits)
+	}
+	if err != nil {
+		if ne, ok := err.(*strconv.NumError); ok {
+			if ne.Err == strconv.ErrRange {
+				return 0, errOutOfRange
+			}
+			return 0, errMalformedNumber
+		}
+		return 0, err
+	}
+	if bits == 32 {
+		return sign | uint64(math.Float32bits(float32(f))), nil
+	}
+	return sign | math.Float64bits(f), nil
+}
+
+// parseHexFloat rounds a hexadecimal mantissa with an optional binary exponent
+// to the nearest float of the given bit size, ties to even
+func parseHexFloat(s string, bits int) (float64, error) {
+	mant, exp := s, int64(0)
+	if i := strings.IndexAny(s, "pP"); i >= 0 {
+		mant = s[:i]
+		e, err := strconv.ParseInt(s[i+1:], 10, 32)
+		if err != nil {
+			if err.(*strconv.NumError).Err != strconv.ErrRange {
+				return 0, errMalformedNumber
+			}
+			e = math.MaxInt32
+			if strings.HasPrefix(s[i+1:], "-") {
+				e = math.MinInt32
+			}
+		}
+		exp = e
+	}
+	if strings.Count(mant, ".") > 1 || mant == "." {
+		return 0, errMalformedNumber
+	}
+	if i := strings.Index(mant, "."); i >= 0 {
+		exp -= int64(4 * (len(mant) - i - 1))
+		mant = mant[:i] + mant[i+1:]
+	}
+	if mant == "" {
+		return 0, errMalformedNumber
+	}
+	m, ok := new(big.Int).SetString(mant, 16)
+	if !ok {
+		return 0, errMalformedNumber
+	}
+	if m.Sign() == 0 {
+		return 0, nil
+	}
+	if exp > 1<<20 {
+		return 0, errOutOfRange
+	}
+	if exp < -(1 << 20) {
+		return 0, nil
+	}
+	// the exact value is rounded once, to nearest even including subnormals
+	f := new(big.Float).SetMantExp(new(big.Float).SetInt(m), int(exp))
+	if bits == 32 {
+		f32, _ := f.Float32()
+		if math.IsInf(float64(f32), 0) {
+			return 0, errOutOfRange
+		}
+		return float64(f32), nil
+	}
+	f64, _ := f.Float64()
+	if math.IsInf(f64, 0) {
+		return 0, errOutOfRange
+	}
+	return f64, nil
+}
@@ -0,0 +1,916 @@
+package wat
+
+import (
+	"math"
+
+	"github.com/vertexdlt/vertexvm/opcode"
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// external kinds as encoded in imports and exports
+const (
+	kindFunc   byte = 0x00
+	kindTable  byte = 0x01
+	kindMemory byte = 0x02
+	kindGlobal byte = 0x03
+)
+
+var kindKeywords = map[string]byte{"func": kindFunc, "table": kindTable, "memory": kindMemory, "global": kindGlobal}
+
+var valueTypes = map[string]byte{
+	"i32": byte(wasm.ValueTypeI32),
+	"i64": byte(wasm.ValueTypeI64),
+	"f32": byte(wasm.ValueTypeF32),
+	"f64": byte(wasm.ValueTypeF64),
+}
+
+type funcType struct {
+	params, results []byte
+}
+
+func (t funcType) equal(o funcType) bool {
+	return string(t.params) == string(o.params) && string(t.results) == string(o.results)
+}
+
+type limits struct {
+	min, max uint32
+	hasMax   bool
+}
+
+// indexSpace assigns indices to the functions, tables, memories or globals of a module
+type indexSpace struct {
+	kind  string
+	count uint32
+	names map[string]uint32
+}
+
+func (s *indexSpace) declare(id *Node) error {
+	if id != nil {
+		if _, ok := s.names[id.Atom]; ok {
+			return id.errorf("duplicate %s %s", s.kind, id.Atom)
+		}
+		s.names[id.Atom] = s.count
+	}
+	s.count++
+	return nil
+}
+
+func (s *indexSpace) resolve(n *Node) (uint32, error) {
+	if n.List || n.String {
+		return 0, n.errorf("expect %s index", s.kind)
+	}
+	if n.isID() {
+		idx, ok := s.names[n.Atom]
+		if !ok {
+			return 0, n.errorf("unknown %s %s", s.kind, n.Atom)
+		}
+		return idx, nil
+	}
+	return parseIndex(n)
+}
+
+func parseIndex(n *Node) (uint32, error) {
+	if n.List || n.String {
+		return 0, n.errorf("expect index")
+	}
+	v, err := parseNat(n.Atom)
+	if err == errMalformedNumber {
+		return 0, n.errorf("unknown operator %s", n.Atom)
+	}
+	if err != nil || v > math.MaxUint32 {
+		return 0, n.errorf("i32 constant out of range %s", n.Atom)
+	}
+	return uint32(v), nil
+}
+
+// parseNat parses an unsigned integer without sign
+func parseNat(s string) (uint64, error) {
+	if s == "" || s[0] < '0' || s[0] > '9' {
+		return 0, errMalformedNumber
+	}
+	return parseInt(s, 64)
+}
+
+type section struct {
+	encoder
+	count int
+}
+
+type module struct {
+	types     []funcType
+	typeNames map[string]uint32
+
+	funcs, tables, mems, globals indexSpace
+
+	imports, funcSec, tableSec, memSec, globalSec, exportSec, elemSec, codeSec, dataSec section
+	start                                                                               *uint32
+	exportNames                                                                         map[string]bool
+
+	next    [4]uint32 // index of the next defined or imported entity by kind
+	defined string    // kind of the first defined function, table, memory or global
+}
+
+func newModule() *module {
+	return &module{
+		typeNames:   map[string]uint32{},
+		funcs:       indexSpace{kind: "function", names: map[string]uint32{}},
+		tables:      indexSpace{kind: "table", names: map[string]uint32{}},
+		mems:        indexSpace{kind: "memory", names: map[string]uint32{}},
+		globals:     indexSpace{kind: "global", names: map[string]uint32{}},
+		exportNames: map[string]bool{},
+	}
+}
+
+// splitID returns the optional identifier following the head of a list and the remaining children
+func splitID(n *Node) (*Node, []*Node) {
+	rest := n.Children[1:]
+	if len(rest) > 0 && rest[0].isID() {
+		return rest[0], rest[1:]
+	}
+	return nil, rest
+}
+
+func (m *module) compile(fields []*Node) ([]byte, error) {
+	for _, field := range fields {
+		if field.Head() == "type" {
+			if err := m.typeField(field); err != nil {
+				return nil, err
+			}
+		}
+	}
+	for _, field := range fields {
+		if err := m.declare(field); err != nil {
+			return nil, err
+		}
+	}
+	for _, field := range fields {
+		if err := m.define(field); err != nil {
+			return nil, err
+		}
+	}
+	return m.encode(), nil
+}
+
+// declare assigns the index of a function, table, memory or global field
+func (m *module) declare(field *Node) error {
+	head := field.Head()
+	switch head {
+	case "func", "table", "memory", "global":
+		id, rest := splitID(field)
+		space := m.space(kindKeywords[head])
+		if err := m.importOrder(field, hasInlineImport(rest), space.kind); err != nil {
+			return err
+		}
+		return space.declare(id)
+	case "import":
+		if len(field.Children) != 4 {
+			return field.errorf("invalid import")
+		}
+		if err := m.importOrder(field, true, ""); err != nil {
+			return err
+		}
+		desc := field.Children[3]
+		kind, ok := kindKeywords[desc.Head()]
+		if !ok {
+			return desc.errorf("invalid import kind")
+		}
+		id, _ := splitID(desc)
+		return m.space(kind).declare(id)
+	case "type", "export", "start", "elem", "data":
+		return nil
+	}
+	return field.errorf("unknown module field %s", head)
+}
+
+// importOrder checks imports precede the definitions as imported entities are indexed first
+func (m *module) importOrder(field *Node, imported bool, kind string) error {
+	if !imported {
+		if m.defined == "" {
+			m.defined = kind
+		}
+		return nil
+	}
+	if m.defined != "" {
+		return field.errorf("import after %s", m.defined)
+	}
+	return nil
+}
+
+// hasInlineImport tells if the fields following the identifier of a definition hold an inline import
+func hasInlineImport(nodes []*Node) bool {
+	for _, n := range nodes {
+		switch n.Head() {
+		case "import":
+			return true
+		case "export":
+		default:
+			return false
+		}
+	}
+	return false
+}
+
+func (m *module) space(kind byte) *indexSpace {
+	switch kind {
+	case kindFunc:
+		return &m.funcs
+	case kindTable:
+		return &m.tables
+	case kindMemory:
+		return &m.mems
+	}
+	return &m.globals
+}
+
+func (m *module) define(field *Node) error {
+	switch field.Head() {
+	case "func":
+		return m.funcField(field)
+	case "table":
+		return m.tableField(field)
+	case "memory":
+		return m.memoryField(field)
+	case "global":
+		return m.globalField(field)
+	case "import":
+		return m.importField(field)
+	case "export":
+		return m.exportField(field)
+	case "start":
+		return m.startField(field)
+	case "elem":
+		return m.elemField(field)
+	case "data":
+		return m.dataField(field)
+	}
+	return nil
+}
+
+func (m *module) typeField(field *Node) error {
+	id, rest := splitID(field)
+	if len(rest) != 1 || rest[0].Head() != "func" {
+		return field.errorf("invalid type definition")
+	}
+	ft, _, remaining, err := m.signature(rest[0].Children[1:], true)
+	if err != nil {
+		return err
+	}
+	if len(remaining) != 0 {
+		if remaining[0].Head() == "param" {
+			return remaining[0].errorf("result before parameter")
+		}
+		return remaining[0].errorf("unexpected token in type definition")
+	}
+	if id != nil {
+		if _, ok := m.typeNames[id.Atom]; ok {
+			return id.errorf("redefinition of type %s", id.Atom)
+		}
+		m.typeNames[id.Atom] = uint32(len(m.types))
+	}
+	m.types = append(m.types, ft)
+	return nil
+}
+
+func valueType(n *Node) (byte, error) {
+	if !n.List && !n.String {
+		if t, ok := valueTypes[n.Atom]; ok {
+			return t, nil
+		}
+	}
+	return 0, n.errorf("unexpected token %s, expect value type", n.Atom)
+}
+
+// signature parses the (param ...) and (result ...) lists at the start of nodes.
+// Parameter names are returned, empty for anonymous ones.
+func (m *module) signature(nodes []*Node, allowNames bool) (funcType, []string, []*Node, error) {
+	var (
+		ft    funcType
+		names []string
+	)
+	for len(nodes) > 0 && nodes[0].Head() == "param" {
+		params := nodes[0].Children[1:]
+		if len(params) > 0 && params[0].isID() {
+			if !allowNames || len(params) != 2 {
+				return ft, nil, nil, params[0].errorf("unexpected token %s", params[0].Atom)
+			}
+			for _, name := range names {
+				if name == params[0].Atom {
+					return ft, nil, nil, params[0].errorf("duplicate local %s", name)
+				}
+			}
+			t, err := valueType(params[1])
+			if err != nil {
+				return ft, nil, nil, err
+			}
+			ft.params = append(ft.params, t)
+			names = append(names, params[0].Atom)
+		} else {
+			for _, p := range params {
+				t, err := valueType(p)
+				if err != nil {
+					return ft, nil, nil, err
+				}
+				ft.params = append(ft.params, t)
+				names = append(names, "")
+			}
+		}
+		nodes = nodes[1:]
+	}
+	for len(nodes) > 0 && nodes[0].Head() == "result" {
+		for _, r := range nodes[0].Children[1:] {
+			t, err := valueType(r)
+			if err != nil {
+				return ft, nil, nil, err
+			}
+			ft.results = append(ft.results, t)
+		}
+		nodes = nodes[1:]
+	}
+	return ft, names, nodes, nil
+}
+
+// typeIndex returns the index of the first type equal to ft, appending it when missing
+func (m *module) typeIndex(ft funcType) uint32 {
+	for i, t := range m.types {
+		if t.equal(ft) {
+			return uint32(i)
+		}
+	}
+	m.types = append(m.types, ft)
+	return uint32(len(m.types) - 1)
+}
+
+// typeUse parses an optional (type x) followed by an inline signature
+func (m *module) typeUse(nodes []*Node, allowNames bool) (uint32, []string, []*Node, error) {
+	var (
+		idx      uint32
+		explicit bool
+	)
+	if len(nodes) > 0 && nodes[0].Head() == "type" {
+		ref := nodes[0].Children
+		if len(ref) != 2 {
+			return 0, nil, nil, nodes[0].errorf("invalid type use")
+		}
+		var err error
+		if ref[1].isID() {
+			var ok bool
+			if idx, ok = m.typeNames[ref[1].Atom]; !ok {
+				return 0, nil, nil, ref[1].errorf("unknown type %s", ref[1].Atom)
+			}
+		} else if idx, err = parseIndex(ref[1]); err != nil {
+			return 0, nil, nil, err
+		}
+		if int(idx) >= len(m.types) {
+			return 0, nil, nil, ref[1].errorf("unknown type %d", idx)
+		}
+		explicit = true
+		nodes = nodes[1:]
+	}
+	ft, names, rest, err := m.signature(nodes, allowNames)
+	if err != nil {
+		return 0, nil, nil, err
+	}
+	if len(rest) > 0 && (rest[0].Head() == "param" || rest[0].Head() == "type") {
+		return 0, nil, nil, rest[0].errorf("unexpected token %s", rest[0].Head())
+	}
+	if !explicit {
+		return m.typeIndex(ft), names, rest, nil
+	}
+	declared := m.types[idx]
+	if len(ft.params) == 0 && len(ft.results) == 0 {
+		return idx, make([]string, len(declared.params)), rest, nil
+	}
+	if !declared.equal(ft) {
+		return 0, nil, nil, nodes[0].errorf("inline function type does not match type %d", idx)
+	}
+	return idx, names, rest, nil
+}
+
+type inlineImport struct {
+	module, name string
+}
+
+// inlineExportsImport parses the (export "name") and (import "module" "name") abbreviations
+func (m *module) inlineExportsImport(nodes []*Node) ([]string, *inlineImport, []*Node, error) {
+	var (
+		exports []string
+		imp     *inlineImport
+	)
+	for len(nodes) > 0 {
+		switch nodes[0].Head() {
+		case "export":
+			c := nodes[0].Children
+			if len(c) != 2 || !c[1].String {
+				return nil, nil, nil, nodes[0].errorf("invalid inline export")
+			}
+			exports = append(exports, c[1].Atom)
+		case "import":
+			c := nodes[0].Children
+			if len(c) != 3 || !c[1].String || !c[2].String || imp != nil {
+				return nil, nil, nil, nodes[0].errorf("invalid inline import")
+			}
+			imp = &inlineImport{c[1].Atom, c[2].Atom}
+		default:
+			return exports, imp, nodes, nil
+		}
+		nodes = nodes[1:]
+	}
+	return exports, imp, nodes, nil
+}
+
+func (m *module) export(n *Node, name string, kind byte, idx uint32) error {
+	if m.exportNames[name] {
+		return n.errorf("duplicate export name %q", name)
+	}
+	m.exportNames[name] = true
+	m.exportSec.name(name)
+	m.exportSec.byte(kind)
+	m.exportSec.u32(idx)
+	m.exportSec.count++
+	return nil
+}
+
+func (m *module) exportAll(n *Node, names []string, kind byte, idx uint32) error {
+	for _, name := range names {
+		if err := m.export(n, name, kind, idx); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *module) addImport(imp *inlineImport, kind byte) {
+	m.imports.name(imp.module)
+	m.imports.name(imp.name)
+	m.imports.byte(kind)
+	m.imports.count++
+}
+
+// nextIndex returns the index of the entity defined by the current field
+func (m *module) nextIndex(kind byte) uint32 {
+	idx := m.next[kind]
+	m.next[kind]++
+	return idx
+}
+
+func (m *module) funcField(field *Node) error {
+	idx := m.nextIndex(kindFunc)
+	_, rest := splitID(field)
+	exports, imp, rest, err := m.inlineExportsImport(rest)
+	if err != nil {
+		return err
+	}
+	typeIdx, params, rest, err := m.typeUse(rest, imp == nil)
+	if err != nil {
+		return err
+	}
+	if err := m.exportAll(field, exports, kindFunc, idx); err != nil {
+		return err
+	}
+	if imp != nil {
+		if len(rest) != 0 {
+			return rest[0].errorf("unexpected token in imported function")
+		}
+		m.addImport(imp, kindFunc)
+		m.imports.u32(typeIdx)
+		return nil
+	}
+	m.funcSec.u32(typeIdx)
+	m.funcSec.count++
+	return m.funcBody(field, typeIdx, params, rest)
+}
+
+func (m *module) funcBody(field *Node, typeIdx uint32, params []string, nodes []*Node) error {
+	c := newFuncContext(m)
+	for i, name := range params {
+		if name != "" {
+			c.locals[name] = uint32(i)
+		}
+	}
+	count := uint32(len(params))
+	var locals []byte
+	for len(nodes) > 0 && nodes[0].Head() == "local" {
+		decl := nodes[0].Children[1:]
+		if len(decl) > 0 && decl[0].isID() {
+			if len(decl) != 2 {
+				return decl[0].errorf("invalid local")
+			}
+			if _, ok := c.locals[decl[0].Atom]; ok {
+				return decl[0].errorf("duplicate local %s", decl[0].Atom)
+			}
+			c.locals[decl[0].Atom] = count
+			decl = decl[1:]
+		}
+		for _, d := range decl {
+			t, err := valueType(d)
+			if err != nil {
+				return err
+			}
+			locals = append(locals, t)
+			count++
+		}
+		nodes = nodes[1:]
+	}
+	c.localCount = count
+	c.results = m.types[typeIdx].results
+	if err := c.instrs(nodes); err != nil {
+		return err
+	}
+	c.e.byte(byte(opcode.End))
+
+	// locals are encoded as runs of the same type
+	var body encoder
+	var runs encoder
+	runCount := 0
+	for i := 0; i < len(locals); {
+		j := i
+		for j < len(locals) && locals[j] == locals[i] {
+			j++
+		}
+		runs.u32(uint32(j - i))
+		runs.byte(locals[i])
+		runCount++
+		i = j
+	}
+	body.u32(uint32(runCount))
+	body.bytes(runs.b)
+	body.bytes(c.e.b)
+	m.codeSec.u32(uint32(len(body.b)))
+	m.codeSec.bytes(body.b)
+	m.codeSec.count++
+	return nil
+}
+
+// limits parses min and optional max numbers at the start of nodes
+func parseLimits(n *Node, nodes []*Node) (limits, []*Node, error) {
+	var l limits
+	if len(nodes) == 0 {
+		return l, nil, n.errorf("missing limits")
+	}
+	min, err := parseIndex(nodes[0])
+	if err != nil {
+		return l, nil, err
+	}
+	l.min = min
+	nodes = nodes[1:]
+	if len(nodes) > 0 && !nodes[0].List && !nodes[0].String && len(nodes[0].Atom) > 0 &&
+		nodes[0].Atom[0] >= '0' && nodes[0].Atom[0] <= '9' {
+		if l.max, err = parseIndex(nodes[0]); err != nil {
+			return l, nil, err
+		}
+		l.hasMax = true
+		nodes = nodes[1:]
+	}
+	return l, nodes, nil
+}
+
+func isElemType(n *Node) bool {
+	return n.isKeyword("funcref") || n.isKeyword("anyfunc")
+}
+
+func (m *module) tableField(field *Node) error {
+	idx := m.nextIndex(kindTable)
+	_, rest := splitID(field)
+	exports, imp, rest, err := m.inlineExportsImport(rest)
+	if err != nil {
+		return err
+	}
+	if err := m.exportAll(field, exports, kindTable, idx); err != nil {
+		return err
+	}
+	var l limits
+	if imp == nil && len(rest) == 2 && isElemType(rest[0]) && rest[1].Head() == "elem" {
+		// (table funcref (elem $f ...)) sizes the table to its inline segment
+		var funcs encoder
+		elems := rest[1].Children[1:]
+		for _, elem := range elems {
+			fidx, err := m.funcs.resolve(elem)
+			if err != nil {
+				return err
+			}
+			funcs.u32(fidx)
+		}
+		l = limits{min: uint32(len(elems)), max: uint32(len(elems)), hasMax: true}
+		m.elemSec.u32(idx)
+		m.elemSec.bytes([]byte{byte(opcode.I32Const), 0, byte(opcode.End)})
+		m.elemSec.u32(uint32(len(elems)))
+		m.elemSec.bytes(funcs.b)
+		m.elemSec.count++
+	} else {
+		if l, rest, err = parseLimits(field, rest); err != nil {
+			return err
+		}
+		if len(rest) != 1 || !isElemType(rest[0]) {
+			return field.errorf("expect table element type")
+		}
+	}
+	table := &m.tableSec
+	if imp != nil {
+		m.addImport(imp, kindTable)
+		table = &m.imports
+	} else {
+		m.tableSec.count++
+	}
+	table.byte(wasm.ElemTypeFuncRef)
+	table.limits(l)
+	return nil
+}
+
+func (m *module) memoryField(field *Node) error {
+	idx := m.nextIndex(kindMemory)
+	_, rest := splitID(field)
+	exports, imp, rest, err := m.inlineExportsImport(rest)
+	if err != nil {
+		return err
+	}
+	if err := m.exportAll(field, exports, kindMemory, idx); err != nil {
+		return err
+	}
+	var l limits
+	if imp == nil && len(rest) == 1 && rest[0].Head() == "data" {
+		// (memory (data "...")) sizes the memory to its inline segment
+		data, err := dataStrings(rest[0].Children[1:])
+		if err != nil {
+			return err
+		}
+		pages := uint32((len(data) + 65535) / 65536)
+		l = limits{min: pages, max: pages, hasMax: true}
+		m.dataSec.u32(idx)
+		m.dataSec.bytes([]byte{byte(opcode.I32Const), 0, byte(opcode.End)})
+		m.dataSec.u32(uint32(len(data)))
+		m.dataSec.bytes(data)
+		m.dataSec.count++
+	} else {
+		if l, rest, err = parseLimits(field, rest); err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return rest[0].errorf("unexpected token in memory")
+		}
+	}
+	if imp != nil {
+		m.addImport(imp, kindMemory)
+		m.imports.limits(l)
+	} else {
+		m.memSec.limits(l)
+		m.memSec.count++
+	}
+	return nil
+}
+
+func globalType(n *Node) (byte, byte, error) {
+	if n.Head() == "mut" {
+		if len(n.Children) != 2 {
+			return 0, 0, n.errorf("invalid global type")
+		}
+		t, err := valueType(n.Children[1])
+		return t, 1, err
+	}
+	t, err := valueType(n)
+	return t, 0, err
+}
+
+func (m *module) globalField(field *Node) error {
+	idx := m.nextIndex(kindGlobal)
+	_, rest := splitID(field)
+	exports, imp, rest, err := m.inlineExportsImport(rest)
+	if err != nil {
+		return err
+	}
+	if err := m.exportAll(field, exports, kindGlobal, idx); err != nil {
+		return err
+	}
+	if len(rest) == 0 {
+		return field.errorf("missing global type")
+	}
+	t, mut, err := globalType(rest[0])
+	if err != nil {
+		return err
+	}
+	if imp != nil {
+		if len(rest) != 1 {
+			return rest[1].errorf("unexpected token in imported global")
+		}
+		m.addImport(imp, kindGlobal)
+		m.imports.byte(t)
+		m.imports.byte(mut)
+		return nil
+	}
+	expr, err := m.constExpr(rest[1:])
+	if err != nil {
+		return err
+	}
+	m.globalSec.byte(t)
+	m.globalSec.byte(mut)
+	m.globalSec.bytes(expr)
+	m.globalSec.count++
+	return nil
+}
+
+func (m *module) importField(field *Node) error {
+	c := field.Children
+	if !c[1].String || !c[2].String {
+		return field.errorf("invalid import names")
+	}
+	imp := &inlineImport{c[1].Atom, c[2].Atom}
+	desc := c[3]
+	kind := kindKeywords[desc.Head()]
+	m.nextIndex(kind)
+	_, rest := splitID(desc)
+	switch kind {
+	case kindFunc:
+		typeIdx, _, rest, err := m.typeUse(rest, true)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return rest[0].errorf("unexpected token in import")
+		}
+		m.addImport(imp, kind)
+		m.imports.u32(typeIdx)
+	case kindTable:
+		l, rest, err := parseLimits(desc, rest)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 1 || !isElemType(rest[0]) {
+			return desc.errorf("expect table element type")
+		}
+		m.addImport(imp, kind)
+		m.imports.byte(wasm.ElemTypeFuncRef)
+		m.imports.limits(l)
+	case kindMemory:
+		l, rest, err := parseLimits(desc, rest)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 0 {
+			return rest[0].errorf("unexpected token in import")
+		}
+		m.addImport(imp, kind)
+		m.imports.limits(l)
+	case kindGlobal:
+		if len(rest) != 1 {
+			return desc.errorf("invalid global import")
+		}
+		t, mut, err := globalType(rest[0])
+		if err != nil {
+			return err
+		}
+		m.addImport(imp, kind)
+		m.imports.byte(t)
+		m.imports.byte(mut)
+	}
+	return nil
+}
+
+func (m *module) exportField(field *Node) error {
+	c := field.Children
+	if len(c) != 3 || !c[1].String {
+		return field.errorf("invalid export")
+	}
+	desc := c[2]
+	kind, ok := kindKeywords[desc.Head()]
+	if !ok || len(desc.Children) != 2 {
+		return desc.errorf("invalid export kind")
+	}
+	idx, err := m.space(kind).resolve(desc.Children[1])
+	if err != nil {
+		return err
+	}
+	return m.export(field, c[1].Atom, kind, idx)
+}
+
+func (m *module) startField(field *Node) error {
+	if len(field.Children) != 2 {
+		return field.errorf("invalid start")
+	}
+	if m.start != nil {
+		return field.errorf("multiple start sections")
+	}
+	idx, err := m.funcs.resolve(field.Children[1])
+	if err != nil {
+		return err
+	}
+	m.start = &idx
+	return nil
+}
+
+// offsetExpr parses an (offset ...) list or a single folded instruction
+func (m *module) offsetExpr(n *Node) ([]byte, error) {
+	if n.Head() == "offset" {
+		return m.constExpr(n.Children[1:])
+	}
+	return m.constExpr([]*Node{n})
+}
+
+// constExpr compiles a constant expression followed by end
+func (m *module) constExpr(nodes []*Node) ([]byte, error) {
+	c := newFuncContext(m)
+	if err := c.instrs(nodes); err != nil {
+		return nil, err
+	}
+	c.e.byte(byte(opcode.End))
+	return c.e.b, nil
+}
+
+// segmentTarget parses the optional table or memory index and the offset of a segment
+func (m *module) segmentTarget(field *Node, space *indexSpace) (uint32, []byte, []*Node, error) {
+	rest := field.Children[1:]
+	var idx uint32
+	if len(rest) > 0 && !rest[0].List && !rest[0].String {
+		var err error
+		if idx, err = space.resolve(rest[0]); err != nil {
+			return 0, nil, nil, err
+		}
+		rest = rest[1:]
+	}
+	if len(rest) == 0 || !rest[0].List {
+		return 0, nil, nil, field.errorf("missing segment offset")
+	}
+	offset, err := m.offsetExpr(rest[0])
+	return idx, offset, rest[1:], err
+}
+
+func (m *module) elemField(field *Node) error {
+	idx, offset, rest, err := m.segmentTarget(field, &m.tables)
+	if err != nil {
+		return err
+	}
+	if len(rest) > 0 && rest[0].isKeyword("func") {
+		rest = rest[1:]
+	}
+	m.elemSec.u32(idx)
+	m.elemSec.bytes(offset)
+	m.elemSec.u32(uint32(len(rest)))
+	for _, n := range rest {
+		fidx, err := m.funcs.resolve(n)
+		if err != nil {
+			return err
+		}
+		m.elemSec.u32(fidx)
+	}
+	m.elemSec.count++
+	return nil
+}
+
+func dataStrings(nodes []*Node) ([]byte, error) {
+	var data []byte
+	for _, n := range nodes {
+		if !n.String {
+			return nil, n.errorf("expect data string")
+		}
+		data = append(data, n.Atom...)
+	}
+	return data, nil
+}
+
+func (m *module) dataField(field *Node) error {
+	idx, offset, rest, err := m.segmentTarget(field, &m.mems)
+	if err != nil {
+		return err
+	}
+	data, err := dataStrings(rest)
+	if err != nil {
+		return err
+	}
+	m.dataSec.u32(idx)
+	m.dataSec.bytes(offset)
+	m.dataSec.u32(uint32(len(data)))
+	m.dataSec.bytes(data)
+	m.dataSec.count++
+	return nil
+}
+
+func (m *module) encode() []byte {
+	var e encoder
+	e.fixed(uint64(wasm.Magic), 4)
+	e.fixed(uint64(wasm.Version), 4)
+
+	var types encoder
+	for _, t := range m.types {
+		types.byte(wasm.FuncTypeForm)
+		types.u32(uint32(len(t.params)))
+		types.bytes(t.params)
+		types.u32(uint32(len(t.results)))
+		types.bytes(t.results)
+	}
+	e.section(1, len(m.types), &types)
+	e.section(2, m.imports.count, &m.imports.encoder)
+	e.section(3, m.funcSec.count, &m.funcSec.encoder)
+	e.section(4, m.tableSec.count, &m.tableSec.encoder)
+	e.section(5, m.memSec.count, &m.memSec.encoder)
+	e.section(6, m.globalSec.count, &m.globalSec.encoder)
+	e.section(7, m.exportSec.count, &m.exportSec.encoder)
+	if m.start != nil {
+		var start encoder
+		start.u32(*m.start)
+		e.byte(8)
+		e.u32(uint32(len(start.b)))
+		e.bytes(start.b)
+	}
+	e.section(9, m.elemSec.count, &m.elemSec.encoder)
+	e.section(10, m.codeSec.count, &m.codeSec.encoder)
+	e.section(11, m.dataSec.count, &m.dataSec.encoder)
+	return e.b
+}
@@ -0,0 +1,269 @@
+package wat
+
+import (
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// Error is a syntax or semantic error at a source position
+type Error struct {
+	Line, Col int
+	Msg       string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Col, e.Msg)
+}
+
+// Node is an s-expression, either a parenthesized list or an atom
+type Node struct {
+	Line, Col int
+	List      bool
+	Children  []*Node // list elements
+	Atom      string  // keyword, identifier or number text, or the decoded string
+	String    bool    // the atom is a string literal
+}
+
+func (n *Node) errorf(format string, args ...interface{}) *Error {
+	return &Error{Line: n.Line, Col: n.Col, Msg: fmt.Sprintf(format, args...)}
+}
+
+// Head returns the keyword starting a list, or the empty string
+func (n *Node) Head() string {
+	if !n.List || len(n.Children) == 0 || n.Children[0].List || n.Children[0].String {
+		return ""
+	}
+	return n.Children[0].Atom
+}
+
+// isID tells if n is a symbolic identifier such as $name
+func (n *Node) isID() bool {
+	return !n.List && !n.String && strings.HasPrefix(n.Atom, "$")
+}
+
+// isKeyword tells if n is the atom s
+func (n *Node) isKeyword(s string) bool {
+	return !n.List && !n.String && n.Atom == s
+}
+
+type lexer struct {
+	src       []byte
+	pos       int
+	line, col int
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) *Error {
+	return &Error{Line: l.line, Col: l.col, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n; i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = 1
+		} else {
+			l.col++
+		}
+		l.pos++
+	}
+}
+
+func (l *lexer) skipSpace() error {
+	for l.pos < len(l.src) {
+		c := l.src[l.pos]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			l.advance(1)
+		case c == ';' && l.peek(1) == ';':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+		case c == '(' && l.peek(1) == ';':
+			if err := l.skipBlockComment(); err != nil {
+				return err
+			}
+		default:
+			return nil
+		}
+	}
+	return nil
+}
+
+func (l *lexer) peek(offset int) byte {
+	if l.pos+offset < len(l.src) {
+		return l.src[l.pos+offset]
+	}
+	return 0
+}
+
+func (l *lexer) skipBlockComment() error {
+	start := *l
+	depth := 0
+	for l.pos < len(l.src) {
+		switch {
+		case l.src[l.pos] == '(' && l.peek(1) == ';':
+			depth++
+			l.advance(2)
+		case l.src[l.pos] == ';' && l.peek(1) == ')':
+			depth--
+			l.advance(2)
+			if depth == 0 {
+				return nil
+			}
+		default:
+			l.advance(1)
+		}
+	}
+	return start.errorf("unterminated block comment")
+}
+
+func isIDChar(c byte) bool {
+	return c > ' ' && c < 0x7f && c != '"' && c != ',' && c != ';' && c != '(' && c != ')' && c != '[' && c != ']' && c != '{' && c != '}'
+}
+
+func (l *lexer) node() (*Node, error) {
+	n := &Node{Line: l.line, Col: l.col}
+	c := l.src[l.pos]
+	switch {
+	case c == '(':
+		n.List = true
+		l.advance(1)
+		for {
+			if err := l.skipSpace(); err != nil {
+				return nil, err
+			}
+			if l.pos >= len(l.src) {
+				return nil, n.errorf("unclosed parenthesis")
+			}
+			if l.src[l.pos] == ')' {
+				l.advance(1)
+				return n, nil
+			}
+			child, err := l.node()
+			if err != nil {
+				return nil, err
+			}
+			n.Children = append(n.Children, child)
+		}
+	case c == ')':
+		return nil, l.errorf("unexpected )")
+	case c == '"':
+		s, err := l.str()
+		if err != nil {
+			return nil, err
+		}
+		n.Atom, n.String = s, true
+	case isIDChar(c):
+		start := l.pos
+		for l.pos < len(l.src) && isIDChar(l.src[l.pos]) {
+			l.advance(1)
+		}
+		n.Atom = string(l.src[start:l.pos])
+	default:
+		return nil, l.errorf("unexpected character %q", c)
+	}
+	if l.pos < len(l.src) && !strings.ContainsRune(" \t\n\r()", rune(l.src[l.pos])) &&
+		!(l.src[l.pos] == ';' && l.peek(1) == ';') {
+		return nil, l.errorf("unknown operator: missing separator")
+	}
+	return n, nil
+}
+
+func hexDigit(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+// str decodes a string literal, which may hold arbitrary bytes
+func (l *lexer) str() (string, error) {
+	start := *l
+	l.advance(1)
+	var b []byte
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return "", start.errorf("unterminated string")
+		}
+		c := l.src[l.pos]
+		switch {
+		case c == '"':
+			l.advance(1)
+			return string(b), nil
+		case c < ' ' || c == 0x7f:
+			return "", l.errorf("illegal character in string")
+		case c != '\\':
+			b = append(b, c)
+			l.advance(1)
+			continue
+		}
+		esc := l.peek(1)
+		switch esc {
+		case 'n':
+			b = append(b, '\n')
+		case 't':
+			b = append(b, '\t')
+		case 'r':
+			b = append(b, '\r')
+		case '"', '\'', '\\':
+			b = append(b, esc)
+		case 'u':
+			end := l.pos + 3
+			for end < len(l.src) && l.src[end] != '}' {
+				end++
+			}
+			if l.peek(2) != '{' || end >= len(l.src) || end == l.pos+3 {
+				return "", l.errorf("invalid unicode escape")
+			}
+			var r rune
+			for _, h := range l.src[l.pos+3 : end] {
+				d, ok := hexDigit(h)
+				if !ok || r > utf8.MaxRune {
+					return "", l.errorf("invalid unicode escape")
+				}
+				r = r*16 + rune(d)
+			}
+			if r > utf8.MaxRune || (0xd800 <= r && r < 0xe000) {
+				return "", l.errorf("invalid unicode escape")
+			}
+			b = append(b, string(r)...)
+			l.advance(end + 1 - l.pos)
+			continue
+		default:
+			hi, ok1 := hexDigit(esc)
+			lo, ok2 := hexDigit(l.peek(2))
+			if !ok1 || !ok2 {
+				return "", l.errorf("invalid escape")
+			}
+			b = append(b, hi<<4|lo)
+			l.advance(3)
+			continue
+		}
+		l.advance(2)
+	}
+}
+
+// Parse reads the s-expressions of a text format source
+func Parse(src []byte) ([]*Node, error) {
+	l := &lexer{src: src, line: 1, col: 1}
+	var nodes []*Node
+	for {
+		if err := l.skipSpace(); err != nil {
+			return nil, err
+		}
+		if l.pos >= len(l.src) {
+			return nodes, nil
+		}
+		n, err := l.node()
+		if err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, n)
+	}
+}
@@ -0,0 +1,95 @@
+// Package wat compiles the WebAssembly text format to the binary format.
+//
+// The MVP syntax is supported, including folded expressions, symbolic
+// identifiers and labels, inline imports and exports, and the names of
+// instructions renamed since the MVP such as get_local.
+package wat
+
+import (
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+// Compile translates a text format module to the binary format. The source
+// holds a single (module ...) or bare module fields.
+func Compile(src []byte) ([]byte, error) {
+	nodes, err := Parse(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(nodes) == 1 && nodes[0].Head() == "module" {
+		return CompileModule(nodes[0])
+	}
+	return newModule().compile(nodes)
+}
+
+// CompileModule translates a parsed (module ...) to the binary format,
+// including the (module binary "...") and (module quote "...") forms
+func CompileModule(n *Node) ([]byte, error) {
+	if n.Head() != "module" {
+		return nil, n.errorf("expect module")
+	}
+	_, fields := splitID(n)
+	if len(fields) > 0 && (fields[0].isKeyword("binary") || fields[0].isKeyword("quote")) {
+		data, err := dataStrings(fields[1:])
+		if err != nil {
+			return nil, err
+		}
+		if fields[0].Atom == "binary" {
+			return data, nil
+		}
+		return Compile(data)
+	}
+	return newModule().compile(fields)
+}
+
+// ParseModule compiles a text format module and decodes it
+func ParseModule(src []byte) (*wasm.Module, error) {
+	code, err := Compile(src)
+	if err != nil {
+		return nil, err
+	}
+	return wasm.ReadModule(code)
+}
+
+// ID returns the identifier following the head of a list such as (module $m ...),
+// or the empty string
+func (n *Node) ID() string {
+	if n.List && len(n.Children) > 1 && n.Children[1].isID() {
+		return n.Children[1].Atom
+	}
+	return ""
+}
+
+// Const evaluates a constant instruction such as (f32.const 0.5) to its value
+// type and raw bits
+func Const(n *Node) (wasm.ValueType, uint64, error) {
+	if len(n.Children) != 2 || n.Children[1].List || n.Children[1].String {
+		return 0, 0, n.errorf("expect a constant instruction")
+	}
+	var (
+		t    wasm.ValueType
+		bits uint64
+		err  error
+	)
+	text := n.Children[1].Atom
+	switch n.Head() {
+	case "i32.const":
+		t = wasm.ValueTypeI32
+		bits, err = parseInt(text, 32)
+	case "i64.const":
+		t = wasm.ValueTypeI64
+		bits, err = parseInt(text, 64)
+	case "f32.const":
+		t = wasm.ValueTypeF32
+		bits, err = parseFloat(text, 32)
+	case "f64.const":
+		t = wasm.ValueTypeF64
+		bits, err = parseFloat(text, 64)
+	default:
+		return 0, 0, n.errorf("expect a constant instruction")
+	}
+	if err != nil {
+		return 0, 0, n.Children[1].errorf("%v", err)
+	}
+	return t, bits, nil
+}
@@ -0,0 +1,253 @@
+package wat
+
+import (
+	"bytes"
+	"math"
+	"testing"
+
+	"github.com/vertexdlt/vertexvm/wasm"
+)
+
+func TestParseInt(t *testing.T) {
+	tests := []struct {
+		text string
+		bits uint
+		v    uint64
+		err  error
+	}{
+		{"0", 32, 0, nil},
+		{"-1", 32, 0xffffffff, nil},
+		{"4294967295", 32, 0xffffffff, nil},
+		{"-2147483648", 32, 0x80000000, nil},
+		{"0x7fff_ffff", 32, 0x7fffffff, nil},
+		{"+1_000", 64, 1000, nil},
+		{"-0x8000000000000000", 64, 0x8000000000000000, nil},
+		{"4294967296", 32, 0, errOutOfRange},
+		{"-2147483649", 32, 0, errOutOfRange},
+		{"1__0", 32, 0, errMalformedNumber},
+		{"_1", 32, 0, errMalformedNumber},
+		{"0x", 32, 0, errMalformedNumber},
+		{"1a", 32, 0, errMalformedNumber},
+	}
+	for _, test := range tests {
+		v, err := parseInt(test.text, test.bits)
+		if v != test.v || err != test.err {
+			t.Errorf("parseInt(%s, %d): expect %x %v, got %x %v", test.text, test.bits, test.v, test.err, v, err)
+		}
+	}
+}
+
+func TestParseFloat(t *testing.T) {
+	tests := []struct {
+		text string
+		bits int
+		v    uint64
+		err  error
+	}{
+		{"1.5", 32, uint64(math.Float32bits(1.5)), nil},
+		{"-0", 32, 0x80000000, nil},
+		{"0x1p-149", 32, 1, nil},
+		{"0x1.fffffep127", 32, 0x7f7fffff, nil},
+		{"0x1.ffffffp127", 32, 0, errOutOfRange},
+		{"0x1.00000100000000001p0", 32, 0x3f800001, nil}, // above the tie rounds up
+		{"0x1.000001p0", 32, 0x3f800000, nil},            // tie rounds to even
+		{"0x1.ffffffffccccdp+31", 64, 0x41effffffffccccd, nil},
+		{"-0x1.ccccccp-1", 32, 0xbf666666, nil},
+		{"0x10", 64, math.Float64bits(16), nil},
+		{"1e308", 64, math.Float64bits(1e308), nil},
+		{"1e309", 64, 0, errOutOfRange},
+		{"1_000.000_1", 64, math.Float64bits(1000.0001), nil},
+		{"inf", 32, 0x7f800000, nil},
+		{"-inf", 64, 0xfff0000000000000, nil},
+		{"nan", 32, 0x7fc00000, nil},
+		{"-nan:0x1", 64, 0xfff0000000000001, nil},
+		{"nan:0x7fffff", 32, 0x7fffffff, nil},
+		{"nan:0x800000", 32, 0, errOutOfRange},
+		{"nan:0x0", 64, 0, errOutOfRange},
+		{"1.e", 64, 0, errMalformedNumber},
+		{".5", 64, 0, errMalformedNumber},
+		{"0x1.g", 64, 0, errMalformedNumber},
+	}
+	for _, test := range tests {
+		v, err := parseFloat(test.text, test.bits)
+		if v != test.v || err != test.err {
+			t.Errorf("parseFloat(%s, %d): expect %x %v, got %x %v", test.text, test.bits, test.v, test.err, v, err)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	nodes, err := Parse([]byte(`(a "x\n\41\u{1F600}\"" ;; line
+	(; block (; nested ;) ;) $id) b`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(nodes) != 2 || nodes[0].Head() != "a" || len(nodes[0].Children) != 3 || nodes[1].Atom != "b" {
+		t.Fatalf("Unexpected nodes %+v", nodes)
+	}
+	if s := nodes[0].Children[1]; !s.String || s.Atom != "x\nA\U0001F600\"" {
+		t.Errorf("Unexpected string %q", s.Atom)
+	}
+	if id := nodes[0].Children[2]; !id.isID() || id.Line != 2 {
+		t.Errorf("Unexpected identifier %+v", id)
+	}
+	for _, src := range []string{`(a`, `a)`, `"abc`, `(; open`, `"\x"`, `"\u{d800}"`} {
+		if _, err := Parse([]byte(src)); err == nil {
+			t.Errorf("Expect %s to fail", src)
+		}
+	}
+}
+
+// equivalent modules in flat, folded and abbreviated forms compile to the same binary
+func TestCompileForms(t *testing.T) {
+	flat := `(module
+  (type $t (func (param i32 i32) (result i32)))
+  (import "env" "add" (func $add (type $t)))
+  (func $calc (type $t) (param $a i32) (param $b i32) (result i32)
+    (local $x i32)
+    block $out (result i32)
+      local.get $a
+      local.get $b
+      call $add
+      local.tee $x
+      br_if $out
+      i32.const -1
+    end)
+  (memory 1)
+  (export "calc" (func $calc))
+  (export "mem" (memory 0)))`
+	folded := `(module
+  (func $add (import "env" "add") (param i32 i32) (result i32))
+  (func $calc (export "calc") (param $a i32) (param $b i32) (result i32)
+    (local $x i32)
+    (block $out (result i32)
+      (br_if $out (local.tee $x (call $add (local.get $a) (local.get $b))))
+      (i32.const -1)))
+  (memory (export "mem") 1))`
+	legacy := `(type (func (param i32 i32) (result i32)))
+  (import "env" "add" (func (type 0)))
+  (func (type 0) (local i32)
+    block (result i32)
+      get_local 0
+      get_local 1
+      call 0
+      tee_local 2
+      br_if 0
+      i32.const -1
+    end)
+  (memory 1)
+  (export "calc" (func 1))
+  (export "mem" (memory 0))`
+	expected, err := Compile([]byte(flat))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, src := range []string{folded, legacy} {
+		code, err := Compile([]byte(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(code, expected) {
+			t.Errorf("Expect\n% x\ngot\n% x", expected, code)
+		}
+	}
+	m, err := wasm.ReadModule(expected)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body := []byte{0x02, 0x7f, 0x20, 0x00, 0x20, 0x01, 0x10, 0x00, 0x22, 0x02, 0x0d, 0x00, 0x41, 0x7f, 0x0b}
+	if code := m.CodeSec.Codes[0]; !bytes.Equal(code.Exprs, body) || len(code.Locals) != 1 || code.Locals[0].Count != 1 {
+		t.Errorf("Unexpected code %+v", code)
+	}
+}
+
+func TestCompileSegments(t *testing.T) {
+	code, err := Compile([]byte(`(module
+  (global $base (import "env" "base") i32)
+  (global $g (mut f64) (f64.const 0x1p-1))
+  (table funcref (elem $f $f))
+  (memory $m (data "ab" "\ff"))
+  (func $f (if (result i32) (i32.const 1) (then (i32.const 2)) (else (i32.const 3))) drop)
+  (elem (offset (global.get $base)) $f)
+  (data $m (i32.const 4) "c")
+  (start $f))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := wasm.ReadModule(code)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if init := m.ElementSec.Elements[1].Init; !bytes.Equal(init, []byte{0x23, 0x00, 0x0b}) {
+		t.Errorf("Expect the offset to read the imported global, got % x", init)
+	}
+	code, err = Compile([]byte(`(module
+  (global $g (mut f64) (f64.const 0x1p-1))
+  (table funcref (elem $f $f))
+  (memory $m (data "ab" "\ff"))
+  (func $f (if (result i32) (i32.const 1) (then (i32.const 2)) (else (i32.const 3))) drop)
+  (elem (i32.const 1) $f)
+  (data $m (i32.const 4) "c")
+  (start $f))`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err = wasm.ReadModule(code); err != nil {
+		t.Fatal(err)
+	}
+	if l := m.TableSec.Tables[0].Limits; l.Min != 2 || l.Max != 2 || len(m.ElementSec.Elements) != 2 {
+		t.Errorf("Unexpected table %+v %+v", l, m.ElementSec)
+	}
+	if l := m.MemSec.Mems[0].Limits; l.Min != 1 || l.Max != 1 || !bytes.Equal(m.DataSec.DataSegments[0].Init, []byte("ab\xff")) {
+		t.Errorf("Unexpected memory %+v %+v", l, m.DataSec)
+	}
+	if g := m.GlobalSec.Globals[0]; g.Type.Mutability != 1 || !bytes.Equal(g.Init, []byte{0x44, 0, 0, 0, 0, 0, 0, 0xe0, 0x3f, 0x0b}) {
+		t.Errorf("Unexpected global %+v", g)
+	}
+	if m.StartSec.FuncIdx != 0 {
+		t.Errorf("Unexpected start %d", m.StartSec.FuncIdx)
+	}
+	if body := m.CodeSec.Codes[0].Exprs; !bytes.Equal(body, []byte{0x41, 0x01, 0x04, 0x7f, 0x41, 0x02, 0x05, 0x41, 0x03, 0x0b, 0x1a}) {
+		t.Errorf("Unexpected if encoding % x", body)
+	}
+}
+
+func TestCompileErrors(t *testing.T) {
+	for _, src := range []string{
+		`(func (call $missing))`,
+		`(func (br $missing))`,
+		`(func block $a end $b)`,
+		`(func (local.get $x))`,
+		`(func i32.foo)`,
+		`(func (i32.const 0x1_0000_0000))`,
+		`(func (i32.load align=3 (i32.const 0)))`,
+		`(func $f) (func $f)`,
+		`(func (export "a")) (func (export "a"))`,
+		`(memory 1) (bogus)`,
+		`(func (type 3))`,
+		`(memory 1) (import "m" "f" (func))`,
+		`(global i32 (i32.const 0)) (func (import "m" "f"))`,
+	} {
+		if _, err := Compile([]byte(src)); err == nil {
+			t.Errorf("Expect %s to fail", src)
+		}
+	}
+}
+
+func TestCompileModuleForms(t *testing.T) {
+	nodes, err := Parse([]byte(`(module $m binary "\00asm" "\01\00\00\00") (module quote "(func (export \"f\"))")`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	code, err := CompileModule(nodes[0])
+	if err != nil || !bytes.Equal(code, []byte{0, 'a', 's', 'm', 1, 0, 0, 0}) {
+		t.Errorf("Unexpected binary module % x %v", code, err)
+	}
+	code, err = CompileModule(nodes[1])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if m, err := wasm.ReadModule(code); err != nil || m.ExportSec.ExportMap["f"].Name != "f" {
+		t.Errorf("Unexpected quoted module %v", err)
+	}
+}